@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"crypto/tls"
 
+	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/internal/database"
 	"github.com/msrevive/nexus2/internal/config"
 	"github.com/msrevive/nexus2/internal/static"
@@ -26,6 +27,7 @@ type App struct {
 	Config *config.Config
 	DB database.Database
 	Logger *slog.Logger
+	Flags *bitmask.Registry
 	List struct {
 		SystemAdmin *ccmap.Cache[string, string]
 		IP *ccmap.Cache[string, string]
@@ -106,7 +108,13 @@ func (a *App) LoadConfig(path string) (error) {
 		return fmt.Errorf("Unable to load config file %w", err)
 	}
 
+	flags, err := bitmask.NewRegistry(cfg.Flags)
+	if err != nil {
+		return fmt.Errorf("Unable to load user flags %w", err)
+	}
+
 	a.Config = cfg
+	a.Flags = flags
 	return nil
 }
 
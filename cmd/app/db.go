@@ -10,6 +10,7 @@ import (
 	"github.com/msrevive/nexus2/internal/database"
 	"github.com/msrevive/nexus2/internal/database/sqlite"
 	"github.com/msrevive/nexus2/internal/database/postgres"
+	"github.com/msrevive/nexus2/internal/webhook"
 	"github.com/msrevive/nexus2/pkg/utils"
 	"github.com/msrevive/nexus2/pkg/loghandler"
 
@@ -29,6 +30,10 @@ func (a *App) SetupDatabase() error {
 		return database.ErrNotAvailable
 	}
 
+	if a.Config.Audit.Key == "" {
+		a.Logger.Warn("No audit key set, the audit log's hash chain can be rewritten by anyone who can write to the database")
+	}
+
 	// Setup database sync
 	syncCron := cron.New()
 	syncCron.AddFunc(a.Config.Database.Sync, func() {
@@ -53,14 +58,90 @@ func (a *App) SetupDatabase() error {
 			if err := a.DB.RunGC(); err != nil {
 				a.Logger.Warn("Unable to run garbage collection", "error", err)
 			}
+
+			a.prune("operation journal", a.Config.Char.JournalRetention, func(before time.Time) (int, error) {
+				return 0, a.DB.PruneJournal(before)
+			})
+
+			if n, err := a.DB.ExpireApprovals(time.Now().UTC()); err != nil {
+				a.Logger.Warn("Unable to expire approvals", "error", err)
+			} else if n > 0 {
+				a.Logger.Info("Expired pending approvals", "count", n)
+			}
+
+			a.prune("finished jobs", a.Config.Jobs.Retention, a.DB.PruneJobs)
+			a.prune("change feed", a.Config.Changes.Retention, a.DB.PruneChanges)
+			a.prune("webhook deliveries", a.Config.Webhooks.Retention, a.DB.PruneWebhookDeliveries)
 			a.Logger.Info("Finished running garbage collection", "ping", time.Since(t1))
 		}()
 	})
 	gcCron.Start()
 
+	// Setup expiry of temporary bans
+	if a.Config.Database.BanExpiry != "" {
+		banCron := cron.New()
+		banCron.AddFunc(a.Config.Database.BanExpiry, func() {
+			go func() {
+				n, err := a.DB.ExpireBans(time.Now().UTC())
+				if err != nil {
+					a.Logger.Warn("Unable to expire bans", "error", err)
+					return
+				}
+				if n > 0 {
+					a.Logger.Info("Expired temporary bans", "count", n)
+				}
+			}()
+		})
+		banCron.Start()
+	}
+
+	// Setup expiry of flag grants
+	if a.Config.Database.GrantExpiry != "" {
+		grantCron := cron.New()
+		grantCron.AddFunc(a.Config.Database.GrantExpiry, func() {
+			go func() {
+				n, err := a.DB.ExpireFlagGrants(time.Now().UTC())
+				if err != nil {
+					a.Logger.Warn("Unable to expire flag grants", "error", err)
+					return
+				}
+				if n > 0 {
+					a.Logger.Info("Expired flag grants", "count", n)
+				}
+			}()
+		})
+		grantCron.Start()
+	}
+
 	return nil
 }
 
+// prune drops what's older than retention with fn, logging how many it did under label.
+// An empty or zero retention keeps everything.
+func (a *App) prune(label string, retention string, fn func(before time.Time) (int, error)) {
+	if retention == "" {
+		return
+	}
+
+	keep, err := utils.ParseDuration(retention)
+	if err != nil {
+		a.Logger.Warn("Unable to parse "+label+" retention", "error", err)
+		return
+	}
+	if keep <= 0 {
+		return
+	}
+
+	n, err := fn(time.Now().UTC().Add(-keep))
+	if err != nil {
+		a.Logger.Warn("Unable to prune "+label, "error", err)
+		return
+	}
+	if n > 0 {
+		a.Logger.Info("Pruned "+label, "count", n)
+	}
+}
+
 func (a *App) DatabaseConnect() error {
 	maxRetries := a.Config.Database.Postgres.MaxRetries
 	baseDelay := a.Config.Database.Postgres.RetryDelay
@@ -69,6 +150,9 @@ func (a *App) DatabaseConnect() error {
 		for attempt := 1; attempt <= maxRetries; attempt++ {
 			err := a.DB.Connect(a.Config.Database, database.Options{
 				Logger: a.SetUpDatabaseLogger(),
+				AuditKey: []byte(a.Config.Audit.Key),
+				MaxSnapshots: a.Config.Char.MaxSnapshots,
+				Outbox: webhook.NewOutbox(a.Config.Webhooks.Endpoints),
 			})
 			if err == nil {
 				return nil
@@ -93,6 +177,9 @@ func (a *App) DatabaseConnect() error {
 	}else{
 		err := a.DB.Connect(a.Config.Database, database.Options{
 			Logger: a.SetUpDatabaseLogger(),
+			AuditKey: []byte(a.Config.Audit.Key),
+			MaxSnapshots: a.Config.Char.MaxSnapshots,
+			Outbox: webhook.NewOutbox(a.Config.Webhooks.Endpoints),
 		})
 		if err == nil {
 			return nil
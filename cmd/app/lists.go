@@ -0,0 +1,305 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/service"
+	"github.com/msrevive/nexus2/pkg/utils"
+
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// How the ban and admin list files are reconciled with the user flags.
+const (
+	ListSyncMerge = "merge" // the lists only add bans and admins, this is the default
+	ListSyncFile = "file" // the lists are authoritative, flags of users not in them are cleared
+	ListSyncDB = "db" // the lists are ignored, the database is authoritative
+)
+
+// listIssuer is recorded as the admin of bans and unbans made by the list sync.
+const listIssuer = "banlist"
+
+// syncedSuffix names the file next to each list that keeps the SteamIDs it held at the
+// last sync or export. A merge skips users still in it, they were unbanned or removed as
+// admin through the API since and the list hasn't caught up yet.
+const syncedSuffix = ".synced"
+
+// SyncGameLists reconciles the ban and admin list files with the BANNED and ADMIN user
+// flags through the service, each list's changes are made in one transaction. A quiet sync
+// sends no webhooks for them.
+func (a *App) SyncGameLists(svc *service.Service, quiet bool) (listErr error) {
+	policy := a.Config.Verify.ListSync
+	switch policy {
+	case "":
+		policy = ListSyncMerge
+	case ListSyncMerge, ListSyncFile:
+	case ListSyncDB:
+		return nil
+	default:
+		return fmt.Errorf("unknown list sync policy %q", policy)
+	}
+
+	fmt.Println("\t Syncing FN Ban List...")
+	if err := a.syncBanList(svc, quiet, a.Config.Verify.BanListFile, policy); err != nil {
+		listErr = errors.Join(listErr, fmt.Errorf("failed to sync ban list: %w", err))
+	}
+
+	fmt.Println("\t Syncing FN Admin List...")
+	if err := a.syncAdminList(svc, quiet, a.Config.Verify.AdminListFile, policy); err != nil {
+		listErr = errors.Join(listErr, fmt.Errorf("failed to sync admin list: %w", err))
+	}
+
+	return listErr
+}
+
+// ExportGameLists writes the users that are currently banned or admins back to the list files.
+func (a *App) ExportGameLists() error {
+	bans, admins, err := a.GameLists()
+	if err != nil {
+		return err
+	}
+
+	if err := writeSyncedList(a.Config.Verify.BanListFile, bans); err != nil {
+		return fmt.Errorf("failed to export ban list: %w", err)
+	}
+
+	if err := writeSyncedList(a.Config.Verify.AdminListFile, admins); err != nil {
+		return fmt.Errorf("failed to export admin list: %w", err)
+	}
+
+	a.Logger.Info("Exported FN lists", "bans", len(bans), "admins", len(admins))
+	return nil
+}
+
+// GameLists builds the ban and admin lists ExportGameLists would write, keyed by SteamID.
+func (a *App) GameLists() (map[string]string, map[string]string, error) {
+	banned, err := a.DB.GetUsersWithFlag(bitmask.BANNED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bans := make(map[string]string, len(banned))
+	for _, steamid := range banned {
+		bans[steamid] = ""
+		ban, err := a.DB.GetActiveBan(steamid)
+		if err != nil && !errors.Is(err, database.ErrNoDocument) {
+			return nil, nil, err
+		}
+		if ban != nil {
+			bans[steamid] = ban.Reason
+		}
+	}
+
+	admins, err := a.DB.GetUsersWithFlag(bitmask.ADMIN)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the names admins were given in the current file.
+	names, err := readList(a.Config.Verify.AdminListFile)
+	if err != nil && !os.IsNotExist(err) {
+		a.Logger.Warn("Unable to read admin list, names will be left out", "error", err)
+	}
+
+	list := make(map[string]string, len(admins))
+	for _, steamid := range admins {
+		list[steamid] = names[steamid]
+	}
+
+	return bans, list, nil
+}
+
+func (a *App) syncBanList(svc *service.Service, quiet bool, path string, policy string) error {
+	list, err := readList(path)
+	if err != nil {
+		return err
+	}
+
+	banned, err := a.flaggedUsers(bitmask.BANNED)
+	if err != nil {
+		return err
+	}
+
+	synced, err := readSynced(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var changes []database.FlagChange
+	var added, lifted, skipped int
+	for steamid, reason := range list {
+		if banned[steamid] {
+			continue
+		}
+		if _, ok := synced[steamid]; ok && policy == ListSyncMerge {
+			skipped++
+			continue
+		}
+
+		changes = append(changes, listChange(steamid, database.FlagAdd, bitmask.BANNED, reason, path, now))
+		added++
+	}
+
+	if policy == ListSyncFile {
+		for steamid := range banned {
+			if _, ok := list[steamid]; ok {
+				continue
+			}
+
+			changes = append(changes, listChange(steamid, database.FlagRemove, bitmask.BANNED, "removed from ban list", path, now))
+			lifted++
+		}
+	}
+
+	if _, err := svc.SyncListFlags(changes, quiet); err != nil {
+		return err
+	}
+
+	a.Logger.Info("Synced FN ban list", "file", path, "policy", policy, "banned", added, "unbanned", lifted, "skipped", skipped)
+	return writeList(path+syncedSuffix, list)
+}
+
+func (a *App) syncAdminList(svc *service.Service, quiet bool, path string, policy string) error {
+	list, err := readList(path)
+	if err != nil {
+		return err
+	}
+
+	admins, err := a.flaggedUsers(bitmask.ADMIN)
+	if err != nil {
+		return err
+	}
+
+	synced, err := readSynced(path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var changes []database.FlagChange
+	var added, removed, skipped int
+	for steamid := range list {
+		if admins[steamid] {
+			continue
+		}
+		if _, ok := synced[steamid]; ok && policy == ListSyncMerge {
+			skipped++
+			continue
+		}
+
+		changes = append(changes, listChange(steamid, database.FlagAdd, bitmask.ADMIN, "added to admin list", path, now))
+		added++
+	}
+
+	if policy == ListSyncFile {
+		for steamid := range admins {
+			if _, ok := list[steamid]; ok {
+				continue
+			}
+
+			changes = append(changes, listChange(steamid, database.FlagRemove, bitmask.ADMIN, "removed from admin list", path, now))
+			removed++
+		}
+	}
+
+	if _, err := svc.SyncListFlags(changes, quiet); err != nil {
+		return err
+	}
+
+	a.Logger.Info("Synced FN admin list", "file", path, "policy", policy, "added", added, "removed", removed, "skipped", skipped)
+	return writeList(path+syncedSuffix, list)
+}
+
+// listChange is the flag change a list sync makes for the user, listIssuer is recorded
+// as the admin making it and the list file as the source of a grant.
+func listChange(steamid string, op string, flag bitmask.Bitmask, reason string, path string, at time.Time) database.FlagChange {
+	return database.FlagChange{
+		SteamID: steamid,
+		Op: op,
+		Mask: flag,
+		Reason: reason,
+		Source: filepath.Base(path),
+		Admin: listIssuer,
+		GrantedAt: at,
+	}
+}
+
+func (a *App) flaggedUsers(flag bitmask.Bitmask) (map[string]bool, error) {
+	steamids, err := a.DB.GetUsersWithFlag(flag)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[string]bool, len(steamids))
+	for _, steamid := range steamids {
+		users[steamid] = true
+	}
+	return users, nil
+}
+
+// readList reads a ban or admin list. Lists are either an object of SteamID to reason
+// or name, or a plain array of SteamIDs as produced by older tools.
+func readList(path string) (map[string]string, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file = utils.StandardJSON(file, file)
+
+	list := make(map[string]string)
+	if err := json.Unmarshal(file, &list); err == nil {
+		return list, nil
+	}
+
+	var steamids []string
+	if err := json.Unmarshal(file, &steamids); err != nil {
+		return nil, fmt.Errorf("%s: must be an object or an array of SteamIDs: %w", path, err)
+	}
+
+	for _, steamid := range steamids {
+		list[steamid] = ""
+	}
+	return list, nil
+}
+
+// readSynced reads what the list held at the last sync, nil when it hasn't been synced yet.
+func readSynced(path string) (map[string]string, error) {
+	list, err := readList(path + syncedSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return list, err
+}
+
+// writeSyncedList writes the list and records it as synced.
+func writeSyncedList(path string, list map[string]string) error {
+	if err := writeList(path, list); err != nil {
+		return err
+	}
+	return writeList(path+syncedSuffix, list)
+}
+
+// writeList writes the list to a temporary file first so the game never reads a half written list.
+func writeList(path string, list map[string]string) error {
+	data, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"runtime"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/msrevive/nexus2/cmd/app"
 	"github.com/msrevive/nexus2/internal/controller"
+	"github.com/msrevive/nexus2/internal/jobs"
 	"github.com/msrevive/nexus2/internal/middleware"
 	"github.com/msrevive/nexus2/internal/service"
 	"github.com/msrevive/nexus2/internal/static"
@@ -115,9 +117,33 @@ func Run(args []string) (error) {
 	router.Use(mw.PanicRecovery)
 	router.Use(cmw.Timeout(time.Duration(a.Config.Core.Timeout) * time.Second))
 
-	service := service.New(a.DB, a.Config, flags.readonly)
+	service := service.New(a.DB, a.Logger, a.Config, a.Flags, flags.readonly)
+	service.RegisterJob("export_lists", jobs.Kind{
+		Handler: func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+			return true, a.ExportGameLists()
+		},
+		Resumable: true,
+	})
+
+	// The startup sync is quiet, the lists may hold bans from long before the webhooks.
+	var syncLists func() error
+	if !flags.readonly {
+		fmt.Println("-> Syncing FN Lists...")
+		if err := a.SyncGameLists(service, true); err != nil {
+			a.Logger.Warn("Failed to sync FN list(s)!", "error", err)
+		}
+
+		syncLists = func() error {
+			return a.SyncGameLists(service, false)
+		}
+	}
+
 	con := controller.New(service, a.Logger, a.Config, controller.Options{
 		MapList: a.List.Map,
+		LoadLists: a.LoadLists,
+		SyncLists: syncLists,
+		GameLists: a.GameLists,
+		ExportLists: a.ExportGameLists,
 	})
 
 	// API version 2
@@ -151,54 +177,113 @@ func Run(args []string) (error) {
 			if !flags.debug {
 				r.Use(mw.ExternalAuth)
 			}
+			r.Use(con.Audit)
+			r.Use(con.Approval)
 
 			r.Route("/character", func(r chi.Router) {
+				r.Get("/search", con.SearchCharacters)
 				r.Get("/lookup/{steamid:[0-9]+}/{slot:[0-9]+}", con.LookUpCharacterID)
 				r.Get("/deleted/{steamid:[0-9]+}", con.GetDeletedCharacters)
+				r.Method(http.MethodPatch, "/deleted/{steamid:[0-9]+}/restore", controller.Plannable(con.RestoreDeletedCharacters))
+				r.Method(http.MethodPatch, "/deleted/{uuid}/expiry", controller.Plannable(con.PatchDeletedExpiry))
+				r.Method(http.MethodDelete, "/deleted/{uuid}", controller.Plannable(con.PurgeDeletedCharacter))
 				r.Get("/{steamid:[0-9]+}", con.GetCharacters)
 				r.Get("/{uuid}", con.GetCharacterByIDExternal)
-				r.Patch("/restore/{uuid}", con.RestoreCharacter)
+				r.Get("/{uuid}/lineage", con.GetCharacterLineage)
+				r.Method(http.MethodPatch, "/restore/{uuid}", controller.Plannable(con.RestoreCharacter))
+				r.Method(http.MethodPatch, "/restore/{uuid}/to/{slot:[0-9]+}", controller.Plannable(con.RestoreCharacter))
+				r.Method(http.MethodPatch, "/swap/{steamid:[0-9]+}/{a:[0-9]+}/{b:[0-9]+}", controller.Plannable(con.SwapCharacters))
 				r.Get("/export/{uuid}", con.ExportCharacter)
+				r.Get("/export/user/{steamid:[0-9]+}", con.ExportUserCharacters)
+				r.Method(http.MethodPost, "/import/{steamid:[0-9]+}/{slot:[0-9]+}", controller.Plannable(con.ImportCharacter))
 				r.Get("/{steamid:[0-9]+}/{slot:[0-9]+}", con.GetCharacter)
 			})
 
 			r.Route("/rollback/character", func(r chi.Router) {
 				r.Get("/{uuid}", con.GetCharacterVersions)
-				r.Patch("/{uuid}/latest", con.RollbackCharToLatest)
-				r.Patch("/{uuid}/{version:[0-9]+}", con.RollbackCharToVersion)
-				r.Delete("/{uuid}", con.DeleteCharRollbacks)
+				r.Method(http.MethodPatch, "/{uuid}/latest", controller.Plannable(con.RollbackCharToLatest))
+				r.Method(http.MethodPatch, "/{uuid}/undo", controller.Plannable(con.UndoCharacterOperation))
+				r.Method(http.MethodPatch, "/{uuid}/at/{time}", controller.Plannable(con.RollbackCharAt))
+				r.Method(http.MethodPatch, "/{uuid}/{version:[0-9]+}", controller.Plannable(con.RollbackCharToVersion))
+				r.Method(http.MethodDelete, "/{uuid}", controller.Plannable(con.DeleteCharRollbacks))
 				r.Get("/{uuid}/timestamp", con.GetCharacterVersionsTimestamp)
+				r.Get("/{uuid}/diff", con.DiffCharacterVersions)
+				r.Get("/{uuid}/{version:[0-9]+}", con.GetCharacterVersion)
+				r.Get("/{uuid}/{version:[0-9]+}/export", con.ExportCharacterVersion)
+			})
+
+			r.Route("/rollback/mass", func(r chi.Router) {
+				r.Method(http.MethodPost, "/", controller.Plannable(con.PostMassRollback))
+				r.Get("/{uuid}", con.GetMassRollback)
 			})
 
 			r.Route("/unsafe/character", func(r chi.Router) {
-				r.Patch("/move/{uuid}/to/{steamid:[0-9]+}/{slot:[0-9]+}", con.UnsafeMoveCharacter)
-				r.Patch("/copy/{uuid}/to/{steamid:[0-9]+}/{slot:[0-9]+}", con.UnsafeCopyCharacter)
-				r.Delete("/delete/{uuid}", con.UnsafeDeleteCharacter)
+				r.Method(http.MethodPatch, "/move/{uuid}/to/{steamid:[0-9]+}/{slot:[0-9]+}", controller.Plannable(con.UnsafeMoveCharacter))
+				r.Method(http.MethodPatch, "/copy/{uuid}/to/{steamid:[0-9]+}/{slot:[0-9]+}", controller.Plannable(con.UnsafeCopyCharacter))
+				r.Method(http.MethodDelete, "/delete/{uuid}", controller.Plannable(con.UnsafeDeleteCharacter))
 			})
 
+			r.Route("/journal", func(r chi.Router) {
+				r.Get("/", con.GetJournal)
+				r.Get("/{id:[0-9]+}", con.GetJournalEntry)
+				r.Method(http.MethodPatch, "/{id:[0-9]+}/revert", controller.Plannable(con.RevertJournalEntry))
+			})
+
+			r.Route("/approvals", func(r chi.Router) {
+				r.Get("/", con.GetApprovals)
+				r.Get("/{id:[0-9]+}", con.GetApproval)
+				r.Method(http.MethodPatch, "/{id:[0-9]+}/approve", controller.Plannable(con.ApproveRequest))
+				r.Method(http.MethodPatch, "/{id:[0-9]+}/reject", controller.Plannable(con.RejectRequest))
+			})
+
+			r.Route("/jobs", func(r chi.Router) {
+				r.Get("/", con.GetJobs)
+				r.Get("/{uuid}", con.GetJob)
+				r.Post("/{kind}", con.PostJob)
+			})
+
+			r.Get("/changes", con.GetChanges)
+
+			r.Route("/webhooks", func(r chi.Router) {
+				r.Get("/deliveries", con.GetWebhookDeliveries)
+			})
+
+			r.Route("/audit", func(r chi.Router) {
+				r.Get("/", con.GetAuditLog)
+				r.Get("/verify", con.GetAuditVerify)
+			})
+
+			r.Get("/flags", con.GetFlags)
+
 			r.Route("/user", func(r chi.Router) {
+				r.Get("/search", con.SearchUsers)
 				r.Get("/{steamid:[0-9]+}", con.GetUser)
-
-				r.Patch("/ban/{steamid:[0-9]+}", con.PatchBanSteamID)
-				r.Patch("/unban/{steamid:[0-9]+}", con.PatchUnBanSteamID)
-				r.Patch("/admin/{steamid:[0-9]+}", con.PatchAdminSteamID)
-				r.Patch("/unadmin/{steamid:[0-9]+}", con.PatchUnAdminSteamID)
-				r.Patch("/donor/{steamid:[0-9]+}", con.PatchDonorSteamID)
-				r.Patch("/undonor/{steamid:[0-9]+}", con.PatchUnDonorSteamID)
+				r.Method(http.MethodPut, "/{steamid:[0-9]+}/flags/{name}", controller.Plannable(con.PutUserFlag))
+				r.Method(http.MethodDelete, "/{steamid:[0-9]+}/flags/{name}", controller.Plannable(con.DeleteUserFlag))
+				r.Method(http.MethodPost, "/flags/bulk", controller.Plannable(con.PostBulkUserFlags))
+				r.Get("/{steamid:[0-9]+}/notes", con.GetUserNotes)
+				r.Method(http.MethodPost, "/{steamid:[0-9]+}/notes", controller.Plannable(con.PostUserNote))
+
+				r.Method(http.MethodPatch, "/ban/{steamid:[0-9]+}", controller.Plannable(con.PatchBanSteamID))
+				r.Method(http.MethodPatch, "/unban/{steamid:[0-9]+}", controller.Plannable(con.PatchUnBanSteamID))
+				r.Get("/bans/{steamid:[0-9]+}", con.GetBans)
+				r.Method(http.MethodPatch, "/merge/{from:[0-9]+}/into/{to:[0-9]+}", controller.Plannable(con.PatchMergeUsers))
+
+				// Aliases of /user/{steamid}/flags/{name} from before the flag registry.
+				r.Method(http.MethodPatch, "/admin/{steamid:[0-9]+}", controller.Plannable(con.PatchAdminSteamID))
+				r.Method(http.MethodPatch, "/unadmin/{steamid:[0-9]+}", controller.Plannable(con.PatchUnAdminSteamID))
+				r.Method(http.MethodPatch, "/donor/{steamid:[0-9]+}", controller.Plannable(con.PatchDonorSteamID))
+				r.Method(http.MethodPatch, "/undonor/{steamid:[0-9]+}", controller.Plannable(con.PatchUnDonorSteamID))
+				r.Get("/isdonor/{steamid:[0-9]+}", con.GetIsDonorSteamID)
 
 				if flags.debug {
 					r.Get("/list", con.GetAllUsers)
 				}
 			})
 
-			r.Get("/refresh", func(w http.ResponseWriter, r *http.Request) {
-				if err := a.LoadLists(); err != nil {
-					response.Error(w, err)
-					return
-				}
-
-				response.OK(w, true)
-			})
+			r.Get("/refresh", con.GetRefresh)
+			r.Post("/refresh", con.PostRefresh)
+			r.Method(http.MethodPatch, "/refresh/export", controller.Plannable(con.PatchExportLists))
 
 			r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 				response.OK(w, true)
@@ -233,9 +318,23 @@ func Run(args []string) (error) {
 		})
 	})
 
+	if err := con.FindPlannableRoutes(router); err != nil {
+		a.Logger.Error("Failed to find the routes that can plan", "error", err)
+		return err
+	}
+
 	/////////////////////////
 	// Application Startup
 	/////////////////////////
+	fmt.Println("-> Starting Jobs...")
+	if err := service.StartJobs(); err != nil {
+		a.Logger.Error("Failed to start jobs", "error", err)
+		return err
+	}
+
+	fmt.Println("-> Starting Webhooks...")
+	service.StartWebhooks()
+
 	fmt.Println("-> Starting Application...")
 	if err := a.Start(router); err != nil {
 		a.Logger.Error("Failed to start application", "error", err)
@@ -250,6 +349,8 @@ func Run(args []string) (error) {
 	signal.Notify(s, syscall.SIGINT, syscall.SIGTERM)
 	<-s
 
+	service.StopJobs()
+	service.StopWebhooks()
 	if err := a.Close(); err != nil {
 		a.Logger.Error("Failed to close application", "error", err)
 		return err
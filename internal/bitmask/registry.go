@@ -0,0 +1,122 @@
+package bitmask
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Flag is a named bit of the user flags, flags other than the built in ones are defined
+// in the config so adding one doesn't need a recompile.
+type Flag struct {
+	Name string `json:"name"`
+	Bit uint `json:"bit"`
+	Description string `json:"description,omitempty"`
+	Game bool `json:"game"` //whether the game server is sent this flag
+}
+
+func (f Flag) Mask() Bitmask {
+	return 1 << f.Bit
+}
+
+// Builtin flags always exist, the config may change their description and whether the
+// game server sees them but not their bit.
+var Builtin = []Flag{
+	{Name: "banned", Bit: 0, Description: "Banned from FN.", Game: true},
+	{Name: "donor", Bit: 1, Description: "Donated to the project.", Game: true},
+	{Name: "admin", Bit: 2, Description: "FN game master.", Game: true},
+}
+
+type Registry struct {
+	flags []Flag
+	byName map[string]Flag
+}
+
+// NewRegistry builds the registry from the built in flags and the configured ones.
+func NewRegistry(flags []Flag) (*Registry, error) {
+	r := &Registry{
+		byName: make(map[string]Flag),
+	}
+
+	builtin := make(map[string]Flag, len(Builtin))
+	for _, f := range Builtin {
+		builtin[f.Name] = f
+	}
+
+	byBit := make(map[uint]string)
+	add := func(f Flag) error {
+		if f.Name == "" {
+			return fmt.Errorf("flag with bit %d has no name", f.Bit)
+		}
+		if f.Bit > 31 {
+			return fmt.Errorf("flag %s: bit %d is out of range, must be 0-31", f.Name, f.Bit)
+		}
+		if _, ok := r.byName[f.Name]; ok {
+			return fmt.Errorf("flag %s is defined more than once", f.Name)
+		}
+		if other, ok := byBit[f.Bit]; ok {
+			return fmt.Errorf("flag %s: bit %d is already used by %s", f.Name, f.Bit, other)
+		}
+
+		byBit[f.Bit] = f.Name
+		r.byName[f.Name] = f
+		r.flags = append(r.flags, f)
+		return nil
+	}
+
+	overrides := make(map[string]Flag)
+	for _, f := range flags {
+		if b, ok := builtin[f.Name]; ok {
+			if f.Bit != b.Bit {
+				return nil, fmt.Errorf("flag %s is built in and must use bit %d", f.Name, b.Bit)
+			}
+			if _, ok := overrides[f.Name]; ok {
+				return nil, fmt.Errorf("flag %s is defined more than once", f.Name)
+			}
+			overrides[f.Name] = f
+		}
+	}
+
+	for _, f := range Builtin {
+		if o, ok := overrides[f.Name]; ok {
+			f = o
+		}
+		if err := add(f); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range flags {
+		if _, ok := builtin[f.Name]; ok {
+			continue
+		}
+		if err := add(f); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(r.flags, func(i, j int) bool {
+		return r.flags[i].Bit < r.flags[j].Bit
+	})
+	return r, nil
+}
+
+// Lookup returns the flag with the given name.
+func (r *Registry) Lookup(name string) (Flag, bool) {
+	f, ok := r.byName[name]
+	return f, ok
+}
+
+// Flags returns every registered flag ordered by bit.
+func (r *Registry) Flags() []Flag {
+	return append([]Flag(nil), r.flags...)
+}
+
+// GameMask has the bits of every flag the game server is sent.
+func (r *Registry) GameMask() Bitmask {
+	var mask Bitmask
+	for _, f := range r.flags {
+		if f.Game {
+			mask.AddFlag(f.Mask())
+		}
+	}
+	return mask
+}
@@ -6,7 +6,9 @@ import (
 	"time"
 	"path/filepath"
 
+	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/webhook"
   
 	"gopkg.in/ini.v1"
 	"gopkg.in/yaml.v3"
@@ -35,6 +37,26 @@ type Config struct {
 		IPListFile string
 		UserAgent string
 	}
+	Approval struct {
+		Endpoints []string
+		Expiry string
+	}
+	Audit struct {
+		Key string
+	}
+	Jobs struct {
+		Workers int
+		Retention string
+	}
+	Changes struct {
+		Retention string
+		MaxWait string
+	}
+	Webhooks struct {
+		Endpoints []webhook.Endpoint
+		MaxAttempts int
+		Retention string
+	}
 	Verify struct {
 		EnforceBan bool
 		EnforceMap bool
@@ -42,12 +64,21 @@ type Config struct {
 		MapListFile string
 		BanListFile string
 		AdminListFile string
+		ListSync string
 		ScriptsHash uint32
 	}
 	Char struct {
 		MaxBackups int
+		MaxSnapshots int
 		BackupTime time.Duration
 		DeletedExpireTime string
+		JournalRetention string
+	}
+	Flags []bitmask.Flag
+	Merge struct {
+		SlotPolicy string
+		MaxSlots int
+		Flags map[string]string
 	}
 	Log struct {
 		Level string
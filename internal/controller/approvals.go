@@ -0,0 +1,326 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// approvedKey marks a request that's being run after it was approved.
+type approvedKey struct{}
+
+// Approval holds requests to the endpoints in the approval config until a second admin
+// approves them, the request is answered with the pending approval instead. Dry runs to
+// routes that can plan go through since they only show what the request would do.
+func (c *Controller) Approval(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Context().Value(approvedKey{}) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		endpoint, _ := matchRoute(r)
+		if dryRun(r) {
+			if c.checkDryRun(w, r, endpoint) {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+		if endpoint == "" || !c.needsApproval(r.Method, endpoint) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		by, ip, ok := approvalCaller(r)
+		if !ok {
+			c.logger.Warn("controller: approval needs a system admin", "endpoint", endpoint, "ip", utils.GetIP(r))
+			response.Forbidden(w, static.ErrNotSystemAdmin)
+			return
+		}
+
+		var buf bytes.Buffer
+		if size, err := io.Copy(&buf, r.Body); err != nil {
+			c.logger.Error("failed to copy body", "error", err, "size", size, "expectedSize", r.ContentLength)
+			response.Error(w, err)
+			return
+		}
+
+		approval, err := c.service.RequestApproval(&schema.Approval{
+			Method: r.Method,
+			Path: r.URL.RequestURI(),
+			Endpoint: endpoint,
+			Body: buf.String(),
+			Reason: r.Header.Get(AuditReasonHeader),
+			RequestedBy: by,
+			RequestedIP: ip,
+		})
+		if err != nil {
+			if errors.Is(err, static.ErrApprovalReason) {
+				c.logger.Warn("service warning", "error", err)
+				response.BadRequest(w, err)
+				return
+			}
+
+			c.logger.Error("service failed", "error", err)
+			response.Error(w, err)
+			return
+		}
+
+		// Nothing is held in read only mode, the request does nothing anyway.
+		if approval == nil {
+			r.Body = io.NopCloser(&buf)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c.logger.Info("request is waiting for approval", "id", approval.ID, "endpoint", endpoint, "by", approval.RequestedBy)
+		response.StillProcessing(w, approval)
+	})
+}
+
+// needsApproval checks the endpoint against the approval config. Rules are "METHOD /route"
+// or "/route" for any method, relative to the API version, and a trailing * matches the
+// rest of the route.
+func (c *Controller) needsApproval(method string, endpoint string) bool {
+	endpoint = strings.TrimPrefix(endpoint, static.APIVersion)
+
+	for _, rule := range c.config.Approval.Endpoints {
+		m, pattern, ok := strings.Cut(strings.TrimSpace(rule), " ")
+		if !ok {
+			m, pattern = "", m
+		}
+		if m != "" && !strings.EqualFold(m, method) {
+			continue
+		}
+
+		pattern = strings.TrimSpace(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(endpoint, prefix) {
+				return true
+			}
+		} else if endpoint == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GET /approvals?status=pending&limit=50
+func (c *Controller) GetApprovals(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, errors.New("limit must be a positive number"))
+			return
+		}
+	}
+
+	approvals, err := c.service.GetApprovals(r.URL.Query().Get("status"), limit)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, approvals)
+}
+
+// GET /approvals/{id:[0-9]+}
+func (c *Controller) GetApproval(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	approval, err := c.service.GetApproval(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoDocument) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, approval)
+}
+
+// PATCH /approvals/{id:[0-9]+}/approve
+// Runs the held request as the approving admin and answers with its response. A dry run
+// leaves the approval pending and answers with the held request's own dry run.
+func (c *Controller) ApproveRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	by, ip, ok := approvalCaller(r)
+	if !ok {
+		c.logger.Warn("controller: approval needs a system admin", "id", id, "ip", utils.GetIP(r))
+		response.Forbidden(w, static.ErrNotSystemAdmin)
+		return
+	}
+
+	if dryRun(r) {
+		approval, err := c.service.PlanDecision(id, database.ApprovalApproved, by, ip)
+		if err != nil {
+			c.writePlan(w, nil, err)
+			return
+		}
+
+		writeRecorded(w, c.runApproved(r, approval, true))
+		return
+	}
+
+	approval, err := c.service.ApproveRequest(id, by, ip)
+	if err != nil {
+		if c.approvalWarning(w, err) {
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+	if approval == nil {
+		response.OKNoContent(w)
+		return
+	}
+
+	rec := c.runApproved(r, approval, false)
+	if err := c.service.SetApprovalResult(approval, rec.Code, rec.Body.String()); err != nil {
+		c.logger.Error("service failed", "error", err)
+	}
+
+	writeRecorded(w, rec)
+}
+
+// PATCH /approvals/{id:[0-9]+}/reject
+func (c *Controller) RejectRequest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	by, ip, ok := approvalCaller(r)
+	if !ok {
+		c.logger.Warn("controller: approval needs a system admin", "id", id, "ip", utils.GetIP(r))
+		response.Forbidden(w, static.ErrNotSystemAdmin)
+		return
+	}
+
+	if dryRun(r) {
+		approval, err := c.service.PlanDecision(id, database.ApprovalRejected, by, ip)
+		c.writePlan(w, &payload.Plan{
+			Operation: "reject",
+			Result: approval,
+		}, err)
+		return
+	}
+
+	approval, err := c.service.RejectRequest(id, by, ip)
+	if err != nil {
+		if c.approvalWarning(w, err) {
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+	if approval == nil {
+		response.OKNoContent(w)
+		return
+	}
+
+	response.OK(w, approval)
+}
+
+func (c *Controller) approvalWarning(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, database.ErrNoDocument),
+		errors.Is(err, static.ErrApprovalNotPending),
+		errors.Is(err, static.ErrApprovalExpired),
+		errors.Is(err, static.ErrSelfApproval):
+		c.logger.Warn("service warning", "error", err)
+		response.BadRequest(w, err)
+		return true
+	}
+	return false
+}
+
+// runApproved sends the held request through the router again as the approving admin,
+// so it passes the same auth and is recorded in the audit log under their name.
+func (c *Controller) runApproved(r *http.Request, approval *schema.Approval, dry bool) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+
+	router, ok := chi.RouteContext(r.Context()).Routes.(http.Handler)
+	if !ok {
+		response.GenericError(rec)
+		return rec
+	}
+
+	// A nil route context makes the router route the request from the start.
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, (*chi.Context)(nil))
+	ctx = context.WithValue(ctx, approvedKey{}, approval.ID)
+
+	target, err := url.ParseRequestURI(approval.Path)
+	if err != nil {
+		response.Error(rec, err)
+		return rec
+	}
+	if dry {
+		q := target.Query()
+		q.Set("dry_run", "true")
+		target.RawQuery = q.Encode()
+	}
+
+	req := r.Clone(ctx)
+	req.Method = approval.Method
+	req.URL.Path = target.Path
+	req.URL.RawPath = target.RawPath
+	req.URL.RawQuery = target.RawQuery
+	req.RequestURI = target.RequestURI()
+	req.Body = io.NopCloser(strings.NewReader(approval.Body))
+	req.ContentLength = int64(len(approval.Body))
+	req.Header.Set(AuditReasonHeader, approval.Reason)
+
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func writeRecorded(w http.ResponseWriter, rec *httptest.ResponseRecorder) {
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/middleware"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
+
+	"github.com/go-chi/chi/v5"
+	cmw "github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+)
+
+// AuditReasonHeader lets the caller say why they made the request, it's kept in the audit log.
+const AuditReasonHeader = "X-Audit-Reason"
+
+// auditedKey points at a flag the handler sets once it has recorded its own audit entries,
+// so Audit doesn't record the request as well.
+type auditedKey struct{}
+
+// Audit records every request that can change something in the audit log, along with
+// the state of the user and character it targets before and after the request. Dry runs
+// to routes that can plan don't change anything so they aren't recorded.
+func (c *Controller) Audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The target's state has to be taken before the handler changes it.
+		endpoint, tctx := matchRoute(r)
+		if dryRun(r) {
+			if c.checkDryRun(w, r, endpoint) {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+		if endpoint == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		steamid := tctx.URLParam("steamid")
+		if steamid == "" {
+			steamid = tctx.URLParam("from")
+		}
+		var charID *uuid.UUID
+		if uid, err := uuid.Parse(tctx.URLParam("uuid")); err == nil {
+			charID = &uid
+		}
+
+		entry := &schema.AuditEntry{
+			CreatedAt: time.Now().UTC(),
+			IP: utils.GetIP(r),
+			KeyID: keyID(r.Header.Get("Authorization")),
+			Method: r.Method,
+			Endpoint: endpoint,
+			Path: r.URL.Path,
+			SteamID: steamid,
+			CharacterID: charID,
+			Reason: r.Header.Get(AuditReasonHeader),
+		}
+		entry.Before = c.service.AuditState(steamid, charID)
+
+		audited := false
+		r = r.WithContext(context.WithValue(r.Context(), auditedKey{}, &audited))
+
+		ww := cmw.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		if audited {
+			return
+		}
+
+		entry.Status = ww.Status()
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.After = c.service.AuditState(steamid, charID)
+
+		if err := c.service.RecordAudit(entry); err != nil {
+			c.logger.Error("audit: failed to record request", "endpoint", endpoint, "path", entry.Path, "error", err)
+		}
+	})
+}
+
+// auditFlagChanges records an audit entry for every user whose flags the request changed,
+// in place of the one entry Audit would record for the request.
+func (c *Controller) auditFlagChanges(r *http.Request, results []payload.BulkFlagResult) {
+	if audited, ok := r.Context().Value(auditedKey{}).(*bool); ok {
+		*audited = true
+	}
+
+	endpoint, _ := matchRoute(r)
+	for _, res := range results {
+		if !res.Changed {
+			continue
+		}
+
+		before, after := res.Before, res.After
+		entry := &schema.AuditEntry{
+			CreatedAt: time.Now().UTC(),
+			IP: utils.GetIP(r),
+			KeyID: keyID(r.Header.Get("Authorization")),
+			Method: r.Method,
+			Endpoint: endpoint,
+			Path: r.URL.Path,
+			Status: http.StatusOK,
+			SteamID: res.SteamID,
+			Reason: r.Header.Get(AuditReasonHeader),
+			Before: &schema.AuditState{Flags: &before},
+			After: &schema.AuditState{Flags: &after},
+		}
+		if err := c.service.RecordAudit(entry); err != nil {
+			c.logger.Error("audit: failed to record flag change", "steamid", res.SteamID, "error", err)
+		}
+	}
+}
+
+// GET /audit?steamid=&uuid=&ip=&key=&endpoint=&since=&until=&before=&limit=50
+func (c *Controller) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := database.AuditFilter{
+		SteamID: q.Get("steamid"),
+		IP: q.Get("ip"),
+		KeyID: q.Get("key"),
+		Endpoint: q.Get("endpoint"),
+		Limit: 50,
+	}
+
+	if l := q.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, errors.New("limit must be a positive number"))
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if b := q.Get("before"); b != "" {
+		id, err := strconv.ParseInt(b, 10, 64)
+		if err != nil {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+		filter.BeforeID = id
+	}
+
+	if u := q.Get("uuid"); u != "" {
+		uid, err := uuid.Parse(u)
+		if err != nil {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+		filter.CharacterID = &uid
+	}
+
+	for param, dst := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.logger.Error("controller: bad request", "error", err)
+				response.BadRequest(w, err)
+				return
+			}
+			*dst = &t
+		}
+	}
+
+	entries, err := c.service.GetAuditLog(filter)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, entries)
+}
+
+// GET /audit/verify
+func (c *Controller) GetAuditVerify(w http.ResponseWriter, r *http.Request) {
+	result, err := c.service.VerifyAuditLog()
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	if !result.OK {
+		c.logger.Warn("audit log hash chain is broken", "entry", result.BrokenAt)
+	}
+
+	response.OK(w, result)
+}
+
+// matchRoute finds the route pattern and URL params of the request. They're only parsed
+// once the request reaches its route, which is too late for middleware.
+func matchRoute(r *http.Request) (string, *chi.Context) {
+	tctx := chi.NewRouteContext()
+
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.Routes == nil {
+		return "", tctx
+	}
+
+	return rctx.Routes.Find(tctx, r.Method, r.URL.Path), tctx
+}
+
+// callerID identifies the admin making the request by their API key, or by their IP
+// when they don't send one.
+func callerID(r *http.Request) string {
+	if id := keyID(r.Header.Get("Authorization")); id != "" {
+		return id
+	}
+	return "ip:" + utils.GetIP(r)
+}
+
+// approvalCaller identifies the admin holding or deciding an approval. Unlike callerID
+// it doesn't trust whatever key the caller sends, ok is false unless the request came from
+// a system admin's IP with that admin's API key.
+func approvalCaller(r *http.Request) (id string, ip string, ok bool) {
+	if !middleware.IsSystemAdmin(r) {
+		return "", "", false
+	}
+	return keyID(r.Header.Get("Authorization")), utils.GetIP(r), true
+}
+
+// keyID fingerprints the API key so entries made with the same key can be found
+// without the audit log holding the key.
+func keyID(key string) string {
+	if key == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
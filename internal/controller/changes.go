@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/pkg/utils"
+)
+
+// defaultChangeWait is the longest a /changes request waits when changes.maxwait isn't set.
+const defaultChangeWait = 25 * time.Second
+
+// GET /changes?since=0&limit=50&wait=20s
+// Answers with the changes after the cursor since, oldest first. With wait set and nothing
+// new yet the request is held until a change is made or the wait runs out. Pass next back
+// as since to carry on from where the last request stopped. reset is set when since was
+// already pruned, reload everything and carry on from next.
+func (c *Controller) GetChanges(w http.ResponseWriter, r *http.Request) {
+	q := searchQuery{values: r.URL.Query()}
+	limit := q.limit()
+	if q.err != nil {
+		c.logger.Error("controller: bad request", "error", q.err)
+		response.BadRequest(w, q.err)
+		return
+	}
+
+	var since int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		since, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || since < 0 {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, errors.New("since must be a change ID"))
+			return
+		}
+	}
+
+	var wait time.Duration
+	if v := r.URL.Query().Get("wait"); v != "" {
+		var err error
+		wait, err = utils.ParseDuration(v)
+		if err != nil || wait < 0 {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, errors.New("wait must be a duration, e.g. 20s"))
+			return
+		}
+	}
+	if max := c.maxChangeWait(); wait > max {
+		wait = max
+	}
+
+	page, err := c.service.WaitChanges(r.Context(), since, limit, wait)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, page)
+}
+
+// maxChangeWait is changes.maxwait, kept a second under the request timeout so a held
+// request still gets its answer.
+func (c *Controller) maxChangeWait() time.Duration {
+	max := defaultChangeWait
+	if c.config.Changes.MaxWait != "" {
+		if d, err := utils.ParseDuration(c.config.Changes.MaxWait); err != nil {
+			c.logger.Warn("Unable to parse change feed max wait", "error", err)
+		} else {
+			max = d
+		}
+	}
+
+	if timeout := time.Duration(c.config.Core.Timeout) * time.Second; timeout > 0 && max > timeout-time.Second {
+		max = timeout - time.Second
+	}
+	return max
+}
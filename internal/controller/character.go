@@ -5,11 +5,13 @@ import (
 	"net/http"
 	"io"
 	"strconv"
+	"strings"
 	"errors"
 
 	"github.com/msrevive/nexus2/internal/payload"
 	"github.com/msrevive/nexus2/internal/response"
 	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/static"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -36,6 +38,7 @@ func (c *Controller) LookUpCharacterID(w http.ResponseWriter, r *http.Request) {
 }
 
 // PATCH /character/restore/{uuid}
+// PATCH /character/restore/{uuid}/to/{slot:[0-9]+}
 func (c *Controller) RestoreCharacter(w http.ResponseWriter, r *http.Request) {
 	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
 	if err != nil {
@@ -44,7 +47,31 @@ func (c *Controller) RestoreCharacter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := c.service.RestoreCharacter(uid); err != nil {
+	var slot *int
+	if s := chi.URLParam(r, "slot"); s != "" {
+		target, err := strconv.Atoi(s)
+		if err != nil {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+		slot = &target
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanRestoreCharacter(uid, slot)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	if err := c.service.RestoreCharacter(uid, slot, callerID(r)); err != nil {
+		var occupied *database.SlotOccupiedError
+		if errors.As(err, &occupied) {
+			c.logger.Warn("service warning", "error", err)
+			response.Conflict(w, err, occupied)
+			return
+		}
+
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
@@ -53,6 +80,43 @@ func (c *Controller) RestoreCharacter(w http.ResponseWriter, r *http.Request) {
 	response.OK(w, uid.String())
 }
 
+// PATCH /character/swap/{steamid:[0-9]+}/{a:[0-9]+}/{b:[0-9]+}
+func (c *Controller) SwapCharacters(w http.ResponseWriter, r *http.Request) {
+	steamid := chi.URLParam(r, "steamid")
+	a, err := strconv.Atoi(chi.URLParam(r, "a"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+	b, err := strconv.Atoi(chi.URLParam(r, "b"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanSwapCharacters(steamid, a, b)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	if err := c.service.SwapCharacters(steamid, a, b); err != nil {
+		if errors.Is(err, database.ErrNoDocument) || errors.Is(err, static.ErrSameSlot) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OKNoContent(w)
+}
+
 // GET /character/export/{uuid}
 func (c *Controller) ExportCharacter(w http.ResponseWriter, r *http.Request) {
 	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
@@ -81,6 +145,109 @@ func (c *Controller) ExportCharacter(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, file)
 }
 
+// GET /character/export/user/{steamid:[0-9]+}?deleted=true&versions=true
+func (c *Controller) ExportUserCharacters(w http.ResponseWriter, r *http.Request) {
+	steamid := chi.URLParam(r, "steamid")
+	deleted, _ := strconv.ParseBool(r.URL.Query().Get("deleted"))
+	versions, _ := strconv.ParseBool(r.URL.Query().Get("versions"))
+
+	chars, err := c.service.GetCharacterArchive(steamid, deleted)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", steamid+".zip"))
+	if err := payload.WriteCharArchive(w, steamid, chars, versions); err != nil {
+		// headers are already sent at this point so all we can do is log it.
+		c.logger.Error("character archive generation failed", "steamid", steamid, "error", err)
+	}
+}
+
+// POST /character/import/{steamid:[0-9]+}/{slot:[0-9]+}
+// Accepts either a multipart upload with a "file" field or the raw file as the body.
+func (c *Controller) ImportCharacter(w http.ResponseWriter, r *http.Request) {
+	steamid := chi.URLParam(r, "steamid")
+	slot, err := strconv.Atoi(chi.URLParam(r, "slot"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	var rd io.Reader = r.Body
+	filename := ""
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+		defer file.Close()
+
+		rd = file
+		filename = header.Filename
+	}
+
+	data, size, err := payload.ParseCharFile(rd, filename)
+	if err != nil {
+		c.logger.Error("controller: bad character file", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanImportCharacter(steamid, slot, size, data)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	note := "imported from upload"
+	if filename != "" {
+		note = fmt.Sprintf("imported from %s", filename)
+	}
+
+	uid, created, err := c.service.ImportCharacter(steamid, slot, size, data, note, callerID(r))
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.Created(w, payload.CharacterImport{
+		ID: uid,
+		Created: created,
+	})
+}
+
+// GET /character/{uuid}/lineage
+func (c *Controller) GetCharacterLineage(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	lineage, err := c.service.GetLineage(uid)
+	if err != nil {
+		if errors.Is(err, database.ErrNoDocument) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, lineage)
+}
+
 // GET /character/{uuid}
 func (c *Controller) GetCharacterByIDExternal(w http.ResponseWriter, r *http.Request) {
 	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
@@ -105,10 +272,17 @@ func (c *Controller) GetCharacterByIDExternal(w http.ResponseWriter, r *http.Req
 }
 
 // GET /character/deleted/{steamid:[0-9]+}
+// responds with the character IDs by slot, or with their summaries when ?details=true
 func (c *Controller) GetDeletedCharacters(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
 
-	chars, err := c.service.GetDeletedCharacters(steamid)
+	var chars interface{}
+	var err error
+	if details, _ := strconv.ParseBool(r.URL.Query().Get("details")); details {
+		chars, err = c.service.GetDeletedCharacterSummaries(steamid)
+	} else {
+		chars, err = c.service.GetDeletedCharacters(steamid)
+	}
 	if err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
@@ -118,6 +292,95 @@ func (c *Controller) GetDeletedCharacters(w http.ResponseWriter, r *http.Request
 	response.OK(w, chars)
 }
 
+// PATCH /character/deleted/{uuid}/expiry
+//body: {"duration": "30d"} or {"expires_at": "2026-01-02T15:04:05Z"}, duration counts from now
+func (c *Controller) PatchDeletedExpiry(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	var req payload.DeletedExpiry
+	if err := readOptionalJSON(r, &req); err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanSetDeletedExpiry(uid, req)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	expiresAt, err := c.service.SetDeletedExpiry(uid, req)
+	if err != nil {
+		if errors.Is(err, static.ErrNotDeleted) || errors.Is(err, static.ErrBadDeletedExpiry) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, expiresAt)
+}
+
+// DELETE /character/deleted/{uuid}
+func (c *Controller) PurgeDeletedCharacter(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanPurgeDeletedCharacter(uid)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	if err := c.service.PurgeDeletedCharacter(uid, callerID(r)); err != nil {
+		if errors.Is(err, static.ErrNotDeleted) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, uid)
+}
+
+// PATCH /character/deleted/{steamid:[0-9]+}/restore
+func (c *Controller) RestoreDeletedCharacters(w http.ResponseWriter, r *http.Request) {
+	steamid := chi.URLParam(r, "steamid")
+
+	if dryRun(r) {
+		plan, err := c.service.PlanRestoreDeletedCharacters(steamid)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	results, err := c.service.RestoreDeletedCharacters(steamid, callerID(r))
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, results)
+}
+
 // GET /character/{steamid:[0-9]+}
 func (c *Controller) GetCharacters(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
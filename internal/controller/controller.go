@@ -1,14 +1,16 @@
 package controller
 
 import (
+	"bytes"
+	"io"
 	"strconv"
 	"net/http"
 	"log/slog"
 
 	"github.com/msrevive/nexus2/internal/config"
 	"github.com/msrevive/nexus2/internal/service"
-	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/pkg/utils"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/saintwish/kv/ccmap"
@@ -16,6 +18,11 @@ import (
 
 type Options struct {
 	MapList *ccmap.Cache[string, uint32]
+
+	LoadLists func() error // reloads the IP, map and system admin lists
+	SyncLists func() error // syncs the FN ban and admin lists into the users, nil when read only
+	GameLists func() (bans map[string]string, admins map[string]string, err error)
+	ExportLists func() error // writes the users back to the FN ban and admin lists
 }
 
 type Controller struct {
@@ -23,6 +30,8 @@ type Controller struct {
 	config *config.Config
 	service *service.Service
 	mapList *ccmap.Cache[string, uint32]
+	lists Options
+	plannable map[string]bool // "METHOD pattern" of the routes with a Plannable handler
 }
 
 func New(service *service.Service, log *slog.Logger, cfg *config.Config, opts Options) *Controller {
@@ -31,6 +40,7 @@ func New(service *service.Service, log *slog.Logger, cfg *config.Config, opts Op
 		service: service,
 		config: cfg,
 		mapList: opts.MapList,
+		lists: opts,
 	}
 }
 
@@ -97,7 +107,7 @@ func (c *Controller) GetServerVerify(w http.ResponseWriter, r *http.Request) {
 }
 
 //GET ban/{steamid}
-//in this case false means player isn't banned
+//in this case false means player isn't banned, reason and expires_at are only set for recorded bans
 func (c *Controller) GetBanVerify(w http.ResponseWriter, r *http.Request) {
 	if !c.config.Verify.EnforceBan {
 		response.Result(w, false)
@@ -106,19 +116,30 @@ func (c *Controller) GetBanVerify(w http.ResponseWriter, r *http.Request) {
 	
 	steamid := chi.URLParam(r, "steamid")
 	
-	flags, err := c.service.GetUserFlags(steamid)
+	status, err := c.service.GetBanStatus(steamid)
 	if err != nil {
-		c.logger.Error("Unable to get user flags from SteamID", "IP", r.RemoteAddr, "SteamID", steamid)
+		c.logger.Error("Unable to get ban status from SteamID", "IP", r.RemoteAddr, "SteamID", steamid, "error", err)
 		response.GenericError(w)
 		return
 	}
 
-	if flags.HasFlag(bitmask.BANNED) {
-		c.logger.Warn("SteamID is banned from FN", "IP", r.RemoteAddr, "SteamID", steamid)
-		response.Result(w, true)
-		return
+	if status.Banned {
+		c.logger.Warn("SteamID is banned from FN", "IP", r.RemoteAddr, "SteamID", steamid, "reason", status.Reason)
 	}
 	
-	response.Result(w, false)
+	response.BanResult(w, status.Banned, status.Reason, status.ExpiresAt)
 	return
-}
\ No newline at end of file
+}
+
+// readOptionalJSON parses the request body into v, an empty body leaves v as is.
+func readOptionalJSON(r *http.Request, v interface{}) error {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r.Body); err != nil {
+		return err
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	return utils.ProcessJSON(buf.Bytes(), v)
+}
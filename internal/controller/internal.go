@@ -41,9 +41,16 @@ func (c *Controller) PostCharacter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The expiry is only informational, the character is still sent without it.
+	expiry, err := c.service.GetFlagExpiry(char.SteamID)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+	}
+
 	response.Created(w, payload.CharacterCreate{
 		ID: uid,
 		Flags: flags,
+		FlagExpiry: expiry,
 	})
 }
 
@@ -73,7 +80,7 @@ func (c *Controller) PutCharacter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := c.service.UpdateCharacter(uid, char); err != nil {
+	if err := c.service.UpdateCharacter(uid, char, utils.GetIP(r)); err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
@@ -103,6 +110,12 @@ func (c *Controller) GetCharacter(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The expiry is only informational, the character is still sent without it.
+	expiry, err := c.service.GetFlagExpiry(steamid)
+	if err != nil {
+		c.logger.Error("service error", "error", err)
+	}
+
 	response.OKChar(w, payload.Character{
 		ID: char.ID,
 		SteamID: char.SteamID,
@@ -110,6 +123,7 @@ func (c *Controller) GetCharacter(w http.ResponseWriter, r *http.Request) {
 		Size: char.Data.Size,
 		Data: char.Data.Data,
 		Flags: flags,
+		FlagExpiry: expiry,
 	})
 }
 
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/internal/static"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// GET /jobs?status=running&kind=mass_rollback&limit=50
+func (c *Controller) GetJobs(w http.ResponseWriter, r *http.Request) {
+	q := searchQuery{values: r.URL.Query()}
+	limit := q.limit()
+	if q.err != nil {
+		c.logger.Error("controller: bad request", "error", q.err)
+		response.BadRequest(w, q.err)
+		return
+	}
+
+	jobs, err := c.service.GetJobs(r.URL.Query().Get("status"), r.URL.Query().Get("kind"), limit)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, jobs)
+}
+
+// GET /jobs/{uuid}
+func (c *Controller) GetJob(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	job, err := c.service.GetJob(uid)
+	if err != nil {
+		if errors.Is(err, database.ErrNoDocument) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, job)
+}
+
+// POST /jobs/{kind}
+// The body is the job's JSON params, jobs like gc take none. Answers with the queued job,
+// poll GET /jobs/{uuid} for its progress and result.
+func (c *Controller) PostJob(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if size, err := io.Copy(&buf, r.Body); err != nil {
+		c.logger.Error("failed to copy body", "error", err, "size", size, "expectedSize", r.ContentLength)
+		response.Error(w, err)
+		return
+	}
+
+	job, err := c.service.SubmitJob(chi.URLParam(r, "kind"), bytes.TrimSpace(buf.Bytes()), callerID(r))
+	if err != nil {
+		for _, known := range []error{
+			static.ErrUnknownJobKind, static.ErrJobQueueFull, static.ErrBadTimeWindow, static.ErrMassRollbackRunning,
+		} {
+			if errors.Is(err, known) {
+				c.logger.Warn("service warning", "error", err)
+				response.BadRequest(w, err)
+				return
+			}
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	// Nothing runs in read only mode.
+	if job == nil {
+		response.NotAvailable(w)
+		return
+	}
+
+	response.StillProcessing(w, job)
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/internal/static"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// GET /journal?limit=50
+func (c *Controller) GetJournal(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, errors.New("limit must be a positive number"))
+			return
+		}
+	}
+
+	entries, err := c.service.GetJournal(limit)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, entries)
+}
+
+// GET /journal/{id:[0-9]+}
+func (c *Controller) GetJournalEntry(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	entry, err := c.service.GetJournalEntry(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNoDocument) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, entry)
+}
+
+// PATCH /journal/{id:[0-9]+}/revert
+func (c *Controller) RevertJournalEntry(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanRevertJournalEntry(id)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	entry, err := c.service.RevertJournalEntry(id, callerID(r))
+	if err != nil {
+		if errors.Is(err, database.ErrNoDocument) || errors.Is(err, database.ErrAlreadyReverted) ||
+			errors.Is(err, database.ErrSlotOccupied) || errors.Is(err, static.ErrJournalExpired) ||
+			errors.Is(err, database.ErrMovedSince) || errors.Is(err, database.ErrNoOwner) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, entry)
+}
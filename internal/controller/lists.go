@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/pkg/utils"
+)
+
+// DEPRECIATED: GET /refresh, use POST /refresh
+func (c *Controller) GetRefresh(w http.ResponseWriter, r *http.Request) {
+	c.logger.Warn("GET /refresh is depreciated, use POST /refresh", "ip", utils.GetIP(r))
+	c.PostRefresh(w, r)
+}
+
+// POST /refresh
+func (c *Controller) PostRefresh(w http.ResponseWriter, r *http.Request) {
+	if err := c.lists.LoadLists(); err != nil {
+		c.logger.Error("failed to load lists", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	if c.lists.SyncLists != nil {
+		if err := c.lists.SyncLists(); err != nil {
+			c.logger.Error("failed to sync FN lists", "error", err)
+			response.Error(w, err)
+			return
+		}
+	}
+
+	response.OK(w, true)
+}
+
+// PATCH /refresh/export
+// Writes the banned and admin users back to the FN ban and admin lists.
+func (c *Controller) PatchExportLists(w http.ResponseWriter, r *http.Request) {
+	if dryRun(r) {
+		bans, admins, err := c.lists.GameLists()
+		if err != nil {
+			c.logger.Error("failed to build FN lists", "error", err)
+			response.Error(w, err)
+			return
+		}
+
+		response.OK(w, payload.Plan{
+			Operation: "export_lists",
+			Result: map[string]map[string]string{
+				"bans": bans,
+				"admins": admins,
+			},
+		})
+		return
+	}
+
+	if err := c.lists.ExportLists(); err != nil {
+		c.logger.Error("failed to export FN lists", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, true)
+}
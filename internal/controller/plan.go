@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/internal/static"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// dryRun tells whether the request only asks what it would change, mutating endpoints
+// answer ?dry_run=true with a payload.Plan and leave everything as is.
+func dryRun(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return v
+}
+
+// plannable is a handler that answers dry runs with a plan, see Plannable.
+type plannable http.HandlerFunc
+
+func (h plannable) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h(w, r)
+}
+
+// Plannable marks a mutating route's handler as one that answers ?dry_run=true with a
+// plan. Audit and Approval let dry runs through to these without recording or holding
+// them, a dry run anywhere else is refused so it can't be used to get past either.
+func Plannable(h http.HandlerFunc) http.Handler {
+	return plannable(h)
+}
+
+// FindPlannableRoutes looks up the routes registered with a Plannable handler, call it
+// once every route has been registered.
+func (c *Controller) FindPlannableRoutes(routes chi.Routes) error {
+	c.plannable = make(map[string]bool)
+	return chi.Walk(routes, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if _, ok := handler.(plannable); !ok {
+			return nil
+		}
+
+		// Walk leaves a /* behind for every router mounted under another, Find doesn't.
+		for strings.Contains(route, "/*/") {
+			route = strings.ReplaceAll(route, "/*/", "/")
+		}
+		c.plannable[method+" "+route] = true
+		return nil
+	})
+}
+
+// checkDryRun answers a dry run to a route that can't plan with a bad request, ok is
+// false when it did. endpoint is the route pattern from matchRoute.
+func (c *Controller) checkDryRun(w http.ResponseWriter, r *http.Request, endpoint string) (ok bool) {
+	if endpoint == "" || c.plannable[r.Method+" "+endpoint] {
+		return true
+	}
+
+	c.logger.Warn("controller: bad request", "error", static.ErrNoDryRun, "endpoint", endpoint)
+	response.BadRequest(w, static.ErrNoDryRun)
+	return false
+}
+
+// writePlan answers a dry run, errors the request would fail with are bad requests.
+func (c *Controller) writePlan(w http.ResponseWriter, plan *payload.Plan, err error) {
+	if err == nil {
+		response.OK(w, plan)
+		return
+	}
+
+	var occupied *database.SlotOccupiedError
+	if errors.As(err, &occupied) {
+		c.logger.Warn("service warning", "error", err)
+		response.Conflict(w, err, occupied)
+		return
+	}
+
+	for _, known := range []error{
+		database.ErrNoDocument, database.ErrNoOperation, database.ErrAlreadyReverted,
+		database.ErrAlreadyMerged, database.ErrNoFreeSlot, database.ErrMovedSince, database.ErrNoOwner,
+		static.ErrSameSlot, static.ErrSameUser, static.ErrJournalExpired, static.ErrBadMergePolicy,
+		static.ErrUnknownFlag, static.ErrBadGrantExpiry, static.ErrBadBanDuration,
+		static.ErrEmptyNote, static.ErrBadNoteCategory,
+		static.ErrApprovalNotPending, static.ErrApprovalExpired, static.ErrSelfApproval,
+		static.ErrNoFlagChanges, static.ErrTooManyFlagChanges, static.ErrBadFlagOp, static.ErrBadSteamID,
+		static.ErrNotDeleted, static.ErrBadDeletedExpiry,
+	} {
+		if errors.Is(err, known) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+	}
+
+	c.logger.Error("service failed", "error", err)
+	response.Error(w, err)
+}
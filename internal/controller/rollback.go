@@ -1,12 +1,19 @@
 package controller
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"errors"
+	"time"
 
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
 	"github.com/msrevive/nexus2/internal/response"
 	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/utils"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -35,6 +42,61 @@ func (c *Controller) GetCharacterVersions(w http.ResponseWriter, r *http.Request
 	response.OK(w, data)
 }
 
+// GET /rollback/character/{uuid}/{version:[0-9]+}
+func (c *Controller) GetCharacterVersion(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	_, data, err := c.service.GetCharacterVersion(uid, chi.URLParam(r, "version"))
+	if errors.Is(err, static.ErrNoCharacterVersion) {
+		c.logger.Warn("service warning", "error", err)
+		response.BadRequest(w, err)
+		return
+	} else if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, data)
+}
+
+// GET /rollback/character/{uuid}/{version:[0-9]+}/export
+func (c *Controller) ExportCharacterVersion(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	char, data, err := c.service.GetCharacterVersion(uid, chi.URLParam(r, "version"))
+	if errors.Is(err, static.ErrNoCharacterVersion) {
+		c.logger.Warn("service warning", "error", err)
+		response.BadRequest(w, err)
+		return
+	} else if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	file, path, err := payload.GenerateCharFile(char.SteamID, char.Slot, data.Data)
+	if err != nil {
+		c.logger.Error("character file generation failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path))
+	io.Copy(w, file)
+}
+
 // PATCH /rollback/character/{uuid}/latest
 func (c *Controller) RollbackCharToLatest(w http.ResponseWriter, r *http.Request) {
 	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
@@ -44,8 +106,19 @@ func (c *Controller) RollbackCharToLatest(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if dryRun(r) {
+		plan, err := c.service.PlanRollbackCharacterToLatest(uid)
+		c.writePlan(w, plan, err)
+		return
+	}
+
 	// 0 will be the first backup
-	if err := c.service.RollbackCharacterToLatest(uid); err != nil {
+	err = c.service.RollbackCharacterToLatest(uid, callerID(r))
+	if errors.Is(err, database.ErrNoDocument) {
+		c.logger.Warn("service warning", "error", err)
+		response.NotFound(w, err)
+		return
+	} else if err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
@@ -54,6 +127,37 @@ func (c *Controller) RollbackCharToLatest(w http.ResponseWriter, r *http.Request
 	response.OKNoContent(w)
 }
 
+// PATCH /rollback/character/{uuid}/undo
+func (c *Controller) UndoCharacterOperation(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanUndoCharacterOperation(uid)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	op, err := c.service.UndoCharacterOperation(uid, c.config.Char.DeletedExpireTime, callerID(r))
+	if err != nil {
+		if errors.Is(err, database.ErrNoOperation) || errors.Is(err, database.ErrSlotOccupied) {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, op)
+}
+
 // PATCH /rollback/character/{uuid}/{version:[0-9]+}
 func (c *Controller) RollbackCharToVersion(w http.ResponseWriter, r *http.Request) {
 	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
@@ -62,14 +166,25 @@ func (c *Controller) RollbackCharToVersion(w http.ResponseWriter, r *http.Reques
 		response.BadRequest(w, err)
 		return
 	}
-	ver, err := strconv.Atoi(chi.URLParam(r, "version"))
+	ver, err := strconv.ParseInt(chi.URLParam(r, "version"), 10, 64)
 	if err != nil {
 		c.logger.Error("controller: bad request", "error", err)
 		response.BadRequest(w, err)
 		return
 	}
 
-	if err := c.service.RollbackCharacter(uid, ver); err != nil {
+	if dryRun(r) {
+		plan, err := c.service.PlanRollbackCharacter(uid, ver)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	err = c.service.RollbackCharacter(uid, ver, callerID(r))
+	if errors.Is(err, database.ErrNoDocument) {
+		c.logger.Warn("service warning", "error", err)
+		response.NotFound(w, err)
+		return
+	} else if err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
@@ -78,6 +193,41 @@ func (c *Controller) RollbackCharToVersion(w http.ResponseWriter, r *http.Reques
 	response.OKNoContent(w)
 }
 
+// PATCH /rollback/character/{uuid}/at/{time}
+func (c *Controller) RollbackCharAt(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+	t, err := time.Parse(time.RFC3339, chi.URLParam(r, "time"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanRollbackCharacterAt(uid, t)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	ver, err := c.service.RollbackCharacterAt(uid, t, callerID(r))
+	if errors.Is(err, database.ErrNoDocument) {
+		c.logger.Warn("service warning", "error", err)
+		response.NotFound(w, err)
+		return
+	} else if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, ver)
+}
+
 // DELETE /rollback/character/{uuid}
 func (c *Controller) DeleteCharRollbacks(w http.ResponseWriter, r *http.Request) {
 	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
@@ -87,6 +237,12 @@ func (c *Controller) DeleteCharRollbacks(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if dryRun(r) {
+		plan, err := c.service.PlanDeleteCharacterVersions(uid)
+		c.writePlan(w, plan, err)
+		return
+	}
+
 	if err := c.service.DeleteCharacterVersions(uid); err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
@@ -116,4 +272,100 @@ func (c *Controller) GetCharacterVersionsTimestamp(w http.ResponseWriter, r *htt
 	}
 
 	response.OK(w, data)
-}
\ No newline at end of file
+}
+
+// GET /rollback/character/{uuid}/diff?from=N&to=M
+func (c *Controller) DiffCharacterVersions(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" {
+		c.logger.Error("controller: bad request", "error", "missing from version")
+		response.BadRequest(w, static.ErrNoCharacterVersion)
+		return
+	}
+	if to == "" {
+		to = "current"
+	}
+
+	diff, err := c.service.DiffCharacterVersions(uid, from, to)
+	if errors.Is(err, static.ErrNoCharacterVersion) || errors.Is(err, payload.ErrDiffDecode) {
+		c.logger.Warn("service warning", "error", err)
+		response.BadRequest(w, err)
+		return
+	} else if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, diff)
+}
+
+// POST /rollback/mass
+func (c *Controller) PostMassRollback(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if size, err := io.Copy(&buf, r.Body); err != nil {
+		c.logger.Error("failed to copy body", "error", err, "size", size, "expectedSize", r.ContentLength)
+		response.Error(w, err)
+		return
+	}
+	body := buf.Bytes()
+
+	var req payload.MassRollback
+	if err := utils.ProcessJSON(body, &req); err != nil {
+		c.logger.Error("failed to parse JSON", "body", body, "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+	if dryRun(r) {
+		req.DryRun = true
+	}
+
+	job, err := c.service.StartMassRollback(req, callerID(r))
+	if errors.Is(err, static.ErrBadTimeWindow) || errors.Is(err, static.ErrMassRollbackRunning) || errors.Is(err, static.ErrJobQueueFull) {
+		c.logger.Warn("service warning", "error", err)
+		response.BadRequest(w, err)
+		return
+	} else if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	if job.Status == "dry_run" {
+		response.OK(w, job)
+		return
+	}
+
+	response.StillProcessing(w, job)
+}
+
+// GET /rollback/mass/{uuid}
+func (c *Controller) GetMassRollback(w http.ResponseWriter, r *http.Request) {
+	uid, err := uuid.Parse(chi.URLParam(r, "uuid"))
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	job, err := c.service.GetMassRollback(uid)
+	if errors.Is(err, static.ErrNoMassRollback) {
+		c.logger.Warn("service warning", "error", err)
+		response.NotFound(w, err)
+		return
+	} else if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, job)
+}
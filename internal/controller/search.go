@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/internal/static"
+
+	"github.com/google/uuid"
+)
+
+// GET /user/search?flags=banned,donor&without=&created_since=&created_until=&min_chars=&max_chars=&cursor=&limit=50
+func (c *Controller) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := searchQuery{values: r.URL.Query()}
+	filter := database.UserFilter{
+		CreatedSince: q.time("created_since"),
+		CreatedUntil: q.time("created_until"),
+		MinCharacters: q.count("min_chars"),
+		MaxCharacters: q.count("max_chars"),
+		After: q.values.Get("cursor"),
+		Limit: q.limit(),
+	}
+	if q.err != nil {
+		c.logger.Error("controller: bad request", "error", q.err)
+		response.BadRequest(w, q.err)
+		return
+	}
+
+	page, err := c.service.SearchUsers(filter, q.list("flags"), q.list("without"))
+	if err != nil {
+		if errors.Is(err, static.ErrUnknownFlag) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, page)
+}
+
+// GET /character/search?steamid=&modified_since=&modified_until=&min_size=&max_size=&deleted=true|false&cursor=&limit=50
+func (c *Controller) SearchCharacters(w http.ResponseWriter, r *http.Request) {
+	q := searchQuery{values: r.URL.Query()}
+	filter := database.CharacterFilter{
+		SteamID: q.values.Get("steamid"),
+		ModifiedSince: q.time("modified_since"),
+		ModifiedUntil: q.time("modified_until"),
+		MinSize: q.count("min_size"),
+		MaxSize: q.count("max_size"),
+		Deleted: q.bool("deleted"),
+		After: q.uuid("cursor"),
+		Limit: q.limit(),
+	}
+	if q.err != nil {
+		c.logger.Error("controller: bad request", "error", q.err)
+		response.BadRequest(w, q.err)
+		return
+	}
+
+	page, err := c.service.SearchCharacters(filter)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, page)
+}
+
+// searchQuery reads the optional parameters of a search, parameters that aren't set come
+// back as nil. The first bad parameter is kept in err and the ones after it are skipped.
+type searchQuery struct {
+	values url.Values
+	err error
+}
+
+func (q *searchQuery) get(param string) string {
+	if q.err != nil {
+		return ""
+	}
+	return q.values.Get(param)
+}
+
+// maxLimit caps how many rows a single page can ask for.
+const maxLimit = 500
+
+func (q *searchQuery) limit() int {
+	l := q.get("limit")
+	if l == "" {
+		return 50
+	}
+
+	limit, err := strconv.Atoi(l)
+	if err != nil || limit < 1 || limit > maxLimit {
+		q.err = fmt.Errorf("limit must be a number from 1 to %d", maxLimit)
+		return 50
+	}
+	return limit
+}
+
+func (q *searchQuery) time(param string) *time.Time {
+	v := q.get(param)
+	if v == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		q.err = err
+		return nil
+	}
+	return &t
+}
+
+func (q *searchQuery) count(param string) *int {
+	v := q.get(param)
+	if v == "" {
+		return nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		q.err = fmt.Errorf("%s must be a number that isn't negative", param)
+		return nil
+	}
+	return &n
+}
+
+func (q *searchQuery) bool(param string) *bool {
+	v := q.get(param)
+	if v == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		q.err = fmt.Errorf("%s must be true or false", param)
+		return nil
+	}
+	return &b
+}
+
+func (q *searchQuery) uuid(param string) *uuid.UUID {
+	v := q.get(param)
+	if v == "" {
+		return nil
+	}
+
+	id, err := uuid.Parse(v)
+	if err != nil {
+		q.err = err
+		return nil
+	}
+	return &id
+}
+
+// list splits a comma separated parameter, ?flags=banned,donor
+func (q *searchQuery) list(param string) []string {
+	var list []string
+	for _, v := range strings.Split(q.values.Get(param), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
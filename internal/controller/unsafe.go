@@ -26,7 +26,13 @@ func (c *Controller) UnsafeMoveCharacter(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	newUID, err := c.service.MoveCharacter(uid, steamid, slot)
+	if dryRun(r) {
+		plan, err := c.service.PlanMoveCharacter(uid, steamid, slot)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	newUID, err := c.service.MoveCharacter(uid, steamid, slot, callerID(r))
 	if err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.BadRequest(w, err)
@@ -52,7 +58,13 @@ func (c *Controller) UnsafeCopyCharacter(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	newUID, err := c.service.CopyCharacter(uid, steamid, slot)
+	if dryRun(r) {
+		plan, err := c.service.PlanCopyCharacter(uid, steamid, slot)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	newUID, err := c.service.CopyCharacter(uid, steamid, slot, callerID(r))
 	if err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.BadRequest(w, err)
@@ -71,7 +83,13 @@ func (c *Controller) UnsafeDeleteCharacter(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := c.service.HardDeleteCharacter(uid); err != nil {
+	if dryRun(r) {
+		plan, err := c.service.PlanHardDeleteCharacter(uid)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	if err := c.service.HardDeleteCharacter(uid, callerID(r)); err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.BadRequest(w, err)
 		return
@@ -1,10 +1,24 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
 	"github.com/msrevive/nexus2/internal/response"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -36,90 +50,339 @@ func (c *Controller) GetAllUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 //PATCH user/ban/{steamid}
+//body is optional: {"reason": "", "duration": "7d", "evidence": ""}, no duration is a permanent ban
 func (c *Controller) PatchBanSteamID(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
-	
-	if err := c.service.AddUserFlag(steamid, bitmask.BANNED); err != nil {
+
+	var req payload.Ban
+	if err := readOptionalJSON(r, &req); err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanBanUser(steamid, req, utils.GetIP(r))
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	ban, err := c.service.BanUser(steamid, req, utils.GetIP(r))
+	if err != nil {
+		if errors.Is(err, static.ErrBadBanDuration) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
 	}
-	
-	response.OKNoContent(w)
+
+	response.OK(w, ban)
 	return
 }
 
 //PATCH user/unban/{steamid}
+//body is optional: {"reason": ""}
 func (c *Controller) PatchUnBanSteamID(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
-	
-	if err := c.service.RemoveUserFlag(steamid, bitmask.BANNED); err != nil {
+
+	var req payload.Unban
+	if err := readOptionalJSON(r, &req); err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanUnbanUser(steamid)
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	if err := c.service.UnbanUser(steamid, req.Reason, utils.GetIP(r)); err != nil {
+		if errors.Is(err, database.ErrNoDocument) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
 	}
-	
+
 	response.OKNoContent(w)
 	return
 }
 
-//PATCH user/admin/{steamid}
-func (c *Controller) PatchAdminSteamID(w http.ResponseWriter, r *http.Request) {
+//GET user/bans/{steamid}
+func (c *Controller) GetBans(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
-	
-	if err := c.service.AddUserFlag(steamid, bitmask.ADMIN); err != nil {
+
+	bans, err := c.service.GetBans(steamid)
+	if err != nil {
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
 	}
-	
-	response.OKNoContent(w)
+
+	response.OK(w, bans)
 	return
 }
 
-//PATCH user/unadmin/{steamid}
-func (c *Controller) PatchUnAdminSteamID(w http.ResponseWriter, r *http.Request) {
+//GET user/{steamid}/notes?category=warning
+func (c *Controller) GetUserNotes(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
-	
-	if err := c.service.RemoveUserFlag(steamid, bitmask.ADMIN); err != nil {
+
+	notes, err := c.service.GetUserNotes(steamid, r.URL.Query().Get("category"))
+	if err != nil {
+		if errors.Is(err, static.ErrBadNoteCategory) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
 	}
-	
-	response.OKNoContent(w)
+
+	response.OK(w, notes)
 	return
 }
 
-//PATCH user/donor/{steamid}
-func (c *Controller) PatchDonorSteamID(w http.ResponseWriter, r *http.Request) {
+//POST user/{steamid}/notes
+//body: {"body": "", "category": "warning", "author": "", "character_id": ""}
+func (c *Controller) PostUserNote(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
-	
-	if err := c.service.AddUserFlag(steamid, bitmask.DONOR); err != nil {
+
+	var buf bytes.Buffer
+	if size, err := io.Copy(&buf, r.Body); err != nil {
+		c.logger.Error("failed to copy body", "error", err, "size", size, "expectedSize", r.ContentLength)
+		response.Error(w, err)
+		return
+	}
+	body := buf.Bytes()
+
+	var req payload.UserNote
+	if err := utils.ProcessJSON(body, &req); err != nil {
+		c.logger.Error("failed to parse JSON", "body", body, "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanUserNote(steamid, req, callerID(r))
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	note, err := c.service.AddUserNote(steamid, req, callerID(r))
+	if err != nil {
+		if errors.Is(err, static.ErrEmptyNote) || errors.Is(err, static.ErrBadNoteCategory) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
 	}
-	
-	response.OKNoContent(w)
+
+	response.Created(w, note)
+	return
+}
+
+//PATCH user/admin/{steamid}
+func (c *Controller) PatchAdminSteamID(w http.ResponseWriter, r *http.Request) {
+	c.setUserFlag(w, r, chi.URLParam(r, "steamid"), "admin", true)
+	return
+}
+
+//PATCH user/unadmin/{steamid}
+func (c *Controller) PatchUnAdminSteamID(w http.ResponseWriter, r *http.Request) {
+	c.setUserFlag(w, r, chi.URLParam(r, "steamid"), "admin", false)
+	return
+}
+
+//PATCH user/donor/{steamid}
+//body is optional: {"source": "", "duration": "30d"} or {"source": "", "expires_at": ""}
+func (c *Controller) PatchDonorSteamID(w http.ResponseWriter, r *http.Request) {
+	c.setUserFlag(w, r, chi.URLParam(r, "steamid"), "donor", true)
 	return
 }
 
 //PATCH user/undonor/{steamid}
 func (c *Controller) PatchUnDonorSteamID(w http.ResponseWriter, r *http.Request) {
-	steamid := chi.URLParam(r, "steamid")
-	
-	if err := c.service.RemoveUserFlag(steamid, bitmask.DONOR); err != nil {
+	c.setUserFlag(w, r, chi.URLParam(r, "steamid"), "donor", false)
+	return
+}
+
+//PUT user/{steamid}/flags/{name}
+//body is optional: {"source": "", "duration": "30d"} or {"source": "", "expires_at": ""}
+func (c *Controller) PutUserFlag(w http.ResponseWriter, r *http.Request) {
+	c.setUserFlag(w, r, chi.URLParam(r, "steamid"), chi.URLParam(r, "name"), true)
+	return
+}
+
+//DELETE user/{steamid}/flags/{name}
+func (c *Controller) DeleteUserFlag(w http.ResponseWriter, r *http.Request) {
+	c.setUserFlag(w, r, chi.URLParam(r, "steamid"), chi.URLParam(r, "name"), false)
+	return
+}
+
+//GET flags
+func (c *Controller) GetFlags(w http.ResponseWriter, r *http.Request) {
+	response.OK(w, c.service.GetFlags())
+	return
+}
+
+func (c *Controller) setUserFlag(w http.ResponseWriter, r *http.Request, steamid string, name string, set bool) {
+	var grant *schema.FlagGrant
+	var err error
+	if set {
+		var req payload.FlagGrant
+		if err := readOptionalJSON(r, &req); err != nil {
+			c.logger.Error("controller: bad request", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		if dryRun(r) {
+			plan, err := c.service.PlanGrantUserFlag(steamid, name, req, utils.GetIP(r))
+			c.writePlan(w, plan, err)
+			return
+		}
+
+		grant, err = c.service.GrantUserFlag(steamid, name, req, utils.GetIP(r))
+	} else if dryRun(r) {
+		plan, err := c.service.PlanClearUserFlag(steamid, name)
+		c.writePlan(w, plan, err)
+		return
+	} else {
+		err = c.service.ClearUserFlag(steamid, name, utils.GetIP(r))
+	}
+	if err != nil {
+		if errors.Is(err, static.ErrUnknownFlag) || errors.Is(err, static.ErrBadGrantExpiry) ||
+			errors.Is(err, database.ErrNoDocument) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
 		c.logger.Error("service failed", "error", err)
 		response.Error(w, err)
 		return
 	}
-	
-	response.OKNoContent(w)
-	return
+
+	if grant == nil {
+		response.OKNoContent(w)
+		return
+	}
+	response.OK(w, grant)
 }
 
-//PATCH user/isdonor/{steamid}
+//POST user/flags/bulk
+//JSON body: {"steamids": [], "op": "add", "flags": ["donor"], "changes": [{"steamid": "", "op": "set", "flags": []}], "source": "", "duration": "30d"}
+//or a text/csv body of steamid,op,flags rows with the flags separated by spaces, the grant comes from ?source=&duration=&expires_at=
+func (c *Controller) PostBulkUserFlags(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if size, err := io.Copy(&buf, r.Body); err != nil {
+		c.logger.Error("failed to copy body", "error", err, "size", size, "expectedSize", r.ContentLength)
+		response.Error(w, err)
+		return
+	}
+
+	var req payload.BulkFlags
+	var err error
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "text/csv" {
+		req, err = parseBulkFlagsCSV(buf.Bytes(), r.URL.Query())
+	} else {
+		err = utils.ProcessJSON(buf.Bytes(), &req)
+	}
+	if err != nil {
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	if dryRun(r) {
+		plan, err := c.service.PlanBulkUpdateFlags(req, utils.GetIP(r))
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	results, err := c.service.BulkUpdateFlags(req, utils.GetIP(r))
+	if err != nil {
+		if errors.Is(err, static.ErrNoFlagChanges) || errors.Is(err, static.ErrTooManyFlagChanges) ||
+			errors.Is(err, static.ErrBadFlagOp) || errors.Is(err, static.ErrBadSteamID) ||
+			errors.Is(err, static.ErrUnknownFlag) || errors.Is(err, static.ErrBadGrantExpiry) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	c.auditFlagChanges(r, results)
+	response.OK(w, results)
+}
+
+// parseBulkFlagsCSV reads steamid,op,flags rows, a first row starting with "steamid" is
+// taken as a header.
+func parseBulkFlagsCSV(body []byte, q url.Values) (payload.BulkFlags, error) {
+	req := payload.BulkFlags{
+		FlagGrant: payload.FlagGrant{
+			Source: q.Get("source"),
+			Duration: q.Get("duration"),
+		},
+	}
+	if e := q.Get("expires_at"); e != "" {
+		t, err := time.Parse(time.RFC3339, e)
+		if err != nil {
+			return req, err
+		}
+		req.ExpiresAt = &t
+	}
+
+	cr := csv.NewReader(bytes.NewReader(body))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return req, err
+	}
+
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && strings.EqualFold(row[0], "steamid") {
+			continue
+		}
+		if len(row) < 2 {
+			return req, fmt.Errorf("csv row %d: want steamid,op,flags", i+1)
+		}
+
+		change := payload.BulkFlagChange{
+			SteamID: strings.TrimSpace(row[0]),
+			Op: strings.ToLower(strings.TrimSpace(row[1])),
+			Flags: make([]string, 0),
+		}
+		if len(row) > 2 {
+			change.Flags = strings.Fields(row[2])
+		}
+		req.Changes = append(req.Changes, change)
+	}
+
+	return req, nil
+}
+
+//GET user/isdonor/{steamid}
 func (c *Controller) GetIsDonorSteamID(w http.ResponseWriter, r *http.Request) {
 	steamid := chi.URLParam(r, "steamid")
 	
@@ -132,7 +395,44 @@ func (c *Controller) GetIsDonorSteamID(w http.ResponseWriter, r *http.Request) {
 	
 	if flags.HasFlag(bitmask.DONOR) {
 		response.OK(w, true)
+		return
 	}
 	response.OK(w, false)
 	return
 }
+
+//PATCH user/merge/{from}/into/{to}?policy=next
+func (c *Controller) PatchMergeUsers(w http.ResponseWriter, r *http.Request) {
+	from := chi.URLParam(r, "from")
+	to := chi.URLParam(r, "to")
+
+	if dryRun(r) {
+		plan, err := c.service.PlanMergeUsers(from, to, r.URL.Query().Get("policy"), callerID(r))
+		c.writePlan(w, plan, err)
+		return
+	}
+
+	res, err := c.service.MergeUsers(from, to, r.URL.Query().Get("policy"), callerID(r))
+	if err != nil {
+		var occupied *database.SlotOccupiedError
+		if errors.As(err, &occupied) {
+			c.logger.Warn("service warning", "error", err)
+			response.Conflict(w, err, occupied)
+			return
+		}
+
+		if errors.Is(err, database.ErrNoDocument) || errors.Is(err, database.ErrAlreadyMerged) ||
+			errors.Is(err, database.ErrNoFreeSlot) || errors.Is(err, static.ErrSameUser) ||
+			errors.Is(err, static.ErrBadMergePolicy) {
+			c.logger.Warn("service warning", "error", err)
+			response.BadRequest(w, err)
+			return
+		}
+
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, res)
+}
\ No newline at end of file
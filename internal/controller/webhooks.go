@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/response"
+)
+
+// GET /webhooks/deliveries?status=failed&endpoint=discord&event=ban.created&limit=50
+// Answers with the newest webhook deliveries, pending ones show the last error and when
+// they're tried next.
+func (c *Controller) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := searchQuery{values: r.URL.Query()}
+	filter := database.WebhookFilter{
+		Status: q.values.Get("status"),
+		Endpoint: q.values.Get("endpoint"),
+		Event: q.values.Get("event"),
+		Limit: q.limit(),
+	}
+	if q.err != nil {
+		c.logger.Error("controller: bad request", "error", q.err)
+		response.BadRequest(w, q.err)
+		return
+	}
+
+	switch filter.Status {
+	case "", database.WebhookPending, database.WebhookDelivered, database.WebhookFailed:
+	default:
+		err := fmt.Errorf("status must be %s, %s or %s", database.WebhookPending, database.WebhookDelivered, database.WebhookFailed)
+		c.logger.Error("controller: bad request", "error", err)
+		response.BadRequest(w, err)
+		return
+	}
+
+	deliveries, err := c.service.GetWebhookDeliveries(filter)
+	if err != nil {
+		c.logger.Error("service failed", "error", err)
+		response.Error(w, err)
+		return
+	}
+
+	response.OK(w, deliveries)
+}
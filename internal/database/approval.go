@@ -0,0 +1,9 @@
+package database
+
+// Approval statuses, only pending approvals can be approved or rejected.
+const (
+	ApprovalPending = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired = "expired"
+)
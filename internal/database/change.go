@@ -0,0 +1,15 @@
+package database
+
+// Kinds of change in the change feed. Deleted is a soft delete, purged is the character
+// being removed for good, either by hand or once it expired.
+const (
+	ChangeCharacterCreated = "character_created"
+	ChangeCharacterUpdated = "character_updated"
+	ChangeCharacterDeleted = "character_deleted"
+	ChangeCharacterPurged = "character_purged"
+	ChangeCharacterRestored = "character_restored"
+	ChangeCharacterRolledBack = "character_rolled_back"
+	ChangeCharacterMoved = "character_moved"
+	ChangeCharacterCopied = "character_copied"
+	ChangeUserFlags = "user_flags"
+)
@@ -18,4 +18,6 @@ type Config struct {
 	}
 	Sync string
 	GarbageCollection string
+	BanExpiry string
+	GrantExpiry string
 }
\ No newline at end of file
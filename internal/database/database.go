@@ -4,6 +4,7 @@ import (
 	"time"
 	"log/slog"
 	"errors"
+	"fmt"
 
 	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/pkg/database/schema"
@@ -15,37 +16,158 @@ var (
 	ErrNoDocument = errors.New("no document")
 	ErrNotImplemented = errors.New("database not yet implemented")
 	ErrNotAvailable = errors.New("database not available")
+	ErrNoOperation = errors.New("no operation to undo")
+	ErrSlotOccupied = errors.New("slot is occupied")
+	ErrAlreadyReverted = errors.New("operation was already reverted")
+	ErrAlreadyMerged = errors.New("user was already merged into another account")
+	ErrNoFreeSlot = errors.New("no free slot")
+	ErrDryRun = errors.New("dry run, changes were rolled back")
+	ErrMovedSince = errors.New("character was moved again since the operation")
+	ErrNoOwner = errors.New("character has no owner to restore it to")
 )
 
+// SlotOccupiedError is returned when a slot that needs to be free already holds an
+// active character, errors.Is matches it against ErrSlotOccupied.
+type SlotOccupiedError struct {
+	SteamID string `json:"steamid"`
+	Slot int `json:"slot"`
+	CharacterID uuid.UUID `json:"character_id"` //the character in the slot
+}
+
+func (e *SlotOccupiedError) Error() string {
+	return fmt.Sprintf("slot %d of %s is occupied by character %s", e.Slot, e.SteamID, e.CharacterID)
+}
+
+func (e *SlotOccupiedError) Is(target error) bool {
+	return target == ErrSlotOccupied
+}
+
+// ModifiedCharacter is an active character whose data was last saved within a time window,
+// along with the newest version saved before that window started.
+type ModifiedCharacter struct {
+	ID uuid.UUID
+	SteamID string
+	Slot int
+	ModifiedAt time.Time
+	Server string
+	Version int64 // 0 when there is no version from before the window
+	VersionCreatedAt time.Time
+}
+
+// RollbackTarget is a character and the version RollbackCharacters rolls it back to.
+type RollbackTarget struct {
+	ID uuid.UUID
+	Version int64
+}
+
 type Options struct {
 	Logger *slog.Logger
+	AuditKey []byte // keys the audit log's hash chain, see AuditHash
+	Outbox Outbox // queues webhook deliveries for events, none are queued when it's nil
+	MaxSnapshots int // snapshots RunGC keeps per character on top of those an undo needs, 0 keeps them all
 }
 
 type Database interface {
 	Connect(cfg Config, opts Options) error
 	Disconnect() error
+	// DryRun returns a view of the database whose writes are rolled back instead of
+	// committed, plans run the real operation through it to find out if it would work.
+	DryRun() Database
+	// WithEvent returns a view of the database that queues the event's webhook deliveries
+	// in the transaction of the write made through it. Ban events are queued by the ban
+	// operations themselves.
+	WithEvent(event Event) Database
+	// Quiet returns a view of the database that queues no webhook deliveries for the
+	// writes made through it, ban events included.
+	Quiet() Database
 
 	GetAllUsers() ([]*schema.User, error)
 	GetUser(steamid string) (*schema.User, error)
+	NewUser(steamid string) error
+	GetUsersWithFlag(flag bitmask.Bitmask) ([]string, error)
 	SetUserFlags(steamid string, flags bitmask.Bitmask) (error)
 	GetUserFlags(steamid string) (bitmask.Bitmask, error)
+	SearchUsers(filter UserFilter) ([]schema.UserSummary, error)
+	MergeUsers(from string, to string, opts MergeOptions) (*MergeResult, error)
+
+	GrantUserFlag(grant *schema.FlagGrant) error
+	BulkUpdateFlags(changes []FlagChange) ([]FlagChangeResult, error)
+	GetFlagGrants(steamid string) ([]schema.FlagGrant, error)
+	ExpireFlagGrants(now time.Time) (int, error)
+
+	AddUserNote(note *schema.UserNote) (int64, error)
+	GetUserNotes(steamid string, category string) ([]schema.UserNote, error)
+	GetUserNoteSummary(steamid string) (*schema.NoteSummary, error)
+
+	AddAuditEntry(entry *schema.AuditEntry) (int64, error)
+	GetAuditLog(filter AuditFilter) ([]schema.AuditEntry, error)
+	VerifyAuditLog() (*AuditVerify, error)
+
+	AddApproval(approval *schema.Approval) (int64, error)
+	GetApproval(id int64) (*schema.Approval, error)
+	GetApprovals(status string, limit int) ([]schema.Approval, error)
+	DecideApproval(id int64, status string, by string, ip string, at time.Time) error
+	SetApprovalResult(id int64, status int, result string) error
+	ExpireApprovals(now time.Time) (int, error)
+
+	AddJob(job *schema.Job) error
+	UpdateJob(job *schema.Job) error
+	GetJob(id uuid.UUID) (*schema.Job, error)
+	GetJobs(status string, kind string, limit int) ([]schema.Job, error)
+	PruneJobs(before time.Time) (int, error)
+
+	AddWebhookDelivery(delivery *schema.WebhookDelivery) (int64, error)
+	UpdateWebhookDelivery(delivery *schema.WebhookDelivery) error
+	GetDueWebhookDeliveries(now time.Time, limit int) ([]schema.WebhookDelivery, error)
+	GetWebhookDeliveries(filter WebhookFilter) ([]schema.WebhookDelivery, error)
+	PruneWebhookDeliveries(before time.Time) (int, error)
+
+	AddBan(ban *schema.Ban) (int64, error)
+	LiftBan(steamid string, admin string, reason string) error
+	GetActiveBan(steamid string) (*schema.Ban, error)
+	GetBans(steamid string) ([]schema.Ban, error)
+	ExpireBans(now time.Time) (int, error)
 
 	NewCharacter(steamid string, slot int, size int, data string) (uuid.UUID, error)
-	UpdateCharacter(id uuid.UUID, size int, data string, backupMax int, backupTime time.Duration) error
+	UpdateCharacter(id uuid.UUID, size int, data string, server string, backupMax int, backupTime time.Duration) error
 	GetCharacter(id uuid.UUID) (*schema.Character, error)
 	GetCharacters(steamid string) (map[int]schema.Character, error) //Gotta be a map cause JSON
+	SearchCharacters(filter CharacterFilter) ([]schema.CharacterSummary, error)
 	LookUpCharacterID(steamid string, slot int) (uuid.UUID, error)
 	SoftDeleteCharacter(id uuid.UUID, expiration time.Duration) error
 	DeleteCharacter(id uuid.UUID) error
 	DeleteCharacterReference(steamid string, slot int) error
-	MoveCharacter(id uuid.UUID, steamid string, slot int) error
-	CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid.UUID, error)
-	RestoreCharacter(id uuid.UUID) error
+	MoveCharacter(id uuid.UUID, steamid string, slot int, admin string) error
+	CopyCharacter(id uuid.UUID, steamid string, slot int, admin string) (uuid.UUID, error)
+	RestoreCharacter(id uuid.UUID, slot *int, admin string) error
+	GetDeletedSlot(id uuid.UUID) (string, int, error)
+	GetDeletedCharacters(steamid string) ([]schema.CharacterSummary, error)
+	SetCharacterExpiry(id uuid.UUID, expiresAt time.Time) error
+	SwapCharacters(steamid string, a int, b int) error
+	ImportCharacter(steamid string, slot int, size int, data string, note string, admin string, backupMax int) (uuid.UUID, bool, error)
+	GetLineage(id uuid.UUID) (*schema.Lineage, error)
 
-	RollbackCharacter(id uuid.UUID, ver int) error
+	RollbackCharacter(id uuid.UUID, ver int64) error
+	// RollbackCharacters rolls back every target in one transaction, none of them are
+	// rolled back when one fails.
+	RollbackCharacters(targets []RollbackTarget) error
 	RollbackCharacterToLatest(id uuid.UUID) error
 	DeleteCharacterVersions(id uuid.UUID) error
-	GetRollbackVersionsTimestamp(id uuid.UUID) (map[int]string, error)
+	GetRollbackVersionsTimestamp(id uuid.UUID) (map[int64]string, error)
+	// GetCharacterVersionAt returns the ID of the newest version saved at or before t.
+	GetCharacterVersionAt(id uuid.UUID, t time.Time) (int64, error)
+	UndoCharacterOperation(id uuid.UUID, expiration time.Duration, admin string) (*schema.CharacterOperation, error)
+	GetUndoOperation(id uuid.UUID) (*schema.CharacterOperation, error)
+	GetModifiedCharacters(from time.Time, to time.Time, servers []string) ([]ModifiedCharacter, error)
+
+	GetJournal(limit int) ([]schema.JournalEntry, error)
+	GetJournalEntry(id int64) (*schema.JournalEntry, error)
+	RevertJournalEntry(id int64, expiration time.Duration, admin string) (*schema.JournalEntry, error)
+	PruneJournal(before time.Time) error
+
+	GetChanges(since int64, limit int) ([]schema.Change, error)
+	ChangeKept(id int64) (bool, int64, error)
+	PruneChanges(before time.Time) (int, error)
 
 	SyncToDisk() error
 	RunGC() error
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+)
+
+// Operations of a FlagChange.
+const (
+	FlagAdd = "add" // set the bits in the mask
+	FlagRemove = "remove" // clear the bits in the mask
+	FlagSet = "set" // replace the user's flags with the mask
+)
+
+// FlagChange is one change made by BulkUpdateFlags. The grant fields are recorded for
+// every bit an add or set covers, the same as GrantUserFlag would. Bans the change makes
+// or lifts get Reason, and the grant's expiry when they're made.
+type FlagChange struct {
+	SteamID string
+	Op string
+	Mask bitmask.Bitmask
+	Reason string
+	Source string
+	Admin string
+	GrantedAt time.Time
+	ExpiresAt *time.Time
+}
+
+// Apply returns the flags after the change.
+func (c FlagChange) Apply(flags bitmask.Bitmask) bitmask.Bitmask {
+	switch c.Op {
+	case FlagAdd:
+		return flags | c.Mask
+	case FlagRemove:
+		return flags &^ c.Mask
+	case FlagSet:
+		return c.Mask
+	}
+	return flags
+}
+
+// FlagChangeResult is a user's flags before and after their FlagChange.
+type FlagChangeResult struct {
+	SteamID string
+	Before bitmask.Bitmask
+	After bitmask.Bitmask
+	Created bool // the user hadn't joined yet
+}
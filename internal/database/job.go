@@ -0,0 +1,9 @@
+package database
+
+// Job statuses, queued and running jobs are picked back up when the server restarts.
+const (
+	JobQueued = "queued"
+	JobRunning = "running"
+	JobDone = "done"
+	JobFailed = "failed"
+)
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+// Slot conflict policies for MergeUsers.
+const (
+	MergeNextSlot = "next" // move the character to the next free slot
+	MergeReplace = "replace" // soft delete the character already in the slot
+	MergeFail = "fail" // abort the merge
+)
+
+// MergeOptions controls how MergeUsers resolves slot conflicts and combines flags.
+type MergeOptions struct {
+	Policy string
+	MaxSlots int // slots an account can use, 0 is unlimited
+	Expiration time.Duration // expiry for characters soft deleted by the "replace" policy
+	Flags func(from bitmask.Bitmask, to bitmask.Bitmask) bitmask.Bitmask // nil keeps the flags of both accounts
+	Admin string // who requested the merge, recorded in the lineage of every moved character
+}
+
+// MergeResult describes where everything from the old account ended up.
+type MergeResult struct {
+	From string `json:"from"`
+	To string `json:"to"`
+	Characters []MergedCharacter `json:"characters"`
+	DeletedCharacters []MergedCharacter `json:"deleted_characters"`
+	Replaced []uuid.UUID `json:"replaced,omitempty"` //characters soft deleted to make room
+	Flags uint32 `json:"flags"`
+}
+
+type MergedCharacter struct {
+	ID uuid.UUID `json:"id"`
+	FromSlot int `json:"from_slot"`
+	ToSlot int `json:"to_slot"`
+}
+
+// MergeOrder returns the slots in src with the ones free in taken first, so those keep
+// their slot before any conflict is given one of the free slots.
+func MergeOrder(src map[int]uuid.UUID, taken map[int]uuid.UUID) []int {
+	slots := make([]int, 0, len(src))
+	for slot := range src {
+		slots = append(slots, slot)
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		_, ci := taken[slots[i]]
+		_, cj := taken[slots[j]]
+		if ci != cj {
+			return !ci
+		}
+		return slots[i] < slots[j]
+	})
+
+	return slots
+}
+
+// FreeSlot returns the lowest slot not in taken, max of 0 means there's no limit.
+func FreeSlot(taken map[int]uuid.UUID, max int) (int, bool) {
+	for slot := 0; max <= 0 || slot < max; slot++ {
+		if _, ok := taken[slot]; !ok {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+// MergeGrant returns the grant the merged account gets for a flag it keeps, or nil when it
+// keeps the flag for good. An account with the flag but no grant has it for good, otherwise
+// the grant that runs out last wins.
+func MergeGrant(fromHas bool, from *schema.FlagGrant, toHas bool, to *schema.FlagGrant) *schema.FlagGrant {
+	switch {
+	case fromHas && from == nil, toHas && to == nil:
+		return nil
+	case !toHas:
+		return from
+	case !fromHas:
+		return to
+	case from.ExpiresAt == nil:
+		return from
+	case to.ExpiresAt == nil || !from.ExpiresAt.After(*to.ExpiresAt):
+		return to
+	}
+	return from
+}
@@ -0,0 +1,132 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const approvalColumns = `id, method, path, endpoint, body, reason, requested_by, requested_ip, status,
+	created_at, expires_at, decided_by, decided_ip, decided_at, result_status, result`
+
+func (d *postgresDB) AddApproval(approval *schema.Approval) (int64, error) {
+	var id int64
+	ctx := context.Background()
+
+	err := d.db.QueryRow(ctx, `
+		INSERT INTO approvals (method, path, endpoint, body, reason, requested_by, requested_ip, status, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`,
+		approval.Method, approval.Path, approval.Endpoint, approval.Body, approval.Reason,
+		approval.RequestedBy, approval.RequestedIP, approval.Status, approval.CreatedAt.UTC(), approval.ExpiresAt.UTC(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (d *postgresDB) GetApproval(id int64) (*schema.Approval, error) {
+	ctx := context.Background()
+	a, err := scanApproval(d.db.QueryRow(ctx, `SELECT `+approvalColumns+` FROM approvals WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// GetApprovals returns the newest approvals, status filters them when it's not empty.
+func (d *postgresDB) GetApprovals(status string, limit int) ([]schema.Approval, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT `+approvalColumns+`
+		FROM approvals
+		WHERE ($1::text = '' OR status = $1)
+		ORDER BY id DESC LIMIT $2`,
+		status, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := make([]schema.Approval, 0)
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, *a)
+	}
+	return approvals, rows.Err()
+}
+
+// DecideApproval approves or rejects an approval that's still pending and hasn't
+// expired, ErrNoDocument is returned when there's no such approval.
+func (d *postgresDB) DecideApproval(id int64, status string, by string, ip string, at time.Time) error {
+	ctx := context.Background()
+	ct, err := d.db.Exec(ctx, `
+		UPDATE approvals SET status = $1, decided_by = $2, decided_ip = $3, decided_at = $4
+		WHERE id = $5 AND status = $6 AND expires_at > $4`,
+		status, by, ip, at.UTC(), id, database.ApprovalPending,
+	)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return database.ErrNoDocument
+	}
+
+	return nil
+}
+
+// SetApprovalResult records the response of an approved request once it ran.
+func (d *postgresDB) SetApprovalResult(id int64, status int, result string) error {
+	ctx := context.Background()
+	_, err := d.db.Exec(ctx,
+		`UPDATE approvals SET result_status = $1, result = $2 WHERE id = $3`, int32(status), result, id,
+	)
+	return err
+}
+
+// ExpireApprovals marks the pending approvals that ran out by now as expired and
+// returns how many there were.
+func (d *postgresDB) ExpireApprovals(now time.Time) (int, error) {
+	ctx := context.Background()
+	ct, err := d.db.Exec(ctx,
+		`UPDATE approvals SET status = $1 WHERE status = $2 AND expires_at <= $3`,
+		database.ApprovalExpired, database.ApprovalPending, now.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(ct.RowsAffected()), nil
+}
+
+func scanApproval(row pgx.Row) (*schema.Approval, error) {
+	var (
+		a schema.Approval
+		resultStatus int32
+	)
+	err := row.Scan(
+		&a.ID, &a.Method, &a.Path, &a.Endpoint, &a.Body, &a.Reason, &a.RequestedBy, &a.RequestedIP, &a.Status,
+		&a.CreatedAt, &a.ExpiresAt, &a.DecidedBy, &a.DecidedIP, &a.DecidedAt, &resultStatus, &a.Result,
+	)
+	if err != nil {
+		return nil, err
+	}
+	a.ResultStatus = int(resultStatus)
+
+	return &a, nil
+}
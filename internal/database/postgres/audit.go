@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/jackc/pgx/v5"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// AddAuditEntry appends the entry to the audit log, chaining its hash to the last entry.
+// PrevHash and Hash are set on the entry.
+func (d *postgresDB) AddAuditEntry(entry *schema.AuditEntry) (int64, error) {
+	var id int64
+	ctx := context.Background()
+
+	// Postgres keeps times to the microsecond, the hash has to match what's read back.
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	before, err := auditState(entry.Before)
+	if err != nil {
+		return 0, err
+	}
+	after, err := auditState(entry.After)
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.execTx(ctx, func(tx pgx.Tx) error {
+		// Only one entry can be chained at a time, readers aren't blocked.
+		if _, err := tx.Exec(ctx, `LOCK TABLE audit_log IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+
+		var prevHash string
+		err := tx.QueryRow(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+
+		entry.PrevHash = prevHash
+		entry.Hash = database.AuditHash(d.AuditKey, prevHash, entry, stateString(before), stateString(after))
+
+		return tx.QueryRow(ctx, `
+			INSERT INTO audit_log (created_at, ip, key_id, method, endpoint, path, status, steam_id,
+				character_id, reason, before_state, after_state, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING id`,
+			entry.CreatedAt, entry.IP, entry.KeyID, entry.Method, entry.Endpoint, entry.Path, int32(entry.Status), entry.SteamID,
+			entry.CharacterID, entry.Reason, before, after, entry.PrevHash, entry.Hash,
+		).Scan(&id)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAuditLog returns the entries matching the filter, newest first.
+func (d *postgresDB) GetAuditLog(filter database.AuditFilter) ([]schema.AuditEntry, error) {
+	ctx := context.Background()
+
+	var (
+		where []string
+		args []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.SteamID != "" {
+		add("steam_id = $%d", filter.SteamID)
+	}
+	if filter.CharacterID != nil {
+		add("character_id = $%d", *filter.CharacterID)
+	}
+	if filter.IP != "" {
+		add("ip = $%d", filter.IP)
+	}
+	if filter.KeyID != "" {
+		add("key_id = $%d", filter.KeyID)
+	}
+	if filter.Endpoint != "" {
+		add("starts_with(endpoint, $%d)", filter.Endpoint)
+	}
+	if filter.Since != nil {
+		add("created_at >= $%d", filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		add("created_at < $%d", filter.Until.UTC())
+	}
+	if filter.BeforeID > 0 {
+		add("id < $%d", filter.BeforeID)
+	}
+
+	query := `
+		SELECT id, created_at, ip, key_id, method, endpoint, path, status, steam_id,
+			character_id, reason, before_state, after_state, prev_hash, hash
+		FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := d.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]schema.AuditEntry, 0)
+	for rows.Next() {
+		e, _, _, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// VerifyAuditLog walks the audit log from the first entry and checks every hash along
+// with the link to the entry before it.
+func (d *postgresDB) VerifyAuditLog() (*database.AuditVerify, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT id, created_at, ip, key_id, method, endpoint, path, status, steam_id,
+			character_id, reason, before_state, after_state, prev_hash, hash
+		FROM audit_log
+		ORDER BY id ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &database.AuditVerify{OK: true}
+	var prevHash string
+	for rows.Next() {
+		e, before, after, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Checked++
+
+		if e.PrevHash != prevHash || database.AuditHash(d.AuditKey, prevHash, e, before, after) != e.Hash {
+			result.OK = false
+			result.BrokenAt = e.ID
+			break
+		}
+		prevHash = e.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func auditState(state *schema.AuditState) (*string, error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	s := string(data)
+	return &s, nil
+}
+
+func stateString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// scanAuditEntry also returns the before and after states as stored, they're what the hash covers.
+func scanAuditEntry(row pgx.Row) (*schema.AuditEntry, string, string, error) {
+	var (
+		e schema.AuditEntry
+		status int32
+		before *string
+		after *string
+	)
+	err := row.Scan(
+		&e.ID, &e.CreatedAt, &e.IP, &e.KeyID, &e.Method, &e.Endpoint, &e.Path, &status, &e.SteamID,
+		&e.CharacterID, &e.Reason, &before, &after, &e.PrevHash, &e.Hash,
+	)
+	if err != nil {
+		return nil, "", "", err
+	}
+	e.Status = int(status)
+
+	if before != nil {
+		if err := json.Unmarshal([]byte(*before), &e.Before); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if after != nil {
+		if err := json.Unmarshal([]byte(*after), &e.After); err != nil {
+			return nil, "", "", err
+		}
+	}
+	return &e, stateString(before), stateString(after), nil
+}
@@ -0,0 +1,272 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// AddBan bans the user and sets the BANNED flag, creating the user if they haven't
+// joined yet. An active ban the user already has is lifted and replaced by this one.
+func (d *postgresDB) AddBan(ban *schema.Ban) (int64, error) {
+	var id int64
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO users (id) VALUES ($1) ON CONFLICT(id) DO NOTHING`, ban.SteamID,
+		); err != nil {
+			return fmt.Errorf("upsert user: %w", err)
+		}
+
+		var err error
+		if id, err = d.insertBan(ctx, tx, ban); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx,
+			`UPDATE users SET flags = flags | $1 WHERE id = $2`, int32(bitmask.BANNED), ban.SteamID,
+		); err != nil {
+			return err
+		}
+
+		if err := userChange(ctx, tx, ban.SteamID); err != nil {
+			return err
+		}
+
+		return clearBanGrant(ctx, tx, ban.SteamID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// LiftBan unbans the user, lifting their active ban and clearing the BANNED flag. Users
+// banned by flag only, from before bans were recorded, just have the flag cleared.
+func (d *postgresDB) LiftBan(steamid string, admin string, reason string) error {
+	ctx := context.Background()
+	return d.execTx(ctx, func(tx pgx.Tx) error {
+		ct, err := tx.Exec(ctx,
+			`UPDATE users SET flags = flags & ~$1::integer WHERE id = $2`, int32(bitmask.BANNED), steamid,
+		)
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 0 {
+			return database.ErrNoDocument
+		}
+
+		if err := liftBans(ctx, tx, steamid, time.Now().UTC(), admin, reason); err != nil {
+			return err
+		}
+
+		if err := userChange(ctx, tx, steamid); err != nil {
+			return err
+		}
+
+		if err := clearBanGrant(ctx, tx, steamid); err != nil {
+			return err
+		}
+
+		return d.queueUnban(ctx, tx, steamid, admin, fmt.Sprintf("%s was unbanned: %s", steamid, reason), nil)
+	})
+}
+
+// GetActiveBan returns the user's ban that hasn't been lifted or expired yet.
+func (d *postgresDB) GetActiveBan(steamid string) (*schema.Ban, error) {
+	ctx := context.Background()
+	b, err := scanBan(d.db.QueryRow(ctx, `
+		SELECT id, steam_id, reason, admin, evidence, created_at, expires_at, lifted_at, lifted_by, lift_reason
+		FROM bans
+		WHERE steam_id = $1 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $2)
+		ORDER BY id DESC LIMIT 1`,
+		steamid, time.Now().UTC(),
+	))
+	if err == pgx.ErrNoRows {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// GetBans returns every ban the user has had, newest first.
+func (d *postgresDB) GetBans(steamid string) ([]schema.Ban, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT id, steam_id, reason, admin, evidence, created_at, expires_at, lifted_at, lifted_by, lift_reason
+		FROM bans
+		WHERE steam_id = $1
+		ORDER BY id DESC`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := make([]schema.Ban, 0)
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, *b)
+	}
+	return bans, rows.Err()
+}
+
+// ExpireBans lifts every temporary ban that ran out by now and clears the BANNED flag
+// of those users. It returns how many bans expired.
+func (d *postgresDB) ExpireBans(now time.Time) (int, error) {
+	var expired int
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			UPDATE bans SET lifted_at = $1, lift_reason = 'expired'
+			WHERE lifted_at IS NULL AND expires_at IS NOT NULL AND expires_at <= $1
+			RETURNING id, steam_id`,
+			now.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		ids := make(map[int64]string)
+		steamids := make(map[string]struct{})
+		for rows.Next() {
+			var id int64
+			var steamid string
+			if err := rows.Scan(&id, &steamid); err != nil {
+				rows.Close()
+				return err
+			}
+			ids[id] = steamid
+			steamids[steamid] = struct{}{}
+			expired++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for id, steamid := range ids {
+			if err := d.queueUnban(ctx, tx, steamid, "", fmt.Sprintf("ban %d of %s expired", id, steamid), map[string]int64{"ban_id": id}); err != nil {
+				return err
+			}
+		}
+
+		for steamid := range steamids {
+			// A ban that was added after this one expired keeps the flag.
+			ct, err := tx.Exec(ctx, `
+				UPDATE users SET flags = flags & ~$1::integer
+				WHERE id = $2 AND NOT EXISTS (
+					SELECT 1 FROM bans
+					WHERE steam_id = $2 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $3)
+				)`,
+				int32(bitmask.BANNED), steamid, now.UTC(),
+			)
+			if err != nil {
+				return err
+			}
+			if ct.RowsAffected() > 0 {
+				if err := userChange(ctx, tx, steamid); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// liftBans lifts the user's bans that are still active at the given time.
+func liftBans(ctx context.Context, tx pgx.Tx, steamid string, at time.Time, admin string, reason string) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE bans SET lifted_at = $1, lifted_by = $2, lift_reason = $3
+		WHERE steam_id = $4 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $1)`,
+		at.UTC(), admin, reason, steamid,
+	)
+	return err
+}
+
+// insertBan records the ban, lifting the active ban it replaces, and queues its webhook
+// event. Setting the BANNED flag is left to the caller.
+func (d *postgresDB) insertBan(ctx context.Context, tx pgx.Tx, ban *schema.Ban) (int64, error) {
+	if err := liftBans(ctx, tx, ban.SteamID, ban.CreatedAt, ban.Admin, "replaced"); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO bans (steam_id, reason, admin, evidence, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		ban.SteamID, ban.Reason, ban.Admin, ban.Evidence, ban.CreatedAt.UTC(), ban.ExpiresAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Every way of banning someone records the ban here, so this is the one place the
+	// event is queued from.
+	b := *ban
+	b.ID = id
+	if err := d.queueEvent(ctx, tx, database.Event{
+		Kind: database.EventBan,
+		Summary: fmt.Sprintf("%s was banned: %s", ban.SteamID, ban.Reason),
+		SteamID: ban.SteamID,
+		By: ban.Admin,
+		Data: &b,
+	}); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// queueUnban queues the webhook event for the user being unbanned, LiftBan, bulk flag
+// changes and ExpireBans all call it.
+func (d *postgresDB) queueUnban(ctx context.Context, tx pgx.Tx, steamid string, by string, summary string, data interface{}) error {
+	return d.queueEvent(ctx, tx, database.Event{
+		Kind: database.EventUnban,
+		Summary: summary,
+		SteamID: steamid,
+		By: by,
+		Data: data,
+	})
+}
+
+// clearBanGrant removes a grant of the BANNED flag, the user's bans decide when it's
+// cleared so an old grant expiring can't unban them.
+func clearBanGrant(ctx context.Context, tx pgx.Tx, steamid string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM flag_grants WHERE steam_id = $1 AND bit = 0`, steamid)
+	return err
+}
+
+func scanBan(row pgx.Row) (*schema.Ban, error) {
+	var b schema.Ban
+	err := row.Scan(
+		&b.ID, &b.SteamID, &b.Reason, &b.Admin, &b.Evidence, &b.CreatedAt,
+		&b.ExpiresAt, &b.LiftedAt, &b.LiftedBy, &b.LiftReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
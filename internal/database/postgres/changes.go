@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// GetChanges returns up to limit changes made after the change with the given ID, oldest
+// first.
+func (d *postgresDB) GetChanges(since int64, limit int) ([]schema.Change, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT id, kind, character_id, source_id, steam_id, slot, flags, created_at
+		FROM changes WHERE id > $1 ORDER BY id ASC LIMIT $2`,
+		since, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]schema.Change, 0)
+	for rows.Next() {
+		var c schema.Change
+		if err := rows.Scan(&c.ID, &c.Kind, &c.CharacterID, &c.SourceID, &c.SteamID, &c.Slot, &c.Flags, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// ChangeKept reports whether the change with the given ID hasn't been pruned, along with
+// the ID of the newest change.
+func (d *postgresDB) ChangeKept(id int64) (bool, int64, error) {
+	ctx := context.Background()
+	var kept bool
+	var latest int64
+	err := d.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM changes WHERE id = $1), COALESCE(MAX(id), 0) FROM changes`, id,
+	).Scan(&kept, &latest)
+	return kept, latest, err
+}
+
+// PruneChanges removes the changes made before the given time and returns how many there
+// were.
+func (d *postgresDB) PruneChanges(before time.Time) (int, error) {
+	ctx := context.Background()
+	ct, err := d.db.Exec(ctx, `DELETE FROM changes WHERE created_at < $1`, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return int(ct.RowsAffected()), nil
+}
+
+// changesLock is the advisory lock held by transactions that add to the change feed, so
+// they commit their changes in ID order and a reader can never skip past a change that
+// commits late.
+const changesLock = 0x6e65787573
+
+// lockChanges takes the change feed lock until the end of the transaction, call it right
+// before inserting into changes. Taking it again in the same transaction is a no-op.
+func lockChanges(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, int64(changesLock))
+	return err
+}
+
+// characterChange records a change to the character as it is in the transaction, so it has
+// to be called after the character was written and before it's deleted. Soft deleted
+// characters are recorded under the slot they were deleted from.
+func characterChange(ctx context.Context, tx pgx.Tx, kind string, id uuid.UUID) error {
+	return characterChangeFrom(ctx, tx, kind, id, uuid.Nil)
+}
+
+// characterChangeFrom is characterChange for a character that was made from another one.
+func characterChangeFrom(ctx context.Context, tx pgx.Tx, kind string, id uuid.UUID, source uuid.UUID) error {
+	var sourceID *uuid.UUID
+	if source != uuid.Nil {
+		sourceID = &source
+	}
+
+	if err := lockChanges(ctx, tx); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO changes (kind, character_id, source_id, steam_id, slot, created_at)
+		SELECT $1::text, c.id, $2::uuid, COALESCE(c.steam_id, dc.steam_id, ''), COALESCE(c.slot, dc.slot), $3::timestamptz
+		FROM characters c
+		LEFT JOIN deleted_characters dc ON dc.character_id = c.id
+		WHERE c.id = $4`,
+		kind, sourceID, time.Now().UTC(), id,
+	)
+	return err
+}
+
+// userChange records the user's flags after they were changed.
+func userChange(ctx context.Context, tx pgx.Tx, steamid string) error {
+	if err := lockChanges(ctx, tx); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO changes (kind, steam_id, flags, created_at)
+		SELECT $1::text, id, flags, $2::timestamptz FROM users WHERE id = $3`,
+		database.ChangeUserFlags, time.Now().UTC(), steamid,
+	)
+	return err
+}
@@ -40,7 +40,7 @@ func (d *postgresDB) NewCharacter(steamid string, slot int, size int, data strin
 			return fmt.Errorf("insert character: %w", err)
 		}
 
-		return nil
+		return characterChange(ctx, tx, database.ChangeCharacterCreated, charID)
 	})
 	if err != nil {
 		return uuid.Nil, err
@@ -50,14 +50,24 @@ func (d *postgresDB) NewCharacter(steamid string, slot int, size int, data strin
 
 // UpdateCharacter stores the latest state in the coalescing map. The next
 // flushWorker tick will commit all coalesced updates in a single transaction.
-func (d *postgresDB) UpdateCharacter(id uuid.UUID, size int, data string, backupMax int, backupTime time.Duration) error {
-	d.coalesceMu.Lock()
-	d.pendingUpdates[id] = pendingUpdate{
+func (d *postgresDB) UpdateCharacter(id uuid.UUID, size int, data string, server string, backupMax int, backupTime time.Duration) error {
+	upd := pendingUpdate{
 		size:       size,
 		data:       data,
+		server:     server,
 		backupMax:  backupMax,
 		backupTime: backupTime,
 	}
+	// A dry run can't wait for the flush, the update is applied and rolled back now.
+	if d.dryRun {
+		ctx := context.Background()
+		return d.execTx(ctx, func(tx pgx.Tx) error {
+			return applyCharacterUpdate(ctx, tx, id, upd)
+		})
+	}
+
+	d.coalesceMu.Lock()
+	d.pendingUpdates[id] = upd
 	d.coalesceMu.Unlock()
 	return nil
 }
@@ -69,13 +79,14 @@ func applyCharacterUpdate(ctx context.Context, tx pgx.Tx, id uuid.UUID, upd pend
 		dataCreatedAt time.Time
 		dataSize      int
 		dataPayload   string
+		dataServer    string
 	)
 	err := tx.QueryRow(ctx, `
-		SELECT data_created_at, data_size, data_payload
+		SELECT data_created_at, data_size, data_payload, data_server
 		FROM characters WHERE id = $1
 		FOR UPDATE`, // we do FOR UPDATE to let postgres know to lock it ahead of time for updating.
 		id,
-	).Scan(&dataCreatedAt, &dataSize, &dataPayload)
+	).Scan(&dataCreatedAt, &dataSize, &dataPayload, &dataServer)
 
 	if err == pgx.ErrNoRows {
 		return database.ErrNoDocument
@@ -122,9 +133,9 @@ func applyCharacterUpdate(ctx context.Context, tx pgx.Tx, id uuid.UUID, upd pend
 
 			if dataCreatedAt.After(newestCreatedAt.Add(upd.backupTime)) {
 				if _, err := tx.Exec(ctx, `
-					INSERT INTO character_versions (character_id, created_at, size, data_payload)
-					VALUES ($1, $2, $3, $4)`,
-					id, dataCreatedAt, dataSize, dataPayload,
+					INSERT INTO character_versions (character_id, created_at, size, data_payload, server)
+					VALUES ($1, $2, $3, $4, $5)`,
+					id, dataCreatedAt, dataSize, dataPayload, dataServer,
 				); err != nil {
 					return err
 				}
@@ -132,9 +143,9 @@ func applyCharacterUpdate(ctx context.Context, tx pgx.Tx, id uuid.UUID, upd pend
 		} else {
 			// No versions yet — always snapshot.
 			if _, err := tx.Exec(ctx, `
-				INSERT INTO character_versions (character_id, created_at, size, data_payload)
-				VALUES ($1, $2, $3, $4)`,
-				id, dataCreatedAt, dataSize, dataPayload,
+				INSERT INTO character_versions (character_id, created_at, size, data_payload, server)
+				VALUES ($1, $2, $3, $4, $5)`,
+				id, dataCreatedAt, dataSize, dataPayload, dataServer,
 			); err != nil {
 				return err
 			}
@@ -144,11 +155,15 @@ func applyCharacterUpdate(ctx context.Context, tx pgx.Tx, id uuid.UUID, upd pend
 	// Write the new current character data.
 	_, err = tx.Exec(ctx, `
 		UPDATE characters
-		SET data_created_at = $1, data_size = $2, data_payload = $3
-		WHERE id = $4`,
-		time.Now().UTC(), upd.size, upd.data, id,
+		SET data_created_at = $1, data_size = $2, data_payload = $3, data_server = $4
+		WHERE id = $5`,
+		time.Now().UTC(), upd.size, upd.data, upd.server, id,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	return characterChange(ctx, tx, database.ChangeCharacterUpdated, id)
 }
 
 func (d *postgresDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
@@ -163,12 +178,12 @@ func (d *postgresDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
 
 	err := d.db.QueryRow(ctx, `
 		SELECT steam_id, slot, created_at, deleted_at,
-			data_created_at, data_size, data_payload
+			data_created_at, data_size, data_payload, data_server
 		FROM characters WHERE id = $1`,
 		id,
 	).Scan(
 		&steamID, &slot, &c.CreatedAt, &deletedAt,
-		&c.Data.CreatedAt, &c.Data.Size, &c.Data.Data,
+		&c.Data.CreatedAt, &c.Data.Size, &c.Data.Data, &c.Data.Server,
 	)
 	if err == pgx.ErrNoRows {
 		return nil, database.ErrNoDocument
@@ -192,7 +207,7 @@ func (d *postgresDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
 
 	// Load version history.
 	rows, err := d.db.Query(ctx, `
-		SELECT created_at, size, data_payload
+		SELECT id, created_at, size, data_payload, tag, note, server
 		FROM character_versions
 		WHERE character_id = $1 ORDER BY id ASC`,
 		id,
@@ -204,7 +219,7 @@ func (d *postgresDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
 
 	for rows.Next() {
 		var v schema.CharacterData
-		if err := rows.Scan(&v.CreatedAt, &v.Size, &v.Data); err != nil {
+		if err := rows.Scan(&v.ID, &v.CreatedAt, &v.Size, &v.Data, &v.Tag, &v.Note, &v.Server); err != nil {
 			return nil, err
 		}
 		c.Versions = append(c.Versions, v)
@@ -279,43 +294,68 @@ func (d *postgresDB) SoftDeleteCharacter(id uuid.UUID, expiration time.Duration)
 	ctx := context.Background()
 
 	return d.execTx(ctx, func(tx pgx.Tx) error {
-		var steamID string
-		var slot int
-		err := tx.QueryRow(ctx,
-			`SELECT steam_id, slot FROM characters WHERE id = $1`, id,
-		).Scan(&steamID, &slot)
-		if err == pgx.ErrNoRows {
-			return database.ErrNoDocument
-		}
-		if err != nil {
-			return err
-		}
+		return softDeleteCharacter(ctx, tx, id, now, expiresAt)
+	})
+}
 
-		// we orphan the character data here.
-		if _, err := tx.Exec(ctx, `
-			UPDATE characters SET deleted_at = $1, expires_at = $2, steam_id = NULL, slot = NULL WHERE id = $3`,
-			now, expiresAt, id,
-		); err != nil {
-			return err
-		}
+// softDeleteCharacter is the body of SoftDeleteCharacter so other operations can
+// soft delete within their own transaction.
+func softDeleteCharacter(ctx context.Context, tx pgx.Tx, id uuid.UUID, now time.Time, expiresAt time.Time) error {
+	var steamID string
+	var slot int
+	err := tx.QueryRow(ctx,
+		`SELECT steam_id, slot FROM characters WHERE id = $1`, id,
+	).Scan(&steamID, &slot)
+	if err == pgx.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
 
-		_, err = tx.Exec(ctx, `
-			INSERT INTO deleted_characters (steam_id, slot, character_id, deleted_at)
-			VALUES ($1, $2, $3, $4)
-			ON CONFLICT (steam_id, slot) DO UPDATE
-			SET character_id = EXCLUDED.character_id,
-			    deleted_at   = EXCLUDED.deleted_at`,
-			steamID, slot, id, now,
-		)
+	// we orphan the character data here.
+	if _, err := tx.Exec(ctx, `
+		UPDATE characters SET deleted_at = $1, expires_at = $2, steam_id = NULL, slot = NULL WHERE id = $3`,
+		now, expiresAt, id,
+	); err != nil {
 		return err
-	})
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO deleted_characters (steam_id, slot, character_id, deleted_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (steam_id, slot) DO UPDATE
+		SET character_id = EXCLUDED.character_id,
+		    deleted_at   = EXCLUDED.deleted_at`,
+		steamID, slot, id, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	return characterChange(ctx, tx, database.ChangeCharacterDeleted, id)
 }
 
 // DeleteCharacter permanently removes the character and all associated data.
+// The character is journaled first so the delete can be reverted.
 func (d *postgresDB) DeleteCharacter(id uuid.UUID) error {
 	ctx := context.Background()
 	return d.execTx(ctx, func(tx pgx.Tx) error {
-		_, err := tx.Exec(ctx, `DELETE FROM characters WHERE id = $1`, id)
+		before, err := characterState(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := journalOperation(ctx, tx, "delete", id, uuid.Nil, before); err != nil {
+			return err
+		}
+
+		// Recorded while the character still exists so the change has its owner and slot.
+		if err := characterChange(ctx, tx, database.ChangeCharacterPurged, id); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM characters WHERE id = $1`, id)
 		return err
 	})
 }
@@ -334,53 +374,89 @@ func (d *postgresDB) DeleteCharacterReference(steamid string, slot int) error {
 }
 
 // MoveCharacter transfers a character to a different user/slot atomically.
-func (d *postgresDB) MoveCharacter(id uuid.UUID, steamid string, slot int) error {
+// admin is whoever requested it, recorded in the character's lineage.
+func (d *postgresDB) MoveCharacter(id uuid.UUID, steamid string, slot int, admin string) error {
 	ctx := context.Background()
 	return d.execTx(ctx, func(tx pgx.Tx) error {
-		var oldSteamID string
-		var oldSlot int
-		err := tx.QueryRow(ctx,
-			`SELECT steam_id, slot FROM characters WHERE id = $1`, id,
-		).Scan(&oldSteamID, &oldSlot)
-		if err == pgx.ErrNoRows {
-			return database.ErrNoDocument
-		}
+		before, err := characterState(ctx, tx, id)
 		if err != nil {
 			return err
 		}
 
-		// Ensure target user exists.
-		var exists int
-		if err := tx.QueryRow(ctx,
-			`SELECT COUNT(*) FROM users WHERE id = $1`, steamid,
-		).Scan(&exists); err != nil {
+		if err := beginOperation(ctx, tx, id, "move"); err != nil {
 			return err
 		}
-		if exists == 0 {
-			return database.ErrNoDocument
+
+		if err := moveCharacter(ctx, tx, id, steamid, slot); err != nil {
+			return err
 		}
 
-		// Clear old slot.
-		if _, err := tx.Exec(ctx, `
-			UPDATE characters SET steam_id = NULL, slot = NULL
-			WHERE steam_id = $1 AND slot = $2 AND deleted_at IS NULL`,
-			oldSteamID, oldSlot,
-		); err != nil {
+		if err := recordLineage(ctx, tx, id, id, "move", steamid, slot, admin); err != nil {
 			return err
 		}
 
-		// Assign to new owner.
-		_, err = tx.Exec(ctx, `
-			UPDATE characters SET steam_id = $1, slot = $2, deleted_at = NULL
-			WHERE id = $3`,
-			steamid, slot, id,
-		)
-		return err
+		return journalMove(ctx, tx, id, before, steamid, slot)
 	})
 }
 
+// moveCharacter is the body of MoveCharacter so other operations can move
+// characters within their own transaction.
+func moveCharacter(ctx context.Context, tx pgx.Tx, id uuid.UUID, steamid string, slot int) error {
+	var oldSteamID pgtype.Text
+	var oldSlot pgtype.Int4
+	var deletedAt pgtype.Timestamptz
+	err := tx.QueryRow(ctx,
+		`SELECT steam_id, slot, deleted_at FROM characters WHERE id = $1`, id,
+	).Scan(&oldSteamID, &oldSlot, &deletedAt)
+	if err == pgx.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
+	if deletedAt.Valid {
+		return fmt.Errorf("character %s is deleted: %w", id, database.ErrNoDocument)
+	}
+
+	// Ensure target user exists.
+	var exists int
+	if err := tx.QueryRow(ctx,
+		`SELECT COUNT(*) FROM users WHERE id = $1`, steamid,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return database.ErrNoDocument
+	}
+
+	if err := checkSlotFree(ctx, tx, steamid, slot, id); err != nil {
+		return err
+	}
+
+	// Clear old slot.
+	if _, err := tx.Exec(ctx, `
+		UPDATE characters SET steam_id = NULL, slot = NULL
+		WHERE steam_id = $1 AND slot = $2 AND deleted_at IS NULL`,
+		oldSteamID, oldSlot,
+	); err != nil {
+		return err
+	}
+
+	// Assign to new owner.
+	_, err = tx.Exec(ctx, `
+		UPDATE characters SET steam_id = $1, slot = $2
+		WHERE id = $3`,
+		steamid, slot, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return characterChange(ctx, tx, database.ChangeCharacterMoved, id)
+}
+
 // CopyCharacter duplicates a character's current data under a new UUID.
-func (d *postgresDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid.UUID, error) {
+func (d *postgresDB) CopyCharacter(id uuid.UUID, steamid string, slot int, admin string) (uuid.UUID, error) {
 	newID := uuid.New()
 	now := time.Now().UTC()
 	ctx := context.Background()
@@ -401,6 +477,10 @@ func (d *postgresDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid
 			return err
 		}
 
+		if err := checkSlotFree(ctx, tx, steamid, slot, uuid.Nil); err != nil {
+			return err
+		}
+
 		if _, err := tx.Exec(ctx,
 			`INSERT INTO users (id) VALUES ($1) ON CONFLICT(id) DO NOTHING`, steamid,
 		); err != nil {
@@ -413,7 +493,29 @@ func (d *postgresDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid
 			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
 			newID, steamid, slot, now, dataCreatedAt, dataSize, dataPayload,
 		)
-		return err
+		if err != nil {
+			return err
+		}
+
+		// The operation is recorded against the copy, undoing it removes the copy.
+		if err := recordOperation(ctx, tx, newID, "copy", 0, steamid, slot); err != nil {
+			return err
+		}
+
+		if err := recordLineage(ctx, tx, newID, id, "copy", steamid, slot, admin); err != nil {
+			return err
+		}
+
+		if err := characterChangeFrom(ctx, tx, database.ChangeCharacterCopied, newID, id); err != nil {
+			return err
+		}
+
+		before, err := characterState(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		return journalOperation(ctx, tx, "copy", id, newID, before)
 	})
 	if err != nil {
 		return uuid.Nil, err
@@ -421,15 +523,149 @@ func (d *postgresDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid
 	return newID, nil
 }
 
+// ImportCharacter writes an uploaded character file into a slot. If the slot is empty a new
+// character is created, otherwise the current data is snapshotted so the import can be
+// undone and replaced, any update from the game server still waiting to be flushed is
+// dropped so it can't overwrite the import. Either way the imported data is recorded as an
+// "import" version, older versions are pruned to stay within backupMax.
+func (d *postgresDB) ImportCharacter(steamid string, slot int, size int, data string, note string, admin string, backupMax int) (uuid.UUID, bool, error) {
+	charID := uuid.New()
+	created := false
+	var dropped *pendingUpdate
+	now := time.Now().UTC()
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO users (id) VALUES ($1) ON CONFLICT(id) DO NOTHING`, steamid,
+		); err != nil {
+			return fmt.Errorf("upsert user: %w", err)
+		}
+
+		var (
+			existingID uuid.UUID
+			dataCreatedAt time.Time
+			dataSize int
+			dataPayload string
+		)
+		err := tx.QueryRow(ctx, `
+			SELECT id, data_created_at, data_size, data_payload
+			FROM characters
+			WHERE steam_id = $1 AND slot = $2 AND deleted_at IS NULL
+			FOR UPDATE`,
+			steamid, slot,
+		).Scan(&existingID, &dataCreatedAt, &dataSize, &dataPayload)
+
+		switch {
+		case err == pgx.ErrNoRows:
+			created = true
+			if _, err := tx.Exec(ctx, `
+				INSERT INTO characters
+					(id, steam_id, slot, created_at, data_created_at, data_size, data_payload)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+				charID, steamid, slot, now, now, size, data,
+			); err != nil {
+				return fmt.Errorf("insert character: %w", err)
+			}
+
+			if err := recordOperation(ctx, tx, charID, "import", 0, steamid, slot); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			charID = existingID
+			if upd, ok := d.dropPendingUpdate(charID); ok {
+				dropped = &upd
+			}
+
+			snapID, err := insertSnapshot(ctx, tx, charID, "import", dataCreatedAt, dataSize, dataPayload)
+			if err != nil {
+				return err
+			}
+
+			if err := recordOperation(ctx, tx, charID, "import", snapID, steamid, slot); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(ctx, `
+				UPDATE characters
+				SET data_created_at = $1, data_size = $2, data_payload = $3
+				WHERE id = $4`,
+				now, size, data, charID,
+			); err != nil {
+				return err
+			}
+		}
+
+		if err := capVersions(ctx, tx, charID, backupMax); err != nil {
+			return err
+		}
+
+		if _, err := insertVersion(ctx, tx, charID, now, size, data, "import", note); err != nil {
+			return err
+		}
+
+		kind := database.ChangeCharacterUpdated
+		if created {
+			kind = database.ChangeCharacterCreated
+		}
+		if err := characterChange(ctx, tx, kind, charID); err != nil {
+			return err
+		}
+
+		return recordLineage(ctx, tx, charID, uuid.Nil, "import", steamid, slot, admin)
+	})
+	if err != nil {
+		if dropped != nil {
+			d.requeueUpdate(charID, *dropped)
+		}
+		return uuid.Nil, false, err
+	}
+	return charID, created, nil
+}
+
+// capVersions prunes the character's oldest versions to make room for one more within
+// max, 0 keeps them all.
+func capVersions(ctx context.Context, tx pgx.Tx, id uuid.UUID, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, `
+		DELETE FROM character_versions
+		WHERE character_id = $1 AND id NOT IN (
+			SELECT id FROM character_versions
+			WHERE character_id = $1 ORDER BY id DESC LIMIT $2
+		)`,
+		id, max-1,
+	)
+	return err
+}
+
+// insertVersion appends a tagged version to the character's version history
+// and returns the ID of the new version.
+func insertVersion(ctx context.Context, tx pgx.Tx, id uuid.UUID, createdAt time.Time, size int, data string, tag string, note string) (int64, error) {
+	var verID int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO character_versions (character_id, created_at, size, data_payload, tag, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		id, createdAt, size, data, tag, note,
+	).Scan(&verID)
+	return verID, err
+}
+
 // RestoreCharacter clears the soft-delete markers and makes the character active again.
-func (d *postgresDB) RestoreCharacter(id uuid.UUID) error {
+// slot restores it into a different slot than it was deleted from, nil keeps the original slot.
+func (d *postgresDB) RestoreCharacter(id uuid.UUID, slot *int, admin string) error {
 	ctx := context.Background()
 	return d.execTx(ctx, func(tx pgx.Tx) error {
 		var steamID string
-		var slot int
+		var origSlot int
 		err := tx.QueryRow(ctx,
 			`SELECT steam_id, slot FROM deleted_characters WHERE character_id = $1`, id,
-		).Scan(&steamID, &slot)
+		).Scan(&steamID, &origSlot)
 		if err == pgx.ErrNoRows {
 			return database.ErrNoDocument
 		}
@@ -437,58 +673,269 @@ func (d *postgresDB) RestoreCharacter(id uuid.UUID) error {
 			return err
 		}
 
+		target := origSlot
+		if slot != nil {
+			target = *slot
+		}
+
+		if err := checkSlotFree(ctx, tx, steamID, target, id); err != nil {
+			return err
+		}
+
+		if err := beginOperation(ctx, tx, id, "restore"); err != nil {
+			return err
+		}
+
 		if _, err := tx.Exec(ctx, `
 			UPDATE characters SET deleted_at = NULL, expires_at = NULL, steam_id = $1, slot = $2 WHERE id = $3`,
-			steamID, slot, id,
+			steamID, target, id,
 		); err != nil {
 			return err
 		}
 
-		_, err = tx.Exec(ctx,
+		if _, err := tx.Exec(ctx,
 			`DELETE FROM deleted_characters WHERE character_id = $1`,
 			id,
+		); err != nil {
+			return err
+		}
+
+		if err := characterChange(ctx, tx, database.ChangeCharacterRestored, id); err != nil {
+			return err
+		}
+
+		return recordLineage(ctx, tx, id, id, "restore", steamID, target, admin)
+	})
+}
+
+// GetDeletedSlot returns the owner and slot a soft deleted character was deleted from.
+func (d *postgresDB) GetDeletedSlot(id uuid.UUID) (string, int, error) {
+	ctx := context.Background()
+	var steamID string
+	var slot int
+	err := d.db.QueryRow(ctx,
+		`SELECT steam_id, slot FROM deleted_characters WHERE character_id = $1`, id,
+	).Scan(&steamID, &slot)
+	if err == pgx.ErrNoRows {
+		return "", 0, database.ErrNoDocument
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	return steamID, slot, nil
+}
+
+// GetDeletedCharacters returns the user's soft deleted characters, ordered by the slot
+// they were deleted from.
+func (d *postgresDB) GetDeletedCharacters(steamid string) ([]schema.CharacterSummary, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT c.id, dc.steam_id, dc.slot, c.created_at, c.data_created_at, c.data_size,
+			c.data_server, c.deleted_at, c.expires_at
+		FROM deleted_characters dc
+		JOIN characters c ON c.id = dc.character_id
+		WHERE dc.steam_id = $1
+		ORDER BY dc.slot`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chars := make([]schema.CharacterSummary, 0)
+	for rows.Next() {
+		c, err := scanCharacterSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, *c)
+	}
+	return chars, rows.Err()
+}
+
+// SetCharacterExpiry changes when GC purges a soft deleted character.
+func (d *postgresDB) SetCharacterExpiry(id uuid.UUID, expiresAt time.Time) error {
+	ctx := context.Background()
+	return d.execTx(ctx, func(tx pgx.Tx) error {
+		ct, err := tx.Exec(ctx,
+			`UPDATE characters SET expires_at = $1 WHERE id = $2 AND deleted_at IS NOT NULL`,
+			expiresAt.UTC(), id,
 		)
-		return err
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 0 {
+			return database.ErrNoDocument
+		}
+		return nil
 	})
 }
 
-// RollbackCharacter replaces the current character data with the version at
-// index ver (0-based, ordered oldest → newest).
-func (d *postgresDB) RollbackCharacter(id uuid.UUID, ver int) error {
+// SwapCharacters swaps the characters in two of the user's slots in one transaction,
+// either slot may be empty. Swapping the same slots again reverses it.
+func (d *postgresDB) SwapCharacters(steamid string, a int, b int) error {
 	ctx := context.Background()
 	return d.execTx(ctx, func(tx pgx.Tx) error {
-		var createdAt time.Time
-		var size int
-		var payload string
-		err := tx.QueryRow(ctx, `
-			SELECT created_at, size, data_payload
-			FROM character_versions
-			WHERE character_id = $1
-			ORDER BY id ASC
-			LIMIT 1 OFFSET $2`,
-			id, ver,
-		).Scan(&createdAt, &size, &payload)
-		if err == pgx.ErrNoRows {
-			return fmt.Errorf("no character version at index %d", ver)
+		idA, err := slotCharacter(ctx, tx, steamid, a)
+		if err != nil {
+			return err
 		}
+		idB, err := slotCharacter(ctx, tx, steamid, b)
 		if err != nil {
 			return err
 		}
+		if idA == uuid.Nil && idB == uuid.Nil {
+			return database.ErrNoDocument
+		}
 
-		_, err = tx.Exec(ctx, `
-			UPDATE characters
-			SET data_created_at = $1, data_size = $2, data_payload = $3
-			WHERE id = $4`,
-			createdAt, size, payload, id,
-		)
+		// Free both slots first so the UNIQUE (steam_id, slot) constraint doesn't block the swap.
+		if _, err := tx.Exec(ctx, `
+			UPDATE characters SET slot = NULL
+			WHERE steam_id = $1 AND slot IN ($2, $3) AND deleted_at IS NULL`,
+			steamid, a, b,
+		); err != nil {
+			return err
+		}
+
+		if idA != uuid.Nil {
+			if _, err := tx.Exec(ctx, `UPDATE characters SET slot = $1 WHERE id = $2`, b, idA); err != nil {
+				return err
+			}
+		}
+		if idB != uuid.Nil {
+			if _, err := tx.Exec(ctx, `UPDATE characters SET slot = $1 WHERE id = $2`, a, idB); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range []uuid.UUID{idA, idB} {
+			if id == uuid.Nil {
+				continue
+			}
+			if err := characterChange(ctx, tx, database.ChangeCharacterMoved, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// slotCharacter returns the active character in the slot, uuid.Nil when it's empty.
+func slotCharacter(ctx context.Context, tx pgx.Tx, steamid string, slot int) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := tx.QueryRow(ctx, `
+		SELECT id FROM characters
+		WHERE steam_id = $1 AND slot = $2 AND deleted_at IS NULL`,
+		steamid, slot,
+	).Scan(&id)
+	if err == pgx.ErrNoRows {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+// checkSlotFree returns a SlotOccupiedError when an active character other than id is in the slot.
+func checkSlotFree(ctx context.Context, tx pgx.Tx, steamid string, slot int, id uuid.UUID) error {
+	other, err := slotCharacter(ctx, tx, steamid, slot)
+	if err != nil {
 		return err
+	}
+	if other != uuid.Nil && other != id {
+		return &database.SlotOccupiedError{SteamID: steamid, Slot: slot, CharacterID: other}
+	}
+	return nil
+}
+
+// RollbackCharacter replaces the current character data with the version ver.
+// Versions are referenced by ID so pruning older versions doesn't change which one we get.
+func (d *postgresDB) RollbackCharacter(id uuid.UUID, ver int64) error {
+	ctx := context.Background()
+	return d.replaceData(ctx, []uuid.UUID{id}, func(tx pgx.Tx) error {
+		return rollbackCharacter(ctx, tx, id, ver)
+	})
+}
+
+// RollbackCharacters rolls back every target in one transaction, none of them are
+// rolled back when one fails.
+func (d *postgresDB) RollbackCharacters(targets []database.RollbackTarget) error {
+	ids := make([]uuid.UUID, len(targets))
+	for i, t := range targets {
+		ids[i] = t.ID
+	}
+
+	ctx := context.Background()
+	return d.replaceData(ctx, ids, func(tx pgx.Tx) error {
+		for _, t := range targets {
+			if err := rollbackCharacter(ctx, tx, t.ID, t.Version); err != nil {
+				return fmt.Errorf("character %s: %w", t.ID, err)
+			}
+		}
+		return nil
 	})
 }
 
+func rollbackCharacter(ctx context.Context, tx pgx.Tx, id uuid.UUID, ver int64) error {
+	var createdAt time.Time
+	var size int
+	var payload string
+	err := tx.QueryRow(ctx, `
+		SELECT created_at, size, data_payload
+		FROM character_versions
+		WHERE id = $1 AND character_id = $2`,
+		ver, id,
+	).Scan(&createdAt, &size, &payload)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("no character version %d: %w", ver, database.ErrNoDocument)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := beginOperation(ctx, tx, id, "rollback"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE characters
+		SET data_created_at = $1, data_size = $2, data_payload = $3
+		WHERE id = $4`,
+		createdAt, size, payload, id,
+	); err != nil {
+		return err
+	}
+
+	return characterChange(ctx, tx, database.ChangeCharacterRolledBack, id)
+}
+
+// GetCharacterVersionAt returns the ID of the newest version saved at or before t.
+func (d *postgresDB) GetCharacterVersionAt(id uuid.UUID, t time.Time) (int64, error) {
+	ctx := context.Background()
+	var ver int64
+	err := d.db.QueryRow(ctx, `
+		SELECT id FROM character_versions
+		WHERE character_id = $1 AND created_at <= $2
+		ORDER BY created_at DESC, id DESC LIMIT 1`,
+		id, t,
+	).Scan(&ver)
+	if err == pgx.ErrNoRows {
+		return 0, fmt.Errorf("no character version at or before %s: %w", t.UTC().Format(time.RFC3339), database.ErrNoDocument)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return ver, nil
+}
+
 // RollbackCharacterToLatest replaces the current data with the most recent version.
 func (d *postgresDB) RollbackCharacterToLatest(id uuid.UUID) error {
 	ctx := context.Background()
-	return d.execTx(ctx, func(tx pgx.Tx) error {
+	return d.replaceData(ctx, []uuid.UUID{id}, func(tx pgx.Tx) error {
 		var createdAt time.Time
 		var size int
 		var payload string
@@ -500,19 +947,26 @@ func (d *postgresDB) RollbackCharacterToLatest(id uuid.UUID) error {
 			id,
 		).Scan(&createdAt, &size, &payload)
 		if err == pgx.ErrNoRows {
-			return fmt.Errorf("no character backups exist")
+			return fmt.Errorf("no character backups exist: %w", database.ErrNoDocument)
 		}
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.Exec(ctx, `
+		if err := beginOperation(ctx, tx, id, "rollback"); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `
 			UPDATE characters
 			SET data_created_at = $1, data_size = $2, data_payload = $3
 			WHERE id = $4`,
 			createdAt, size, payload, id,
-		)
-		return err
+		); err != nil {
+			return err
+		}
+
+		return characterChange(ctx, tx, database.ChangeCharacterRolledBack, id)
 	})
 }
 
@@ -527,10 +981,11 @@ func (d *postgresDB) DeleteCharacterVersions(id uuid.UUID) error {
 	})
 }
 
-func (d *postgresDB) GetRollbackVersionsTimestamp(id uuid.UUID) (map[int]string, error) {
+// GetRollbackVersionsTimestamp returns the timestamp of every version keyed by version ID.
+func (d *postgresDB) GetRollbackVersionsTimestamp(id uuid.UUID) (map[int64]string, error) {
 	ctx := context.Background()
 	rows, err := d.db.Query(ctx, `
-		SELECT created_at
+		SELECT id, created_at
 		FROM character_versions
 		WHERE character_id = $1
 		ORDER BY id ASC`,
@@ -541,15 +996,66 @@ func (d *postgresDB) GetRollbackVersionsTimestamp(id uuid.UUID) (map[int]string,
 	}
 	defer rows.Close()
 
-	versions := make(map[int]string)
-	idx := 0
+	versions := make(map[int64]string)
 	for rows.Next() {
+		var ver int64
 		var createdAt time.Time
-		if err := rows.Scan(&createdAt); err != nil {
+		if err := rows.Scan(&ver, &createdAt); err != nil {
 			return nil, err
 		}
-		versions[idx] = createdAt.UTC().Format(time.RFC3339)
-		idx++
+		versions[ver] = createdAt.UTC().Format(time.RFC3339)
 	}
 	return versions, rows.Err()
-}
\ No newline at end of file
+}
+
+// GetModifiedCharacters finds active characters last saved between from and to. If servers
+// is set only characters saved by one of those servers within the window are returned.
+func (d *postgresDB) GetModifiedCharacters(from time.Time, to time.Time, servers []string) ([]database.ModifiedCharacter, error) {
+	ctx := context.Background()
+
+	query := `
+		SELECT c.id, c.steam_id, c.slot, c.data_created_at, c.data_server, v.id, v.created_at
+		FROM characters c
+		LEFT JOIN character_versions v ON v.id = (
+			SELECT id FROM character_versions
+			WHERE character_id = c.id AND created_at < $1
+			ORDER BY created_at DESC, id DESC LIMIT 1
+		)
+		WHERE c.deleted_at IS NULL AND c.data_created_at >= $1 AND c.data_created_at <= $2`
+	args := []interface{}{from, to}
+
+	if len(servers) > 0 {
+		query += `
+		AND (c.data_server = ANY($3) OR EXISTS (
+			SELECT 1 FROM character_versions sv
+			WHERE sv.character_id = c.id AND sv.server = ANY($3)
+			AND sv.created_at >= $1 AND sv.created_at <= $2
+		))`
+		args = append(args, servers)
+	}
+	query += ` ORDER BY c.data_created_at ASC`
+
+	rows, err := d.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chars []database.ModifiedCharacter
+	for rows.Next() {
+		var (
+			c database.ModifiedCharacter
+			verID pgtype.Int8
+			verCreatedAt pgtype.Timestamptz
+		)
+		if err := rows.Scan(
+			&c.ID, &c.SteamID, &c.Slot, &c.ModifiedAt, &c.Server, &verID, &verCreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		c.Version = verID.Int64
+		c.VersionCreatedAt = verCreatedAt.Time
+		chars = append(chars, c)
+	}
+	return chars, rows.Err()
+}
@@ -0,0 +1,241 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GrantUserFlag sets the flag's bit and records who granted it and until when, a grant
+// the user already has for the flag is replaced.
+func (d *postgresDB) GrantUserFlag(grant *schema.FlagGrant) error {
+	ctx := context.Background()
+	return d.execTx(ctx, func(tx pgx.Tx) error {
+		ct, err := tx.Exec(ctx,
+			`UPDATE users SET flags = flags | (1 << $1::integer) WHERE id = $2`, int32(grant.Bit), grant.SteamID,
+		)
+		if err != nil {
+			return err
+		}
+		if ct.RowsAffected() == 0 {
+			return database.ErrNoDocument
+		}
+
+		if err := userChange(ctx, tx, grant.SteamID); err != nil {
+			return err
+		}
+
+		return saveGrant(ctx, tx, grant)
+	})
+}
+
+// BulkUpdateFlags applies the changes in order in one transaction, users that haven't
+// joined yet are created. Grants are saved for the bits an add or set covers and dropped
+// along with the bits a change clears. Setting BANNED records a ban and clearing it lifts
+// the active ban, the same as AddBan and LiftBan.
+func (d *postgresDB) BulkUpdateFlags(changes []database.FlagChange) ([]database.FlagChangeResult, error) {
+	var results []database.FlagChangeResult
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		results = make([]database.FlagChangeResult, 0, len(changes))
+		for _, c := range changes {
+			ct, err := tx.Exec(ctx, `INSERT INTO users (id) VALUES ($1) ON CONFLICT (id) DO NOTHING`, c.SteamID)
+			if err != nil {
+				return fmt.Errorf("upsert user: %w", err)
+			}
+
+			var flags uint32
+			if err := tx.QueryRow(ctx, `SELECT flags FROM users WHERE id = $1`, c.SteamID).Scan(&flags); err != nil {
+				return err
+			}
+			before := bitmask.Bitmask(flags)
+			after := c.Apply(before)
+
+			if after != before {
+				if _, err := tx.Exec(ctx, `UPDATE users SET flags = $1 WHERE id = $2`, uint32(after), c.SteamID); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(ctx,
+					`DELETE FROM flag_grants WHERE steam_id = $1 AND ($2::integer >> bit) & 1 = 0`, c.SteamID, int32(after),
+				); err != nil {
+					return err
+				}
+				if err := userChange(ctx, tx, c.SteamID); err != nil {
+					return err
+				}
+			}
+
+			if err := d.bulkBan(ctx, tx, c, before, after); err != nil {
+				return err
+			}
+
+			if c.Op != database.FlagRemove {
+				for bit := uint(0); bit < 32; bit++ {
+					// The ban decides when BANNED is cleared, not a grant.
+					if !c.Mask.HasFlag(1 << bit) || bitmask.Bitmask(1 << bit) == bitmask.BANNED {
+						continue
+					}
+					if err := saveGrant(ctx, tx, &schema.FlagGrant{
+						SteamID: c.SteamID,
+						Bit: bit,
+						Source: c.Source,
+						Admin: c.Admin,
+						GrantedAt: c.GrantedAt,
+						ExpiresAt: c.ExpiresAt,
+					}); err != nil {
+						return err
+					}
+				}
+			}
+
+			results = append(results, database.FlagChangeResult{
+				SteamID: c.SteamID,
+				Before: before,
+				After: after,
+				Created: ct.RowsAffected() > 0,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// bulkBan records or lifts a ban when the change sets or clears the user's BANNED flag.
+func (d *postgresDB) bulkBan(ctx context.Context, tx pgx.Tx, c database.FlagChange, before bitmask.Bitmask, after bitmask.Bitmask) error {
+	switch {
+	case !before.HasFlag(bitmask.BANNED) && after.HasFlag(bitmask.BANNED):
+		_, err := d.insertBan(ctx, tx, &schema.Ban{
+			SteamID: c.SteamID,
+			Reason: c.Reason,
+			Admin: c.Admin,
+			CreatedAt: c.GrantedAt,
+			ExpiresAt: c.ExpiresAt,
+		})
+		return err
+	case before.HasFlag(bitmask.BANNED) && !after.HasFlag(bitmask.BANNED):
+		if err := liftBans(ctx, tx, c.SteamID, c.GrantedAt, c.Admin, c.Reason); err != nil {
+			return err
+		}
+		return d.queueUnban(ctx, tx, c.SteamID, c.Admin, fmt.Sprintf("%s was unbanned by a bulk flag change: %s", c.SteamID, c.Reason), nil)
+	}
+	return nil
+}
+
+// saveGrant records the grant, replacing the one the user already has for the bit.
+func saveGrant(ctx context.Context, tx pgx.Tx, grant *schema.FlagGrant) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO flag_grants (steam_id, bit, source, admin, granted_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (steam_id, bit) DO UPDATE
+		SET source     = EXCLUDED.source,
+		    admin      = EXCLUDED.admin,
+		    granted_at = EXCLUDED.granted_at,
+		    expires_at = EXCLUDED.expires_at`,
+		grant.SteamID, int32(grant.Bit), grant.Source, grant.Admin, grant.GrantedAt.UTC(), grant.ExpiresAt,
+	)
+	return err
+}
+
+// GetFlagGrants returns the user's grants ordered by bit.
+func (d *postgresDB) GetFlagGrants(steamid string) ([]schema.FlagGrant, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT steam_id, bit, source, admin, granted_at, expires_at
+		FROM flag_grants
+		WHERE steam_id = $1
+		ORDER BY bit ASC`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return readGrants(rows)
+}
+
+// readGrants reads and closes rows selecting every column of flag_grants.
+func readGrants(rows pgx.Rows) ([]schema.FlagGrant, error) {
+	defer rows.Close()
+
+	grants := make([]schema.FlagGrant, 0)
+	for rows.Next() {
+		var (
+			g schema.FlagGrant
+			bit int32
+		)
+		if err := rows.Scan(&g.SteamID, &bit, &g.Source, &g.Admin, &g.GrantedAt, &g.ExpiresAt); err != nil {
+			return nil, err
+		}
+		g.Bit = uint(bit)
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// ExpireFlagGrants clears the bits of every grant that ran out by now and removes the
+// grants. It returns how many grants expired.
+func (d *postgresDB) ExpireFlagGrants(now time.Time) (int, error) {
+	var expired int
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			DELETE FROM flag_grants
+			WHERE expires_at IS NOT NULL AND expires_at <= $1
+			RETURNING steam_id, bit`,
+			now.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		var grants []schema.FlagGrant
+		for rows.Next() {
+			var (
+				g schema.FlagGrant
+				bit int32
+			)
+			if err := rows.Scan(&g.SteamID, &bit); err != nil {
+				rows.Close()
+				return err
+			}
+			g.Bit = uint(bit)
+			grants = append(grants, g)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, g := range grants {
+			if _, err := tx.Exec(ctx,
+				`UPDATE users SET flags = flags & ~(1 << $1::integer) WHERE id = $2`, int32(g.Bit), g.SteamID,
+			); err != nil {
+				return err
+			}
+
+			if err := userChange(ctx, tx, g.SteamID); err != nil {
+				return err
+			}
+		}
+
+		expired = len(grants)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const jobColumns = `id, kind, status, params, progress, total, result, error, requested_by,
+	created_at, started_at, finished_at`
+
+func (d *postgresDB) AddJob(job *schema.Job) error {
+	ctx := context.Background()
+	_, err := d.db.Exec(ctx, `
+		INSERT INTO jobs (id, kind, status, params, progress, total, result, requested_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		job.ID, job.Kind, job.Status, string(job.Params), int32(job.Progress), int32(job.Total), string(job.Result),
+		job.RequestedBy, job.CreatedAt.UTC(),
+	)
+	return err
+}
+
+// UpdateJob saves the job's status, progress and result, ErrNoDocument is returned when
+// there's no such job.
+func (d *postgresDB) UpdateJob(job *schema.Job) error {
+	ctx := context.Background()
+	ct, err := d.db.Exec(ctx, `
+		UPDATE jobs SET status = $1, progress = $2, total = $3, result = $4, error = $5, started_at = $6, finished_at = $7
+		WHERE id = $8`,
+		job.Status, int32(job.Progress), int32(job.Total), string(job.Result), job.Error,
+		job.StartedAt, job.FinishedAt, job.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return database.ErrNoDocument
+	}
+
+	return nil
+}
+
+func (d *postgresDB) GetJob(id uuid.UUID) (*schema.Job, error) {
+	ctx := context.Background()
+	j, err := scanJob(d.db.QueryRow(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// GetJobs returns the newest jobs, status and kind filter them when they're not empty.
+func (d *postgresDB) GetJobs(status string, kind string, limit int) ([]schema.Job, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE ($1::text = '' OR status = $1) AND ($2::text = '' OR kind = $2)
+		ORDER BY created_at DESC LIMIT $3`,
+		status, kind, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]schema.Job, 0)
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *j)
+	}
+	return jobs, rows.Err()
+}
+
+// PruneJobs removes the jobs that finished before the given time and returns how many
+// there were, queued and running jobs are always kept.
+func (d *postgresDB) PruneJobs(before time.Time) (int, error) {
+	ctx := context.Background()
+	ct, err := d.db.Exec(ctx,
+		`DELETE FROM jobs WHERE status IN ($1, $2) AND finished_at < $3`,
+		database.JobDone, database.JobFailed, before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(ct.RowsAffected()), nil
+}
+
+func scanJob(row pgx.Row) (*schema.Job, error) {
+	var (
+		j schema.Job
+		progress int32
+		total int32
+		params string
+		result string
+	)
+	err := row.Scan(
+		&j.ID, &j.Kind, &j.Status, &params, &progress, &total, &result, &j.Error, &j.RequestedBy,
+		&j.CreatedAt, &j.StartedAt, &j.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	j.Progress = int(progress)
+	j.Total = int(total)
+	if params != "" {
+		j.Params = []byte(params)
+	}
+	if result != "" {
+		j.Result = []byte(result)
+	}
+	return &j, nil
+}
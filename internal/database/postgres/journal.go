@@ -0,0 +1,352 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// GetJournal returns the most recent journal entries, newest first. The before
+// state is left out since it holds every version of the character.
+func (d *postgresDB) GetJournal(limit int) ([]schema.JournalEntry, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT id, operation, character_id, target_id, to_steam_id, to_slot, created_at, reverted_at
+		FROM operation_journal
+		ORDER BY id DESC LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]schema.JournalEntry, 0)
+	for rows.Next() {
+		var e schema.JournalEntry
+		var toSteamID pgtype.Text
+		if err := rows.Scan(&e.ID, &e.Operation, &e.CharacterID, &e.TargetID, &toSteamID, &e.ToSlot, &e.CreatedAt, &e.RevertedAt); err != nil {
+			return nil, err
+		}
+		e.ToSteamID = toSteamID.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetJournalEntry returns a journal entry along with the character's state before the operation.
+func (d *postgresDB) GetJournalEntry(id int64) (*schema.JournalEntry, error) {
+	ctx := context.Background()
+	return journalEntry(d.db.QueryRow(ctx, `
+		SELECT id, operation, character_id, target_id, to_steam_id, to_slot, before_state, created_at, reverted_at
+		FROM operation_journal WHERE id = $1`,
+		id,
+	))
+}
+
+// RevertJournalEntry reverts a journaled operation. Moves are moved back as long as the
+// character is still where the move put it, copies are soft deleted and deleted characters
+// are recreated with all of their versions under their original IDs. admin is recorded in
+// the lineage of characters moved back or recreated.
+func (d *postgresDB) RevertJournalEntry(id int64, expiration time.Duration, admin string) (*schema.JournalEntry, error) {
+	var entry *schema.JournalEntry
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		entry, err = journalEntry(tx.QueryRow(ctx, `
+			SELECT id, operation, character_id, target_id, to_steam_id, to_slot, before_state, created_at, reverted_at
+			FROM operation_journal WHERE id = $1
+			FOR UPDATE`,
+			id,
+		))
+		if err != nil {
+			return err
+		}
+		if entry.RevertedAt != nil {
+			return database.ErrAlreadyReverted
+		}
+
+		now := time.Now().UTC()
+		switch entry.Operation {
+		case "move":
+			if err := checkMovedTo(ctx, tx, entry); err != nil {
+				return err
+			}
+			if err := undoMove(ctx, tx, entry.CharacterID, entry.Before.SteamID, entry.Before.Slot); err != nil {
+				return err
+			}
+			if err := recordLineage(ctx, tx, entry.CharacterID, entry.CharacterID, "revert", entry.Before.SteamID, entry.Before.Slot, admin); err != nil {
+				return err
+			}
+		case "copy":
+			if entry.TargetID == nil {
+				return fmt.Errorf("journal entry %d has no copy to remove", id)
+			}
+			if err := softDeleteCharacter(ctx, tx, *entry.TargetID, now, now.Add(expiration)); err != nil {
+				return err
+			}
+			// The copy is gone, none of its operations can be undone anymore.
+			if _, err := tx.Exec(ctx,
+				`UPDATE character_operations SET undone_at = $1 WHERE character_id = $2 AND undone_at IS NULL`,
+				now, *entry.TargetID,
+			); err != nil {
+				return err
+			}
+		case "delete":
+			if err := restoreCharacterState(ctx, tx, entry.Before, now.Add(expiration)); err != nil {
+				return err
+			}
+			if err := recordLineage(ctx, tx, entry.CharacterID, entry.CharacterID, "revert", entry.Before.SteamID, entry.Before.Slot, admin); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unable to revert %s operation", entry.Operation)
+		}
+
+		entry.RevertedAt = &now
+		_, err = tx.Exec(ctx,
+			`UPDATE operation_journal SET reverted_at = $1 WHERE id = $2`,
+			now, id,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// PruneJournal removes journal entries created before the given time.
+func (d *postgresDB) PruneJournal(before time.Time) error {
+	ctx := context.Background()
+	return d.execTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM operation_journal WHERE created_at < $1`, before)
+		return err
+	})
+}
+
+func journalEntry(row pgx.Row) (*schema.JournalEntry, error) {
+	var (
+		e schema.JournalEntry
+		toSteamID pgtype.Text
+		before string
+	)
+	err := row.Scan(&e.ID, &e.Operation, &e.CharacterID, &e.TargetID, &toSteamID, &e.ToSlot, &before, &e.CreatedAt, &e.RevertedAt)
+	if err == pgx.ErrNoRows {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.ToSteamID = toSteamID.String
+
+	e.Before = &schema.Character{}
+	if err := json.Unmarshal([]byte(before), e.Before); err != nil {
+		return nil, fmt.Errorf("journal entry %d: %w", e.ID, err)
+	}
+
+	return &e, nil
+}
+
+// journalOperation writes an operation to the journal along with the character's
+// state from before it. targetID is the new character for copies, otherwise uuid.Nil.
+func journalOperation(ctx context.Context, tx pgx.Tx, op string, id uuid.UUID, targetID uuid.UUID, before *schema.Character) error {
+	return insertJournal(ctx, tx, op, id, targetID, before, pgtype.Text{}, pgtype.Int4{})
+}
+
+// journalMove journals a move along with where it put the character, so reverting it
+// can check the character hasn't been moved again since.
+func journalMove(ctx context.Context, tx pgx.Tx, id uuid.UUID, before *schema.Character, steamid string, slot int) error {
+	return insertJournal(ctx, tx, "move", id, uuid.Nil, before,
+		pgtype.Text{String: steamid, Valid: true}, pgtype.Int4{Int32: int32(slot), Valid: true},
+	)
+}
+
+func insertJournal(ctx context.Context, tx pgx.Tx, op string, id uuid.UUID, targetID uuid.UUID, before *schema.Character, toSteamID pgtype.Text, toSlot pgtype.Int4) error {
+	state, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+
+	var target *uuid.UUID
+	if targetID != uuid.Nil {
+		target = &targetID
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO operation_journal (operation, character_id, target_id, to_steam_id, to_slot, before_state, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		op, id, target, toSteamID, toSlot, string(state), time.Now().UTC(),
+	)
+	return err
+}
+
+// checkMovedTo makes sure a journaled move's character is still active in the slot the
+// move put it in, reverting it otherwise would undo whatever moved it since.
+func checkMovedTo(ctx context.Context, tx pgx.Tx, entry *schema.JournalEntry) error {
+	if entry.Before.SteamID == "" {
+		return fmt.Errorf("character %s: %w", entry.CharacterID, database.ErrNoOwner)
+	}
+
+	var steamID pgtype.Text
+	var slot pgtype.Int4
+	var deletedAt *time.Time
+	err := tx.QueryRow(ctx,
+		`SELECT steam_id, slot, deleted_at FROM characters WHERE id = $1 FOR UPDATE`, entry.CharacterID,
+	).Scan(&steamID, &slot, &deletedAt)
+	if err == pgx.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
+
+	if deletedAt != nil || !slot.Valid || entry.ToSlot == nil ||
+		steamID.String != entry.ToSteamID || int(slot.Int32) != *entry.ToSlot {
+		return fmt.Errorf("character %s: %w", entry.CharacterID, database.ErrMovedSince)
+	}
+	return nil
+}
+
+// characterState reads the full character, versions included, within the transaction.
+// Soft deleted characters get the owner and slot they were deleted from.
+func characterState(ctx context.Context, tx pgx.Tx, id uuid.UUID) (*schema.Character, error) {
+	c := &schema.Character{ID: id}
+
+	var (
+		steamID pgtype.Text
+		slot pgtype.Int4
+	)
+	err := tx.QueryRow(ctx, `
+		SELECT steam_id, slot, created_at, deleted_at,
+			data_created_at, data_size, data_payload, data_server
+		FROM characters WHERE id = $1`,
+		id,
+	).Scan(
+		&steamID, &slot, &c.CreatedAt, &c.DeletedAt,
+		&c.Data.CreatedAt, &c.Data.Size, &c.Data.Data, &c.Data.Server,
+	)
+	if err == pgx.ErrNoRows {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.SteamID = steamID.String
+	c.Slot = int(slot.Int32)
+
+	if c.DeletedAt != nil {
+		err := tx.QueryRow(ctx,
+			`SELECT steam_id, slot FROM deleted_characters WHERE character_id = $1`, id,
+		).Scan(&c.SteamID, &c.Slot)
+		if err != nil && err != pgx.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, created_at, size, data_payload, tag, note, server
+		FROM character_versions
+		WHERE character_id = $1 ORDER BY id ASC`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v schema.CharacterData
+		if err := rows.Scan(&v.ID, &v.CreatedAt, &v.Size, &v.Data, &v.Tag, &v.Note, &v.Server); err != nil {
+			return nil, err
+		}
+		c.Versions = append(c.Versions, v)
+	}
+	return c, rows.Err()
+}
+
+// restoreCharacterState recreates a deleted character from its journaled state. Characters
+// that were soft deleted at the time come back soft deleted with a new expiry.
+func restoreCharacterState(ctx context.Context, tx pgx.Tx, c *schema.Character, expiresAt time.Time) error {
+	var exists int
+	if err := tx.QueryRow(ctx,
+		`SELECT COUNT(*) FROM characters WHERE id = $1`, c.ID,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fmt.Errorf("character %s already exists", c.ID)
+	}
+	if c.SteamID == "" {
+		return fmt.Errorf("character %s: %w", c.ID, database.ErrNoOwner)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO users (id) VALUES ($1) ON CONFLICT(id) DO NOTHING`, c.SteamID,
+	); err != nil {
+		return fmt.Errorf("upsert user: %w", err)
+	}
+
+	if c.DeletedAt == nil {
+		if err := checkSlotFree(ctx, tx, c.SteamID, c.Slot, c.ID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO characters
+				(id, steam_id, slot, created_at, data_created_at, data_size, data_payload, data_server)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			c.ID, c.SteamID, c.Slot, c.CreatedAt, c.Data.CreatedAt, c.Data.Size, c.Data.Data, c.Data.Server,
+		); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO characters
+				(id, created_at, deleted_at, expires_at, data_created_at, data_size, data_payload, data_server)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			c.ID, c.CreatedAt, *c.DeletedAt, expiresAt, c.Data.CreatedAt, c.Data.Size, c.Data.Data, c.Data.Server,
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO deleted_characters (steam_id, slot, character_id, deleted_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (steam_id, slot) DO UPDATE
+			SET character_id = EXCLUDED.character_id,
+			    deleted_at   = EXCLUDED.deleted_at`,
+			c.SteamID, c.Slot, c.ID, *c.DeletedAt,
+		); err != nil {
+			return err
+		}
+	}
+
+	// The versions keep their IDs, they're stable references for rollbacks.
+	for _, v := range c.Versions {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO character_versions (id, character_id, created_at, size, data_payload, tag, note, server)
+			OVERRIDING SYSTEM VALUE
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			v.ID, c.ID, v.CreatedAt, v.Size, v.Data, v.Tag, v.Note, v.Server,
+		); err != nil {
+			return err
+		}
+	}
+
+	kind := database.ChangeCharacterRestored
+	if c.DeletedAt != nil {
+		kind = database.ChangeCharacterDeleted
+	}
+	return characterChange(ctx, tx, kind, c.ID)
+}
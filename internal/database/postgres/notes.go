@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func (d *postgresDB) AddUserNote(note *schema.UserNote) (int64, error) {
+	var id int64
+	ctx := context.Background()
+
+	err := d.db.QueryRow(ctx, `
+		INSERT INTO user_notes (steam_id, author, category, character_id, body, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		note.SteamID, note.Author, note.Category, note.CharacterID, note.Body, note.CreatedAt.UTC(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetUserNotes returns the user's notes newest first, category filters them when it's not empty.
+func (d *postgresDB) GetUserNotes(steamid string, category string) ([]schema.UserNote, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `
+		SELECT id, steam_id, author, category, character_id, body, created_at
+		FROM user_notes
+		WHERE steam_id = $1 AND ($2::text = '' OR category = $2)
+		ORDER BY id DESC`,
+		steamid, category,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := make([]schema.UserNote, 0)
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *n)
+	}
+	return notes, rows.Err()
+}
+
+// GetUserNoteSummary counts the user's notes per category along with the newest note.
+func (d *postgresDB) GetUserNoteSummary(steamid string) (*schema.NoteSummary, error) {
+	ctx := context.Background()
+	summary := &schema.NoteSummary{
+		Categories: make(map[string]int),
+	}
+
+	rows, err := d.db.Query(ctx,
+		`SELECT category, COUNT(*) FROM user_notes WHERE steam_id = $1 GROUP BY category`, steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category string
+		var count int
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, err
+		}
+		summary.Categories[category] = count
+		summary.Count += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if summary.Count > 0 {
+		summary.Latest, err = scanNote(d.db.QueryRow(ctx, `
+			SELECT id, steam_id, author, category, character_id, body, created_at
+			FROM user_notes
+			WHERE steam_id = $1
+			ORDER BY id DESC LIMIT 1`,
+			steamid,
+		))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+func scanNote(row pgx.Row) (*schema.UserNote, error) {
+	var n schema.UserNote
+	if err := row.Scan(&n.ID, &n.SteamID, &n.Author, &n.Category, &n.CharacterID, &n.Body, &n.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
@@ -0,0 +1,247 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// UndoCharacterOperation reverts the most recent operation on the character that
+// hasn't been undone yet. Operations are undone newest first, like a stack. admin is
+// recorded in the lineage of characters moved back.
+func (d *postgresDB) UndoCharacterOperation(id uuid.UUID, expiration time.Duration, admin string) (*schema.CharacterOperation, error) {
+	var op schema.CharacterOperation
+	var dropped *pendingUpdate
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		var snapshotID pgtype.Int8
+		var steamID pgtype.Text
+		var slot pgtype.Int4
+		err := tx.QueryRow(ctx, `
+			SELECT id, operation, snapshot_id, steam_id, slot, created_at
+			FROM character_operations
+			WHERE character_id = $1 AND undone_at IS NULL
+			ORDER BY id DESC LIMIT 1
+			FOR UPDATE`,
+			id,
+		).Scan(&op.ID, &op.Operation, &snapshotID, &steamID, &slot, &op.CreatedAt)
+		if err == pgx.ErrNoRows {
+			return database.ErrNoOperation
+		}
+		if err != nil {
+			return err
+		}
+
+		op.CharacterID = id
+		op.SnapshotID = snapshotID.Int64
+		op.SteamID = steamID.String
+		op.Slot = int(slot.Int32)
+
+		now := time.Now().UTC()
+		switch {
+		case op.Operation == "move":
+			if err := undoMove(ctx, tx, id, op.SteamID, op.Slot); err != nil {
+				return err
+			}
+			if err := recordLineage(ctx, tx, id, id, "undo", op.SteamID, op.Slot, admin); err != nil {
+				return err
+			}
+		case op.Operation == "rollback", op.Operation == "import" && op.SnapshotID != 0:
+			if err := lockCharacters(ctx, tx, []uuid.UUID{id}); err != nil {
+				return err
+			}
+			if upd, ok := d.dropPendingUpdate(id); ok {
+				dropped = &upd
+			}
+			if err := undoData(ctx, tx, id, op.SnapshotID); err != nil {
+				return err
+			}
+		default: // copy, restore, or an import that created the character
+			if err := softDeleteCharacter(ctx, tx, id, now, now.Add(expiration)); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx,
+			`UPDATE character_operations SET undone_at = $1 WHERE id = $2`,
+			now, op.ID,
+		)
+		op.UndoneAt = &now
+		return err
+	})
+	if err != nil {
+		if dropped != nil {
+			d.requeueUpdate(id, *dropped)
+		}
+		return nil, err
+	}
+
+	return &op, nil
+}
+
+// GetUndoOperation returns the operation UndoCharacterOperation would undo next.
+func (d *postgresDB) GetUndoOperation(id uuid.UUID) (*schema.CharacterOperation, error) {
+	op := schema.CharacterOperation{CharacterID: id}
+	ctx := context.Background()
+
+	var snapshotID pgtype.Int8
+	var steamID pgtype.Text
+	var slot pgtype.Int4
+	err := d.db.QueryRow(ctx, `
+		SELECT id, operation, snapshot_id, steam_id, slot, created_at
+		FROM character_operations
+		WHERE character_id = $1 AND undone_at IS NULL
+		ORDER BY id DESC LIMIT 1`,
+		id,
+	).Scan(&op.ID, &op.Operation, &snapshotID, &steamID, &slot, &op.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return nil, database.ErrNoOperation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	op.SnapshotID = snapshotID.Int64
+	op.SteamID = steamID.String
+	op.Slot = int(slot.Int32)
+	return &op, nil
+}
+
+// undoData puts the snapshot taken before the operation back as the current data,
+// the data being replaced is snapshotted in turn.
+func undoData(ctx context.Context, tx pgx.Tx, id uuid.UUID, snapshotID int64) error {
+	var createdAt time.Time
+	var size int
+	var payload string
+	err := tx.QueryRow(ctx, `
+		SELECT data_created_at, data_size, data_payload
+		FROM character_snapshots
+		WHERE id = $1 AND character_id = $2`,
+		snapshotID, id,
+	).Scan(&createdAt, &size, &payload)
+	if err == pgx.ErrNoRows {
+		return fmt.Errorf("snapshot %d no longer exists", snapshotID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := snapshotCharacter(ctx, tx, id, "undo"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE characters
+		SET data_created_at = $1, data_size = $2, data_payload = $3
+		WHERE id = $4`,
+		createdAt, size, payload, id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return characterChange(ctx, tx, database.ChangeCharacterRolledBack, id)
+}
+
+// undoMove moves the character back to its previous owner, moveCharacter refuses
+// when something has taken that slot since.
+func undoMove(ctx context.Context, tx pgx.Tx, id uuid.UUID, steamid string, slot int) error {
+	return moveCharacter(ctx, tx, id, steamid, slot)
+}
+
+// beginOperation journals the operation with the character's current owner so it can be
+// undone, rollbacks replace the data so theirs is snapshotted too. Call it before making
+// any changes.
+func beginOperation(ctx context.Context, tx pgx.Tx, id uuid.UUID, op string) error {
+	var steamID pgtype.Text
+	var slot pgtype.Int4
+	err := tx.QueryRow(ctx,
+		`SELECT steam_id, slot FROM characters WHERE id = $1`, id,
+	).Scan(&steamID, &slot)
+	if err == pgx.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapID int64
+	if op == "rollback" {
+		if snapID, err = snapshotCharacter(ctx, tx, id, op); err != nil {
+			return err
+		}
+	}
+
+	return recordOperation(ctx, tx, id, op, snapID, steamID.String, int(slot.Int32))
+}
+
+// snapshotCharacter saves the character's current data before the operation replaces it.
+func snapshotCharacter(ctx context.Context, tx pgx.Tx, id uuid.UUID, op string) (int64, error) {
+	var createdAt time.Time
+	var size int
+	var payload string
+	err := tx.QueryRow(ctx,
+		`SELECT data_created_at, data_size, data_payload FROM characters WHERE id = $1`, id,
+	).Scan(&createdAt, &size, &payload)
+	if err == pgx.ErrNoRows {
+		return 0, database.ErrNoDocument
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return insertSnapshot(ctx, tx, id, op, createdAt, size, payload)
+}
+
+func insertSnapshot(ctx context.Context, tx pgx.Tx, id uuid.UUID, op string, createdAt time.Time, size int, data string) (int64, error) {
+	var snapID int64
+	err := tx.QueryRow(ctx, `
+		INSERT INTO character_snapshots (character_id, operation, data_created_at, data_size, data_payload, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		id, op, createdAt, size, data, time.Now().UTC(),
+	).Scan(&snapID)
+	return snapID, err
+}
+
+// pruneSnapshots keeps the newest max snapshots of every character, along with any older
+// snapshot an operation that can still be undone needs.
+func pruneSnapshots(ctx context.Context, tx pgx.Tx, max int) error {
+	_, err := tx.Exec(ctx, `
+		DELETE FROM character_snapshots WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY character_id ORDER BY id DESC) AS n
+				FROM character_snapshots
+			) ranked
+			WHERE n > $1 AND NOT EXISTS (
+				SELECT 1 FROM character_operations o
+				WHERE o.snapshot_id = ranked.id AND o.undone_at IS NULL
+			)
+		)`,
+		max,
+	)
+	return err
+}
+
+// recordOperation journals an operation, snapshotID is 0 when there was no
+// snapshot and steamid is empty when the character had no owner.
+func recordOperation(ctx context.Context, tx pgx.Tx, id uuid.UUID, op string, snapshotID int64, steamid string, slot int) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO character_operations (character_id, operation, snapshot_id, steam_id, slot, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		id, op,
+		pgtype.Int8{Int64: snapshotID, Valid: snapshotID != 0},
+		pgtype.Text{String: steamid, Valid: steamid != ""},
+		pgtype.Int4{Int32: int32(slot), Valid: steamid != ""},
+		time.Now().UTC(),
+	)
+	return err
+}
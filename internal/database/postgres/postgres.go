@@ -20,6 +20,7 @@ import (
 type pendingUpdate struct {
 	size       int
 	data       string
+	server     string
 	backupMax  int
 	backupTime time.Duration
 }
@@ -33,11 +34,17 @@ type postgresDB struct {
 	// pendingUpdates is the coalescing map. When UpdateCharacter is called,
 	// we just overwrite the entry for that character ID. On each flush tick,
 	// all pending entries are committed in a single transaction.
-	coalesceMu     sync.RWMutex
+	// The lock is shared with the copies made by DryRun.
+	coalesceMu     *sync.RWMutex
 	pendingUpdates map[uuid.UUID]pendingUpdate
 
 	done chan struct{}
-	wg   sync.WaitGroup
+	wg   *sync.WaitGroup
+
+	// dryRun rolls back every transaction made through this copy, see DryRun.
+	dryRun bool
+	// event is queued with every transaction made through this copy, see WithEvent.
+	event *database.Event
 
 	database.Options
 }
@@ -45,11 +52,38 @@ type postgresDB struct {
 func New() *postgresDB {
 	return &postgresDB{
 		flushInterval:  15 * time.Second,
+		coalesceMu:     &sync.RWMutex{},
 		pendingUpdates: make(map[uuid.UUID]pendingUpdate),
 		done:           make(chan struct{}),
+		wg:             &sync.WaitGroup{},
 	}
 }
 
+// DryRun returns a copy of the database whose transactions run in full and are then
+// rolled back, so character and user operations fail exactly as they would for real
+// without changing anything.
+func (d *postgresDB) DryRun() database.Database {
+	dry := *d
+	dry.dryRun = true
+	return &dry
+}
+
+// WithEvent returns a copy of the database that queues the event's webhook deliveries in
+// each transaction made through it.
+func (d *postgresDB) WithEvent(event database.Event) database.Database {
+	view := *d
+	view.event = &event
+	return &view
+}
+
+// Quiet returns a copy of the database without an outbox, so no webhook deliveries are
+// queued in the transactions made through it.
+func (d *postgresDB) Quiet() database.Database {
+	view := *d
+	view.Outbox = nil
+	return &view
+}
+
 func (d *postgresDB) Connect(cfg database.Config, opts database.Options) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3 * time.Second)
 	defer cancel();
@@ -87,7 +121,7 @@ func (d *postgresDB) Connect(cfg database.Config, opts database.Options) error {
 	}
 
 	d.db = pool
-	d.Logger = opts.Logger
+	d.Options = opts
 
 	if cfg.Postgres.CreateTables == true {
 		if err := migrate(ctx, pool); err != nil {
@@ -115,17 +149,43 @@ func (d *postgresDB) SyncToDisk() error {
 }
 
 // RunGC flushes pending updates and then purges any soft-deleted characters
-// whose expiration timestamp has passed.
+// whose expiration timestamp has passed, and prunes snapshots over the cap.
 func (d *postgresDB) RunGC() error {
+	if err := d.flushPendingUpdates(); err != nil {
+		return err
+	}
+
 	ctx := context.Background()
-	_, err := d.db.Exec(ctx,
-		`DELETE FROM characters WHERE expires_at IS NOT NULL AND expires_at <= NOW()`,
-	)
-	return err
+	return d.execTx(ctx, func(tx pgx.Tx) error {
+		if err := lockChanges(ctx, tx); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO changes (kind, character_id, steam_id, slot, created_at)
+			SELECT $1::text, c.id, COALESCE(dc.steam_id, ''), dc.slot, $2::timestamptz
+			FROM characters c
+			LEFT JOIN deleted_characters dc ON dc.character_id = c.id
+			WHERE c.expires_at IS NOT NULL AND c.expires_at <= NOW()
+			ORDER BY c.expires_at`,
+			database.ChangeCharacterPurged, time.Now().UTC(),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM characters WHERE expires_at IS NOT NULL AND expires_at <= NOW()`,
+		); err != nil {
+			return err
+		}
+
+		if d.MaxSnapshots > 0 {
+			return pruneSnapshots(ctx, tx, d.MaxSnapshots)
+		}
+		return nil
+	})
 }
 
-// execTx runs fn inside a transaction. Postgres supports multiple concurrent
-// writers, so there is no need for a serialized write channel.
+// execTx runs fn inside a transaction.
 func (d *postgresDB) execTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
 	tx, err := d.db.Begin(ctx)
 	if err != nil {
@@ -135,6 +195,15 @@ func (d *postgresDB) execTx(ctx context.Context, fn func(tx pgx.Tx) error) error
 		_ = tx.Rollback(ctx)
 		return err
 	}
+	if d.event != nil {
+		if err := d.queueEvent(ctx, tx, *d.event); err != nil {
+			_ = tx.Rollback(ctx)
+			return err
+		}
+	}
+	if d.dryRun {
+		return tx.Rollback(ctx)
+	}
 	return tx.Commit(ctx)
 }
 
@@ -163,28 +232,51 @@ func (d *postgresDB) flushWorker() {
 // then commits all coalesced updates in a single transaction.
 func (d *postgresDB) flushPendingUpdates() error {
 	d.coalesceMu.Lock()
-	if len(d.pendingUpdates) == 0 {
-		d.coalesceMu.Unlock()
-		return nil
-	}
-
-	snapshot := d.pendingUpdates
-	d.pendingUpdates = make(map[uuid.UUID]pendingUpdate)
+	empty := len(d.pendingUpdates) == 0
 	d.coalesceMu.Unlock()
-
-	// Sort IDs to acquire row locks in a consistent order and prevent deadlocks.
-	ids := make([]uuid.UUID, 0, len(snapshot))
-	for id := range snapshot {
-		ids = append(ids, id)
+	if empty {
+		return nil
 	}
-	sort.Slice(ids, func(i, j int) bool {
-		return ids[i].String() < ids[j].String()
-	})
 
+	var snapshot map[uuid.UUID]pendingUpdate
 	ctx := context.Background()
 	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		d.coalesceMu.Lock()
+		ids := make([]uuid.UUID, 0, len(d.pendingUpdates))
+		for id := range d.pendingUpdates {
+			ids = append(ids, id)
+		}
+		d.coalesceMu.Unlock()
+
+		// Sort IDs to acquire row locks in a consistent order and prevent deadlocks.
+		sort.Slice(ids, func(i, j int) bool {
+			return ids[i].String() < ids[j].String()
+		})
+
+		// The rows are locked before their updates are taken. A write that drops a pending
+		// update holds the row lock while it does, so it either drops the update before
+		// we take it or waits for the flush to commit first.
+		if err := lockCharacters(ctx, tx, ids); err != nil {
+			return err
+		}
+
+		// Updates queued since are left for the next flush, their rows aren't locked.
+		d.coalesceMu.Lock()
+		snapshot = make(map[uuid.UUID]pendingUpdate, len(ids))
 		for _, id := range ids {
-			if err := applyCharacterUpdate(ctx, tx, id, snapshot[id]); err != nil {
+			if upd, ok := d.pendingUpdates[id]; ok {
+				snapshot[id] = upd
+				delete(d.pendingUpdates, id)
+			}
+		}
+		d.coalesceMu.Unlock()
+
+		for _, id := range ids {
+			upd, ok := snapshot[id]
+			if !ok {
+				continue
+			}
+			if err := applyCharacterUpdate(ctx, tx, id, upd); err != nil {
 				return fmt.Errorf("flush update for %s: %w", id, err)
 			}
 		}
@@ -194,26 +286,80 @@ func (d *postgresDB) flushPendingUpdates() error {
 	if err != nil {
 		// Merge the failed snapshot back into the pending map.
 		// Any newer updates written since the swap take priority.
-		d.coalesceMu.Lock()
 		for id, upd := range snapshot {
-			if _, exists := d.pendingUpdates[id]; !exists {
-				d.pendingUpdates[id] = upd
+			d.requeueUpdate(id, upd)
+		}
+	}
+
+	return err
+}
+
+// dropPendingUpdate discards the character's coalesced update so it can't overwrite data
+// that was just replaced outright. Call it from the write's transaction once it holds the
+// character's row lock, put the update back with requeueUpdate if the write fails.
+func (d *postgresDB) dropPendingUpdate(id uuid.UUID) (pendingUpdate, bool) {
+	if d.dryRun {
+		return pendingUpdate{}, false
+	}
+
+	d.coalesceMu.Lock()
+	defer d.coalesceMu.Unlock()
+	upd, ok := d.pendingUpdates[id]
+	delete(d.pendingUpdates, id)
+	return upd, ok
+}
+
+// replaceData runs fn, a write that replaces the data of the characters outright, and
+// drops their pending updates in the same transaction. They're put back if it fails.
+func (d *postgresDB) replaceData(ctx context.Context, ids []uuid.UUID, fn func(tx pgx.Tx) error) error {
+	dropped := make(map[uuid.UUID]pendingUpdate)
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		if err := lockCharacters(ctx, tx, ids); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if upd, ok := d.dropPendingUpdate(id); ok {
+				dropped[id] = upd
 			}
 		}
-		d.coalesceMu.Unlock()
+		return fn(tx)
+	})
+	if err != nil {
+		for id, upd := range dropped {
+			d.requeueUpdate(id, upd)
+		}
 	}
+	return err
+}
 
+// lockCharacters locks the characters' rows until the end of the transaction, in ID order
+// like every caller so they can't deadlock with each other.
+func lockCharacters(ctx context.Context, tx pgx.Tx, ids []uuid.UUID) error {
+	_, err := tx.Exec(ctx,
+		`SELECT id FROM characters WHERE id = ANY($1) ORDER BY id FOR UPDATE`, ids,
+	)
 	return err
 }
 
+// requeueUpdate puts back an update that wasn't written, unless a newer one came in since.
+func (d *postgresDB) requeueUpdate(id uuid.UUID, upd pendingUpdate) {
+	d.coalesceMu.Lock()
+	defer d.coalesceMu.Unlock()
+	if _, exists := d.pendingUpdates[id]; !exists {
+		d.pendingUpdates[id] = upd
+	}
+}
+
 // migrate creates the schema on first run. Uses Postgres-native types.
 func migrate(ctx context.Context, pool *pgxpool.Pool) error {
 	_, err := pool.Exec(ctx, `
 		CREATE TABLE IF NOT EXISTS users (
-			id         TEXT PRIMARY KEY,
-			revision   INTEGER NOT NULL DEFAULT 0,
-			flags      INTEGER NOT NULL DEFAULT 0,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+			id          TEXT PRIMARY KEY,
+			revision    INTEGER NOT NULL DEFAULT 0,
+			flags       INTEGER NOT NULL DEFAULT 0,
+			created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			merged_into TEXT,
+			merged_at   TIMESTAMPTZ
 		);
 
 		CREATE TABLE IF NOT EXISTS characters (
@@ -226,6 +372,7 @@ func migrate(ctx context.Context, pool *pgxpool.Pool) error {
 			data_created_at TIMESTAMPTZ,
 			data_size       INTEGER NOT NULL DEFAULT 0,
 			data_payload    TEXT NOT NULL DEFAULT '',
+			data_server     TEXT NOT NULL DEFAULT '',
 			UNIQUE (steam_id, slot)
 		);
 
@@ -243,11 +390,196 @@ func migrate(ctx context.Context, pool *pgxpool.Pool) error {
 			character_id UUID NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
 			created_at   TIMESTAMPTZ NOT NULL,
 			size         INTEGER NOT NULL,
-			data_payload TEXT NOT NULL
+			data_payload TEXT NOT NULL,
+			tag          TEXT NOT NULL DEFAULT '',
+			note         TEXT NOT NULL DEFAULT '',
+			server       TEXT NOT NULL DEFAULT ''
+		);
+
+		ALTER TABLE character_versions ADD COLUMN IF NOT EXISTS tag  TEXT NOT NULL DEFAULT '';
+		ALTER TABLE character_versions ADD COLUMN IF NOT EXISTS note TEXT NOT NULL DEFAULT '';
+		ALTER TABLE character_versions ADD COLUMN IF NOT EXISTS server TEXT NOT NULL DEFAULT '';
+		ALTER TABLE characters ADD COLUMN IF NOT EXISTS data_server TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_into TEXT;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS merged_at TIMESTAMPTZ;
+
+		-- Data saved before an admin operation replaced it, kept apart from the versions
+		-- so rollbacks never pick one and the version cap never prunes one an undo needs.
+		CREATE TABLE IF NOT EXISTS character_snapshots (
+			id              BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			character_id    UUID NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
+			operation       TEXT NOT NULL, -- the operation it was taken before
+			data_created_at TIMESTAMPTZ NOT NULL,
+			data_size       INTEGER NOT NULL,
+			data_payload    TEXT NOT NULL,
+			created_at      TIMESTAMPTZ NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS character_operations (
+			id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			character_id UUID NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
+			operation    TEXT NOT NULL,
+			snapshot_id  BIGINT,
+			steam_id     TEXT,
+			slot         INTEGER,
+			created_at   TIMESTAMPTZ NOT NULL,
+			undone_at    TIMESTAMPTZ
+		);
+
+		ALTER TABLE character_operations ADD COLUMN IF NOT EXISTS snapshot_id BIGINT;
+
+		CREATE TABLE IF NOT EXISTS operation_journal (
+			id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			operation    TEXT NOT NULL,
+			character_id UUID NOT NULL, -- no foreign key, entries outlive deleted characters
+			target_id    UUID,
+			to_steam_id  TEXT,
+			to_slot      INTEGER,
+			before_state TEXT NOT NULL,
+			created_at   TIMESTAMPTZ NOT NULL,
+			reverted_at  TIMESTAMPTZ
+		);
+
+		ALTER TABLE operation_journal ADD COLUMN IF NOT EXISTS to_steam_id TEXT;
+		ALTER TABLE operation_journal ADD COLUMN IF NOT EXISTS to_slot     INTEGER;
+
+		CREATE TABLE IF NOT EXISTS character_lineage (
+			id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			character_id UUID NOT NULL, -- no foreign keys, lineage outlives deleted characters
+			source_id    UUID,
+			operation    TEXT NOT NULL,
+			steam_id     TEXT NOT NULL,
+			slot         INTEGER NOT NULL,
+			admin        TEXT NOT NULL DEFAULT '',
+			created_at   TIMESTAMPTZ NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS bans (
+			id          BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			steam_id    TEXT NOT NULL REFERENCES users(id),
+			reason      TEXT NOT NULL DEFAULT '',
+			admin       TEXT NOT NULL DEFAULT '',
+			evidence    TEXT NOT NULL DEFAULT '',
+			created_at  TIMESTAMPTZ NOT NULL,
+			expires_at  TIMESTAMPTZ, -- NULL for permanent bans
+			lifted_at   TIMESTAMPTZ,
+			lifted_by   TEXT NOT NULL DEFAULT '',
+			lift_reason TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE TABLE IF NOT EXISTS flag_grants (
+			steam_id   TEXT NOT NULL REFERENCES users(id),
+			bit        INTEGER NOT NULL,
+			source     TEXT NOT NULL DEFAULT '',
+			admin      TEXT NOT NULL DEFAULT '',
+			granted_at TIMESTAMPTZ NOT NULL,
+			expires_at TIMESTAMPTZ, -- NULL for grants that don't expire
+			PRIMARY KEY (steam_id, bit)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_notes (
+			id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			steam_id     TEXT NOT NULL, -- no foreign keys, notes can be left before the user first joins
+			author       TEXT NOT NULL DEFAULT '',
+			category     TEXT NOT NULL,
+			character_id UUID,
+			body         TEXT NOT NULL,
+			created_at   TIMESTAMPTZ NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			created_at   TIMESTAMPTZ NOT NULL,
+			ip           TEXT NOT NULL,
+			key_id       TEXT NOT NULL DEFAULT '',
+			method       TEXT NOT NULL,
+			endpoint     TEXT NOT NULL,
+			path         TEXT NOT NULL,
+			status       INTEGER NOT NULL,
+			steam_id     TEXT NOT NULL DEFAULT '',
+			character_id UUID,
+			reason       TEXT NOT NULL DEFAULT '',
+			before_state TEXT,
+			after_state  TEXT,
+			prev_hash    TEXT NOT NULL,
+			hash         TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS approvals (
+			id            BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			method        TEXT NOT NULL,
+			path          TEXT NOT NULL,
+			endpoint      TEXT NOT NULL,
+			body          TEXT NOT NULL DEFAULT '',
+			reason        TEXT NOT NULL,
+			requested_by  TEXT NOT NULL,
+			requested_ip  TEXT NOT NULL,
+			status        TEXT NOT NULL,
+			created_at    TIMESTAMPTZ NOT NULL,
+			expires_at    TIMESTAMPTZ NOT NULL,
+			decided_by    TEXT NOT NULL DEFAULT '',
+			decided_ip    TEXT NOT NULL DEFAULT '',
+			decided_at    TIMESTAMPTZ,
+			result_status INTEGER NOT NULL DEFAULT 0,
+			result        TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE TABLE IF NOT EXISTS jobs (
+			id            UUID PRIMARY KEY,
+			kind          TEXT NOT NULL,
+			status        TEXT NOT NULL,
+			params        TEXT NOT NULL DEFAULT '',
+			progress      INTEGER NOT NULL DEFAULT 0,
+			total         INTEGER NOT NULL DEFAULT 0,
+			result        TEXT NOT NULL DEFAULT '',
+			error         TEXT NOT NULL DEFAULT '',
+			requested_by  TEXT NOT NULL,
+			created_at    TIMESTAMPTZ NOT NULL,
+			started_at    TIMESTAMPTZ,
+			finished_at   TIMESTAMPTZ
+		);
+
+		CREATE TABLE IF NOT EXISTS changes (
+			id           BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			kind         TEXT NOT NULL,
+			character_id UUID,          -- no foreign key, changes outlive purged characters
+			source_id    UUID,          -- the character a copy was made from
+			steam_id     TEXT NOT NULL DEFAULT '',
+			slot         INTEGER,
+			flags        INTEGER,       -- the user's flags after a flag change
+			created_at   TIMESTAMPTZ NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id            BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			endpoint      TEXT NOT NULL, -- name of the endpoint in the config
+			event         TEXT NOT NULL,
+			body          TEXT NOT NULL, -- sent as is on every attempt
+			status        TEXT NOT NULL, -- pending, delivered or failed
+			attempts      INTEGER NOT NULL DEFAULT 0,
+			next_attempt  TIMESTAMPTZ,   -- when a pending delivery is tried next
+			response_code INTEGER NOT NULL DEFAULT 0,
+			error         TEXT NOT NULL DEFAULT '',
+			created_at    TIMESTAMPTZ NOT NULL,
+			finished_at   TIMESTAMPTZ
 		);
 
 		CREATE INDEX IF NOT EXISTS idx_chars_steam_id   ON characters(steam_id);
 		CREATE INDEX IF NOT EXISTS idx_charver_char_id  ON character_versions(character_id);
+		CREATE INDEX IF NOT EXISTS idx_charsnap_char_id ON character_snapshots(character_id);
+		CREATE INDEX IF NOT EXISTS idx_charops_char_id  ON character_operations(character_id);
+		CREATE INDEX IF NOT EXISTS idx_journal_created  ON operation_journal(created_at);
+		CREATE INDEX IF NOT EXISTS idx_lineage_char_id  ON character_lineage(character_id);
+		CREATE INDEX IF NOT EXISTS idx_lineage_src_id   ON character_lineage(source_id);
+		CREATE INDEX IF NOT EXISTS idx_bans_steam_id    ON bans(steam_id);
+		CREATE INDEX IF NOT EXISTS idx_grants_expires  ON flag_grants(expires_at);
+		CREATE INDEX IF NOT EXISTS idx_notes_steam_id   ON user_notes(steam_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_steam_id   ON audit_log(steam_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_created    ON audit_log(created_at);
+		CREATE INDEX IF NOT EXISTS idx_approvals_status ON approvals(status);
+		CREATE INDEX IF NOT EXISTS idx_jobs_status      ON jobs(status);
+		CREATE INDEX IF NOT EXISTS idx_changes_created  ON changes(created_at);
+		CREATE INDEX IF NOT EXISTS idx_webhooks_status  ON webhook_deliveries(status, next_attempt);
 	`)
 	return err
 }
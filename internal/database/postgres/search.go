@@ -0,0 +1,157 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// activeCharacters counts the characters of the user u that aren't deleted.
+const activeCharacters = `(SELECT COUNT(*) FROM characters c WHERE c.steam_id = u.id AND c.deleted_at IS NULL)`
+
+// SearchUsers returns the users matching the filter, ordered by SteamID.
+func (d *postgresDB) SearchUsers(filter database.UserFilter) ([]schema.UserSummary, error) {
+	ctx := context.Background()
+
+	var (
+		where []string
+		args []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.Flags != 0 {
+		args = append(args, int32(filter.Flags))
+		where = append(where, fmt.Sprintf("u.flags & $%d = $%d", len(args), len(args)))
+	}
+	if filter.WithoutFlags != 0 {
+		add("u.flags & $%d = 0", int32(filter.WithoutFlags))
+	}
+	if filter.CreatedSince != nil {
+		add("u.created_at >= $%d", filter.CreatedSince.UTC())
+	}
+	if filter.CreatedUntil != nil {
+		add("u.created_at < $%d", filter.CreatedUntil.UTC())
+	}
+	if filter.MinCharacters != nil {
+		add(activeCharacters+" >= $%d", *filter.MinCharacters)
+	}
+	if filter.MaxCharacters != nil {
+		add(activeCharacters+" <= $%d", *filter.MaxCharacters)
+	}
+	if filter.After != "" {
+		add("u.id > $%d", filter.After)
+	}
+
+	query := `
+		SELECT u.id, u.flags, u.created_at, COALESCE(u.merged_into, ''), ` + activeCharacters + `
+		FROM users u`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY u.id ASC LIMIT $%d", len(args))
+
+	rows, err := d.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]schema.UserSummary, 0)
+	for rows.Next() {
+		var u schema.UserSummary
+		if err := rows.Scan(&u.ID, &u.Flags, &u.CreatedAt, &u.MergedInto, &u.Characters); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SearchCharacters returns the characters matching the filter, ordered by ID. Soft deleted
+// characters are listed under the slot they were deleted from. Saves that are still
+// waiting to be flushed aren't taken into account.
+func (d *postgresDB) SearchCharacters(filter database.CharacterFilter) ([]schema.CharacterSummary, error) {
+	ctx := context.Background()
+
+	var (
+		where []string
+		args []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.SteamID != "" {
+		add("COALESCE(c.steam_id, dc.steam_id) = $%d", filter.SteamID)
+	}
+	if filter.ModifiedSince != nil {
+		add("c.data_created_at >= $%d", filter.ModifiedSince.UTC())
+	}
+	if filter.ModifiedUntil != nil {
+		add("c.data_created_at < $%d", filter.ModifiedUntil.UTC())
+	}
+	if filter.MinSize != nil {
+		add("c.data_size >= $%d", int32(*filter.MinSize))
+	}
+	if filter.MaxSize != nil {
+		add("c.data_size <= $%d", int32(*filter.MaxSize))
+	}
+	if filter.Deleted != nil {
+		if *filter.Deleted {
+			where = append(where, "c.deleted_at IS NOT NULL")
+		} else {
+			where = append(where, "c.deleted_at IS NULL")
+		}
+	}
+	if filter.After != nil {
+		add("c.id > $%d", *filter.After)
+	}
+
+	query := `
+		SELECT c.id, COALESCE(c.steam_id, dc.steam_id, ''), COALESCE(c.slot, dc.slot, 0), c.created_at,
+			c.data_created_at, c.data_size, c.data_server, c.deleted_at, c.expires_at
+		FROM characters c
+		LEFT JOIN deleted_characters dc ON dc.character_id = c.id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY c.id ASC LIMIT $%d", len(args))
+
+	rows, err := d.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chars := make([]schema.CharacterSummary, 0)
+	for rows.Next() {
+		c, err := scanCharacterSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, *c)
+	}
+	return chars, rows.Err()
+}
+
+// scanCharacterSummary scans the columns selected by SearchCharacters.
+func scanCharacterSummary(row pgx.Row) (*schema.CharacterSummary, error) {
+	var c schema.CharacterSummary
+	err := row.Scan(
+		&c.ID, &c.SteamID, &c.Slot, &c.CreatedAt, &c.ModifiedAt,
+		&c.Size, &c.Server, &c.DeletedAt, &c.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/internal/database"
@@ -17,7 +18,7 @@ func (d *postgresDB) GetAllUsers() ([]*schema.User, error) {
 
 	rows, err := d.db.Query(ctx, `
 		SELECT
-			u.id, u.revision, u.flags,
+			u.id, u.revision, u.flags, COALESCE(u.merged_into, ''),
 			c.slot           AS char_slot,
 			c.id             AS char_id,
 			dc.slot          AS del_slot,
@@ -41,12 +42,13 @@ func (d *postgresDB) GetAllUsers() ([]*schema.User, error) {
 			id string
 			revision int
 			flags uint32
+			mergedInto string
 			charSlot *int
 			charID *uuid.UUID
 			delSlot *int
 			delID *uuid.UUID
 		)
-		if err := rows.Scan(&id, &revision, &flags, &charSlot, &charID, &delSlot, &delID); err != nil {
+		if err := rows.Scan(&id, &revision, &flags, &mergedInto, &charSlot, &charID, &delSlot, &delID); err != nil {
 			return nil, err
 		}
 
@@ -56,6 +58,7 @@ func (d *postgresDB) GetAllUsers() ([]*schema.User, error) {
 				ID: id,
 				Revision: revision,
 				Flags: flags,
+				MergedInto: mergedInto,
 				Characters: make(map[int]uuid.UUID),
 				DeletedCharacters: make(map[int]uuid.UUID),
 			}
@@ -86,7 +89,7 @@ func (d *postgresDB) GetUser(steamid string) (*schema.User, error) {
 
 	rows, err := d.db.Query(ctx, `
 		SELECT
-			u.id, u.revision, u.flags,
+			u.id, u.revision, u.flags, COALESCE(u.merged_into, ''),
 			c.slot          AS char_slot,
 			c.id            AS char_id,
 			dc.slot         AS del_slot,
@@ -110,12 +113,13 @@ func (d *postgresDB) GetUser(steamid string) (*schema.User, error) {
 			id string
 			revision int
 			flags uint32
+			mergedInto string
 			charSlot *int
 			charID *uuid.UUID
 			delSlot *int
 			delID *uuid.UUID
 		)
-		if err := rows.Scan(&id, &revision, &flags, &charSlot, &charID, &delSlot, &delID); err != nil {
+		if err := rows.Scan(&id, &revision, &flags, &mergedInto, &charSlot, &charID, &delSlot, &delID); err != nil {
 			return nil, err
 		}
 
@@ -124,6 +128,7 @@ func (d *postgresDB) GetUser(steamid string) (*schema.User, error) {
 				ID: id,
 				Revision: revision,
 				Flags: flags,
+				MergedInto: mergedInto,
 				Characters: make(map[int]uuid.UUID),
 				DeletedCharacters: make(map[int]uuid.UUID),
 			}
@@ -146,6 +151,35 @@ func (d *postgresDB) GetUser(steamid string) (*schema.User, error) {
 	return u, nil
 }
 
+// NewUser creates the user if they don't exist yet.
+func (d *postgresDB) NewUser(steamid string) error {
+	ctx := context.Background()
+	return d.execTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO users (id) VALUES ($1) ON CONFLICT(id) DO NOTHING`, steamid)
+		return err
+	})
+}
+
+// GetUsersWithFlag returns the SteamIDs of every user that has the flag set.
+func (d *postgresDB) GetUsersWithFlag(flag bitmask.Bitmask) ([]string, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, `SELECT id FROM users WHERE flags & $1 != 0 ORDER BY id`, int32(flag))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steamids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		steamids = append(steamids, id)
+	}
+	return steamids, rows.Err()
+}
+
 func (d *postgresDB) SetUserFlags(steamid string, flags bitmask.Bitmask) error {
 	ctx := context.Background()
 	return d.execTx(ctx, func(tx pgx.Tx) error {
@@ -158,7 +192,16 @@ func (d *postgresDB) SetUserFlags(steamid string, flags bitmask.Bitmask) error {
 		if ct.RowsAffected() == 0 {
 			return database.ErrNoDocument
 		}
-		return nil
+
+		// Grants of flags that were just cleared are gone with them.
+		_, err = tx.Exec(ctx,
+			`DELETE FROM flag_grants WHERE steam_id = $1 AND ($2::integer >> bit) & 1 = 0`, steamid, int32(flags),
+		)
+		if err != nil {
+			return err
+		}
+
+		return userChange(ctx, tx, steamid)
 	})
 }
 
@@ -176,3 +219,298 @@ func (d *postgresDB) GetUserFlags(steamid string) (bitmask.Bitmask, error) {
 	}
 	return bitmask.Bitmask(flags), nil
 }
+
+// MergeUsers moves the active and deleted characters of one account to another, which has to
+// exist already, combines their flags along with the grants and bans behind them and leaves
+// the old account as a tombstone pointing at the new one. Every active character moved is
+// journaled so the move can be reverted.
+func (d *postgresDB) MergeUsers(from string, to string, opts database.MergeOptions) (*database.MergeResult, error) {
+	res := &database.MergeResult{
+		From: from,
+		To: to,
+		Characters: make([]database.MergedCharacter, 0),
+		DeletedCharacters: make([]database.MergedCharacter, 0),
+	}
+	ctx := context.Background()
+
+	err := d.execTx(ctx, func(tx pgx.Tx) error {
+		fromFlags, err := mergeableFlags(ctx, tx, from)
+		if err != nil {
+			return err
+		}
+
+		toFlags, err := mergeableFlags(ctx, tx, to)
+		if err != nil {
+			return err
+		}
+
+		// Active characters
+		chars, err := userSlots(ctx, tx, `SELECT slot, id FROM characters WHERE steam_id = $1 AND deleted_at IS NULL`, from)
+		if err != nil {
+			return err
+		}
+		taken, err := userSlots(ctx, tx, `SELECT slot, id FROM characters WHERE steam_id = $1 AND deleted_at IS NULL`, to)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, slot := range database.MergeOrder(chars, taken) {
+			id := chars[slot]
+			target := slot
+
+			if opts.MaxSlots > 0 && slot >= opts.MaxSlots {
+				// The account doesn't have this slot, whatever the policy it can only go in a free one.
+				var ok bool
+				if target, ok = database.FreeSlot(taken, opts.MaxSlots); !ok {
+					return database.ErrNoFreeSlot
+				}
+			} else if other, ok := taken[slot]; ok {
+				switch opts.Policy {
+				case database.MergeReplace:
+					if err := softDeleteCharacter(ctx, tx, other, now, now.Add(opts.Expiration)); err != nil {
+						return err
+					}
+					res.Replaced = append(res.Replaced, other)
+				case database.MergeNextSlot:
+					if target, ok = database.FreeSlot(taken, opts.MaxSlots); !ok {
+						return database.ErrNoFreeSlot
+					}
+				default:
+					return &database.SlotOccupiedError{SteamID: to, Slot: slot, CharacterID: other}
+				}
+			}
+			taken[target] = id
+
+			before, err := characterState(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(ctx,
+				`UPDATE characters SET steam_id = $1, slot = $2 WHERE id = $3`,
+				to, target, id,
+			); err != nil {
+				return err
+			}
+
+			if err := recordOperation(ctx, tx, id, "move", 0, from, slot); err != nil {
+				return err
+			}
+
+			if err := recordLineage(ctx, tx, id, id, "merge", to, target, opts.Admin); err != nil {
+				return err
+			}
+
+			if err := journalMove(ctx, tx, id, before, to, target); err != nil {
+				return err
+			}
+
+			if err := characterChange(ctx, tx, database.ChangeCharacterMoved, id); err != nil {
+				return err
+			}
+
+			res.Characters = append(res.Characters, database.MergedCharacter{ID: id, FromSlot: slot, ToSlot: target})
+		}
+
+		// Deleted characters, these aren't limited to MaxSlots since they can be restored into any slot.
+		deleted, err := userSlots(ctx, tx, `SELECT slot, character_id FROM deleted_characters WHERE steam_id = $1`, from)
+		if err != nil {
+			return err
+		}
+		deletedTaken, err := userSlots(ctx, tx, `SELECT slot, character_id FROM deleted_characters WHERE steam_id = $1`, to)
+		if err != nil {
+			return err
+		}
+
+		for _, slot := range database.MergeOrder(deleted, deletedTaken) {
+			id := deleted[slot]
+			target := slot
+			if _, ok := deletedTaken[slot]; ok {
+				target, _ = database.FreeSlot(deletedTaken, 0)
+			}
+			deletedTaken[target] = id
+
+			if _, err := tx.Exec(ctx,
+				`UPDATE deleted_characters SET steam_id = $1, slot = $2 WHERE character_id = $3`,
+				to, target, id,
+			); err != nil {
+				return err
+			}
+
+			if err := characterChange(ctx, tx, database.ChangeCharacterMoved, id); err != nil {
+				return err
+			}
+
+			res.DeletedCharacters = append(res.DeletedCharacters, database.MergedCharacter{ID: id, FromSlot: slot, ToSlot: target})
+		}
+
+		// Flags
+		flags := fromFlags | toFlags
+		if opts.Flags != nil {
+			flags = opts.Flags(fromFlags, toFlags)
+		}
+		res.Flags = uint32(flags)
+
+		if _, err := tx.Exec(ctx, `UPDATE users SET flags = $1 WHERE id = $2`, uint32(flags), to); err != nil {
+			return err
+		}
+
+		if err := mergeGrants(ctx, tx, from, to, fromFlags, toFlags, flags); err != nil {
+			return err
+		}
+
+		if err := d.mergeBans(ctx, tx, from, to, toFlags, flags, now, opts.Admin); err != nil {
+			return err
+		}
+
+		if err := userChange(ctx, tx, to); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			`UPDATE users SET merged_into = $1, merged_at = $2 WHERE id = $3`,
+			to, now, from,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// mergeGrants gives the merged account the grants of the flags it keeps, the one that runs
+// out last when both accounts have the flag, so a flag granted for a while doesn't become
+// permanent. Grants given to the merged account are taken from the old one. BANNED is left
+// to mergeBans.
+func mergeGrants(ctx context.Context, tx pgx.Tx, from string, to string, fromFlags bitmask.Bitmask, toFlags bitmask.Bitmask, flags bitmask.Bitmask) error {
+	fromGrants, err := userGrants(ctx, tx, from)
+	if err != nil {
+		return err
+	}
+	toGrants, err := userGrants(ctx, tx, to)
+	if err != nil {
+		return err
+	}
+
+	// Grants of flags the merged account doesn't keep are gone with them.
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM flag_grants WHERE steam_id = $1 AND ($2::integer >> bit) & 1 = 0`, to, int32(flags),
+	); err != nil {
+		return err
+	}
+
+	for bit := uint(0); bit < 32; bit++ {
+		mask := bitmask.Bitmask(1 << bit)
+		if !flags.HasFlag(mask) || mask == bitmask.BANNED {
+			continue
+		}
+
+		grant := database.MergeGrant(fromFlags.HasFlag(mask), fromGrants[bit], toFlags.HasFlag(mask), toGrants[bit])
+		if grant != nil && grant == toGrants[bit] {
+			continue
+		}
+
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM flag_grants WHERE steam_id IN ($1, $2) AND bit = $3`, from, to, int32(bit),
+		); err != nil {
+			return err
+		}
+		if grant == nil {
+			continue
+		}
+
+		g := *grant
+		g.SteamID = to
+		if err := saveGrant(ctx, tx, &g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userGrants returns the user's grants by bit.
+func userGrants(ctx context.Context, tx pgx.Tx, steamid string) (map[uint]*schema.FlagGrant, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT steam_id, bit, source, admin, granted_at, expires_at
+		FROM flag_grants WHERE steam_id = $1`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := readGrants(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byBit := make(map[uint]*schema.FlagGrant, len(grants))
+	for i := range grants {
+		byBit[grants[i].Bit] = &grants[i]
+	}
+	return byBit, nil
+}
+
+// mergeBans moves the active bans of the old account to the merged one when it keeps
+// BANNED, so the ban keeps its reason and still expires. When the merged account doesn't
+// keep BANNED its own bans are lifted, the same as clearing the flag anywhere else.
+func (d *postgresDB) mergeBans(ctx context.Context, tx pgx.Tx, from string, to string, toFlags bitmask.Bitmask, flags bitmask.Bitmask, now time.Time, admin string) error {
+	if flags.HasFlag(bitmask.BANNED) {
+		_, err := tx.Exec(ctx, `
+			UPDATE bans SET steam_id = $1
+			WHERE steam_id = $2 AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > $3)`,
+			to, from, now,
+		)
+		return err
+	}
+
+	if !toFlags.HasFlag(bitmask.BANNED) {
+		return nil
+	}
+	if err := liftBans(ctx, tx, to, now, admin, "merged"); err != nil {
+		return err
+	}
+	return d.queueUnban(ctx, tx, to, admin, fmt.Sprintf("%s was unbanned by merging %s into it", to, from), nil)
+}
+
+// mergeableFlags returns the user's flags, failing if the user was already merged away.
+func mergeableFlags(ctx context.Context, tx pgx.Tx, steamid string) (bitmask.Bitmask, error) {
+	var flags uint32
+	var mergedInto *string
+	err := tx.QueryRow(ctx,
+		`SELECT flags, merged_into FROM users WHERE id = $1`, steamid,
+	).Scan(&flags, &mergedInto)
+	if err == pgx.ErrNoRows {
+		return 0, database.ErrNoDocument
+	}
+	if err != nil {
+		return 0, err
+	}
+	if mergedInto != nil {
+		return 0, database.ErrAlreadyMerged
+	}
+	return bitmask.Bitmask(flags), nil
+}
+
+// userSlots runs a query selecting slot and character ID for the user into a map.
+func userSlots(ctx context.Context, tx pgx.Tx, query string, steamid string) (map[int]uuid.UUID, error) {
+	rows, err := tx.Query(ctx, query, steamid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := make(map[int]uuid.UUID)
+	for rows.Next() {
+		var slot int
+		var id uuid.UUID
+		if err := rows.Scan(&slot, &id); err != nil {
+			return nil, err
+		}
+		slots[slot] = id
+	}
+	return slots, rows.Err()
+}
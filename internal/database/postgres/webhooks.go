@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const webhookColumns = `id, endpoint, event, body, status, attempts, next_attempt, response_code, error,
+	created_at, finished_at`
+
+const insertWebhookDelivery = `
+	INSERT INTO webhook_deliveries (endpoint, event, body, status, attempts, next_attempt, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING id`
+
+// AddWebhookDelivery queues a delivery and returns its ID.
+func (d *postgresDB) AddWebhookDelivery(delivery *schema.WebhookDelivery) (int64, error) {
+	var id int64
+	ctx := context.Background()
+	err := d.db.QueryRow(ctx, insertWebhookDelivery,
+		delivery.Endpoint, delivery.Event, delivery.Body, delivery.Status, int32(delivery.Attempts),
+		delivery.NextAttempt, delivery.CreatedAt.UTC(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// queueEvent writes the event's webhook deliveries in the transaction, nothing is queued
+// without an outbox.
+func (d *postgresDB) queueEvent(ctx context.Context, tx pgx.Tx, event database.Event) error {
+	if d.Outbox == nil {
+		return nil
+	}
+
+	deliveries, err := d.Outbox.Deliveries(event)
+	if err != nil {
+		return err
+	}
+	for _, delivery := range deliveries {
+		if _, err := tx.Exec(ctx, insertWebhookDelivery,
+			delivery.Endpoint, delivery.Event, delivery.Body, delivery.Status, int32(delivery.Attempts),
+			delivery.NextAttempt, delivery.CreatedAt.UTC(),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateWebhookDelivery saves the outcome of an attempt, ErrNoDocument is returned when
+// there's no such delivery.
+func (d *postgresDB) UpdateWebhookDelivery(delivery *schema.WebhookDelivery) error {
+	ctx := context.Background()
+	ct, err := d.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt = $3, response_code = $4, error = $5, finished_at = $6
+		WHERE id = $7`,
+		delivery.Status, int32(delivery.Attempts), delivery.NextAttempt, int32(delivery.ResponseCode),
+		delivery.Error, delivery.FinishedAt, delivery.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if ct.RowsAffected() == 0 {
+		return database.ErrNoDocument
+	}
+
+	return nil
+}
+
+// GetDueWebhookDeliveries returns the pending deliveries whose next attempt is due by now,
+// oldest first.
+func (d *postgresDB) GetDueWebhookDeliveries(now time.Time, limit int) ([]schema.WebhookDelivery, error) {
+	return d.webhookDeliveries(`
+		SELECT `+webhookColumns+`
+		FROM webhook_deliveries
+		WHERE status = $1 AND next_attempt <= $2
+		ORDER BY id ASC LIMIT $3`,
+		database.WebhookPending, now.UTC(), limit,
+	)
+}
+
+// GetWebhookDeliveries returns the newest deliveries matching the filter.
+func (d *postgresDB) GetWebhookDeliveries(filter database.WebhookFilter) ([]schema.WebhookDelivery, error) {
+	return d.webhookDeliveries(`
+		SELECT `+webhookColumns+`
+		FROM webhook_deliveries
+		WHERE ($1::text = '' OR status = $1) AND ($2::text = '' OR endpoint = $2) AND ($3::text = '' OR event = $3)
+		ORDER BY id DESC LIMIT $4`,
+		filter.Status, filter.Endpoint, filter.Event, filter.Limit,
+	)
+}
+
+// PruneWebhookDeliveries removes the deliveries that were delivered or failed for good
+// before the given time and returns how many there were. Pending deliveries are kept.
+func (d *postgresDB) PruneWebhookDeliveries(before time.Time) (int, error) {
+	ctx := context.Background()
+	ct, err := d.db.Exec(ctx,
+		`DELETE FROM webhook_deliveries WHERE status IN ($1, $2) AND finished_at < $3`,
+		database.WebhookDelivered, database.WebhookFailed, before.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(ct.RowsAffected()), nil
+}
+
+func (d *postgresDB) webhookDeliveries(query string, args ...interface{}) ([]schema.WebhookDelivery, error) {
+	ctx := context.Background()
+	rows, err := d.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]schema.WebhookDelivery, 0)
+	for rows.Next() {
+		var (
+			w schema.WebhookDelivery
+			attempts int32
+			responseCode int32
+		)
+		err := rows.Scan(
+			&w.ID, &w.Endpoint, &w.Event, &w.Body, &w.Status, &attempts, &w.NextAttempt, &responseCode, &w.Error,
+			&w.CreatedAt, &w.FinishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		w.Attempts = int(attempts)
+		w.ResponseCode = int(responseCode)
+		deliveries = append(deliveries, w)
+	}
+	return deliveries, rows.Err()
+}
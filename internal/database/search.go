@@ -0,0 +1,35 @@
+package database
+
+import (
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+
+	"github.com/google/uuid"
+)
+
+// UserFilter narrows SearchUsers, fields left empty match every user. Users come back
+// ordered by SteamID.
+type UserFilter struct {
+	Flags bitmask.Bitmask // users with all of these flags
+	WithoutFlags bitmask.Bitmask // users with none of these flags
+	CreatedSince *time.Time
+	CreatedUntil *time.Time
+	MinCharacters *int // counts characters that aren't deleted
+	MaxCharacters *int
+	After string // only users with a greater SteamID, for paging
+	Limit int
+}
+
+// CharacterFilter narrows SearchCharacters, fields left empty match every character.
+// Characters come back ordered by ID.
+type CharacterFilter struct {
+	SteamID string
+	ModifiedSince *time.Time // when the current data was saved
+	ModifiedUntil *time.Time
+	MinSize *int
+	MaxSize *int
+	Deleted *bool // soft deleted characters only, or none of them
+	After *uuid.UUID // only characters with a greater ID, for paging
+	Limit int
+}
@@ -0,0 +1,151 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+const approvalColumns = `id, method, path, endpoint, body, reason, requested_by, requested_ip, status,
+	created_at, expires_at, decided_by, decided_ip, decided_at, result_status, result`
+
+func (d *sqliteDB) AddApproval(approval *schema.Approval) (int64, error) {
+	var id int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			INSERT INTO approvals (method, path, endpoint, body, reason, requested_by, requested_ip, status, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			approval.Method, approval.Path, approval.Endpoint, approval.Body, approval.Reason,
+			approval.RequestedBy, approval.RequestedIP, approval.Status, approval.CreatedAt.UTC(), approval.ExpiresAt.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (d *sqliteDB) GetApproval(id int64) (*schema.Approval, error) {
+	a, err := scanApproval(d.db.QueryRow(`SELECT `+approvalColumns+` FROM approvals WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// GetApprovals returns the newest approvals, status filters them when it's not empty.
+func (d *sqliteDB) GetApprovals(status string, limit int) ([]schema.Approval, error) {
+	rows, err := d.db.Query(`
+		SELECT `+approvalColumns+`
+		FROM approvals
+		WHERE (? = '' OR status = ?)
+		ORDER BY id DESC LIMIT ?`,
+		status, status, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := make([]schema.Approval, 0)
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, *a)
+	}
+	return approvals, rows.Err()
+}
+
+// DecideApproval approves or rejects an approval that's still pending and hasn't
+// expired, ErrNoDocument is returned when there's no such approval.
+func (d *sqliteDB) DecideApproval(id int64, status string, by string, ip string, at time.Time) error {
+	return d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			UPDATE approvals SET status = ?, decided_by = ?, decided_ip = ?, decided_at = ?
+			WHERE id = ? AND status = ? AND expires_at > ?`,
+			status, by, ip, at.UTC(), id, database.ApprovalPending, at.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return database.ErrNoDocument
+		}
+		return nil
+	})
+}
+
+// SetApprovalResult records the response of an approved request once it ran.
+func (d *sqliteDB) SetApprovalResult(id int64, status int, result string) error {
+	return d.exec(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`UPDATE approvals SET result_status = ?, result = ? WHERE id = ?`, status, result, id,
+		)
+		return err
+	})
+}
+
+// ExpireApprovals marks the pending approvals that ran out by now as expired and
+// returns how many there were.
+func (d *sqliteDB) ExpireApprovals(now time.Time) (int, error) {
+	var expired int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE approvals SET status = ? WHERE status = ? AND expires_at <= ?`,
+			database.ApprovalExpired, database.ApprovalPending, now.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		expired, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(expired), nil
+}
+
+func scanApproval(row rowScanner) (*schema.Approval, error) {
+	var (
+		a schema.Approval
+		decidedAt sql.NullTime
+	)
+	err := row.Scan(
+		&a.ID, &a.Method, &a.Path, &a.Endpoint, &a.Body, &a.Reason, &a.RequestedBy, &a.RequestedIP, &a.Status,
+		&a.CreatedAt, &a.ExpiresAt, &a.DecidedBy, &a.DecidedIP, &decidedAt, &a.ResultStatus, &a.Result,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if decidedAt.Valid {
+		a.DecidedAt = &decidedAt.Time
+	}
+	return &a, nil
+}
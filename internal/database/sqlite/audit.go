@@ -0,0 +1,216 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// AddAuditEntry appends the entry to the audit log, chaining its hash to the last entry.
+// PrevHash and Hash are set on the entry.
+func (d *sqliteDB) AddAuditEntry(entry *schema.AuditEntry) (int64, error) {
+	var id int64
+
+	// Stored times lose anything past microseconds in postgres, keep both backends the
+	// same so the hash can be checked again later.
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	before, err := auditState(entry.Before)
+	if err != nil {
+		return 0, err
+	}
+	after, err := auditState(entry.After)
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.exec(func(tx *sql.Tx) error {
+		var prevHash string
+		err := tx.QueryRow(`SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		entry.PrevHash = prevHash
+		entry.Hash = database.AuditHash(d.AuditKey, prevHash, entry, before.String, after.String)
+
+		var charID sql.NullString
+		if entry.CharacterID != nil {
+			charID = sql.NullString{String: entry.CharacterID.String(), Valid: true}
+		}
+
+		res, err := tx.Exec(`
+			INSERT INTO audit_log (created_at, ip, key_id, method, endpoint, path, status, steam_id,
+				character_id, reason, before_state, after_state, prev_hash, hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.CreatedAt, entry.IP, entry.KeyID, entry.Method, entry.Endpoint, entry.Path, entry.Status, entry.SteamID,
+			charID, entry.Reason, before, after, entry.PrevHash, entry.Hash,
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAuditLog returns the entries matching the filter, newest first.
+func (d *sqliteDB) GetAuditLog(filter database.AuditFilter) ([]schema.AuditEntry, error) {
+	var (
+		where []string
+		args []interface{}
+	)
+	if filter.SteamID != "" {
+		where = append(where, "steam_id = ?")
+		args = append(args, filter.SteamID)
+	}
+	if filter.CharacterID != nil {
+		where = append(where, "character_id = ?")
+		args = append(args, filter.CharacterID.String())
+	}
+	if filter.IP != "" {
+		where = append(where, "ip = ?")
+		args = append(args, filter.IP)
+	}
+	if filter.KeyID != "" {
+		where = append(where, "key_id = ?")
+		args = append(args, filter.KeyID)
+	}
+	if filter.Endpoint != "" {
+		where = append(where, "substr(endpoint, 1, length(?)) = ?")
+		args = append(args, filter.Endpoint, filter.Endpoint)
+	}
+	if filter.Since != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, filter.Until.UTC())
+	}
+	if filter.BeforeID > 0 {
+		where = append(where, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := `
+		SELECT id, created_at, ip, key_id, method, endpoint, path, status, steam_id,
+			character_id, reason, before_state, after_state, prev_hash, hash
+		FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]schema.AuditEntry, 0)
+	for rows.Next() {
+		e, _, _, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, rows.Err()
+}
+
+// VerifyAuditLog walks the audit log from the first entry and checks every hash along
+// with the link to the entry before it.
+func (d *sqliteDB) VerifyAuditLog() (*database.AuditVerify, error) {
+	rows, err := d.db.Query(`
+		SELECT id, created_at, ip, key_id, method, endpoint, path, status, steam_id,
+			character_id, reason, before_state, after_state, prev_hash, hash
+		FROM audit_log
+		ORDER BY id ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &database.AuditVerify{OK: true}
+	var prevHash string
+	for rows.Next() {
+		e, before, after, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Checked++
+
+		if e.PrevHash != prevHash || database.AuditHash(d.AuditKey, prevHash, e, before, after) != e.Hash {
+			result.OK = false
+			result.BrokenAt = e.ID
+			break
+		}
+		prevHash = e.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func auditState(state *schema.AuditState) (sql.NullString, error) {
+	if state == nil {
+		return sql.NullString{}, nil
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// scanAuditEntry also returns the before and after states as stored, they're what the hash covers.
+func scanAuditEntry(row rowScanner) (*schema.AuditEntry, string, string, error) {
+	var (
+		e schema.AuditEntry
+		charID sql.NullString
+		before sql.NullString
+		after sql.NullString
+	)
+	err := row.Scan(
+		&e.ID, &e.CreatedAt, &e.IP, &e.KeyID, &e.Method, &e.Endpoint, &e.Path, &e.Status, &e.SteamID,
+		&charID, &e.Reason, &before, &after, &e.PrevHash, &e.Hash,
+	)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if charID.Valid {
+		cid, _ := uuid.Parse(charID.String)
+		e.CharacterID = &cid
+	}
+	if before.Valid {
+		if err := json.Unmarshal([]byte(before.String), &e.Before); err != nil {
+			return nil, "", "", err
+		}
+	}
+	if after.Valid {
+		if err := json.Unmarshal([]byte(after.String), &e.After); err != nil {
+			return nil, "", "", err
+		}
+	}
+	return &e, before.String, after.String, nil
+}
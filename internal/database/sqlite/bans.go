@@ -0,0 +1,286 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+// AddBan bans the user and sets the BANNED flag, creating the user if they haven't
+// joined yet. An active ban the user already has is lifted and replaced by this one.
+func (d *sqliteDB) AddBan(ban *schema.Ban) (int64, error) {
+	var id int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			`INSERT INTO users (id) VALUES (?) ON CONFLICT(id) DO NOTHING`, ban.SteamID,
+		); err != nil {
+			return fmt.Errorf("upsert user: %w", err)
+		}
+
+		var err error
+		if id, err = d.insertBan(tx, ban); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE users SET flags = flags | ? WHERE id = ?`, uint32(bitmask.BANNED), ban.SteamID,
+		); err != nil {
+			return err
+		}
+
+		if err := userChange(tx, ban.SteamID); err != nil {
+			return err
+		}
+
+		return clearBanGrant(tx, ban.SteamID)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// LiftBan unbans the user, lifting their active ban and clearing the BANNED flag. Users
+// banned by flag only, from before bans were recorded, just have the flag cleared.
+func (d *sqliteDB) LiftBan(steamid string, admin string, reason string) error {
+	return d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE users SET flags = flags & ~? WHERE id = ?`, uint32(bitmask.BANNED), steamid,
+		)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			return database.ErrNoDocument
+		}
+
+		if err := liftBans(tx, steamid, time.Now().UTC(), admin, reason); err != nil {
+			return err
+		}
+
+		if err := userChange(tx, steamid); err != nil {
+			return err
+		}
+
+		if err := clearBanGrant(tx, steamid); err != nil {
+			return err
+		}
+
+		return d.queueUnban(tx, steamid, admin, fmt.Sprintf("%s was unbanned: %s", steamid, reason), nil)
+	})
+}
+
+// GetActiveBan returns the user's ban that hasn't been lifted or expired yet.
+func (d *sqliteDB) GetActiveBan(steamid string) (*schema.Ban, error) {
+	b, err := scanBan(d.db.QueryRow(`
+		SELECT id, steam_id, reason, admin, evidence, created_at, expires_at, lifted_at, lifted_by, lift_reason
+		FROM bans
+		WHERE steam_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY id DESC LIMIT 1`,
+		steamid, time.Now().UTC(),
+	))
+	if err == sql.ErrNoRows {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// GetBans returns every ban the user has had, newest first.
+func (d *sqliteDB) GetBans(steamid string) ([]schema.Ban, error) {
+	rows, err := d.db.Query(`
+		SELECT id, steam_id, reason, admin, evidence, created_at, expires_at, lifted_at, lifted_by, lift_reason
+		FROM bans
+		WHERE steam_id = ?
+		ORDER BY id DESC`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := make([]schema.Ban, 0)
+	for rows.Next() {
+		b, err := scanBan(rows)
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, *b)
+	}
+	return bans, rows.Err()
+}
+
+// ExpireBans lifts every temporary ban that ran out by now and clears the BANNED flag
+// of those users. It returns how many bans expired.
+func (d *sqliteDB) ExpireBans(now time.Time) (int, error) {
+	var expired int
+
+	err := d.exec(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT id, steam_id FROM bans
+			WHERE lifted_at IS NULL AND expires_at IS NOT NULL AND expires_at <= ?`,
+			now.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		ids := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var steamid string
+			if err := rows.Scan(&id, &steamid); err != nil {
+				rows.Close()
+				return err
+			}
+			ids[id] = steamid
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for id, steamid := range ids {
+			if _, err := tx.Exec(`
+				UPDATE bans SET lifted_at = ?, lift_reason = 'expired'
+				WHERE id = ?`,
+				now.UTC(), id,
+			); err != nil {
+				return err
+			}
+			if err := d.queueUnban(tx, steamid, "", fmt.Sprintf("ban %d of %s expired", id, steamid), map[string]int64{"ban_id": id}); err != nil {
+				return err
+			}
+
+			// A ban that was added after this one expired keeps the flag.
+			res, err := tx.Exec(`
+				UPDATE users SET flags = flags & ~?
+				WHERE id = ? AND NOT EXISTS (
+					SELECT 1 FROM bans
+					WHERE steam_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+				)`,
+				uint32(bitmask.BANNED), steamid, steamid, now.UTC(),
+			)
+			if err != nil {
+				return err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				if err := userChange(tx, steamid); err != nil {
+					return err
+				}
+			}
+		}
+
+		expired = len(ids)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
+
+// liftBans lifts the user's bans that are still active at the given time.
+func liftBans(tx *sql.Tx, steamid string, at time.Time, admin string, reason string) error {
+	_, err := tx.Exec(`
+		UPDATE bans SET lifted_at = ?, lifted_by = ?, lift_reason = ?
+		WHERE steam_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`,
+		at.UTC(), admin, reason, steamid, at.UTC(),
+	)
+	return err
+}
+
+// insertBan records the ban, lifting the active ban it replaces, and queues its webhook
+// event. Setting the BANNED flag is left to the caller.
+func (d *sqliteDB) insertBan(tx *sql.Tx, ban *schema.Ban) (int64, error) {
+	if err := liftBans(tx, ban.SteamID, ban.CreatedAt, ban.Admin, "replaced"); err != nil {
+		return 0, err
+	}
+
+	var expiresAt sql.NullTime
+	if ban.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: ban.ExpiresAt.UTC(), Valid: true}
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO bans (steam_id, reason, admin, evidence, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		ban.SteamID, ban.Reason, ban.Admin, ban.Evidence, ban.CreatedAt.UTC(), expiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// Every way of banning someone records the ban here, so this is the one place the
+	// event is queued from.
+	b := *ban
+	b.ID = id
+	if err := d.queueEvent(tx, database.Event{
+		Kind: database.EventBan,
+		Summary: fmt.Sprintf("%s was banned: %s", ban.SteamID, ban.Reason),
+		SteamID: ban.SteamID,
+		By: ban.Admin,
+		Data: &b,
+	}); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// queueUnban queues the webhook event for the user being unbanned, LiftBan, bulk flag
+// changes and ExpireBans all call it.
+func (d *sqliteDB) queueUnban(tx *sql.Tx, steamid string, by string, summary string, data interface{}) error {
+	return d.queueEvent(tx, database.Event{
+		Kind: database.EventUnban,
+		Summary: summary,
+		SteamID: steamid,
+		By: by,
+		Data: data,
+	})
+}
+
+// clearBanGrant removes a grant of the BANNED flag, the user's bans decide when it's
+// cleared so an old grant expiring can't unban them.
+func clearBanGrant(tx *sql.Tx, steamid string) error {
+	_, err := tx.Exec(`DELETE FROM flag_grants WHERE steam_id = ? AND bit = 0`, steamid)
+	return err
+}
+
+func scanBan(row rowScanner) (*schema.Ban, error) {
+	var (
+		b schema.Ban
+		expiresAt sql.NullTime
+		liftedAt sql.NullTime
+	)
+	err := row.Scan(
+		&b.ID, &b.SteamID, &b.Reason, &b.Admin, &b.Evidence, &b.CreatedAt,
+		&expiresAt, &liftedAt, &b.LiftedBy, &b.LiftReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		b.ExpiresAt = &expiresAt.Time
+	}
+	if liftedAt.Valid {
+		b.LiftedAt = &liftedAt.Time
+	}
+	return &b, nil
+}
@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+// GetChanges returns up to limit changes made after the change with the given ID, oldest
+// first.
+func (d *sqliteDB) GetChanges(since int64, limit int) ([]schema.Change, error) {
+	rows, err := d.db.Query(`
+		SELECT id, kind, character_id, source_id, steam_id, slot, flags, created_at
+		FROM changes WHERE id > ? ORDER BY id ASC LIMIT ?`,
+		since, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := make([]schema.Change, 0)
+	for rows.Next() {
+		var (
+			c schema.Change
+			charID, sourceID sql.NullString
+			slot, flags sql.NullInt64
+		)
+		if err := rows.Scan(&c.ID, &c.Kind, &charID, &sourceID, &c.SteamID, &slot, &flags, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		if charID.Valid {
+			id, _ := uuid.Parse(charID.String)
+			c.CharacterID = &id
+		}
+		if sourceID.Valid {
+			id, _ := uuid.Parse(sourceID.String)
+			c.SourceID = &id
+		}
+		if slot.Valid {
+			s := int(slot.Int64)
+			c.Slot = &s
+		}
+		if flags.Valid {
+			f := uint32(flags.Int64)
+			c.Flags = &f
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// ChangeKept reports whether the change with the given ID hasn't been pruned, along with
+// the ID of the newest change.
+func (d *sqliteDB) ChangeKept(id int64) (bool, int64, error) {
+	var kept bool
+	var latest int64
+	err := d.db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM changes WHERE id = ?), COALESCE(MAX(id), 0) FROM changes`, id,
+	).Scan(&kept, &latest)
+	return kept, latest, err
+}
+
+// PruneChanges removes the changes made before the given time and returns how many there
+// were.
+func (d *sqliteDB) PruneChanges(before time.Time) (int, error) {
+	var pruned int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM changes WHERE created_at < ?`, before.UTC())
+		if err != nil {
+			return err
+		}
+
+		pruned, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(pruned), nil
+}
+
+// characterChange records a change to the character as it is in the transaction, so it has
+// to be called after the character was written and before it's deleted. Soft deleted
+// characters are recorded under the slot they were deleted from.
+func characterChange(tx *sql.Tx, kind string, id uuid.UUID) error {
+	return characterChangeFrom(tx, kind, id, uuid.Nil)
+}
+
+// characterChangeFrom is characterChange for a character that was made from another one.
+func characterChangeFrom(tx *sql.Tx, kind string, id uuid.UUID, source uuid.UUID) error {
+	var sourceID sql.NullString
+	if source != uuid.Nil {
+		sourceID = sql.NullString{String: source.String(), Valid: true}
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO changes (kind, character_id, source_id, steam_id, slot, created_at)
+		SELECT ?, c.id, ?, COALESCE(c.steam_id, dc.steam_id, ''), COALESCE(c.slot, dc.slot), ?
+		FROM characters c
+		LEFT JOIN deleted_characters dc ON dc.character_id = c.id
+		WHERE c.id = ?`,
+		kind, sourceID, time.Now().UTC(), id.String(),
+	)
+	return err
+}
+
+// userChange records the user's flags after they were changed.
+func userChange(tx *sql.Tx, steamid string) error {
+	_, err := tx.Exec(`
+		INSERT INTO changes (kind, steam_id, flags, created_at)
+		SELECT ?, id, flags, ? FROM users WHERE id = ?`,
+		database.ChangeUserFlags, time.Now().UTC(), steamid,
+	)
+	return err
+}
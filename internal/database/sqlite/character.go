@@ -3,6 +3,7 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/msrevive/nexus2/internal/database"
@@ -38,7 +39,7 @@ func (d *sqliteDB) NewCharacter(steamid string, slot int, size int, data string)
 			return fmt.Errorf("insert character: %w", err)
 		}
 
-		return nil
+		return characterChange(tx, database.ChangeCharacterCreated, charID)
 	})
 	if err != nil {
 		return uuid.Nil, err
@@ -52,14 +53,23 @@ func (d *sqliteDB) NewCharacter(steamid string, slot int, size int, data string)
 //
 // This means 100 calls to UpdateCharacter for the same character within the
 // flush window result in exactly 1 database write — the one with the final state.
-func (d *sqliteDB) UpdateCharacter(id uuid.UUID, size int, data string, backupMax int, backupTime time.Duration) error {
-	d.coalesceMu.Lock()
-	d.pendingUpdates[id] = pendingUpdate{
+func (d *sqliteDB) UpdateCharacter(id uuid.UUID, size int, data string, server string, backupMax int, backupTime time.Duration) error {
+	upd := pendingUpdate{
 		size:       size,
 		data:       data,
+		server:     server,
 		backupMax:  backupMax,
 		backupTime: backupTime,
 	}
+	// A dry run can't wait for the flush, the update is applied and rolled back now.
+	if d.dryRun {
+		return d.exec(func(tx *sql.Tx) error {
+			return applyCharacterUpdate(tx, id, upd)
+		})
+	}
+
+	d.coalesceMu.Lock()
+	d.pendingUpdates[id] = upd
 	d.coalesceMu.Unlock()
 	return nil
 }
@@ -75,12 +85,13 @@ func applyCharacterUpdate(tx *sql.Tx, id uuid.UUID, upd pendingUpdate) error {
 		dataCreatedAt time.Time
 		dataSize      int
 		dataPayload   string
+		dataServer    string
 	)
 	err := tx.QueryRow(`
-		SELECT data_created_at, data_size, data_payload
+		SELECT data_created_at, data_size, data_payload, data_server
 		FROM characters WHERE id = ?`,
 		id.String(),
-	).Scan(&dataCreatedAt, &dataSize, &dataPayload)
+	).Scan(&dataCreatedAt, &dataSize, &dataPayload, &dataServer)
 
 	if err == sql.ErrNoRows {
 		return database.ErrNoDocument
@@ -130,9 +141,9 @@ func applyCharacterUpdate(tx *sql.Tx, id uuid.UUID, upd pendingUpdate) error {
 
 			if dataCreatedAt.After(newestCreatedAt.Add(upd.backupTime)) {
 				if _, err := tx.Exec(`
-					INSERT INTO character_versions (character_id, created_at, size, data_payload)
-					VALUES (?, ?, ?, ?)`,
-					id.String(), dataCreatedAt, dataSize, dataPayload,
+					INSERT INTO character_versions (character_id, created_at, size, data_payload, server)
+					VALUES (?, ?, ?, ?, ?)`,
+					id.String(), dataCreatedAt, dataSize, dataPayload, dataServer,
 				); err != nil {
 					return err
 				}
@@ -140,9 +151,9 @@ func applyCharacterUpdate(tx *sql.Tx, id uuid.UUID, upd pendingUpdate) error {
 		} else {
 			// No versions yet — always snapshot the current data on the first update.
 			if _, err := tx.Exec(`
-				INSERT INTO character_versions (character_id, created_at, size, data_payload)
-				VALUES (?, ?, ?, ?)`,
-				id.String(), dataCreatedAt, dataSize, dataPayload,
+				INSERT INTO character_versions (character_id, created_at, size, data_payload, server)
+				VALUES (?, ?, ?, ?, ?)`,
+				id.String(), dataCreatedAt, dataSize, dataPayload, dataServer,
 			); err != nil {
 				return err
 			}
@@ -152,11 +163,15 @@ func applyCharacterUpdate(tx *sql.Tx, id uuid.UUID, upd pendingUpdate) error {
 	// Write the new current character data.
 	_, err = tx.Exec(`
 		UPDATE characters
-		SET data_created_at = ?, data_size = ?, data_payload = ?
+		SET data_created_at = ?, data_size = ?, data_payload = ?, data_server = ?
 		WHERE id = ?`,
-		time.Now().UTC(), upd.size, upd.data, id.String(),
+		time.Now().UTC(), upd.size, upd.data, upd.server, id.String(),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	return characterChange(tx, database.ChangeCharacterUpdated, id)
 }
 
 func (d *sqliteDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
@@ -170,12 +185,12 @@ func (d *sqliteDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
 
 	err := d.db.QueryRow(`
 		SELECT steam_id, slot, created_at, deleted_at,
-		    data_created_at, data_size, data_payload
+		    data_created_at, data_size, data_payload, data_server
 		FROM characters WHERE id = ?`,
 		id.String(),
 	).Scan(
 		&steamID, &slot, &c.CreatedAt, &deletedAt,
-		&c.Data.CreatedAt, &c.Data.Size, &c.Data.Data,
+		&c.Data.CreatedAt, &c.Data.Size, &c.Data.Data, &c.Data.Server,
 	)
 	if err == sql.ErrNoRows {
 		return nil, database.ErrNoDocument
@@ -191,7 +206,7 @@ func (d *sqliteDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
 
 	// Load the versions slice (Versions []CharacterData).
 	rows, err := d.db.Query(`
-		SELECT created_at, size, data_payload
+		SELECT id, created_at, size, data_payload, tag, note, server
 		FROM character_versions
 		WHERE character_id = ? ORDER BY id ASC`,
 		id.String(),
@@ -203,7 +218,7 @@ func (d *sqliteDB) GetCharacter(id uuid.UUID) (*schema.Character, error) {
 
 	for rows.Next() {
 		var v schema.CharacterData
-		if err := rows.Scan(&v.CreatedAt, &v.Size, &v.Data); err != nil {
+		if err := rows.Scan(&v.ID, &v.CreatedAt, &v.Size, &v.Data, &v.Tag, &v.Note, &v.Server); err != nil {
 			return nil, err
 		}
 		c.Versions = append(c.Versions, v)
@@ -270,45 +285,76 @@ func (d *sqliteDB) SoftDeleteCharacter(id uuid.UUID, expiration time.Duration) e
 	expiresAt := now.Add(expiration)
 
 	return d.exec(func(tx *sql.Tx) error {
-		var steamID string
-		var slot int
-		err := tx.QueryRow(
-			`SELECT steam_id, slot FROM characters WHERE id = ?`, id.String(),
-		).Scan(&steamID, &slot)
-		if err == sql.ErrNoRows {
-			return database.ErrNoDocument
-		}
-		if err != nil {
-			return err
-		}
+		return softDeleteCharacter(tx, id, now, expiresAt)
+	})
+}
 
-		if _, err := tx.Exec(`
-			UPDATE characters SET deleted_at = ?, expires_at = ?, steam_id = NULL, slot = NULL WHERE id = ?`,
-			now, expiresAt, id.String(),
-		); err != nil {
-			return err
-		}
+// softDeleteCharacter is the body of SoftDeleteCharacter so other operations can
+// soft delete within their own transaction.
+func softDeleteCharacter(tx *sql.Tx, id uuid.UUID, now time.Time, expiresAt time.Time) error {
+	var steamID string
+	var slot int
+	err := tx.QueryRow(
+		`SELECT steam_id, slot FROM characters WHERE id = ?`, id.String(),
+	).Scan(&steamID, &slot)
+	if err == sql.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
 
-		// Upsert into deleted_characters to preserve the slot → id mapping
-		// (mirrors user.DeletedCharacters in the pebble implementation).
-		_, err = tx.Exec(`
-			INSERT INTO deleted_characters (steam_id, slot, character_id, deleted_at)
-			VALUES (?, ?, ?, ?)
-			ON CONFLICT (steam_id, slot) DO UPDATE
-			SET character_id = excluded.character_id,
-			    deleted_at   = excluded.deleted_at`,
-			steamID, slot, id.String(), now,
-		)
+	if _, err := tx.Exec(`
+		UPDATE characters SET deleted_at = ?, expires_at = ?, steam_id = NULL, slot = NULL WHERE id = ?`,
+		now, expiresAt, id.String(),
+	); err != nil {
 		return err
-	})
+	}
+
+	// Upsert into deleted_characters to preserve the slot → id mapping
+	// (mirrors user.DeletedCharacters in the pebble implementation).
+	_, err = tx.Exec(`
+		INSERT INTO deleted_characters (steam_id, slot, character_id, deleted_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (steam_id, slot) DO UPDATE
+		SET character_id = excluded.character_id,
+		    deleted_at   = excluded.deleted_at`,
+		steamID, slot, id.String(), now,
+	)
+	if err != nil {
+		return err
+	}
+
+	return characterChange(tx, database.ChangeCharacterDeleted, id)
 }
 
 // DeleteCharacter permanently removes the character and all associated data.
-// cascade on character_versions handles version cleanup automatically.
+// The character is journaled first so the delete can be reverted.
 func (d *sqliteDB) DeleteCharacter(id uuid.UUID) error {
 	return d.exec(func(tx *sql.Tx) error {
-		// character_versions are deleted by ON DELETE CASCADE.
-		_, err := tx.Exec(`DELETE FROM characters WHERE id = ?`, id.String())
+		before, err := characterState(tx, id)
+		if err != nil {
+			return err
+		}
+
+		if err := journalOperation(tx, "delete", id, uuid.Nil, before); err != nil {
+			return err
+		}
+
+		// Recorded while the character still exists so the change has its owner and slot.
+		if err := characterChange(tx, database.ChangeCharacterPurged, id); err != nil {
+			return err
+		}
+
+		// Foreign keys aren't enabled on the connection so ON DELETE CASCADE never
+		// fires, clean up the child rows ourselves.
+		for _, table := range []string{"character_versions", "character_operations", "deleted_characters"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE character_id = ?`, id.String()); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(`DELETE FROM characters WHERE id = ?`, id.String())
 		return err
 	})
 }
@@ -332,55 +378,91 @@ func (d *sqliteDB) DeleteCharacterReference(steamid string, slot int) error {
 }
 
 // MoveCharacter transfers a character to a different user/slot atomically.
-func (d *sqliteDB) MoveCharacter(id uuid.UUID, steamid string, slot int) error {
+// admin is whoever requested it, recorded in the character's lineage.
+func (d *sqliteDB) MoveCharacter(id uuid.UUID, steamid string, slot int, admin string) error {
 	return d.exec(func(tx *sql.Tx) error {
-		// Fetch the character's current owner so we can clear that slot.
-		var oldSteamID string
-		var oldSlot int
-		err := tx.QueryRow(
-			`SELECT steam_id, slot FROM characters WHERE id = ?`, id.String(),
-		).Scan(&oldSteamID, &oldSlot)
-		if err == sql.ErrNoRows {
-			return database.ErrNoDocument
-		}
+		before, err := characterState(tx, id)
 		if err != nil {
 			return err
 		}
 
-		// Ensure the target user exists.
-		var exists int
-		if err := tx.QueryRow(
-			`SELECT COUNT(*) FROM users WHERE id = ?`, steamid,
-		).Scan(&exists); err != nil {
+		if err := beginOperation(tx, id, "move"); err != nil {
 			return err
 		}
-		if exists == 0 {
-			return database.ErrNoDocument
+
+		if err := moveCharacter(tx, id, steamid, slot); err != nil {
+			return err
 		}
 
-		// Clear the old owner's reference by nullifying steam_id/slot so the
-		// UNIQUE constraint on (steam_id, slot) doesn't block the reassignment.
-		if _, err := tx.Exec(`
-			UPDATE characters SET steam_id = NULL, slot = NULL
-			WHERE steam_id = ? AND slot = ? AND deleted_at IS NULL`,
-			oldSteamID, oldSlot,
-		); err != nil {
+		if err := recordLineage(tx, id, id, "move", steamid, slot, admin); err != nil {
 			return err
 		}
 
-		// Now assign the character to the new owner.
-		_, err = tx.Exec(`
-			UPDATE characters SET steam_id = ?, slot = ?, deleted_at = NULL
-			WHERE id = ?`,
-			steamid, slot, id.String(),
-		)
-		return err
+		return journalMove(tx, id, before, steamid, slot)
 	})
 }
 
+// moveCharacter is the body of MoveCharacter so other operations can move
+// characters within their own transaction.
+func moveCharacter(tx *sql.Tx, id uuid.UUID, steamid string, slot int) error {
+	// Fetch the character's current owner so we can clear that slot.
+	var oldSteamID sql.NullString
+	var oldSlot sql.NullInt64
+	var deletedAt sql.NullTime
+	err := tx.QueryRow(
+		`SELECT steam_id, slot, deleted_at FROM characters WHERE id = ?`, id.String(),
+	).Scan(&oldSteamID, &oldSlot, &deletedAt)
+	if err == sql.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
+	if deletedAt.Valid {
+		return fmt.Errorf("character %s is deleted: %w", id, database.ErrNoDocument)
+	}
+
+	// Ensure the target user exists.
+	var exists int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM users WHERE id = ?`, steamid,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return database.ErrNoDocument
+	}
+
+	if err := checkSlotFree(tx, steamid, slot, id); err != nil {
+		return err
+	}
+
+	// Clear the old owner's reference by nullifying steam_id/slot so the
+	// UNIQUE constraint on (steam_id, slot) doesn't block the reassignment.
+	if _, err := tx.Exec(`
+		UPDATE characters SET steam_id = NULL, slot = NULL
+		WHERE steam_id = ? AND slot = ? AND deleted_at IS NULL`,
+		oldSteamID, oldSlot,
+	); err != nil {
+		return err
+	}
+
+	// Now assign the character to the new owner.
+	_, err = tx.Exec(`
+		UPDATE characters SET steam_id = ?, slot = ?
+		WHERE id = ?`,
+		steamid, slot, id.String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return characterChange(tx, database.ChangeCharacterMoved, id)
+}
+
 // CopyCharacter duplicates a character's current data under a new UUID
 // assigned to the target user/slot.
-func (d *sqliteDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid.UUID, error) {
+func (d *sqliteDB) CopyCharacter(id uuid.UUID, steamid string, slot int, admin string) (uuid.UUID, error) {
 	newID := uuid.New()
 	now := time.Now().UTC()
 
@@ -400,6 +482,10 @@ func (d *sqliteDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid.U
 			return err
 		}
 
+		if err := checkSlotFree(tx, steamid, slot, uuid.Nil); err != nil {
+			return err
+		}
+
 		// Ensure the target user exists.
 		if _, err := tx.Exec(
 			`INSERT INTO users (id) VALUES (?) ON CONFLICT(id) DO NOTHING`, steamid,
@@ -413,7 +499,29 @@ func (d *sqliteDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid.U
 			VALUES (?, ?, ?, ?, ?, ?, ?)`,
 			newID.String(), steamid, slot, now, dataCreatedAt, dataSize, dataPayload,
 		)
-		return err
+		if err != nil {
+			return err
+		}
+
+		// The operation is recorded against the copy, undoing it removes the copy.
+		if err := recordOperation(tx, newID, "copy", 0, steamid, slot); err != nil {
+			return err
+		}
+
+		if err := recordLineage(tx, newID, id, "copy", steamid, slot, admin); err != nil {
+			return err
+		}
+
+		if err := characterChangeFrom(tx, database.ChangeCharacterCopied, newID, id); err != nil {
+			return err
+		}
+
+		before, err := characterState(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return journalOperation(tx, "copy", id, newID, before)
 	})
 	if err != nil {
 		return uuid.Nil, err
@@ -421,15 +529,150 @@ func (d *sqliteDB) CopyCharacter(id uuid.UUID, steamid string, slot int) (uuid.U
 	return newID, nil
 }
 
+// ImportCharacter writes an uploaded character file into a slot. If the slot is empty a new
+// character is created, otherwise the current data is snapshotted so the import can be
+// undone and replaced, any update from the game server still waiting to be flushed is
+// dropped so it can't overwrite the import. Either way the imported data is recorded as an
+// "import" version, older versions are pruned to stay within backupMax.
+func (d *sqliteDB) ImportCharacter(steamid string, slot int, size int, data string, note string, admin string, backupMax int) (uuid.UUID, bool, error) {
+	charID := uuid.New()
+	created := false
+	var dropped *pendingUpdate
+	now := time.Now().UTC()
+
+	err := d.exec(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(
+			`INSERT INTO users (id) VALUES (?) ON CONFLICT(id) DO NOTHING`, steamid,
+		); err != nil {
+			return fmt.Errorf("upsert user: %w", err)
+		}
+
+		var (
+			idStr string
+			dataCreatedAt time.Time
+			dataSize int
+			dataPayload string
+		)
+		err := tx.QueryRow(`
+			SELECT id, data_created_at, data_size, data_payload
+			FROM characters
+			WHERE steam_id = ? AND slot = ? AND deleted_at IS NULL`,
+			steamid, slot,
+		).Scan(&idStr, &dataCreatedAt, &dataSize, &dataPayload)
+
+		switch {
+		case err == sql.ErrNoRows:
+			created = true
+			if _, err := tx.Exec(`
+				INSERT INTO characters
+					(id, steam_id, slot, created_at, data_created_at, data_size, data_payload)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				charID.String(), steamid, slot, now, now, size, data,
+			); err != nil {
+				return fmt.Errorf("insert character: %w", err)
+			}
+
+			if err := recordOperation(tx, charID, "import", 0, steamid, slot); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			if charID, err = uuid.Parse(idStr); err != nil {
+				return err
+			}
+			if upd, ok := d.dropPendingUpdate(charID); ok {
+				dropped = &upd
+			}
+
+			snapID, err := insertSnapshot(tx, charID, "import", dataCreatedAt, dataSize, dataPayload)
+			if err != nil {
+				return err
+			}
+
+			if err := recordOperation(tx, charID, "import", snapID, steamid, slot); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(`
+				UPDATE characters
+				SET data_created_at = ?, data_size = ?, data_payload = ?
+				WHERE id = ?`,
+				now, size, data, charID.String(),
+			); err != nil {
+				return err
+			}
+		}
+
+		if err := capVersions(tx, charID, backupMax); err != nil {
+			return err
+		}
+
+		if _, err := insertVersion(tx, charID, now, size, data, "import", note); err != nil {
+			return err
+		}
+
+		kind := database.ChangeCharacterUpdated
+		if created {
+			kind = database.ChangeCharacterCreated
+		}
+		if err := characterChange(tx, kind, charID); err != nil {
+			return err
+		}
+
+		return recordLineage(tx, charID, uuid.Nil, "import", steamid, slot, admin)
+	})
+	if err != nil {
+		if dropped != nil {
+			d.requeueUpdate(charID, *dropped)
+		}
+		return uuid.Nil, false, err
+	}
+	return charID, created, nil
+}
+
+// capVersions prunes the character's oldest versions to make room for one more within
+// max, 0 keeps them all.
+func capVersions(tx *sql.Tx, id uuid.UUID, max int) error {
+	if max <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`
+		DELETE FROM character_versions
+		WHERE character_id = ? AND id NOT IN (
+			SELECT id FROM character_versions
+			WHERE character_id = ? ORDER BY id DESC LIMIT ?
+		)`,
+		id.String(), id.String(), max-1,
+	)
+	return err
+}
+
+// insertVersion appends a tagged version to the character's version history
+// and returns the ID of the new version.
+func insertVersion(tx *sql.Tx, id uuid.UUID, createdAt time.Time, size int, data string, tag string, note string) (int64, error) {
+	res, err := tx.Exec(`
+		INSERT INTO character_versions (character_id, created_at, size, data_payload, tag, note)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		id.String(), createdAt, size, data, tag, note,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
 // RestoreCharacter clears the soft-delete markers and removes the entry from
-// deleted_characters, making the character active again.
-func (d *sqliteDB) RestoreCharacter(id uuid.UUID) error {
+// deleted_characters, making the character active again. slot restores it into a
+// different slot than it was deleted from, nil keeps the original slot.
+func (d *sqliteDB) RestoreCharacter(id uuid.UUID, slot *int, admin string) error {
 	return d.exec(func(tx *sql.Tx) error {
 		var steamID string
-		var slot int
+		var origSlot int
 		err := tx.QueryRow(
 			`SELECT steam_id, slot FROM deleted_characters WHERE character_id = ?`, id.String(),
-		).Scan(&steamID, &slot)
+		).Scan(&steamID, &origSlot)
 		if err == sql.ErrNoRows {
 			return database.ErrNoDocument
 		}
@@ -437,56 +680,261 @@ func (d *sqliteDB) RestoreCharacter(id uuid.UUID) error {
 			return err
 		}
 
+		target := origSlot
+		if slot != nil {
+			target = *slot
+		}
+
+		if err := checkSlotFree(tx, steamID, target, id); err != nil {
+			return err
+		}
+
+		if err := beginOperation(tx, id, "restore"); err != nil {
+			return err
+		}
+
 		if _, err := tx.Exec(`
 			UPDATE characters SET deleted_at = NULL, expires_at = NULL, steam_id = ?, slot = ? WHERE id = ?`,
-			steamID, slot, id.String(),
+			steamID, target, id.String(),
 		); err != nil {
 			return err
 		}
 
-		_, err = tx.Exec(
+		if _, err := tx.Exec(
 			`DELETE FROM deleted_characters WHERE character_id = ?`,
 			id,
+		); err != nil {
+			return err
+		}
+
+		if err := characterChange(tx, database.ChangeCharacterRestored, id); err != nil {
+			return err
+		}
+
+		return recordLineage(tx, id, id, "restore", steamID, target, admin)
+	})
+}
+
+// GetDeletedSlot returns the owner and slot a soft deleted character was deleted from.
+func (d *sqliteDB) GetDeletedSlot(id uuid.UUID) (string, int, error) {
+	var steamID string
+	var slot int
+	err := d.db.QueryRow(
+		`SELECT steam_id, slot FROM deleted_characters WHERE character_id = ?`, id.String(),
+	).Scan(&steamID, &slot)
+	if err == sql.ErrNoRows {
+		return "", 0, database.ErrNoDocument
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	return steamID, slot, nil
+}
+
+// GetDeletedCharacters returns the user's soft deleted characters, ordered by the slot
+// they were deleted from.
+func (d *sqliteDB) GetDeletedCharacters(steamid string) ([]schema.CharacterSummary, error) {
+	rows, err := d.db.Query(`
+		SELECT c.id, dc.steam_id, dc.slot, c.created_at, c.data_created_at, c.data_size,
+			c.data_server, c.deleted_at, c.expires_at
+		FROM deleted_characters dc
+		JOIN characters c ON c.id = dc.character_id
+		WHERE dc.steam_id = ?
+		ORDER BY dc.slot`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chars := make([]schema.CharacterSummary, 0)
+	for rows.Next() {
+		c, err := scanCharacterSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, *c)
+	}
+	return chars, rows.Err()
+}
+
+// SetCharacterExpiry changes when GC purges a soft deleted character.
+func (d *sqliteDB) SetCharacterExpiry(id uuid.UUID, expiresAt time.Time) error {
+	return d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE characters SET expires_at = ? WHERE id = ? AND deleted_at IS NOT NULL`,
+			expiresAt.UTC(), id.String(),
 		)
-		return err
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return database.ErrNoDocument
+		}
+		return nil
 	})
 }
 
-// RollbackCharacter replaces the current character data with the version at
-// index ver (0-based, ordered oldest → newest). Mirrors the pebble implementation.
-func (d *sqliteDB) RollbackCharacter(id uuid.UUID, ver int) error {
+// SwapCharacters swaps the characters in two of the user's slots in one transaction,
+// either slot may be empty. Swapping the same slots again reverses it.
+func (d *sqliteDB) SwapCharacters(steamid string, a int, b int) error {
 	return d.exec(func(tx *sql.Tx) error {
-		var createdAt time.Time
-		var size int
-		var payload string
-		err := tx.QueryRow(`
-			SELECT created_at, size, data_payload
-			FROM character_versions
-			WHERE character_id = ?
-			ORDER BY id ASC
-			LIMIT 1 OFFSET ?`,
-			id.String(), ver,
-		).Scan(&createdAt, &size, &payload)
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("no character version at index %d", ver)
+		idA, err := slotCharacter(tx, steamid, a)
+		if err != nil {
+			return err
 		}
+		idB, err := slotCharacter(tx, steamid, b)
 		if err != nil {
 			return err
 		}
+		if idA == uuid.Nil && idB == uuid.Nil {
+			return database.ErrNoDocument
+		}
 
-		_, err = tx.Exec(`
-			UPDATE characters
-			SET data_created_at = ?, data_size = ?, data_payload = ?
-			WHERE id = ?`,
-			createdAt, size, payload, id.String(),
-		)
+		// Free both slots first so neither update collides with the other character.
+		if _, err := tx.Exec(`
+			UPDATE characters SET slot = NULL
+			WHERE steam_id = ? AND slot IN (?, ?) AND deleted_at IS NULL`,
+			steamid, a, b,
+		); err != nil {
+			return err
+		}
+
+		if idA != uuid.Nil {
+			if _, err := tx.Exec(`UPDATE characters SET slot = ? WHERE id = ?`, b, idA.String()); err != nil {
+				return err
+			}
+		}
+		if idB != uuid.Nil {
+			if _, err := tx.Exec(`UPDATE characters SET slot = ? WHERE id = ?`, a, idB.String()); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range []uuid.UUID{idA, idB} {
+			if id == uuid.Nil {
+				continue
+			}
+			if err := characterChange(tx, database.ChangeCharacterMoved, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// slotCharacter returns the active character in the slot, uuid.Nil when it's empty.
+func slotCharacter(tx *sql.Tx, steamid string, slot int) (uuid.UUID, error) {
+	var idStr string
+	err := tx.QueryRow(`
+		SELECT id FROM characters
+		WHERE steam_id = ? AND slot = ? AND deleted_at IS NULL`,
+		steamid, slot,
+	).Scan(&idStr)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.Parse(idStr)
+}
+
+// checkSlotFree returns a SlotOccupiedError when an active character other than id is in the slot.
+func checkSlotFree(tx *sql.Tx, steamid string, slot int, id uuid.UUID) error {
+	other, err := slotCharacter(tx, steamid, slot)
+	if err != nil {
 		return err
+	}
+	if other != uuid.Nil && other != id {
+		return &database.SlotOccupiedError{SteamID: steamid, Slot: slot, CharacterID: other}
+	}
+	return nil
+}
+
+// RollbackCharacter replaces the current character data with the version ver.
+// Versions are referenced by ID so pruning older versions doesn't change which one we get.
+func (d *sqliteDB) RollbackCharacter(id uuid.UUID, ver int64) error {
+	return d.replaceData([]uuid.UUID{id}, func(tx *sql.Tx) error {
+		return rollbackCharacter(tx, id, ver)
 	})
 }
 
+// RollbackCharacters rolls back every target in one transaction, none of them are
+// rolled back when one fails.
+func (d *sqliteDB) RollbackCharacters(targets []database.RollbackTarget) error {
+	ids := make([]uuid.UUID, len(targets))
+	for i, t := range targets {
+		ids[i] = t.ID
+	}
+
+	return d.replaceData(ids, func(tx *sql.Tx) error {
+		for _, t := range targets {
+			if err := rollbackCharacter(tx, t.ID, t.Version); err != nil {
+				return fmt.Errorf("character %s: %w", t.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+func rollbackCharacter(tx *sql.Tx, id uuid.UUID, ver int64) error {
+	var createdAt time.Time
+	var size int
+	var payload string
+	err := tx.QueryRow(`
+		SELECT created_at, size, data_payload
+		FROM character_versions
+		WHERE id = ? AND character_id = ?`,
+		ver, id.String(),
+	).Scan(&createdAt, &size, &payload)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no character version %d: %w", ver, database.ErrNoDocument)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := beginOperation(tx, id, "rollback"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE characters
+		SET data_created_at = ?, data_size = ?, data_payload = ?
+		WHERE id = ?`,
+		createdAt, size, payload, id.String(),
+	); err != nil {
+		return err
+	}
+
+	return characterChange(tx, database.ChangeCharacterRolledBack, id)
+}
+
+// GetCharacterVersionAt returns the ID of the newest version saved at or before t.
+func (d *sqliteDB) GetCharacterVersionAt(id uuid.UUID, t time.Time) (int64, error) {
+	var ver int64
+	err := d.db.QueryRow(`
+		SELECT id FROM character_versions
+		WHERE character_id = ? AND created_at <= ?
+		ORDER BY created_at DESC, id DESC LIMIT 1`,
+		id.String(), t.UTC(),
+	).Scan(&ver)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no character version at or before %s: %w", t.UTC().Format(time.RFC3339), database.ErrNoDocument)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return ver, nil
+}
+
 // RollbackCharacterToLatest replaces the current data with the most recent version.
 func (d *sqliteDB) RollbackCharacterToLatest(id uuid.UUID) error {
-	return d.exec(func(tx *sql.Tx) error {
+	return d.replaceData([]uuid.UUID{id}, func(tx *sql.Tx) error {
 		var createdAt time.Time
 		var size int
 		var payload string
@@ -498,19 +946,26 @@ func (d *sqliteDB) RollbackCharacterToLatest(id uuid.UUID) error {
 			id.String(),
 		).Scan(&createdAt, &size, &payload)
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("no character backups exist")
+			return fmt.Errorf("no character backups exist: %w", database.ErrNoDocument)
 		}
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.Exec(`
+		if err := beginOperation(tx, id, "rollback"); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`
 			UPDATE characters
 			SET data_created_at = ?, data_size = ?, data_payload = ?
 			WHERE id = ?`,
 			createdAt, size, payload, id.String(),
-		)
-		return err
+		); err != nil {
+			return err
+		}
+
+		return characterChange(tx, database.ChangeCharacterRolledBack, id)
 	})
 }
 
@@ -524,9 +979,10 @@ func (d *sqliteDB) DeleteCharacterVersions(id uuid.UUID) error {
 	})
 }
 
-func (d *sqliteDB) GetRollbackVersionsTimestamp(id uuid.UUID) (map[int]string, error) {
+// GetRollbackVersionsTimestamp returns the timestamp of every version keyed by version ID.
+func (d *sqliteDB) GetRollbackVersionsTimestamp(id uuid.UUID) (map[int64]string, error) {
 	rows, err := d.db.Query(`
-		SELECT created_at
+		SELECT id, created_at
 		FROM character_versions
 		WHERE character_id = ?
 		ORDER BY id ASC`,
@@ -537,15 +993,74 @@ func (d *sqliteDB) GetRollbackVersionsTimestamp(id uuid.UUID) (map[int]string, e
 	}
 	defer rows.Close()
 
-	versions := make(map[int]string)
-	idx := 0
+	versions := make(map[int64]string)
 	for rows.Next() {
+		var ver int64
 		var createdAt time.Time
-		if err := rows.Scan(&createdAt); err != nil {
+		if err := rows.Scan(&ver, &createdAt); err != nil {
 			return nil, err
 		}
-		versions[idx] = createdAt.UTC().Format(time.RFC3339)
-		idx++
+		versions[ver] = createdAt.UTC().Format(time.RFC3339)
 	}
 	return versions, rows.Err()
 }
+
+// GetModifiedCharacters finds active characters last saved between from and to. If servers
+// is set only characters saved by one of those servers within the window are returned.
+func (d *sqliteDB) GetModifiedCharacters(from time.Time, to time.Time, servers []string) ([]database.ModifiedCharacter, error) {
+	from, to = from.UTC(), to.UTC()
+
+	query := `
+		SELECT c.id, c.steam_id, c.slot, c.data_created_at, c.data_server, v.id, v.created_at
+		FROM characters c
+		LEFT JOIN character_versions v ON v.id = (
+			SELECT id FROM character_versions
+			WHERE character_id = c.id AND created_at < ?
+			ORDER BY created_at DESC, id DESC LIMIT 1
+		)
+		WHERE c.deleted_at IS NULL AND c.data_created_at >= ? AND c.data_created_at <= ?`
+	args := []interface{}{from, from, to}
+
+	if len(servers) > 0 {
+		in := strings.TrimSuffix(strings.Repeat("?,", len(servers)), ",")
+		query += `
+		AND (c.data_server IN (` + in + `) OR EXISTS (
+			SELECT 1 FROM character_versions sv
+			WHERE sv.character_id = c.id AND sv.server IN (` + in + `)
+			AND sv.created_at >= ? AND sv.created_at <= ?
+		))`
+		for i := 0; i < 2; i++ {
+			for _, srv := range servers {
+				args = append(args, srv)
+			}
+		}
+		args = append(args, from, to)
+	}
+	query += ` ORDER BY c.data_created_at ASC`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chars []database.ModifiedCharacter
+	for rows.Next() {
+		var (
+			c database.ModifiedCharacter
+			idStr string
+			verID sql.NullInt64
+			verCreatedAt sql.NullTime
+		)
+		if err := rows.Scan(
+			&idStr, &c.SteamID, &c.Slot, &c.ModifiedAt, &c.Server, &verID, &verCreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		c.ID, _ = uuid.Parse(idStr)
+		c.Version = verID.Int64
+		c.VersionCreatedAt = verCreatedAt.Time
+		chars = append(chars, c)
+	}
+	return chars, rows.Err()
+}
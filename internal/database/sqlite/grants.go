@@ -0,0 +1,245 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+// GrantUserFlag sets the flag's bit and records who granted it and until when, a grant
+// the user already has for the flag is replaced.
+func (d *sqliteDB) GrantUserFlag(grant *schema.FlagGrant) error {
+	return d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE users SET flags = flags | ? WHERE id = ?`, uint32(1)<<grant.Bit, grant.SteamID,
+		)
+		if err != nil {
+			return err
+		}
+		n, _ := res.RowsAffected()
+		if n == 0 {
+			return database.ErrNoDocument
+		}
+
+		if err := userChange(tx, grant.SteamID); err != nil {
+			return err
+		}
+
+		return saveGrant(tx, grant)
+	})
+}
+
+// BulkUpdateFlags applies the changes in order in one transaction, users that haven't
+// joined yet are created. Grants are saved for the bits an add or set covers and dropped
+// along with the bits a change clears. Setting BANNED records a ban and clearing it lifts
+// the active ban, the same as AddBan and LiftBan.
+func (d *sqliteDB) BulkUpdateFlags(changes []database.FlagChange) ([]database.FlagChangeResult, error) {
+	var results []database.FlagChangeResult
+
+	err := d.exec(func(tx *sql.Tx) error {
+		results = make([]database.FlagChangeResult, 0, len(changes))
+		for _, c := range changes {
+			res, err := tx.Exec(`INSERT INTO users (id) VALUES (?) ON CONFLICT(id) DO NOTHING`, c.SteamID)
+			if err != nil {
+				return fmt.Errorf("upsert user: %w", err)
+			}
+			created, _ := res.RowsAffected()
+
+			var flags uint32
+			if err := tx.QueryRow(`SELECT flags FROM users WHERE id = ?`, c.SteamID).Scan(&flags); err != nil {
+				return err
+			}
+			before := bitmask.Bitmask(flags)
+			after := c.Apply(before)
+
+			if after != before {
+				if _, err := tx.Exec(`UPDATE users SET flags = ? WHERE id = ?`, uint32(after), c.SteamID); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(
+					`DELETE FROM flag_grants WHERE steam_id = ? AND (? >> bit) & 1 = 0`, c.SteamID, uint32(after),
+				); err != nil {
+					return err
+				}
+				if err := userChange(tx, c.SteamID); err != nil {
+					return err
+				}
+			}
+
+			if err := d.bulkBan(tx, c, before, after); err != nil {
+				return err
+			}
+
+			if c.Op != database.FlagRemove {
+				for bit := uint(0); bit < 32; bit++ {
+					// The ban decides when BANNED is cleared, not a grant.
+					if !c.Mask.HasFlag(1 << bit) || bitmask.Bitmask(1 << bit) == bitmask.BANNED {
+						continue
+					}
+					if err := saveGrant(tx, &schema.FlagGrant{
+						SteamID: c.SteamID,
+						Bit: bit,
+						Source: c.Source,
+						Admin: c.Admin,
+						GrantedAt: c.GrantedAt,
+						ExpiresAt: c.ExpiresAt,
+					}); err != nil {
+						return err
+					}
+				}
+			}
+
+			results = append(results, database.FlagChangeResult{
+				SteamID: c.SteamID,
+				Before: before,
+				After: after,
+				Created: created > 0,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// bulkBan records or lifts a ban when the change sets or clears the user's BANNED flag.
+func (d *sqliteDB) bulkBan(tx *sql.Tx, c database.FlagChange, before bitmask.Bitmask, after bitmask.Bitmask) error {
+	switch {
+	case !before.HasFlag(bitmask.BANNED) && after.HasFlag(bitmask.BANNED):
+		_, err := d.insertBan(tx, &schema.Ban{
+			SteamID: c.SteamID,
+			Reason: c.Reason,
+			Admin: c.Admin,
+			CreatedAt: c.GrantedAt,
+			ExpiresAt: c.ExpiresAt,
+		})
+		return err
+	case before.HasFlag(bitmask.BANNED) && !after.HasFlag(bitmask.BANNED):
+		if err := liftBans(tx, c.SteamID, c.GrantedAt, c.Admin, c.Reason); err != nil {
+			return err
+		}
+		return d.queueUnban(tx, c.SteamID, c.Admin, fmt.Sprintf("%s was unbanned by a bulk flag change: %s", c.SteamID, c.Reason), nil)
+	}
+	return nil
+}
+
+// saveGrant records the grant, replacing the one the user already has for the bit.
+func saveGrant(tx *sql.Tx, grant *schema.FlagGrant) error {
+	var expiresAt sql.NullTime
+	if grant.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: grant.ExpiresAt.UTC(), Valid: true}
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO flag_grants (steam_id, bit, source, admin, granted_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (steam_id, bit) DO UPDATE
+		SET source     = excluded.source,
+		    admin      = excluded.admin,
+		    granted_at = excluded.granted_at,
+		    expires_at = excluded.expires_at`,
+		grant.SteamID, grant.Bit, grant.Source, grant.Admin, grant.GrantedAt.UTC(), expiresAt,
+	)
+	return err
+}
+
+// GetFlagGrants returns the user's grants ordered by bit.
+func (d *sqliteDB) GetFlagGrants(steamid string) ([]schema.FlagGrant, error) {
+	rows, err := d.db.Query(`
+		SELECT steam_id, bit, source, admin, granted_at, expires_at
+		FROM flag_grants
+		WHERE steam_id = ?
+		ORDER BY bit ASC`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return readGrants(rows)
+}
+
+// readGrants reads and closes rows selecting every column of flag_grants.
+func readGrants(rows *sql.Rows) ([]schema.FlagGrant, error) {
+	defer rows.Close()
+
+	grants := make([]schema.FlagGrant, 0)
+	for rows.Next() {
+		var (
+			g schema.FlagGrant
+			expiresAt sql.NullTime
+		)
+		if err := rows.Scan(&g.SteamID, &g.Bit, &g.Source, &g.Admin, &g.GrantedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		if expiresAt.Valid {
+			g.ExpiresAt = &expiresAt.Time
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// ExpireFlagGrants clears the bits of every grant that ran out by now and removes the
+// grants. It returns how many grants expired.
+func (d *sqliteDB) ExpireFlagGrants(now time.Time) (int, error) {
+	var expired int
+
+	err := d.exec(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT steam_id, bit FROM flag_grants
+			WHERE expires_at IS NOT NULL AND expires_at <= ?`,
+			now.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		var grants []schema.FlagGrant
+		for rows.Next() {
+			var g schema.FlagGrant
+			if err := rows.Scan(&g.SteamID, &g.Bit); err != nil {
+				rows.Close()
+				return err
+			}
+			grants = append(grants, g)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, g := range grants {
+			if _, err := tx.Exec(
+				`UPDATE users SET flags = flags & ~? WHERE id = ?`, uint32(1)<<g.Bit, g.SteamID,
+			); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(
+				`DELETE FROM flag_grants WHERE steam_id = ? AND bit = ?`, g.SteamID, g.Bit,
+			); err != nil {
+				return err
+			}
+
+			if err := userChange(tx, g.SteamID); err != nil {
+				return err
+			}
+		}
+
+		expired = len(grants)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,153 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+const jobColumns = `id, kind, status, params, progress, total, result, error, requested_by,
+	created_at, started_at, finished_at`
+
+func (d *sqliteDB) AddJob(job *schema.Job) error {
+	return d.exec(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO jobs (id, kind, status, params, progress, total, result, requested_by, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			job.ID.String(), job.Kind, job.Status, string(job.Params), job.Progress, job.Total, string(job.Result),
+			job.RequestedBy, job.CreatedAt.UTC(),
+		)
+		return err
+	})
+}
+
+// UpdateJob saves the job's status, progress and result, ErrNoDocument is returned when
+// there's no such job.
+func (d *sqliteDB) UpdateJob(job *schema.Job) error {
+	return d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			UPDATE jobs SET status = ?, progress = ?, total = ?, result = ?, error = ?, started_at = ?, finished_at = ?
+			WHERE id = ?`,
+			job.Status, job.Progress, job.Total, string(job.Result), job.Error,
+			nullTime(job.StartedAt), nullTime(job.FinishedAt), job.ID.String(),
+		)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return database.ErrNoDocument
+		}
+		return nil
+	})
+}
+
+func (d *sqliteDB) GetJob(id uuid.UUID) (*schema.Job, error) {
+	j, err := scanJob(d.db.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id.String()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// GetJobs returns the newest jobs, status and kind filter them when they're not empty.
+func (d *sqliteDB) GetJobs(status string, kind string, limit int) ([]schema.Job, error) {
+	rows, err := d.db.Query(`
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE (? = '' OR status = ?) AND (? = '' OR kind = ?)
+		ORDER BY created_at DESC LIMIT ?`,
+		status, status, kind, kind, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]schema.Job, 0)
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *j)
+	}
+	return jobs, rows.Err()
+}
+
+// PruneJobs removes the jobs that finished before the given time and returns how many
+// there were, queued and running jobs are always kept.
+func (d *sqliteDB) PruneJobs(before time.Time) (int, error) {
+	var pruned int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`DELETE FROM jobs WHERE status IN (?, ?) AND finished_at < ?`,
+			database.JobDone, database.JobFailed, before.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		pruned, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(pruned), nil
+}
+
+func scanJob(row rowScanner) (*schema.Job, error) {
+	var (
+		j schema.Job
+		id string
+		params string
+		result string
+		startedAt sql.NullTime
+		finishedAt sql.NullTime
+	)
+	err := row.Scan(
+		&id, &j.Kind, &j.Status, &params, &j.Progress, &j.Total, &result, &j.Error, &j.RequestedBy,
+		&j.CreatedAt, &startedAt, &finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	j.ID, _ = uuid.Parse(id)
+	if params != "" {
+		j.Params = []byte(params)
+	}
+	if result != "" {
+		j.Result = []byte(result)
+	}
+	if startedAt.Valid {
+		j.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		j.FinishedAt = &finishedAt.Time
+	}
+	return &j, nil
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
@@ -0,0 +1,378 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// GetJournal returns the most recent journal entries, newest first. The before
+// state is left out since it holds every version of the character.
+func (d *sqliteDB) GetJournal(limit int) ([]schema.JournalEntry, error) {
+	rows, err := d.db.Query(`
+		SELECT id, operation, character_id, target_id, to_steam_id, to_slot, created_at, reverted_at
+		FROM operation_journal
+		ORDER BY id DESC LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]schema.JournalEntry, 0)
+	for rows.Next() {
+		var (
+			e schema.JournalEntry
+			charID string
+			targetID sql.NullString
+			toSteamID sql.NullString
+			toSlot sql.NullInt64
+			revertedAt sql.NullTime
+		)
+		if err := rows.Scan(&e.ID, &e.Operation, &charID, &targetID, &toSteamID, &toSlot, &e.CreatedAt, &revertedAt); err != nil {
+			return nil, err
+		}
+		e.CharacterID, _ = uuid.Parse(charID)
+		if targetID.Valid {
+			tid, _ := uuid.Parse(targetID.String)
+			e.TargetID = &tid
+		}
+		e.ToSteamID = toSteamID.String
+		if toSlot.Valid {
+			slot := int(toSlot.Int64)
+			e.ToSlot = &slot
+		}
+		if revertedAt.Valid {
+			e.RevertedAt = &revertedAt.Time
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetJournalEntry returns a journal entry along with the character's state before the operation.
+func (d *sqliteDB) GetJournalEntry(id int64) (*schema.JournalEntry, error) {
+	return journalEntry(d.db.QueryRow(`
+		SELECT id, operation, character_id, target_id, to_steam_id, to_slot, before_state, created_at, reverted_at
+		FROM operation_journal WHERE id = ?`,
+		id,
+	))
+}
+
+// RevertJournalEntry reverts a journaled operation. Moves are moved back as long as the
+// character is still where the move put it, copies are soft deleted and deleted characters
+// are recreated with all of their versions under their original IDs. admin is recorded in
+// the lineage of characters moved back or recreated.
+func (d *sqliteDB) RevertJournalEntry(id int64, expiration time.Duration, admin string) (*schema.JournalEntry, error) {
+	var entry *schema.JournalEntry
+
+	err := d.exec(func(tx *sql.Tx) error {
+		var err error
+		entry, err = journalEntry(tx.QueryRow(`
+			SELECT id, operation, character_id, target_id, to_steam_id, to_slot, before_state, created_at, reverted_at
+			FROM operation_journal WHERE id = ?`,
+			id,
+		))
+		if err != nil {
+			return err
+		}
+		if entry.RevertedAt != nil {
+			return database.ErrAlreadyReverted
+		}
+
+		now := time.Now().UTC()
+		switch entry.Operation {
+		case "move":
+			if err := checkMovedTo(tx, entry); err != nil {
+				return err
+			}
+			if err := undoMove(tx, entry.CharacterID, entry.Before.SteamID, entry.Before.Slot); err != nil {
+				return err
+			}
+			if err := recordLineage(tx, entry.CharacterID, entry.CharacterID, "revert", entry.Before.SteamID, entry.Before.Slot, admin); err != nil {
+				return err
+			}
+		case "copy":
+			if entry.TargetID == nil {
+				return fmt.Errorf("journal entry %d has no copy to remove", id)
+			}
+			if err := softDeleteCharacter(tx, *entry.TargetID, now, now.Add(expiration)); err != nil {
+				return err
+			}
+			// The copy is gone, none of its operations can be undone anymore.
+			if _, err := tx.Exec(
+				`UPDATE character_operations SET undone_at = ? WHERE character_id = ? AND undone_at IS NULL`,
+				now, entry.TargetID.String(),
+			); err != nil {
+				return err
+			}
+		case "delete":
+			if err := restoreCharacterState(tx, entry.Before, now.Add(expiration)); err != nil {
+				return err
+			}
+			if err := recordLineage(tx, entry.CharacterID, entry.CharacterID, "revert", entry.Before.SteamID, entry.Before.Slot, admin); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unable to revert %s operation", entry.Operation)
+		}
+
+		entry.RevertedAt = &now
+		_, err = tx.Exec(
+			`UPDATE operation_journal SET reverted_at = ? WHERE id = ?`,
+			now, id,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// PruneJournal removes journal entries created before the given time.
+func (d *sqliteDB) PruneJournal(before time.Time) error {
+	return d.exec(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM operation_journal WHERE created_at < ?`, before.UTC())
+		return err
+	})
+}
+
+func journalEntry(row *sql.Row) (*schema.JournalEntry, error) {
+	var (
+		e schema.JournalEntry
+		charID string
+		targetID sql.NullString
+		toSteamID sql.NullString
+		toSlot sql.NullInt64
+		before string
+		revertedAt sql.NullTime
+	)
+	err := row.Scan(&e.ID, &e.Operation, &charID, &targetID, &toSteamID, &toSlot, &before, &e.CreatedAt, &revertedAt)
+	if err == sql.ErrNoRows {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.CharacterID, _ = uuid.Parse(charID)
+	if targetID.Valid {
+		tid, _ := uuid.Parse(targetID.String)
+		e.TargetID = &tid
+	}
+	e.ToSteamID = toSteamID.String
+	if toSlot.Valid {
+		slot := int(toSlot.Int64)
+		e.ToSlot = &slot
+	}
+	if revertedAt.Valid {
+		e.RevertedAt = &revertedAt.Time
+	}
+
+	e.Before = &schema.Character{}
+	if err := json.Unmarshal([]byte(before), e.Before); err != nil {
+		return nil, fmt.Errorf("journal entry %d: %w", e.ID, err)
+	}
+
+	return &e, nil
+}
+
+// journalOperation writes an operation to the journal along with the character's
+// state from before it. targetID is the new character for copies, otherwise uuid.Nil.
+func journalOperation(tx *sql.Tx, op string, id uuid.UUID, targetID uuid.UUID, before *schema.Character) error {
+	return insertJournal(tx, op, id, targetID, before, sql.NullString{}, sql.NullInt64{})
+}
+
+// journalMove journals a move along with where it put the character, so reverting it
+// can check the character hasn't been moved again since.
+func journalMove(tx *sql.Tx, id uuid.UUID, before *schema.Character, steamid string, slot int) error {
+	return insertJournal(tx, "move", id, uuid.Nil, before,
+		sql.NullString{String: steamid, Valid: true}, sql.NullInt64{Int64: int64(slot), Valid: true},
+	)
+}
+
+func insertJournal(tx *sql.Tx, op string, id uuid.UUID, targetID uuid.UUID, before *schema.Character, toSteamID sql.NullString, toSlot sql.NullInt64) error {
+	state, err := json.Marshal(before)
+	if err != nil {
+		return err
+	}
+
+	target := sql.NullString{String: targetID.String(), Valid: targetID != uuid.Nil}
+	_, err = tx.Exec(`
+		INSERT INTO operation_journal (operation, character_id, target_id, to_steam_id, to_slot, before_state, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		op, id.String(), target, toSteamID, toSlot, string(state), time.Now().UTC(),
+	)
+	return err
+}
+
+// checkMovedTo makes sure a journaled move's character is still active in the slot the
+// move put it in, reverting it otherwise would undo whatever moved it since.
+func checkMovedTo(tx *sql.Tx, entry *schema.JournalEntry) error {
+	if entry.Before.SteamID == "" {
+		return fmt.Errorf("character %s: %w", entry.CharacterID, database.ErrNoOwner)
+	}
+
+	var steamID sql.NullString
+	var slot sql.NullInt64
+	var deletedAt sql.NullTime
+	err := tx.QueryRow(
+		`SELECT steam_id, slot, deleted_at FROM characters WHERE id = ?`, entry.CharacterID.String(),
+	).Scan(&steamID, &slot, &deletedAt)
+	if err == sql.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
+
+	if deletedAt.Valid || !slot.Valid || entry.ToSlot == nil ||
+		steamID.String != entry.ToSteamID || int(slot.Int64) != *entry.ToSlot {
+		return fmt.Errorf("character %s: %w", entry.CharacterID, database.ErrMovedSince)
+	}
+	return nil
+}
+
+// characterState reads the full character, versions included, within the transaction.
+// Soft deleted characters get the owner and slot they were deleted from.
+func characterState(tx *sql.Tx, id uuid.UUID) (*schema.Character, error) {
+	c := &schema.Character{ID: id}
+
+	var (
+		steamID sql.NullString
+		slot sql.NullInt32
+		deletedAt sql.NullTime
+	)
+	err := tx.QueryRow(`
+		SELECT steam_id, slot, created_at, deleted_at,
+		    data_created_at, data_size, data_payload, data_server
+		FROM characters WHERE id = ?`,
+		id.String(),
+	).Scan(
+		&steamID, &slot, &c.CreatedAt, &deletedAt,
+		&c.Data.CreatedAt, &c.Data.Size, &c.Data.Data, &c.Data.Server,
+	)
+	if err == sql.ErrNoRows {
+		return nil, database.ErrNoDocument
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.SteamID = steamID.String
+	c.Slot = int(slot.Int32)
+
+	if deletedAt.Valid {
+		c.DeletedAt = &deletedAt.Time
+
+		err := tx.QueryRow(
+			`SELECT steam_id, slot FROM deleted_characters WHERE character_id = ?`, id.String(),
+		).Scan(&c.SteamID, &c.Slot)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, created_at, size, data_payload, tag, note, server
+		FROM character_versions
+		WHERE character_id = ? ORDER BY id ASC`,
+		id.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v schema.CharacterData
+		if err := rows.Scan(&v.ID, &v.CreatedAt, &v.Size, &v.Data, &v.Tag, &v.Note, &v.Server); err != nil {
+			return nil, err
+		}
+		c.Versions = append(c.Versions, v)
+	}
+	return c, rows.Err()
+}
+
+// restoreCharacterState recreates a deleted character from its journaled state. Characters
+// that were soft deleted at the time come back soft deleted with a new expiry.
+func restoreCharacterState(tx *sql.Tx, c *schema.Character, expiresAt time.Time) error {
+	var exists int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM characters WHERE id = ?`, c.ID.String(),
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return fmt.Errorf("character %s already exists", c.ID)
+	}
+	if c.SteamID == "" {
+		return fmt.Errorf("character %s: %w", c.ID, database.ErrNoOwner)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO users (id) VALUES (?) ON CONFLICT(id) DO NOTHING`, c.SteamID,
+	); err != nil {
+		return fmt.Errorf("upsert user: %w", err)
+	}
+
+	if c.DeletedAt == nil {
+		if err := checkSlotFree(tx, c.SteamID, c.Slot, c.ID); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO characters
+				(id, steam_id, slot, created_at, data_created_at, data_size, data_payload, data_server)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ID.String(), c.SteamID, c.Slot, c.CreatedAt, c.Data.CreatedAt, c.Data.Size, c.Data.Data, c.Data.Server,
+		); err != nil {
+			return err
+		}
+	} else {
+		if _, err := tx.Exec(`
+			INSERT INTO characters
+				(id, created_at, deleted_at, expires_at, data_created_at, data_size, data_payload, data_server)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.ID.String(), c.CreatedAt, *c.DeletedAt, expiresAt, c.Data.CreatedAt, c.Data.Size, c.Data.Data, c.Data.Server,
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO deleted_characters (steam_id, slot, character_id, deleted_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (steam_id, slot) DO UPDATE
+			SET character_id = excluded.character_id,
+			    deleted_at   = excluded.deleted_at`,
+			c.SteamID, c.Slot, c.ID.String(), *c.DeletedAt,
+		); err != nil {
+			return err
+		}
+	}
+
+	// The versions keep their IDs, they're stable references for rollbacks.
+	for _, v := range c.Versions {
+		if _, err := tx.Exec(`
+			INSERT INTO character_versions (id, character_id, created_at, size, data_payload, tag, note, server)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			v.ID, c.ID.String(), v.CreatedAt, v.Size, v.Data, v.Tag, v.Note, v.Server,
+		); err != nil {
+			return err
+		}
+	}
+
+	kind := database.ChangeCharacterRestored
+	if c.DeletedAt != nil {
+		kind = database.ChangeCharacterDeleted
+	}
+	return characterChange(tx, kind, c.ID)
+}
@@ -0,0 +1,123 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+// GetLineage returns the character's own history along with every character it was
+// copied from and every character copied from it.
+func (d *sqliteDB) GetLineage(id uuid.UUID) (*schema.Lineage, error) {
+	lineage := &schema.Lineage{CharacterID: id}
+
+	var err error
+	lineage.History, err = d.lineageEdges(`
+		SELECT id, character_id, source_id, operation, steam_id, slot, admin, created_at
+		FROM character_lineage
+		WHERE character_id = ? AND operation != 'copy'
+		ORDER BY id ASC`,
+		id.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lineage.Ancestors, err = d.lineageEdges(`
+		WITH RECURSIVE ancestors(id, depth) AS (
+			SELECT ?, 0
+			UNION
+			SELECT l.source_id, a.depth + 1
+			FROM character_lineage l
+			JOIN ancestors a ON l.character_id = a.id
+			WHERE l.operation = 'copy'
+		)
+		SELECT l.id, l.character_id, l.source_id, l.operation, l.steam_id, l.slot, l.admin, l.created_at
+		FROM character_lineage l
+		JOIN ancestors a ON l.character_id = a.id
+		WHERE l.operation = 'copy'
+		ORDER BY a.depth ASC`,
+		id.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lineage.Descendants, err = d.lineageEdges(`
+		WITH RECURSIVE descendants(id) AS (
+			SELECT ?
+			UNION
+			SELECT l.character_id
+			FROM character_lineage l
+			JOIN descendants d ON l.source_id = d.id
+			WHERE l.operation = 'copy'
+		)
+		SELECT id, character_id, source_id, operation, steam_id, slot, admin, created_at
+		FROM character_lineage
+		WHERE operation = 'copy' AND source_id IN (SELECT id FROM descendants)
+		ORDER BY id ASC`,
+		id.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lineage is kept after a character is deleted, only fail if there's nothing at all.
+	if len(lineage.History) == 0 && len(lineage.Ancestors) == 0 && len(lineage.Descendants) == 0 {
+		var exists int
+		if err := d.db.QueryRow(
+			`SELECT COUNT(*) FROM characters WHERE id = ?`, id.String(),
+		).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			return nil, database.ErrNoDocument
+		}
+	}
+
+	return lineage, nil
+}
+
+func (d *sqliteDB) lineageEdges(query string, args ...interface{}) ([]schema.LineageEdge, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	edges := make([]schema.LineageEdge, 0)
+	for rows.Next() {
+		var (
+			e schema.LineageEdge
+			charID string
+			sourceID sql.NullString
+		)
+		if err := rows.Scan(&e.ID, &charID, &sourceID, &e.Operation, &e.SteamID, &e.Slot, &e.Admin, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.CharacterID, _ = uuid.Parse(charID)
+		if sourceID.Valid {
+			sid, _ := uuid.Parse(sourceID.String)
+			e.SourceID = &sid
+		}
+		edges = append(edges, e)
+	}
+	return edges, rows.Err()
+}
+
+// recordLineage adds a lineage edge for the character, source is uuid.Nil when the
+// character didn't come from another one. steamid and slot are the owner afterwards.
+func recordLineage(tx *sql.Tx, id uuid.UUID, source uuid.UUID, op string, steamid string, slot int, admin string) error {
+	_, err := tx.Exec(`
+		INSERT INTO character_lineage (character_id, source_id, operation, steam_id, slot, admin, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		id.String(),
+		sql.NullString{String: source.String(), Valid: source != uuid.Nil},
+		op, steamid, slot, admin, time.Now().UTC(),
+	)
+	return err
+}
@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"database/sql"
+
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+func (d *sqliteDB) AddUserNote(note *schema.UserNote) (int64, error) {
+	var id int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		var charID sql.NullString
+		if note.CharacterID != nil {
+			charID = sql.NullString{String: note.CharacterID.String(), Valid: true}
+		}
+
+		res, err := tx.Exec(`
+			INSERT INTO user_notes (steam_id, author, category, character_id, body, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			note.SteamID, note.Author, note.Category, charID, note.Body, note.CreatedAt.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetUserNotes returns the user's notes newest first, category filters them when it's not empty.
+func (d *sqliteDB) GetUserNotes(steamid string, category string) ([]schema.UserNote, error) {
+	rows, err := d.db.Query(`
+		SELECT id, steam_id, author, category, character_id, body, created_at
+		FROM user_notes
+		WHERE steam_id = ? AND (? = '' OR category = ?)
+		ORDER BY id DESC`,
+		steamid, category, category,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := make([]schema.UserNote, 0)
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, *n)
+	}
+	return notes, rows.Err()
+}
+
+// GetUserNoteSummary counts the user's notes per category along with the newest note.
+func (d *sqliteDB) GetUserNoteSummary(steamid string) (*schema.NoteSummary, error) {
+	summary := &schema.NoteSummary{
+		Categories: make(map[string]int),
+	}
+
+	rows, err := d.db.Query(
+		`SELECT category, COUNT(*) FROM user_notes WHERE steam_id = ? GROUP BY category`, steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var category string
+		var count int
+		if err := rows.Scan(&category, &count); err != nil {
+			return nil, err
+		}
+		summary.Categories[category] = count
+		summary.Count += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if summary.Count > 0 {
+		summary.Latest, err = scanNote(d.db.QueryRow(`
+			SELECT id, steam_id, author, category, character_id, body, created_at
+			FROM user_notes
+			WHERE steam_id = ?
+			ORDER BY id DESC LIMIT 1`,
+			steamid,
+		))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return summary, nil
+}
+
+func scanNote(row rowScanner) (*schema.UserNote, error) {
+	var (
+		n schema.UserNote
+		charID sql.NullString
+	)
+	if err := row.Scan(&n.ID, &n.SteamID, &n.Author, &n.Category, &charID, &n.Body, &n.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if charID.Valid {
+		cid, _ := uuid.Parse(charID.String)
+		n.CharacterID = &cid
+	}
+	return &n, nil
+}
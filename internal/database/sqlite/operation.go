@@ -0,0 +1,240 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+// UndoCharacterOperation reverts the most recent operation on the character that
+// hasn't been undone yet. Operations are undone newest first, like a stack. admin is
+// recorded in the lineage of characters moved back.
+func (d *sqliteDB) UndoCharacterOperation(id uuid.UUID, expiration time.Duration, admin string) (*schema.CharacterOperation, error) {
+	var op schema.CharacterOperation
+	var dropped *pendingUpdate
+
+	err := d.exec(func(tx *sql.Tx) error {
+		var snapshotID sql.NullInt64
+		var steamID sql.NullString
+		var slot sql.NullInt64
+		err := tx.QueryRow(`
+			SELECT id, operation, snapshot_id, steam_id, slot, created_at
+			FROM character_operations
+			WHERE character_id = ? AND undone_at IS NULL
+			ORDER BY id DESC LIMIT 1`,
+			id.String(),
+		).Scan(&op.ID, &op.Operation, &snapshotID, &steamID, &slot, &op.CreatedAt)
+		if err == sql.ErrNoRows {
+			return database.ErrNoOperation
+		}
+		if err != nil {
+			return err
+		}
+
+		op.CharacterID = id
+		op.SnapshotID = snapshotID.Int64
+		op.SteamID = steamID.String
+		op.Slot = int(slot.Int64)
+
+		now := time.Now().UTC()
+		switch {
+		case op.Operation == "move":
+			if err := undoMove(tx, id, op.SteamID, op.Slot); err != nil {
+				return err
+			}
+			if err := recordLineage(tx, id, id, "undo", op.SteamID, op.Slot, admin); err != nil {
+				return err
+			}
+		case op.Operation == "rollback", op.Operation == "import" && op.SnapshotID != 0:
+			if upd, ok := d.dropPendingUpdate(id); ok {
+				dropped = &upd
+			}
+			if err := undoData(tx, id, op.SnapshotID); err != nil {
+				return err
+			}
+		default: // copy, restore, or an import that created the character
+			if err := softDeleteCharacter(tx, id, now, now.Add(expiration)); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(
+			`UPDATE character_operations SET undone_at = ? WHERE id = ?`,
+			now, op.ID,
+		)
+		op.UndoneAt = &now
+		return err
+	})
+	if err != nil {
+		if dropped != nil {
+			d.requeueUpdate(id, *dropped)
+		}
+		return nil, err
+	}
+
+	return &op, nil
+}
+
+// GetUndoOperation returns the operation UndoCharacterOperation would undo next.
+func (d *sqliteDB) GetUndoOperation(id uuid.UUID) (*schema.CharacterOperation, error) {
+	op := schema.CharacterOperation{CharacterID: id}
+
+	var snapshotID sql.NullInt64
+	var steamID sql.NullString
+	var slot sql.NullInt64
+	err := d.db.QueryRow(`
+		SELECT id, operation, snapshot_id, steam_id, slot, created_at
+		FROM character_operations
+		WHERE character_id = ? AND undone_at IS NULL
+		ORDER BY id DESC LIMIT 1`,
+		id.String(),
+	).Scan(&op.ID, &op.Operation, &snapshotID, &steamID, &slot, &op.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, database.ErrNoOperation
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	op.SnapshotID = snapshotID.Int64
+	op.SteamID = steamID.String
+	op.Slot = int(slot.Int64)
+	return &op, nil
+}
+
+// undoData puts the snapshot taken before the operation back as the current data,
+// the data being replaced is snapshotted in turn.
+func undoData(tx *sql.Tx, id uuid.UUID, snapshotID int64) error {
+	var createdAt time.Time
+	var size int
+	var payload string
+	err := tx.QueryRow(`
+		SELECT data_created_at, data_size, data_payload
+		FROM character_snapshots
+		WHERE id = ? AND character_id = ?`,
+		snapshotID, id.String(),
+	).Scan(&createdAt, &size, &payload)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("snapshot %d no longer exists", snapshotID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := snapshotCharacter(tx, id, "undo"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE characters
+		SET data_created_at = ?, data_size = ?, data_payload = ?
+		WHERE id = ?`,
+		createdAt, size, payload, id.String(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return characterChange(tx, database.ChangeCharacterRolledBack, id)
+}
+
+// undoMove moves the character back to its previous owner, moveCharacter refuses
+// when something has taken that slot since.
+func undoMove(tx *sql.Tx, id uuid.UUID, steamid string, slot int) error {
+	return moveCharacter(tx, id, steamid, slot)
+}
+
+// beginOperation journals the operation with the character's current owner so it can be
+// undone, rollbacks replace the data so theirs is snapshotted too. Call it before making
+// any changes.
+func beginOperation(tx *sql.Tx, id uuid.UUID, op string) error {
+	var steamID sql.NullString
+	var slot sql.NullInt64
+	err := tx.QueryRow(
+		`SELECT steam_id, slot FROM characters WHERE id = ?`, id.String(),
+	).Scan(&steamID, &slot)
+	if err == sql.ErrNoRows {
+		return database.ErrNoDocument
+	}
+	if err != nil {
+		return err
+	}
+
+	var snapID int64
+	if op == "rollback" {
+		if snapID, err = snapshotCharacter(tx, id, op); err != nil {
+			return err
+		}
+	}
+
+	return recordOperation(tx, id, op, snapID, steamID.String, int(slot.Int64))
+}
+
+// snapshotCharacter saves the character's current data before the operation replaces it.
+func snapshotCharacter(tx *sql.Tx, id uuid.UUID, op string) (int64, error) {
+	var createdAt time.Time
+	var size int
+	var payload string
+	err := tx.QueryRow(
+		`SELECT data_created_at, data_size, data_payload FROM characters WHERE id = ?`, id.String(),
+	).Scan(&createdAt, &size, &payload)
+	if err == sql.ErrNoRows {
+		return 0, database.ErrNoDocument
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return insertSnapshot(tx, id, op, createdAt, size, payload)
+}
+
+func insertSnapshot(tx *sql.Tx, id uuid.UUID, op string, createdAt time.Time, size int, data string) (int64, error) {
+	res, err := tx.Exec(`
+		INSERT INTO character_snapshots (character_id, operation, data_created_at, data_size, data_payload, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		id.String(), op, createdAt, size, data, time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// pruneSnapshots keeps the newest max snapshots of every character, along with any older
+// snapshot an operation that can still be undone needs.
+func pruneSnapshots(tx *sql.Tx, max int) error {
+	_, err := tx.Exec(`
+		DELETE FROM character_snapshots WHERE id IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (PARTITION BY character_id ORDER BY id DESC) AS n
+				FROM character_snapshots
+			) ranked
+			WHERE n > ? AND NOT EXISTS (
+				SELECT 1 FROM character_operations o
+				WHERE o.snapshot_id = ranked.id AND o.undone_at IS NULL
+			)
+		)`,
+		max,
+	)
+	return err
+}
+
+// recordOperation journals an operation, snapshotID is 0 when there was no
+// snapshot and steamid is empty when the character had no owner.
+func recordOperation(tx *sql.Tx, id uuid.UUID, op string, snapshotID int64, steamid string, slot int) error {
+	_, err := tx.Exec(`
+		INSERT INTO character_operations (character_id, operation, snapshot_id, steam_id, slot, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		id.String(), op,
+		sql.NullInt64{Int64: snapshotID, Valid: snapshotID != 0},
+		sql.NullString{String: steamid, Valid: steamid != ""},
+		sql.NullInt64{Int64: int64(slot), Valid: steamid != ""},
+		time.Now().UTC(),
+	)
+	return err
+}
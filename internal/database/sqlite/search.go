@@ -0,0 +1,170 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+// activeCharacters counts the characters of the user u that aren't deleted.
+const activeCharacters = `(SELECT COUNT(*) FROM characters c WHERE c.steam_id = u.id AND c.deleted_at IS NULL)`
+
+// SearchUsers returns the users matching the filter, ordered by SteamID.
+func (d *sqliteDB) SearchUsers(filter database.UserFilter) ([]schema.UserSummary, error) {
+	var (
+		where []string
+		args []interface{}
+	)
+	if filter.Flags != 0 {
+		where = append(where, "u.flags & ? = ?")
+		args = append(args, uint32(filter.Flags), uint32(filter.Flags))
+	}
+	if filter.WithoutFlags != 0 {
+		where = append(where, "u.flags & ? = 0")
+		args = append(args, uint32(filter.WithoutFlags))
+	}
+	if filter.CreatedSince != nil {
+		where = append(where, "u.created_at >= ?")
+		args = append(args, filter.CreatedSince.UTC())
+	}
+	if filter.CreatedUntil != nil {
+		where = append(where, "u.created_at < ?")
+		args = append(args, filter.CreatedUntil.UTC())
+	}
+	if filter.MinCharacters != nil {
+		where = append(where, activeCharacters+" >= ?")
+		args = append(args, *filter.MinCharacters)
+	}
+	if filter.MaxCharacters != nil {
+		where = append(where, activeCharacters+" <= ?")
+		args = append(args, *filter.MaxCharacters)
+	}
+	if filter.After != "" {
+		where = append(where, "u.id > ?")
+		args = append(args, filter.After)
+	}
+
+	query := `
+		SELECT u.id, u.flags, u.created_at, COALESCE(u.merged_into, ''), ` + activeCharacters + `
+		FROM users u`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY u.id ASC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]schema.UserSummary, 0)
+	for rows.Next() {
+		var u schema.UserSummary
+		if err := rows.Scan(&u.ID, &u.Flags, &u.CreatedAt, &u.MergedInto, &u.Characters); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SearchCharacters returns the characters matching the filter, ordered by ID. Soft deleted
+// characters are listed under the slot they were deleted from. Saves that are still
+// waiting to be flushed aren't taken into account.
+func (d *sqliteDB) SearchCharacters(filter database.CharacterFilter) ([]schema.CharacterSummary, error) {
+	var (
+		where []string
+		args []interface{}
+	)
+	if filter.SteamID != "" {
+		where = append(where, "COALESCE(c.steam_id, dc.steam_id) = ?")
+		args = append(args, filter.SteamID)
+	}
+	if filter.ModifiedSince != nil {
+		where = append(where, "c.data_created_at >= ?")
+		args = append(args, filter.ModifiedSince.UTC())
+	}
+	if filter.ModifiedUntil != nil {
+		where = append(where, "c.data_created_at < ?")
+		args = append(args, filter.ModifiedUntil.UTC())
+	}
+	if filter.MinSize != nil {
+		where = append(where, "c.data_size >= ?")
+		args = append(args, *filter.MinSize)
+	}
+	if filter.MaxSize != nil {
+		where = append(where, "c.data_size <= ?")
+		args = append(args, *filter.MaxSize)
+	}
+	if filter.Deleted != nil {
+		if *filter.Deleted {
+			where = append(where, "c.deleted_at IS NOT NULL")
+		} else {
+			where = append(where, "c.deleted_at IS NULL")
+		}
+	}
+	if filter.After != nil {
+		where = append(where, "c.id > ?")
+		args = append(args, filter.After.String())
+	}
+
+	query := `
+		SELECT c.id, COALESCE(c.steam_id, dc.steam_id, ''), COALESCE(c.slot, dc.slot, 0), c.created_at,
+			c.data_created_at, c.data_size, c.data_server, c.deleted_at, c.expires_at
+		FROM characters c
+		LEFT JOIN deleted_characters dc ON dc.character_id = c.id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY c.id ASC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chars := make([]schema.CharacterSummary, 0)
+	for rows.Next() {
+		c, err := scanCharacterSummary(rows)
+		if err != nil {
+			return nil, err
+		}
+		chars = append(chars, *c)
+	}
+	return chars, rows.Err()
+}
+
+// scanCharacterSummary scans the columns selected by SearchCharacters.
+func scanCharacterSummary(row rowScanner) (*schema.CharacterSummary, error) {
+	var (
+		c schema.CharacterSummary
+		idStr string
+		modifiedAt, deletedAt, expiresAt sql.NullTime
+	)
+	err := row.Scan(
+		&idStr, &c.SteamID, &c.Slot, &c.CreatedAt, &modifiedAt,
+		&c.Size, &c.Server, &deletedAt, &expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.ID, _ = uuid.Parse(idStr)
+	if modifiedAt.Valid {
+		c.ModifiedAt = &modifiedAt.Time
+	}
+	if deletedAt.Valid {
+		c.DeletedAt = &deletedAt.Time
+	}
+	if expiresAt.Valid {
+		c.ExpiresAt = &expiresAt.Time
+	}
+	return &c, nil
+}
@@ -26,6 +26,7 @@ type writeOp struct {
 type pendingUpdate struct {
 	size       int
 	data       string
+	server     string
 	backupMax  int
 	backupTime time.Duration
 }
@@ -43,11 +44,17 @@ type sqliteDB struct {
 	// pendingUpdates is the coalescing map. When UpdateCharacter is called,
 	// we just overwrite the entry for that character ID. On each flush tick,
 	// all pending entries are committed in a single transaction.
-	coalesceMu     sync.Mutex
+	// The lock is shared with the copies made by DryRun.
+	coalesceMu     *sync.Mutex
 	pendingUpdates map[uuid.UUID]pendingUpdate
 
 	done chan struct{}
-	wg   sync.WaitGroup
+	wg   *sync.WaitGroup
+
+	// dryRun rolls back every write made through this copy, see DryRun.
+	dryRun bool
+	// event is queued with every write made through this copy, see WithEvent.
+	event *database.Event
 
 	database.Options
 }
@@ -56,11 +63,37 @@ func New() *sqliteDB {
 	return &sqliteDB{
 		writeCh:        make(chan writeOp, 512),
 		flushInterval:  500 * time.Millisecond,
+		coalesceMu:     &sync.Mutex{},
 		pendingUpdates: make(map[uuid.UUID]pendingUpdate),
 		done:           make(chan struct{}),
+		wg:             &sync.WaitGroup{},
 	}
 }
 
+// DryRun returns a copy of the database whose writes run in full and are then rolled
+// back, so they fail exactly as they would for real without changing anything.
+func (d *sqliteDB) DryRun() database.Database {
+	dry := *d
+	dry.dryRun = true
+	return &dry
+}
+
+// WithEvent returns a copy of the database that queues the event's webhook deliveries in
+// the transaction of each write made through it.
+func (d *sqliteDB) WithEvent(event database.Event) database.Database {
+	view := *d
+	view.event = &event
+	return &view
+}
+
+// Quiet returns a copy of the database without an outbox, so no webhook deliveries are
+// queued for the writes made through it.
+func (d *sqliteDB) Quiet() database.Database {
+	view := *d
+	view.Outbox = nil
+	return &view
+}
+
 func (d *sqliteDB) Connect(cfg database.Config, opts database.Options) error {
 	// Ensure all parent directories exist before opening the SQLite file.
 	if dir := filepath.Dir(cfg.SQLite.Path); dir != "" {
@@ -89,7 +122,7 @@ func (d *sqliteDB) Connect(cfg database.Config, opts database.Options) error {
 	}
 
 	d.db = db
-	d.Logger = opts.Logger
+	d.Options = opts
 
 	d.wg.Add(2)
 	go d.writeWorker()
@@ -112,13 +145,35 @@ func (d *sqliteDB) SyncToDisk() error {
 }
 
 // RunGC flushes pending updates and then purges any soft-deleted characters
-// whose expiration timestamp has passed.
+// whose expiration timestamp has passed, and prunes snapshots over the cap.
 func (d *sqliteDB) RunGC() error {
+	if err := d.flushPendingUpdates(); err != nil {
+		return err
+	}
+
 	return d.exec(func(tx *sql.Tx) error {
-		_, err := tx.Exec(
+		if _, err := tx.Exec(`
+			INSERT INTO changes (kind, character_id, steam_id, slot, created_at)
+			SELECT ?, c.id, COALESCE(dc.steam_id, ''), dc.slot, ?
+			FROM characters c
+			LEFT JOIN deleted_characters dc ON dc.character_id = c.id
+			WHERE c.expires_at IS NOT NULL AND c.expires_at <= datetime('now')
+			ORDER BY c.expires_at`,
+			database.ChangeCharacterPurged, time.Now().UTC(),
+		); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(
 			`DELETE FROM characters WHERE expires_at IS NOT NULL AND expires_at <= datetime('now')`,
-		)
-		return err
+		); err != nil {
+			return err
+		}
+
+		if d.MaxSnapshots > 0 {
+			return pruneSnapshots(tx, d.MaxSnapshots)
+		}
+		return nil
 	})
 }
 
@@ -126,9 +181,32 @@ func (d *sqliteDB) RunGC() error {
 // function into a writeOp, ships it to the single writer goroutine, and
 // blocks until the result comes back.
 func (d *sqliteDB) exec(fn func(tx *sql.Tx) error) error {
+	if d.event != nil {
+		event, write := *d.event, fn
+		fn = func(tx *sql.Tx) error {
+			if err := write(tx); err != nil {
+				return err
+			}
+			return d.queueEvent(tx, event)
+		}
+	}
+	if d.dryRun {
+		write := fn
+		fn = func(tx *sql.Tx) error {
+			if err := write(tx); err != nil {
+				return err
+			}
+			return database.ErrDryRun
+		}
+	}
+
 	resp := make(chan error, 1)
 	d.writeCh <- writeOp{fn: fn, resp: resp}
-	return <-resp
+	err := <-resp
+	if d.dryRun && err == database.ErrDryRun {
+		return nil
+	}
+	return err
 }
 
 // writeWorker is the ONLY goroutine that opens transactions and writes to
@@ -198,16 +276,20 @@ func (d *sqliteDB) flushWorker() error {
 // database write.
 func (d *sqliteDB) flushPendingUpdates() error {
 	d.coalesceMu.Lock()
-	if len(d.pendingUpdates) == 0 {
-		d.coalesceMu.Unlock()
+	empty := len(d.pendingUpdates) == 0
+	d.coalesceMu.Unlock()
+	if empty {
 		return nil
 	}
-	// Swap out the map so callers can keep writing while we flush.
-	snapshot := d.pendingUpdates
-	d.pendingUpdates = make(map[uuid.UUID]pendingUpdate)
-	d.coalesceMu.Unlock()
 
 	return d.exec(func(tx *sql.Tx) error {
+		// Swap out the map so callers can keep writing while we flush. It's swapped on the
+		// write goroutine so a write that drops a pending update can't race the flush.
+		d.coalesceMu.Lock()
+		snapshot := d.pendingUpdates
+		d.pendingUpdates = make(map[uuid.UUID]pendingUpdate)
+		d.coalesceMu.Unlock()
+
 		for id, upd := range snapshot {
 			if err := applyCharacterUpdate(tx, id, upd); err != nil {
 				return fmt.Errorf("flush update for %s: %w", id, err)
@@ -217,16 +299,68 @@ func (d *sqliteDB) flushPendingUpdates() error {
 	})
 }
 
+// dropPendingUpdate discards the character's coalesced update so it can't overwrite data
+// that was just replaced outright. Call it from the write's transaction, put the update
+// back with requeueUpdate if the write fails.
+func (d *sqliteDB) dropPendingUpdate(id uuid.UUID) (pendingUpdate, bool) {
+	if d.dryRun {
+		return pendingUpdate{}, false
+	}
+
+	d.coalesceMu.Lock()
+	defer d.coalesceMu.Unlock()
+	upd, ok := d.pendingUpdates[id]
+	delete(d.pendingUpdates, id)
+	return upd, ok
+}
+
+// requeueUpdate puts back an update dropped by a write that failed, unless a newer
+// one came in since.
+func (d *sqliteDB) requeueUpdate(id uuid.UUID, upd pendingUpdate) {
+	d.coalesceMu.Lock()
+	defer d.coalesceMu.Unlock()
+	if _, exists := d.pendingUpdates[id]; !exists {
+		d.pendingUpdates[id] = upd
+	}
+}
+
+// replaceData runs fn, a write that replaces the data of the characters outright, and
+// drops their pending updates in the same transaction. They're put back if it fails.
+func (d *sqliteDB) replaceData(ids []uuid.UUID, fn func(tx *sql.Tx) error) error {
+	dropped := make(map[uuid.UUID]pendingUpdate)
+	err := d.exec(func(tx *sql.Tx) error {
+		for _, id := range ids {
+			if upd, ok := d.dropPendingUpdate(id); ok {
+				dropped[id] = upd
+			}
+		}
+		return fn(tx)
+	})
+	if err != nil {
+		for id, upd := range dropped {
+			d.requeueUpdate(id, upd)
+		}
+	}
+	return err
+}
+
+// rowScanner is a *sql.Row or *sql.Rows, so one scan function reads both.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
 // migrate creates the schema on first run. Queries are idempotent (IF NOT EXISTS).
 // When moving to Postgres: swap TEXT for UUID, DATETIME for TIMESTAMPTZ,
 // AUTOINCREMENT for GENERATED ALWAYS AS IDENTITY, and ? for $N placeholders.
 func migrate(db *sql.DB) error {
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS users (
-			id         TEXT PRIMARY KEY,
-			revision   INTEGER NOT NULL DEFAULT 0,
-			flags      INTEGER NOT NULL DEFAULT 0,
-			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			id          TEXT PRIMARY KEY,
+			revision    INTEGER NOT NULL DEFAULT 0,
+			flags       INTEGER NOT NULL DEFAULT 0,
+			created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			merged_into TEXT,          -- set when the account was merged into another
+			merged_at   DATETIME
 		);
 
 		CREATE TABLE IF NOT EXISTS characters (
@@ -238,7 +372,8 @@ func migrate(db *sql.DB) error {
 			expires_at      DATETIME,      -- populated on soft-delete for GC
 			data_created_at DATETIME,
 			data_size       INTEGER NOT NULL DEFAULT 0,
-			data_payload    TEXT NOT NULL DEFAULT ''
+			data_payload    TEXT NOT NULL DEFAULT '',
+			data_server     TEXT NOT NULL DEFAULT ''
 		);
 
 		CREATE TABLE IF NOT EXISTS deleted_characters (
@@ -257,11 +392,238 @@ func migrate(db *sql.DB) error {
 			character_id TEXT NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
 			created_at   DATETIME NOT NULL,
 			size         INTEGER NOT NULL,
-			data_payload TEXT NOT NULL
+			data_payload TEXT NOT NULL,
+			tag          TEXT NOT NULL DEFAULT '',
+			note         TEXT NOT NULL DEFAULT '',
+			server       TEXT NOT NULL DEFAULT ''
+		);
+
+		-- Data saved before an admin operation replaced it, kept apart from the versions
+		-- so rollbacks never pick one and the version cap never prunes one an undo needs.
+		CREATE TABLE IF NOT EXISTS character_snapshots (
+			id              INTEGER PRIMARY KEY AUTOINCREMENT,
+			character_id    TEXT NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
+			operation       TEXT NOT NULL, -- the operation it was taken before
+			data_created_at DATETIME NOT NULL,
+			data_size       INTEGER NOT NULL,
+			data_payload    TEXT NOT NULL,
+			created_at      DATETIME NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS character_operations (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			character_id TEXT NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
+			operation    TEXT NOT NULL,
+			snapshot_id  INTEGER,
+			steam_id     TEXT,
+			slot         INTEGER,
+			created_at   DATETIME NOT NULL,
+			undone_at    DATETIME
+		);
+
+		CREATE TABLE IF NOT EXISTS operation_journal (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			operation    TEXT NOT NULL,
+			character_id TEXT NOT NULL, -- no foreign key, entries outlive deleted characters
+			target_id    TEXT,
+			to_steam_id  TEXT,
+			to_slot      INTEGER,
+			before_state TEXT NOT NULL,
+			created_at   DATETIME NOT NULL,
+			reverted_at  DATETIME
+		);
+
+		CREATE TABLE IF NOT EXISTS character_lineage (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			character_id TEXT NOT NULL, -- no foreign keys, lineage outlives deleted characters
+			source_id    TEXT,
+			operation    TEXT NOT NULL,
+			steam_id     TEXT NOT NULL,
+			slot         INTEGER NOT NULL,
+			admin        TEXT NOT NULL DEFAULT '',
+			created_at   DATETIME NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS bans (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			steam_id    TEXT NOT NULL REFERENCES users(id),
+			reason      TEXT NOT NULL DEFAULT '',
+			admin       TEXT NOT NULL DEFAULT '',
+			evidence    TEXT NOT NULL DEFAULT '',
+			created_at  DATETIME NOT NULL,
+			expires_at  DATETIME, -- NULL for permanent bans
+			lifted_at   DATETIME,
+			lifted_by   TEXT NOT NULL DEFAULT '',
+			lift_reason TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE TABLE IF NOT EXISTS flag_grants (
+			steam_id   TEXT NOT NULL REFERENCES users(id),
+			bit        INTEGER NOT NULL,
+			source     TEXT NOT NULL DEFAULT '',
+			admin      TEXT NOT NULL DEFAULT '',
+			granted_at DATETIME NOT NULL,
+			expires_at DATETIME, -- NULL for grants that don't expire
+			PRIMARY KEY (steam_id, bit)
+		);
+
+		CREATE TABLE IF NOT EXISTS user_notes (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			steam_id     TEXT NOT NULL, -- no foreign keys, notes can be left before the user first joins
+			author       TEXT NOT NULL DEFAULT '',
+			category     TEXT NOT NULL,
+			character_id TEXT,
+			body         TEXT NOT NULL,
+			created_at   DATETIME NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at   DATETIME NOT NULL,
+			ip           TEXT NOT NULL,
+			key_id       TEXT NOT NULL DEFAULT '',
+			method       TEXT NOT NULL,
+			endpoint     TEXT NOT NULL,
+			path         TEXT NOT NULL,
+			status       INTEGER NOT NULL,
+			steam_id     TEXT NOT NULL DEFAULT '',
+			character_id TEXT,
+			reason       TEXT NOT NULL DEFAULT '',
+			before_state TEXT,
+			after_state  TEXT,
+			prev_hash    TEXT NOT NULL,
+			hash         TEXT NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS approvals (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			method        TEXT NOT NULL,
+			path          TEXT NOT NULL,
+			endpoint      TEXT NOT NULL,
+			body          TEXT NOT NULL DEFAULT '',
+			reason        TEXT NOT NULL,
+			requested_by  TEXT NOT NULL,
+			requested_ip  TEXT NOT NULL,
+			status        TEXT NOT NULL,
+			created_at    DATETIME NOT NULL,
+			expires_at    DATETIME NOT NULL,
+			decided_by    TEXT NOT NULL DEFAULT '',
+			decided_ip    TEXT NOT NULL DEFAULT '',
+			decided_at    DATETIME,
+			result_status INTEGER NOT NULL DEFAULT 0,
+			result        TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE TABLE IF NOT EXISTS jobs (
+			id            TEXT PRIMARY KEY,
+			kind          TEXT NOT NULL,
+			status        TEXT NOT NULL,
+			params        TEXT NOT NULL DEFAULT '',
+			progress      INTEGER NOT NULL DEFAULT 0,
+			total         INTEGER NOT NULL DEFAULT 0,
+			result        TEXT NOT NULL DEFAULT '',
+			error         TEXT NOT NULL DEFAULT '',
+			requested_by  TEXT NOT NULL,
+			created_at    DATETIME NOT NULL,
+			started_at    DATETIME,
+			finished_at   DATETIME
+		);
+
+		CREATE TABLE IF NOT EXISTS changes (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			kind         TEXT NOT NULL,
+			character_id TEXT,          -- no foreign key, changes outlive purged characters
+			source_id    TEXT,          -- the character a copy was made from
+			steam_id     TEXT NOT NULL DEFAULT '',
+			slot         INTEGER,
+			flags        INTEGER,       -- the user's flags after a flag change
+			created_at   DATETIME NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id            INTEGER PRIMARY KEY AUTOINCREMENT,
+			endpoint      TEXT NOT NULL, -- name of the endpoint in the config
+			event         TEXT NOT NULL,
+			body          TEXT NOT NULL, -- sent as is on every attempt
+			status        TEXT NOT NULL, -- pending, delivered or failed
+			attempts      INTEGER NOT NULL DEFAULT 0,
+			next_attempt  DATETIME,      -- when a pending delivery is tried next
+			response_code INTEGER NOT NULL DEFAULT 0,
+			error         TEXT NOT NULL DEFAULT '',
+			created_at    DATETIME NOT NULL,
+			finished_at   DATETIME
 		);
 
 		CREATE INDEX IF NOT EXISTS idx_chars_steam_id   ON characters(steam_id);
 		CREATE INDEX IF NOT EXISTS idx_charver_char_id  ON character_versions(character_id);
+		CREATE INDEX IF NOT EXISTS idx_charsnap_char_id ON character_snapshots(character_id);
+		CREATE INDEX IF NOT EXISTS idx_charops_char_id  ON character_operations(character_id);
+		CREATE INDEX IF NOT EXISTS idx_journal_created  ON operation_journal(created_at);
+		CREATE INDEX IF NOT EXISTS idx_lineage_char_id  ON character_lineage(character_id);
+		CREATE INDEX IF NOT EXISTS idx_lineage_src_id   ON character_lineage(source_id);
+		CREATE INDEX IF NOT EXISTS idx_bans_steam_id    ON bans(steam_id);
+		CREATE INDEX IF NOT EXISTS idx_grants_expires  ON flag_grants(expires_at);
+		CREATE INDEX IF NOT EXISTS idx_notes_steam_id   ON user_notes(steam_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_steam_id   ON audit_log(steam_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_created    ON audit_log(created_at);
+		CREATE INDEX IF NOT EXISTS idx_approvals_status ON approvals(status);
+		CREATE INDEX IF NOT EXISTS idx_jobs_status      ON jobs(status);
+		CREATE INDEX IF NOT EXISTS idx_changes_created  ON changes(created_at);
+		CREATE INDEX IF NOT EXISTS idx_webhooks_status  ON webhook_deliveries(status, next_attempt);
 	`)
+	if err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema, existing databases need them added.
+	columns := []struct{ table, column, def string }{
+		{"character_versions", "tag", "TEXT NOT NULL DEFAULT ''"},
+		{"character_versions", "note", "TEXT NOT NULL DEFAULT ''"},
+		{"character_versions", "server", "TEXT NOT NULL DEFAULT ''"},
+		{"characters", "data_server", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "merged_into", "TEXT"},
+		{"users", "merged_at", "DATETIME"},
+		{"character_operations", "snapshot_id", "INTEGER"},
+		{"operation_journal", "to_steam_id", "TEXT"},
+		{"operation_journal", "to_slot", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.column, c.def); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	return nil
+}
+
+// addColumn adds a column to an existing table if it's missing, SQLite has no
+// ADD COLUMN IF NOT EXISTS so we have to check the table info ourselves.
+func addColumn(db *sql.DB, table, column, def string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid int
+			name, ctype string
+			notNull, pk int
+			dflt sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, def))
 	return err
 }
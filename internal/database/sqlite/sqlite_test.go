@@ -1,14 +1,16 @@
 package sqlite
 
 import (
+	"database/sql"
 	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/internal/database"
-	//"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/database/schema"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,11 +20,17 @@ import (
 // Using a unique URI per test prevents cross-test contamination while
 // still exercising the real schema migration and write worker.
 func newTestDB(t *testing.T) *sqliteDB {
+	t.Helper()
+	return newTestDBWith(t, database.Options{})
+}
+
+// newTestDBWith is newTestDB connecting with opts.
+func newTestDBWith(t *testing.T, opts database.Options) *sqliteDB {
 	t.Helper()
 	db := New()
 	cfg := database.Config{}
 	cfg.SQLite.Path = ":memory:"
-	require.NoError(t, db.Connect(cfg, database.Options{}))
+	require.NoError(t, db.Connect(cfg, opts))
 	t.Cleanup(func() { _ = db.Disconnect() })
 	return db
 }
@@ -44,11 +52,20 @@ func seedCharacter(t *testing.T, db *sqliteDB, steamid string, slot, size int, d
 	return id
 }
 
-// flush waits long enough for the coalescing flush worker to commit any
-// pending UpdateCharacter calls (default interval is 500 ms).
+// flush commits any pending UpdateCharacter calls without waiting for the
+// coalescing flush worker (default interval is 500 ms).
 func flush(t *testing.T, db *sqliteDB) {
 	t.Helper()
-	require.NoError(t, db.RunGC()) // RunGC always calls flushPendingUpdates
+	require.NoError(t, db.flushPendingUpdates())
+}
+
+// versionID returns the ID of the version at index idx (oldest first).
+func versionID(t *testing.T, db *sqliteDB, id uuid.UUID, idx int) int64 {
+	t.Helper()
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	require.Greater(t, len(c.Versions), idx)
+	return c.Versions[idx].ID
 }
 
 // ─── Connect / Disconnect ────────────────────────────────────────────────────
@@ -158,6 +175,364 @@ func TestSetUserFlags_Overwrite(t *testing.T) {
 	assert.Equal(t, bitmask.Bitmask(0x01), flags)
 }
 
+func TestNewUser(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.NewUser("steam1"))
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.Zero(t, flags)
+
+	// Existing users are left alone.
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.ADMIN))
+	require.NoError(t, db.NewUser("steam1"))
+	flags, err = db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.Equal(t, bitmask.ADMIN, flags)
+}
+
+func TestGetUsersWithFlag(t *testing.T) {
+	db := newTestDB(t)
+	for _, steamid := range []string{"steam1", "steam2", "steam3"} {
+		require.NoError(t, db.NewUser(steamid))
+	}
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.ADMIN|bitmask.DONOR))
+	require.NoError(t, db.SetUserFlags("steam2", bitmask.BANNED))
+	require.NoError(t, db.SetUserFlags("steam3", bitmask.ADMIN))
+
+	admins, err := db.GetUsersWithFlag(bitmask.ADMIN)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"steam1", "steam3"}, admins)
+
+	donors, err := db.GetUsersWithFlag(bitmask.DONOR)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"steam1"}, donors)
+
+	require.NoError(t, db.SetUserFlags("steam2", 0))
+	banned, err := db.GetUsersWithFlag(bitmask.BANNED)
+	require.NoError(t, err)
+	assert.Empty(t, banned)
+}
+
+// ─── MergeUsers ──────────────────────────────────────────────────────────────
+
+func TestMergeUsers_MovesCharacters(t *testing.T) {
+	db := newTestDB(t)
+	id0 := seedCharacter(t, db, "old", 0, 1, "a")
+	id2 := seedCharacter(t, db, "old", 2, 1, "b")
+	require.NoError(t, db.NewUser("new"))
+
+	res, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeFail})
+	require.NoError(t, err)
+	assert.Len(t, res.Characters, 2)
+
+	got, err := db.LookUpCharacterID("new", 0)
+	require.NoError(t, err)
+	assert.Equal(t, id0, got)
+
+	got, err = db.LookUpCharacterID("new", 2)
+	require.NoError(t, err)
+	assert.Equal(t, id2, got)
+
+	u, err := db.GetUser("old")
+	require.NoError(t, err)
+	assert.Equal(t, "new", u.MergedInto)
+	assert.Empty(t, u.Characters)
+}
+
+func TestMergeUsers_UnknownTarget(t *testing.T) {
+	db := newTestDB(t)
+	seedCharacter(t, db, "old", 0, 1, "a")
+
+	_, err := db.MergeUsers("old", "typo", database.MergeOptions{Policy: database.MergeFail})
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+
+	_, err = db.GetUser("typo")
+	assert.ErrorIs(t, err, database.ErrNoDocument, "the account isn't created")
+}
+
+func TestMergeUsers_JournalsEachMove(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "old", 1, 1, "a")
+	require.NoError(t, db.NewUser("new"))
+
+	_, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeFail})
+	require.NoError(t, err)
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "move", entries[0].Operation)
+	assert.Equal(t, id, entries[0].CharacterID)
+
+	_, err = db.RevertJournalEntry(entries[0].ID, time.Hour, "")
+	require.NoError(t, err)
+
+	got, err := db.LookUpCharacterID("old", 1)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+}
+
+func TestMergeUsers_MaxSlots(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "old", 4, 1, "a")
+	seedCharacter(t, db, "new", 0, 1, "b")
+
+	res, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeFail, MaxSlots: 2})
+	require.NoError(t, err)
+	require.Len(t, res.Characters, 1)
+	assert.Equal(t, 1, res.Characters[0].ToSlot, "a slot past MaxSlots goes in a free one")
+
+	got, err := db.LookUpCharacterID("new", 1)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+
+	seedCharacter(t, db, "full", 5, 1, "c")
+	_, err = db.MergeUsers("full", "new", database.MergeOptions{Policy: database.MergeFail, MaxSlots: 2})
+	assert.ErrorIs(t, err, database.ErrNoFreeSlot)
+}
+
+func TestMergeUsers_NextSlot(t *testing.T) {
+	db := newTestDB(t)
+	oldID := seedCharacter(t, db, "old", 0, 1, "a")
+	newID := seedCharacter(t, db, "new", 0, 1, "b")
+	seedCharacter(t, db, "new", 1, 1, "c")
+
+	res, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeNextSlot, MaxSlots: 3})
+	require.NoError(t, err)
+	require.Len(t, res.Characters, 1)
+	assert.Equal(t, 2, res.Characters[0].ToSlot)
+
+	got, err := db.LookUpCharacterID("new", 0)
+	require.NoError(t, err)
+	assert.Equal(t, newID, got)
+
+	got, err = db.LookUpCharacterID("new", 2)
+	require.NoError(t, err)
+	assert.Equal(t, oldID, got)
+}
+
+func TestMergeUsers_NoFreeSlot(t *testing.T) {
+	db := newTestDB(t)
+	seedCharacter(t, db, "old", 0, 1, "a")
+	seedCharacter(t, db, "new", 0, 1, "b")
+
+	_, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeNextSlot, MaxSlots: 1})
+	assert.ErrorIs(t, err, database.ErrNoFreeSlot)
+}
+
+func TestMergeUsers_FailLeavesAccountsUntouched(t *testing.T) {
+	db := newTestDB(t)
+	oldID := seedCharacter(t, db, "old", 0, 1, "a")
+	seedCharacter(t, db, "old", 1, 1, "b")
+	newID := seedCharacter(t, db, "new", 1, 1, "c")
+
+	_, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeFail})
+	var occupied *database.SlotOccupiedError
+	require.ErrorAs(t, err, &occupied)
+	assert.Equal(t, newID, occupied.CharacterID)
+
+	// The whole merge is rolled back, including the slot that was free.
+	got, err := db.LookUpCharacterID("old", 0)
+	require.NoError(t, err)
+	assert.Equal(t, oldID, got)
+
+	u, err := db.GetUser("old")
+	require.NoError(t, err)
+	assert.Empty(t, u.MergedInto)
+}
+
+func TestMergeUsers_Replace(t *testing.T) {
+	db := newTestDB(t)
+	oldID := seedCharacter(t, db, "old", 0, 1, "a")
+	newID := seedCharacter(t, db, "new", 0, 1, "b")
+
+	res, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeReplace, Expiration: time.Hour})
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{newID}, res.Replaced)
+
+	got, err := db.LookUpCharacterID("new", 0)
+	require.NoError(t, err)
+	assert.Equal(t, oldID, got)
+
+	c, err := db.GetCharacter(newID)
+	require.NoError(t, err)
+	assert.NotNil(t, c.DeletedAt)
+}
+
+func TestMergeUsers_CarriesDeletedCharacters(t *testing.T) {
+	db := newTestDB(t)
+	oldDel := seedCharacter(t, db, "old", 0, 1, "a")
+	require.NoError(t, db.SoftDeleteCharacter(oldDel, time.Hour))
+	newDel := seedCharacter(t, db, "new", 0, 1, "b")
+	require.NoError(t, db.SoftDeleteCharacter(newDel, time.Hour))
+
+	res, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeFail})
+	require.NoError(t, err)
+	require.Len(t, res.DeletedCharacters, 1)
+	assert.Equal(t, 1, res.DeletedCharacters[0].ToSlot)
+
+	u, err := db.GetUser("new")
+	require.NoError(t, err)
+	assert.Equal(t, newDel, u.DeletedCharacters[0])
+	assert.Equal(t, oldDel, u.DeletedCharacters[1])
+
+	require.NoError(t, db.RestoreCharacter(oldDel, nil, ""))
+	got, err := db.LookUpCharacterID("new", 1)
+	require.NoError(t, err)
+	assert.Equal(t, oldDel, got)
+}
+
+func TestMergeUsers_Flags(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "old")
+	seedUser(t, db, "new")
+	require.NoError(t, db.SetUserFlags("old", bitmask.BANNED|bitmask.ADMIN))
+	require.NoError(t, db.SetUserFlags("new", bitmask.DONOR))
+
+	// Both accounts have a character in slot 0 from seedUser.
+	_, err := db.MergeUsers("old", "new", database.MergeOptions{
+		Policy: database.MergeNextSlot,
+		Flags: func(from bitmask.Bitmask, to bitmask.Bitmask) bitmask.Bitmask {
+			return (from | to) &^ bitmask.ADMIN
+		},
+	})
+	require.NoError(t, err)
+
+	flags, err := db.GetUserFlags("new")
+	require.NoError(t, err)
+	assert.Equal(t, bitmask.BANNED|bitmask.DONOR, flags)
+}
+
+func TestMergeUsers_MovesGrants(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.NewUser("old"))
+	require.NoError(t, db.NewUser("new"))
+	now := time.Now().UTC()
+	soon, later := now.Add(time.Hour), now.Add(48*time.Hour)
+
+	// DONOR only old has for a while, ADMIN both have and new has for longer.
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "old", Bit: 1, Source: "payment1", GrantedAt: now, ExpiresAt: &soon}))
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "old", Bit: 2, GrantedAt: now, ExpiresAt: &soon}))
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "new", Bit: 2, GrantedAt: now, ExpiresAt: &later}))
+
+	_, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeNextSlot})
+	require.NoError(t, err)
+
+	grants, err := db.GetFlagGrants("new")
+	require.NoError(t, err)
+	require.Len(t, grants, 2)
+	assert.Equal(t, "payment1", grants[0].Source)
+	assert.WithinDuration(t, soon, *grants[0].ExpiresAt, time.Second)
+	assert.WithinDuration(t, later, *grants[1].ExpiresAt, time.Second)
+
+	// The donor grant still runs out on the merged account.
+	_, err = db.ExpireFlagGrants(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	flags, err := db.GetUserFlags("new")
+	require.NoError(t, err)
+	assert.False(t, flags.HasFlag(bitmask.DONOR))
+	assert.True(t, flags.HasFlag(bitmask.ADMIN))
+}
+
+func TestMergeUsers_PermanentFlagDropsGrant(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.NewUser("old"))
+	require.NoError(t, db.NewUser("new"))
+	soon := time.Now().UTC().Add(time.Hour)
+
+	require.NoError(t, db.SetUserFlags("old", bitmask.DONOR))
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "new", Bit: 1, GrantedAt: time.Now().UTC(), ExpiresAt: &soon}))
+
+	_, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeNextSlot})
+	require.NoError(t, err)
+
+	grants, err := db.GetFlagGrants("new")
+	require.NoError(t, err)
+	assert.Empty(t, grants)
+}
+
+func TestMergeUsers_MovesActiveBans(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.NewUser("new"))
+	now := time.Now().UTC()
+	expires := now.Add(time.Hour)
+
+	_, err := db.AddBan(&schema.Ban{SteamID: "old", Reason: "cheating", CreatedAt: now, ExpiresAt: &expires})
+	require.NoError(t, err)
+
+	_, err = db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeNextSlot})
+	require.NoError(t, err)
+
+	ban, err := db.GetActiveBan("new")
+	require.NoError(t, err)
+	assert.Equal(t, "cheating", ban.Reason)
+
+	// The ban still expires on the merged account.
+	n, err := db.ExpireBans(now.Add(2 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	flags, err := db.GetUserFlags("new")
+	require.NoError(t, err)
+	assert.False(t, flags.HasFlag(bitmask.BANNED))
+}
+
+func TestMergeUsers_LiftsBansOfDroppedFlag(t *testing.T) {
+	db := newTestDB(t)
+	require.NoError(t, db.NewUser("old"))
+	_, err := db.AddBan(&schema.Ban{SteamID: "new", Reason: "cheating", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+
+	_, err = db.MergeUsers("old", "new", database.MergeOptions{
+		Policy: database.MergeNextSlot,
+		Flags: func(from bitmask.Bitmask, to bitmask.Bitmask) bitmask.Bitmask {
+			return (from | to) &^ bitmask.BANNED
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = db.GetActiveBan("new")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestMergeUsers_AlreadyMerged(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "old")
+	require.NoError(t, db.NewUser("new"))
+
+	_, err := db.MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeFail})
+	require.NoError(t, err)
+
+	_, err = db.MergeUsers("old", "other", database.MergeOptions{Policy: database.MergeFail})
+	assert.ErrorIs(t, err, database.ErrAlreadyMerged)
+
+	// Nothing can be merged into a tombstone either.
+	seedUser(t, db, "other")
+	_, err = db.MergeUsers("other", "old", database.MergeOptions{Policy: database.MergeFail})
+	assert.ErrorIs(t, err, database.ErrAlreadyMerged)
+}
+
+func TestMergeUsers_DryRun(t *testing.T) {
+	db := newTestDB(t)
+	oldID := seedCharacter(t, db, "old", 0, 1, "a")
+	seedCharacter(t, db, "new", 0, 1, "b")
+
+	res, err := db.DryRun().MergeUsers("old", "new", database.MergeOptions{Policy: database.MergeNextSlot, MaxSlots: 3})
+	require.NoError(t, err)
+	require.Len(t, res.Characters, 1)
+	assert.Equal(t, oldID, res.Characters[0].ID)
+	assert.Equal(t, 1, res.Characters[0].ToSlot)
+
+	// Nothing was actually merged.
+	got, err := db.LookUpCharacterID("old", 0)
+	require.NoError(t, err)
+	assert.Equal(t, oldID, got)
+
+	u, err := db.GetUser("old")
+	require.NoError(t, err)
+	assert.Empty(t, u.MergedInto)
+}
+
 // ─── NewCharacter ─────────────────────────────────────────────────────────────
 
 func TestNewCharacter_ReturnsUniqueIDs(t *testing.T) {
@@ -286,8 +661,8 @@ func TestUpdateCharacter_CoalescedFlush(t *testing.T) {
 	id := seedCharacter(t, db, "steam1", 0, 10, "original")
 
 	// Two back-to-back updates — only the last should persist.
-	require.NoError(t, db.UpdateCharacter(id, 20, "second", 0, 0))
-	require.NoError(t, db.UpdateCharacter(id, 30, "third", 0, 0))
+	require.NoError(t, db.UpdateCharacter(id, 20, "second", "", 0, 0))
+	require.NoError(t, db.UpdateCharacter(id, 30, "third", "", 0, 0))
 
 	flush(t, db)
 
@@ -301,7 +676,7 @@ func TestUpdateCharacter_CreatesFirstVersion(t *testing.T) {
 	db := newTestDB(t)
 	id := seedCharacter(t, db, "steam1", 0, 10, "v0")
 
-	require.NoError(t, db.UpdateCharacter(id, 20, "v1", 5, 0))
+	require.NoError(t, db.UpdateCharacter(id, 20, "v1", "", 5, 0))
 	flush(t, db)
 
 	c, err := db.GetCharacter(id)
@@ -317,7 +692,7 @@ func TestUpdateCharacter_RespectsBackupMax(t *testing.T) {
 	// With backupMax=2 and backupTime=0 (always snapshot), after 3 updates
 	// there should be at most 2 versions.
 	for i, payload := range []string{"a", "b", "c"} {
-		require.NoError(t, db.UpdateCharacter(id, i+1, payload, 2, 0))
+		require.NoError(t, db.UpdateCharacter(id, i+1, payload, "", 2, 0))
 		flush(t, db)
 	}
 
@@ -360,7 +735,7 @@ func TestRestoreCharacter(t *testing.T) {
 	id := seedCharacter(t, db, "steam1", 0, 10, "data")
 	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
 
-	require.NoError(t, db.RestoreCharacter(id))
+	require.NoError(t, db.RestoreCharacter(id, nil, ""))
 
 	c, err := db.GetCharacter(id)
 	require.NoError(t, err)
@@ -374,10 +749,108 @@ func TestRestoreCharacter(t *testing.T) {
 
 func TestRestoreCharacter_NotFound(t *testing.T) {
 	db := newTestDB(t)
-	err := db.RestoreCharacter(uuid.New())
+	err := db.RestoreCharacter(uuid.New(), nil, "")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestGetDeletedSlot(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 3, 10, "data")
+
+	_, _, err := db.GetDeletedSlot(id)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+
+	steamid, slot, err := db.GetDeletedSlot(id)
+	require.NoError(t, err)
+	assert.Equal(t, "steam1", steamid)
+	assert.Equal(t, 3, slot)
+}
+
+func TestRestoreCharacter_ToSlot(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+
+	slot := 2
+	require.NoError(t, db.RestoreCharacter(id, &slot, ""))
+
+	got, err := db.LookUpCharacterID("steam1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+
+	_, err = db.LookUpCharacterID("steam1", 0)
 	assert.ErrorIs(t, err, database.ErrNoDocument)
 }
 
+func TestRestoreCharacter_SlotOccupied(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+	other := seedCharacter(t, db, "steam1", 0, 1, "new")
+
+	err := db.RestoreCharacter(id, nil, "")
+	assert.ErrorIs(t, err, database.ErrSlotOccupied)
+
+	var occupied *database.SlotOccupiedError
+	require.ErrorAs(t, err, &occupied)
+	assert.Equal(t, other, occupied.CharacterID)
+	assert.Equal(t, 0, occupied.Slot)
+
+	// The slot wasn't clobbered and the character is still deleted.
+	got, err := db.LookUpCharacterID("steam1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, other, got)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.NotNil(t, c.DeletedAt)
+}
+
+func TestGetDeletedCharacters(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 2, 42, "gone")
+	seedCharacter(t, db, "steam1", 0, 1, "kept")
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+
+	chars, err := db.GetDeletedCharacters("steam1")
+	require.NoError(t, err)
+	require.Len(t, chars, 1)
+	assert.Equal(t, id, chars[0].ID)
+	assert.Equal(t, "steam1", chars[0].SteamID)
+	assert.Equal(t, 2, chars[0].Slot)
+	assert.Equal(t, 42, chars[0].Size)
+	require.NotNil(t, chars[0].DeletedAt)
+	require.NotNil(t, chars[0].ExpiresAt)
+	assert.WithinDuration(t, chars[0].DeletedAt.Add(time.Hour), *chars[0].ExpiresAt, time.Second)
+	assert.NotNil(t, chars[0].ModifiedAt)
+
+	chars, err = db.GetDeletedCharacters("steam2")
+	require.NoError(t, err)
+	assert.Empty(t, chars)
+}
+
+func TestSetCharacterExpiry(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "data")
+	expiresAt := time.Now().UTC().Add(30 * 24 * time.Hour)
+
+	// Only soft deleted characters have an expiry.
+	assert.ErrorIs(t, db.SetCharacterExpiry(id, expiresAt), database.ErrNoDocument)
+
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+	require.NoError(t, db.SetCharacterExpiry(id, expiresAt))
+
+	chars, err := db.GetDeletedCharacters("steam1")
+	require.NoError(t, err)
+	require.Len(t, chars, 1)
+	require.NotNil(t, chars[0].ExpiresAt)
+	assert.WithinDuration(t, expiresAt, *chars[0].ExpiresAt, time.Second)
+
+	assert.ErrorIs(t, db.SetCharacterExpiry(uuid.New(), expiresAt), database.ErrNoDocument)
+}
+
 // ─── DeleteCharacter ─────────────────────────────────────────────────────────
 
 func TestDeleteCharacter(t *testing.T) {
@@ -416,7 +889,7 @@ func TestMoveCharacter(t *testing.T) {
 	id := seedCharacter(t, db, "steam1", 0, 10, "data")
 	seedUser(t, db, "steam2")
 
-	require.NoError(t, db.MoveCharacter(id, "steam2", 3))
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, ""))
 
 	// Character now belongs to steam2 slot 3.
 	c, err := db.GetCharacter(id)
@@ -436,134 +909,2284 @@ func TestMoveCharacter(t *testing.T) {
 
 func TestMoveCharacter_CharacterNotFound(t *testing.T) {
 	db := newTestDB(t)
-	err := db.MoveCharacter(uuid.New(), "steam2", 0)
+	err := db.MoveCharacter(uuid.New(), "steam2", 0, "")
 	assert.ErrorIs(t, err, database.ErrNoDocument)
 }
 
 func TestMoveCharacter_TargetUserNotFound(t *testing.T) {
 	db := newTestDB(t)
 	id := seedCharacter(t, db, "steam1", 0, 10, "data")
-	err := db.MoveCharacter(id, "ghost", 0)
+	err := db.MoveCharacter(id, "ghost", 0, "")
 	assert.ErrorIs(t, err, database.ErrNoDocument)
 }
 
-// ─── CopyCharacter ────────────────────────────────────────────────────────────
-
-func TestCopyCharacter(t *testing.T) {
+func TestMoveCharacter_SlotOccupied(t *testing.T) {
 	db := newTestDB(t)
-	origID := seedCharacter(t, db, "steam1", 0, 42, "original")
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	other := seedCharacter(t, db, "steam2", 1, 10, "other")
 
-	newID, err := db.CopyCharacter(origID, "steam2", 1)
-	require.NoError(t, err)
-	assert.NotEqual(t, origID, newID)
+	err := db.MoveCharacter(id, "steam2", 1, "")
+	var occupied *database.SlotOccupiedError
+	require.ErrorAs(t, err, &occupied)
+	assert.Equal(t, other, occupied.CharacterID)
 
-	// Original unchanged.
-	orig, err := db.GetCharacter(origID)
+	got, err := db.LookUpCharacterID("steam1", 0)
 	require.NoError(t, err)
-	assert.Equal(t, "steam1", orig.SteamID)
+	assert.Equal(t, id, got)
+}
 
-	// Copy has correct owner and payload.
-	copy, err := db.GetCharacter(newID)
+func TestMoveCharacter_Deleted(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+
+	err := db.MoveCharacter(id, "steam2", 0, "")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+
+	c, err := db.GetCharacter(id)
 	require.NoError(t, err)
-	assert.Equal(t, "steam2", copy.SteamID)
-	assert.Equal(t, 1, copy.Slot)
-	assert.Equal(t, "original", copy.Data.Data)
-	assert.Equal(t, 42, copy.Data.Size)
+	assert.NotNil(t, c.DeletedAt)
 }
 
-func TestCopyCharacter_CreatesTargetUserIfMissing(t *testing.T) {
+// ─── SwapCharacters ───────────────────────────────────────────────────────────
+
+func TestSwapCharacters(t *testing.T) {
 	db := newTestDB(t)
-	origID := seedCharacter(t, db, "steam1", 0, 10, "data")
+	idA := seedCharacter(t, db, "steam1", 0, 10, "a")
+	idB := seedCharacter(t, db, "steam1", 1, 10, "b")
+
+	require.NoError(t, db.SwapCharacters("steam1", 0, 1))
 
-	_, err := db.CopyCharacter(origID, "brandnew", 0)
+	got, err := db.LookUpCharacterID("steam1", 0)
 	require.NoError(t, err)
+	assert.Equal(t, idB, got)
 
-	u, err := db.GetUser("brandnew")
+	got, err = db.LookUpCharacterID("steam1", 1)
 	require.NoError(t, err)
-	assert.Equal(t, "brandnew", u.ID)
+	assert.Equal(t, idA, got)
 }
 
-func TestCopyCharacter_OriginalNotFound(t *testing.T) {
+func TestSwapCharacters_EmptySlot(t *testing.T) {
 	db := newTestDB(t)
-	_, err := db.CopyCharacter(uuid.New(), "steam2", 0)
-	assert.ErrorIs(t, err, database.ErrNoDocument)
-}
+	id := seedCharacter(t, db, "steam1", 0, 10, "a")
+
+	require.NoError(t, db.SwapCharacters("steam1", 0, 3))
+
+	_, err := db.LookUpCharacterID("steam1", 0)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+
+	got, err := db.LookUpCharacterID("steam1", 3)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+}
+
+func TestSwapCharacters_BothEmpty(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1") // takes slot 0
+
+	err := db.SwapCharacters("steam1", 1, 2)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+// ─── CopyCharacter ────────────────────────────────────────────────────────────
+
+func TestCopyCharacter(t *testing.T) {
+	db := newTestDB(t)
+	origID := seedCharacter(t, db, "steam1", 0, 42, "original")
+
+	newID, err := db.CopyCharacter(origID, "steam2", 1, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, origID, newID)
+
+	// Original unchanged.
+	orig, err := db.GetCharacter(origID)
+	require.NoError(t, err)
+	assert.Equal(t, "steam1", orig.SteamID)
+
+	// Copy has correct owner and payload.
+	copy, err := db.GetCharacter(newID)
+	require.NoError(t, err)
+	assert.Equal(t, "steam2", copy.SteamID)
+	assert.Equal(t, 1, copy.Slot)
+	assert.Equal(t, "original", copy.Data.Data)
+	assert.Equal(t, 42, copy.Data.Size)
+}
+
+func TestCopyCharacter_CreatesTargetUserIfMissing(t *testing.T) {
+	db := newTestDB(t)
+	origID := seedCharacter(t, db, "steam1", 0, 10, "data")
+
+	_, err := db.CopyCharacter(origID, "brandnew", 0, "")
+	require.NoError(t, err)
+
+	u, err := db.GetUser("brandnew")
+	require.NoError(t, err)
+	assert.Equal(t, "brandnew", u.ID)
+}
+
+func TestCopyCharacter_OriginalNotFound(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.CopyCharacter(uuid.New(), "steam2", 0, "")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestCopyCharacter_SlotOccupied(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	other := seedCharacter(t, db, "steam2", 0, 10, "other")
+
+	_, err := db.CopyCharacter(id, "steam2", 0, "")
+	var occupied *database.SlotOccupiedError
+	require.ErrorAs(t, err, &occupied)
+	assert.Equal(t, other, occupied.CharacterID)
+}
+
+// ─── ImportCharacter ──────────────────────────────────────────────────────────
+
+func TestImportCharacter_EmptySlotCreatesCharacter(t *testing.T) {
+	db := newTestDB(t)
+
+	id, created, err := db.ImportCharacter("steam1", 2, 4, "aW1wb3J0", "imported from test.char", "", 0)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "steam1", c.SteamID)
+	assert.Equal(t, 2, c.Slot)
+	assert.Equal(t, "aW1wb3J0", c.Data.Data)
+	require.Len(t, c.Versions, 1)
+	assert.Equal(t, "import", c.Versions[0].Tag)
+	assert.Equal(t, "imported from test.char", c.Versions[0].Note)
+}
+
+func TestImportCharacter_OccupiedSlotAddsVersion(t *testing.T) {
+	db := newTestDB(t)
+	orig := seedCharacter(t, db, "steam1", 0, 3, "old")
+
+	id, created, err := db.ImportCharacter("steam1", 0, 3, "new", "imported from upload", "", 0)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, orig, id)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "new", c.Data.Data)
+	require.Len(t, c.Versions, 1)
+	assert.Equal(t, "import", c.Versions[0].Tag)
+	assert.Equal(t, []string{"import: old"}, snapshots(t, db, id))
+}
+
+func TestImportCharacter_CapsVersions(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	for _, data := range []string{"v1", "v2", "v3"} {
+		require.NoError(t, db.UpdateCharacter(id, 2, data, "", 5, 0))
+		flush(t, db)
+	}
+
+	_, _, err := db.ImportCharacter("steam1", 0, 3, "new", "", "", 2)
+	require.NoError(t, err)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	require.Len(t, c.Versions, 2)
+	assert.Equal(t, "v2", c.Versions[0].Data)
+	assert.Equal(t, "import", c.Versions[1].Tag)
+}
+
+func TestImportCharacter_DropsPendingUpdate(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	require.NoError(t, db.UpdateCharacter(id, 2, "stale", "", 5, 0))
+
+	_, _, err := db.ImportCharacter("steam1", 0, 3, "new", "", "", 0)
+	require.NoError(t, err)
+	flush(t, db)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "new", c.Data.Data)
+}
+
+// ─── Schema migration ─────────────────────────────────────────────────────────
+
+func TestConnect_AddsMissingVersionColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+
+	// Create a database with the original character_versions layout.
+	raw, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	_, err = raw.Exec(`
+		CREATE TABLE character_versions (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			character_id TEXT NOT NULL,
+			created_at   DATETIME NOT NULL,
+			size         INTEGER NOT NULL,
+			data_payload TEXT NOT NULL
+		)`)
+	require.NoError(t, err)
+	require.NoError(t, raw.Close())
+
+	db := New()
+	cfg := database.Config{}
+	cfg.SQLite.Path = path
+	require.NoError(t, db.Connect(cfg, database.Options{}))
+	t.Cleanup(func() { _ = db.Disconnect() })
+
+	id, _, err := db.ImportCharacter("steam1", 0, 1, "x", "note", "", 0)
+	require.NoError(t, err)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	require.Len(t, c.Versions, 1)
+	assert.Equal(t, "note", c.Versions[0].Note)
+}
 
 // ─── RollbackCharacter ────────────────────────────────────────────────────────
 
-func TestRollbackCharacter(t *testing.T) {
+func TestRollbackCharacter(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	// Create a version by updating once (backupMax>0, backupTime=0 means always snapshot).
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+
+	// Rollback to the "v0" snapshot.
+	require.NoError(t, db.RollbackCharacter(id, versionID(t, db, id, 0)))
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v0", c.Data.Data)
+	assert.Equal(t, 1, c.Data.Size)
+}
+
+func TestRollbackCharacter_InvalidIndex(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "data")
+	err := db.RollbackCharacter(id, 99)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestRollbackCharacters_DropsPendingUpdates(t *testing.T) {
+	db := newTestDB(t)
+	a := seedCharacter(t, db, "steam1", 0, 1, "a0")
+	b := seedCharacter(t, db, "steam2", 0, 1, "b0")
+	require.NoError(t, db.UpdateCharacter(a, 2, "a1", "", 5, 0))
+	require.NoError(t, db.UpdateCharacter(b, 2, "b1", "", 5, 0))
+	flush(t, db)
+
+	// A failed mass rollback leaves the queued saves alone.
+	require.NoError(t, db.UpdateCharacter(a, 3, "a2", "", 5, 0))
+	require.Error(t, db.RollbackCharacters([]database.RollbackTarget{
+		{ID: a, Version: versionID(t, db, a, 0)},
+		{ID: b, Version: 99},
+	}))
+	flush(t, db)
+
+	c, err := db.GetCharacter(a)
+	require.NoError(t, err)
+	assert.Equal(t, "a2", c.Data.Data)
+
+	require.NoError(t, db.UpdateCharacter(a, 4, "a3", "", 5, 0))
+	require.NoError(t, db.UpdateCharacter(b, 4, "b2", "", 5, 0))
+	require.NoError(t, db.RollbackCharacters([]database.RollbackTarget{
+		{ID: a, Version: versionID(t, db, a, 0)},
+		{ID: b, Version: versionID(t, db, b, 0)},
+	}))
+	flush(t, db)
+
+	for id, want := range map[uuid.UUID]string{a: "a0", b: "b0"} {
+		c, err := db.GetCharacter(id)
+		require.NoError(t, err)
+		assert.Equal(t, want, c.Data.Data)
+	}
+}
+
+func TestRollbackCharacters_AllOrNothing(t *testing.T) {
+	db := newTestDB(t)
+	a := seedCharacter(t, db, "steam1", 0, 1, "a0")
+	b := seedCharacter(t, db, "steam2", 0, 1, "b0")
+	require.NoError(t, db.UpdateCharacter(a, 2, "a1", "", 5, 0))
+	require.NoError(t, db.UpdateCharacter(b, 2, "b1", "", 5, 0))
+	flush(t, db)
+
+	err := db.RollbackCharacters([]database.RollbackTarget{
+		{ID: a, Version: versionID(t, db, a, 0)},
+		{ID: b, Version: 99},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), b.String())
+
+	c, err := db.GetCharacter(a)
+	require.NoError(t, err)
+	assert.Equal(t, "a1", c.Data.Data, "the first rollback is undone with the failed one")
+
+	require.NoError(t, db.RollbackCharacters([]database.RollbackTarget{
+		{ID: a, Version: versionID(t, db, a, 0)},
+		{ID: b, Version: versionID(t, db, b, 0)},
+	}))
+	for id, want := range map[uuid.UUID]string{a: "a0", b: "b0"} {
+		c, err := db.GetCharacter(id)
+		require.NoError(t, err)
+		assert.Equal(t, want, c.Data.Data)
+	}
+}
+
+func TestRollbackCharacter_DropsPendingUpdate(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+	ver := versionID(t, db, id, 0)
+
+	// A save queued before the rollback isn't flushed over it.
+	require.NoError(t, db.UpdateCharacter(id, 3, "stale", "", 5, 0))
+	require.NoError(t, db.RollbackCharacter(id, ver))
+	flush(t, db)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v0", c.Data.Data)
+
+	// The same goes for undoing the rollback.
+	require.NoError(t, db.UpdateCharacter(id, 3, "stale", "", 5, 0))
+	_, err = db.UndoCharacterOperation(id, time.Hour, "")
+	require.NoError(t, err)
+	flush(t, db)
+
+	c, err = db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", c.Data.Data)
+}
+
+func TestRollbackCharacter_DryRunKeepsPendingUpdate(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+	ver := versionID(t, db, id, 0)
+	require.NoError(t, db.UpdateCharacter(id, 3, "v2", "", 5, 0))
+
+	require.NoError(t, db.DryRun().RollbackCharacter(id, ver))
+	flush(t, db)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", c.Data.Data)
+}
+
+func TestRollbackCharacter_StableAfterPruning(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 2, 0))
+	flush(t, db)
+	require.NoError(t, db.UpdateCharacter(id, 3, "v2", "", 2, 0))
+	flush(t, db)
+	ver := versionID(t, db, id, 1) // the "v1" snapshot
+
+	// Pruning drops "v0", the ID for "v1" still points at "v1".
+	require.NoError(t, db.UpdateCharacter(id, 4, "v3", "", 2, 0))
+	flush(t, db)
+
+	require.NoError(t, db.RollbackCharacter(id, ver))
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", c.Data.Data)
+}
+
+func TestGetCharacterVersionAt(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+	between := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, db.UpdateCharacter(id, 3, "v2", "", 5, 0))
+	flush(t, db)
+	require.NoError(t, db.UpdateCharacter(id, 4, "v3", "", 5, 0))
+	flush(t, db)
+
+	// "v1" was saved before the cut off, "v2" after.
+	ver, err := db.GetCharacterVersionAt(id, between)
+	require.NoError(t, err)
+	assert.Equal(t, versionID(t, db, id, 1), ver)
+	require.NoError(t, db.RollbackCharacter(id, ver))
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", c.Data.Data)
+}
+
+func TestGetCharacterVersionAt_NoVersionBefore(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+
+	_, err := db.GetCharacterVersionAt(id, time.Now().Add(-time.Hour))
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestRollbackCharacterToLatest(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+	require.NoError(t, db.UpdateCharacter(id, 3, "v2", "", 5, 0))
+	flush(t, db)
+
+	// Manually clobber the current data to simulate corruption.
+	require.NoError(t, db.UpdateCharacter(id, 0, "corrupt", "", 0, 0))
+	flush(t, db)
+
+	require.NoError(t, db.RollbackCharacterToLatest(id))
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	// Should have rolled back to the latest version (v2, since backupMax was 5).
+	assert.NotEqual(t, "corrupt", c.Data.Data)
+}
+
+func TestRollbackCharacterToLatest_NoVersions(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "data")
+	err := db.RollbackCharacterToLatest(id)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+// ─── DeleteCharacterVersions ─────────────────────────────────────────────────
+
+func TestDeleteCharacterVersions(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+
+	require.NoError(t, db.DeleteCharacterVersions(id))
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Empty(t, c.Versions)
+}
+
+func TestDeleteCharacterVersions_NoVersions(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "data")
+	// Should succeed even if there are no versions to delete.
+	assert.NoError(t, db.DeleteCharacterVersions(id))
+}
+
+// ─── GetModifiedCharacters ───────────────────────────────────────────────────
+
+func TestGetModifiedCharacters_Window(t *testing.T) {
+	db := newTestDB(t)
+	before := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	during := seedCharacter(t, db, "steam1", 1, 1, "v0")
+
+	time.Sleep(10 * time.Millisecond)
+	from := time.Now().UTC()
+	require.NoError(t, db.UpdateCharacter(during, 2, "duped", "10.0.0.1", 5, 0))
+	flush(t, db)
+	to := time.Now().UTC()
+
+	chars, err := db.GetModifiedCharacters(from, to, nil)
+	require.NoError(t, err)
+	require.Len(t, chars, 1)
+	assert.Equal(t, during, chars[0].ID)
+	assert.Equal(t, "10.0.0.1", chars[0].Server)
+	assert.Equal(t, versionID(t, db, during, 0), chars[0].Version)
+	assert.NotEqual(t, before, chars[0].ID)
+}
+
+func TestGetModifiedCharacters_ServerFilter(t *testing.T) {
+	db := newTestDB(t)
+	a := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	b := seedCharacter(t, db, "steam1", 1, 1, "v0")
+
+	time.Sleep(10 * time.Millisecond)
+	from := time.Now().UTC()
+	require.NoError(t, db.UpdateCharacter(a, 2, "a1", "10.0.0.1", 5, 0))
+	require.NoError(t, db.UpdateCharacter(b, 2, "b1", "10.0.0.2", 5, 0))
+	flush(t, db)
+	to := time.Now().UTC()
+
+	chars, err := db.GetModifiedCharacters(from, to, []string{"10.0.0.2"})
+	require.NoError(t, err)
+	require.Len(t, chars, 1)
+	assert.Equal(t, b, chars[0].ID)
+}
+
+func TestGetModifiedCharacters_NoVersionBeforeWindow(t *testing.T) {
+	db := newTestDB(t)
+	from := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	chars, err := db.GetModifiedCharacters(from, time.Now().UTC(), nil)
+	require.NoError(t, err)
+	require.Len(t, chars, 1)
+	assert.Equal(t, id, chars[0].ID)
+	assert.Zero(t, chars[0].Version)
+}
+
+// ─── UndoCharacterOperation ──────────────────────────────────────────────────
+
+func TestRollbackCharacter_SnapshotsCurrentData(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+
+	require.NoError(t, db.RollbackCharacter(id, versionID(t, db, id, 0)))
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	require.Len(t, c.Versions, 1, "the snapshot is kept apart from the versions")
+	assert.Equal(t, []string{"rollback: v1"}, snapshots(t, db, id))
+}
+
+func TestCopyCharacter_NoSnapshot(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	_, err := db.CopyCharacter(id, "steam2", 0, "admin")
+	require.NoError(t, err)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Empty(t, c.Versions)
+	assert.Empty(t, snapshots(t, db, id))
+}
+
+func TestRunGC_PrunesSnapshotsAnUndoDoesNotNeed(t *testing.T) {
+	db := newTestDBWith(t, database.Options{MaxSnapshots: 1})
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	require.NoError(t, db.UpdateCharacter(id, 1, "v1", "", 5, 0))
+	flush(t, db)
+	ver := versionID(t, db, id, 0)
+
+	for _, data := range []string{"a", "b", "c"} {
+		require.NoError(t, db.UpdateCharacter(id, 1, data, "", 5, 0))
+		flush(t, db)
+		require.NoError(t, db.RollbackCharacter(id, ver))
+	}
+	_, err := db.UndoCharacterOperation(id, time.Hour, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"rollback: a", "rollback: b", "rollback: c", "undo: v0"}, snapshots(t, db, id))
+
+	require.NoError(t, db.RunGC())
+
+	// The undone rollback's snapshot goes, the two that can still be undone stay.
+	assert.Equal(t, []string{"rollback: a", "rollback: b", "undo: v0"}, snapshots(t, db, id))
+}
+
+func TestUndoCharacterOperation_Rollback(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "", 5, 0))
+	flush(t, db)
+	require.NoError(t, db.RollbackCharacter(id, versionID(t, db, id, 0)))
+
+	op, err := db.UndoCharacterOperation(id, time.Hour, "")
+	require.NoError(t, err)
+	assert.Equal(t, "rollback", op.Operation)
+	assert.NotNil(t, op.UndoneAt)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", c.Data.Data)
+	assert.Equal(t, 2, c.Data.Size)
+
+	// Nothing left to undo.
+	_, err = db.UndoCharacterOperation(id, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrNoOperation)
+}
+
+func TestUndoCharacterOperation_Move(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, ""))
+
+	op, err := db.UndoCharacterOperation(id, time.Hour, "")
+	require.NoError(t, err)
+	assert.Equal(t, "move", op.Operation)
+
+	got, err := db.LookUpCharacterID("steam1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+}
+
+func TestUndoCharacterOperation_MoveSlotTaken(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, ""))
+	seedCharacter(t, db, "steam1", 0, 1, "new")
+
+	_, err := db.UndoCharacterOperation(id, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrSlotOccupied)
+}
+
+func TestUndoCharacterOperation_CopyDeletesCopy(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+
+	newID, err := db.CopyCharacter(id, "steam2", 1, "")
+	require.NoError(t, err)
+
+	// The original has nothing to undo, only the copy does.
+	_, err = db.UndoCharacterOperation(id, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrNoOperation)
+
+	_, err = db.UndoCharacterOperation(newID, time.Hour, "")
+	require.NoError(t, err)
+
+	_, err = db.LookUpCharacterID("steam2", 1)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestUndoCharacterOperation_NotFound(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.UndoCharacterOperation(uuid.New(), time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrNoOperation)
+}
+
+func TestGetUndoOperation(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+
+	_, err := db.GetUndoOperation(id)
+	assert.ErrorIs(t, err, database.ErrNoOperation)
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, ""))
+
+	op, err := db.GetUndoOperation(id)
+	require.NoError(t, err)
+	assert.Equal(t, "move", op.Operation)
+	assert.Equal(t, "steam1", op.SteamID)
+	assert.Equal(t, 0, op.Slot)
+
+	// It's the same operation undo pops.
+	undone, err := db.UndoCharacterOperation(id, time.Hour, "")
+	require.NoError(t, err)
+	assert.Equal(t, op.ID, undone.ID)
+
+	_, err = db.GetUndoOperation(id)
+	assert.ErrorIs(t, err, database.ErrNoOperation)
+}
+
+// ─── Lineage ──────────────────────────────────────────────────────────────────
+
+func TestGetLineage_CopyChain(t *testing.T) {
+	db := newTestDB(t)
+	orig := seedCharacter(t, db, "steam1", 0, 10, "data")
+
+	copy1, err := db.CopyCharacter(orig, "steam2", 0, "10.0.0.1")
+	require.NoError(t, err)
+	copy2, err := db.CopyCharacter(copy1, "steam3", 0, "10.0.0.2")
+	require.NoError(t, err)
+
+	l, err := db.GetLineage(copy2)
+	require.NoError(t, err)
+	require.Len(t, l.Ancestors, 2)
+	assert.Equal(t, copy1, *l.Ancestors[0].SourceID)
+	assert.Equal(t, "10.0.0.2", l.Ancestors[0].Admin)
+	assert.Equal(t, orig, *l.Ancestors[1].SourceID)
+	assert.Empty(t, l.Descendants)
+
+	l, err = db.GetLineage(orig)
+	require.NoError(t, err)
+	assert.Empty(t, l.Ancestors)
+	require.Len(t, l.Descendants, 2)
+	assert.Equal(t, copy1, l.Descendants[0].CharacterID)
+	assert.Equal(t, copy2, l.Descendants[1].CharacterID)
+}
+
+func TestGetLineage_History(t *testing.T) {
+	db := newTestDB(t)
+	id, _, err := db.ImportCharacter("steam1", 0, 1, "x", "note", "admin", 0)
+	require.NoError(t, err)
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, "admin"))
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+	require.NoError(t, db.RestoreCharacter(id, nil, "admin"))
+
+	l, err := db.GetLineage(id)
+	require.NoError(t, err)
+	require.Len(t, l.History, 3)
+	assert.Equal(t, "import", l.History[0].Operation)
+	assert.Nil(t, l.History[0].SourceID)
+	assert.Equal(t, "move", l.History[1].Operation)
+	assert.Equal(t, "steam2", l.History[1].SteamID)
+	assert.Equal(t, 3, l.History[1].Slot)
+	assert.Equal(t, "restore", l.History[2].Operation)
+}
+
+func TestGetLineage_RevertAndUndo(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "x")
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, "admin"))
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	_, err = db.RevertJournalEntry(entries[0].ID, time.Hour, "reverter")
+	require.NoError(t, err)
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 4, "admin"))
+	_, err = db.UndoCharacterOperation(id, time.Hour, "undoer")
+	require.NoError(t, err)
+
+	l, err := db.GetLineage(id)
+	require.NoError(t, err)
+	require.Len(t, l.History, 4)
+	assert.Equal(t, "revert", l.History[1].Operation)
+	assert.Equal(t, "steam1", l.History[1].SteamID)
+	assert.Equal(t, 0, l.History[1].Slot)
+	assert.Equal(t, "reverter", l.History[1].Admin)
+	assert.Equal(t, "undo", l.History[3].Operation)
+	assert.Equal(t, "steam1", l.History[3].SteamID)
+	assert.Equal(t, "undoer", l.History[3].Admin)
+}
+
+func TestGetLineage_OutlivesDeletedCharacters(t *testing.T) {
+	db := newTestDB(t)
+	orig := seedCharacter(t, db, "steam1", 0, 10, "data")
+	copyID, err := db.CopyCharacter(orig, "steam2", 0, "")
+	require.NoError(t, err)
+
+	require.NoError(t, db.DeleteCharacter(orig))
+
+	l, err := db.GetLineage(copyID)
+	require.NoError(t, err)
+	require.Len(t, l.Ancestors, 1)
+	assert.Equal(t, orig, *l.Ancestors[0].SourceID)
+}
+
+func TestGetLineage_NotFound(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.GetLineage(uuid.New())
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+// ─── Operation journal ───────────────────────────────────────────────────────
+
+func TestRevertJournalEntry_Delete(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 2, 1, "v0")
+
+	require.NoError(t, db.UpdateCharacter(id, 2, "v1", "server1", 5, 0))
+	flush(t, db)
+	ver := versionID(t, db, id, 0)
+	require.NoError(t, db.DeleteCharacter(id))
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "delete", entries[0].Operation)
+	assert.Nil(t, entries[0].Before)
+
+	entry, err := db.RevertJournalEntry(entries[0].ID, time.Hour, "")
+	require.NoError(t, err)
+	assert.NotNil(t, entry.RevertedAt)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "steam1", c.SteamID)
+	assert.Equal(t, 2, c.Slot)
+	assert.Equal(t, "v1", c.Data.Data)
+	assert.Equal(t, "server1", c.Data.Server)
+	require.Len(t, c.Versions, 1)
+	assert.Equal(t, ver, c.Versions[0].ID, "versions keep their IDs")
+
+	got, err := db.LookUpCharacterID("steam1", 2)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+}
+
+func TestRevertJournalEntry_DeleteSoftDeleted(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 1, "data")
+
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+	require.NoError(t, db.DeleteCharacter(id))
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	_, err = db.RevertJournalEntry(entries[0].ID, time.Hour, "")
+	require.NoError(t, err)
+
+	// It comes back still soft deleted and can be restored as usual.
+	_, err = db.LookUpCharacterID("steam1", 0)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+	require.NoError(t, db.RestoreCharacter(id, nil, ""))
+
+	got, err := db.LookUpCharacterID("steam1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+}
+
+func TestRevertJournalEntry_Move(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, ""))
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	entry, err := db.GetJournalEntry(entries[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "move", entry.Operation)
+	assert.Equal(t, "steam1", entry.Before.SteamID)
+
+	_, err = db.RevertJournalEntry(entry.ID, time.Hour, "")
+	require.NoError(t, err)
+
+	got, err := db.LookUpCharacterID("steam1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+}
+
+func TestRevertJournalEntry_MovedSince(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, ""))
+	require.NoError(t, db.MoveCharacter(id, "steam2", 4, ""))
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "steam2", entries[1].ToSteamID)
+	require.NotNil(t, entries[1].ToSlot)
+	assert.Equal(t, 3, *entries[1].ToSlot)
+
+	_, err = db.RevertJournalEntry(entries[1].ID, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrMovedSince)
+
+	got, err := db.LookUpCharacterID("steam2", 4)
+	require.NoError(t, err)
+	assert.Equal(t, id, got, "the later move stands")
+}
+
+func TestRevertJournalEntry_DeleteWithoutOwner(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+
+	require.NoError(t, db.DeleteCharacterReference("steam1", 0))
+	require.NoError(t, db.DeleteCharacter(id))
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	_, err = db.RevertJournalEntry(entries[0].ID, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrNoOwner)
+
+	_, err = db.GetUser("")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestRevertJournalEntry_Copy(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+
+	newID, err := db.CopyCharacter(id, "steam2", 1, "")
+	require.NoError(t, err)
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NotNil(t, entries[0].TargetID)
+	assert.Equal(t, newID, *entries[0].TargetID)
+
+	_, err = db.RevertJournalEntry(entries[0].ID, time.Hour, "")
+	require.NoError(t, err)
+
+	_, err = db.LookUpCharacterID("steam2", 1)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+
+	got, err := db.LookUpCharacterID("steam1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+
+	// the copy's operation went with it
+	_, err = db.UndoCharacterOperation(newID, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrNoOperation)
+}
+
+func TestRevertJournalEntry_AlreadyReverted(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.MoveCharacter(id, "steam2", 3, ""))
+
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	_, err = db.RevertJournalEntry(entries[0].ID, time.Hour, "")
+	require.NoError(t, err)
+
+	_, err = db.RevertJournalEntry(entries[0].ID, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrAlreadyReverted)
+}
+
+func TestRevertJournalEntry_NotFound(t *testing.T) {
+	db := newTestDB(t)
+	_, err := db.RevertJournalEntry(1, time.Hour, "")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestPruneJournal(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	require.NoError(t, db.DeleteCharacter(id))
+
+	require.NoError(t, db.PruneJournal(time.Now().UTC().Add(-time.Hour)))
+	entries, err := db.GetJournal(10)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, db.PruneJournal(time.Now().UTC().Add(time.Hour)))
+	entries, err = db.GetJournal(10)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// ─── Bans ────────────────────────────────────────────────────────────────────
+
+func TestAddBan(t *testing.T) {
+	db := newTestDB(t)
+	expires := time.Now().UTC().Add(time.Hour)
+
+	id, err := db.AddBan(&schema.Ban{
+		SteamID: "steam1",
+		Reason: "duping",
+		Admin: "127.0.0.1",
+		Evidence: "https://example.com/demo",
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: &expires,
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, id)
+
+	// The user didn't exist yet, banning creates them.
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.True(t, flags.HasFlag(bitmask.BANNED))
+
+	ban, err := db.GetActiveBan("steam1")
+	require.NoError(t, err)
+	assert.Equal(t, id, ban.ID)
+	assert.Equal(t, "duping", ban.Reason)
+	assert.Equal(t, "127.0.0.1", ban.Admin)
+	assert.Equal(t, "https://example.com/demo", ban.Evidence)
+	require.NotNil(t, ban.ExpiresAt)
+	assert.WithinDuration(t, expires, *ban.ExpiresAt, time.Second)
+	assert.Nil(t, ban.LiftedAt)
+}
+
+func TestAddBan_ReplacesActiveBan(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+
+	first, err := db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "first", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+	second, err := db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "second", Admin: "admin", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+
+	ban, err := db.GetActiveBan("steam1")
+	require.NoError(t, err)
+	assert.Equal(t, second, ban.ID)
+
+	bans, err := db.GetBans("steam1")
+	require.NoError(t, err)
+	require.Len(t, bans, 2)
+	assert.Equal(t, second, bans[0].ID, "newest first")
+	assert.Equal(t, first, bans[1].ID)
+	assert.NotNil(t, bans[1].LiftedAt)
+	assert.Equal(t, "admin", bans[1].LiftedBy)
+	assert.Equal(t, "replaced", bans[1].LiftReason)
+}
+
+func TestLiftBan(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.BANNED|bitmask.DONOR))
+
+	_, err := db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "griefing", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+	require.NoError(t, db.LiftBan("steam1", "admin", "appeal accepted"))
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.False(t, flags.HasFlag(bitmask.BANNED))
+	assert.True(t, flags.HasFlag(bitmask.DONOR), "other flags are untouched")
+
+	_, err = db.GetActiveBan("steam1")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+
+	bans, err := db.GetBans("steam1")
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+	assert.NotNil(t, bans[0].LiftedAt)
+	assert.Equal(t, "admin", bans[0].LiftedBy)
+	assert.Equal(t, "appeal accepted", bans[0].LiftReason)
+}
+
+func TestLiftBan_FlagOnly(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.BANNED))
+
+	require.NoError(t, db.LiftBan("steam1", "admin", ""))
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.False(t, flags.HasFlag(bitmask.BANNED))
+}
+
+func TestLiftBan_NoUser(t *testing.T) {
+	db := newTestDB(t)
+	assert.ErrorIs(t, db.LiftBan("nobody", "admin", ""), database.ErrNoDocument)
+}
+
+func TestExpireBans(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	_, err := db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "temp", CreatedAt: now.Add(-time.Hour), ExpiresAt: &past})
+	require.NoError(t, err)
+	_, err = db.AddBan(&schema.Ban{SteamID: "steam2", Reason: "temp", CreatedAt: now, ExpiresAt: &future})
+	require.NoError(t, err)
+	_, err = db.AddBan(&schema.Ban{SteamID: "steam3", Reason: "perm", CreatedAt: now})
+	require.NoError(t, err)
+
+	n, err := db.ExpireBans(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.False(t, flags.HasFlag(bitmask.BANNED))
+
+	bans, err := db.GetBans("steam1")
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+	assert.NotNil(t, bans[0].LiftedAt)
+	assert.Equal(t, "expired", bans[0].LiftReason)
+
+	for _, steamid := range []string{"steam2", "steam3"} {
+		flags, err := db.GetUserFlags(steamid)
+		require.NoError(t, err)
+		assert.True(t, flags.HasFlag(bitmask.BANNED), steamid)
+	}
+
+	n, err = db.ExpireBans(now)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestExpireBans_KeepsNewerBan(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+
+	_, err := db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "temp", CreatedAt: now.Add(-time.Hour), ExpiresAt: &past})
+	require.NoError(t, err)
+	// Banned again after the first ban ran out but before the expiry job ran.
+	_, err = db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "perm", CreatedAt: now})
+	require.NoError(t, err)
+
+	n, err := db.ExpireBans(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.True(t, flags.HasFlag(bitmask.BANNED))
+
+	ban, err := db.GetActiveBan("steam1")
+	require.NoError(t, err)
+	assert.Equal(t, "perm", ban.Reason)
+}
+
+func TestGetBans_Empty(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+
+	bans, err := db.GetBans("steam1")
+	require.NoError(t, err)
+	assert.Empty(t, bans)
+
+	_, err = db.GetActiveBan("steam1")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+// ─── Flag grants ─────────────────────────────────────────────────────────────
+
+func TestGrantUserFlag(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	expires := time.Now().UTC().Add(30 * 24 * time.Hour)
+
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{
+		SteamID: "steam1",
+		Bit: 1,
+		Source: "payment-123",
+		Admin: "127.0.0.1",
+		GrantedAt: time.Now().UTC(),
+		ExpiresAt: &expires,
+	}))
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.True(t, flags.HasFlag(bitmask.DONOR))
+
+	grants, err := db.GetFlagGrants("steam1")
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	assert.Equal(t, uint(1), grants[0].Bit)
+	assert.Equal(t, "payment-123", grants[0].Source)
+	assert.Equal(t, "127.0.0.1", grants[0].Admin)
+	require.NotNil(t, grants[0].ExpiresAt)
+	assert.WithinDuration(t, expires, *grants[0].ExpiresAt, time.Second)
+}
+
+func TestGrantUserFlag_Replaces(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	expires := time.Now().UTC().Add(time.Hour)
+
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 1, Source: "first", GrantedAt: time.Now().UTC(), ExpiresAt: &expires}))
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 1, Source: "second", GrantedAt: time.Now().UTC()}))
+
+	grants, err := db.GetFlagGrants("steam1")
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	assert.Equal(t, "second", grants[0].Source)
+	assert.Nil(t, grants[0].ExpiresAt)
+}
+
+func TestGrantUserFlag_UserNotFound(t *testing.T) {
+	db := newTestDB(t)
+	err := db.GrantUserFlag(&schema.FlagGrant{SteamID: "nobody", Bit: 1, GrantedAt: time.Now().UTC()})
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestExpireFlagGrants(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 1, GrantedAt: now.Add(-time.Hour), ExpiresAt: &past}))
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 2, GrantedAt: now, ExpiresAt: &future}))
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 3, GrantedAt: now}))
+
+	n, err := db.ExpireFlagGrants(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.False(t, flags.HasFlag(bitmask.DONOR))
+	assert.True(t, flags.HasFlag(bitmask.ADMIN))
+	assert.True(t, flags.HasFlag(1<<3))
+
+	grants, err := db.GetFlagGrants("steam1")
+	require.NoError(t, err)
+	require.Len(t, grants, 2)
+	assert.Equal(t, uint(2), grants[0].Bit)
+	assert.Equal(t, uint(3), grants[1].Bit)
+}
+
+func TestSetUserFlags_RemovesClearedGrants(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 1, GrantedAt: time.Now().UTC()}))
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 2, GrantedAt: time.Now().UTC()}))
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.ADMIN))
+
+	grants, err := db.GetFlagGrants("steam1")
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	assert.Equal(t, uint(2), grants[0].Bit)
+}
+
+func TestAddBan_RemovesBannedGrant(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	past := time.Now().UTC().Add(-time.Minute)
+
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 0, GrantedAt: time.Now().UTC().Add(-time.Hour), ExpiresAt: &past}))
+	_, err := db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "perm", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+
+	// The old grant can't unban the user anymore.
+	n, err := db.ExpireFlagGrants(time.Now().UTC())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.True(t, flags.HasFlag(bitmask.BANNED))
+}
+
+// ─── BulkUpdateFlags ─────────────────────────────────────────────────────────
+
+func TestBulkUpdateFlags(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.ADMIN|bitmask.DONOR))
+	expires := time.Now().UTC().Add(time.Hour)
+
+	results, err := db.BulkUpdateFlags([]database.FlagChange{
+		{SteamID: "steam1", Op: database.FlagRemove, Mask: bitmask.ADMIN},
+		{SteamID: "steam2", Op: database.FlagAdd, Mask: bitmask.DONOR, Source: "patreon", GrantedAt: time.Now().UTC(), ExpiresAt: &expires},
+		{SteamID: "steam1", Op: database.FlagSet, Mask: bitmask.BANNED, GrantedAt: time.Now().UTC()},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	assert.Equal(t, bitmask.ADMIN|bitmask.DONOR, results[0].Before)
+	assert.Equal(t, bitmask.DONOR, results[0].After)
+	assert.True(t, results[1].Created)
+	assert.Equal(t, bitmask.DONOR, results[1].After)
+	assert.Equal(t, bitmask.DONOR, results[2].Before)
+	assert.Equal(t, bitmask.BANNED, results[2].After)
+
+	flags, err := db.GetUserFlags("steam1")
+	require.NoError(t, err)
+	assert.Equal(t, bitmask.BANNED, flags)
+
+	grants, err := db.GetFlagGrants("steam2")
+	require.NoError(t, err)
+	require.Len(t, grants, 1)
+	assert.Equal(t, "patreon", grants[0].Source)
+	require.NotNil(t, grants[0].ExpiresAt)
+}
+
+func TestBulkUpdateFlags_DropsClearedGrants(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam1")
+	require.NoError(t, db.GrantUserFlag(&schema.FlagGrant{SteamID: "steam1", Bit: 1, GrantedAt: time.Now().UTC()}))
+
+	_, err := db.BulkUpdateFlags([]database.FlagChange{
+		{SteamID: "steam1", Op: database.FlagRemove, Mask: bitmask.DONOR},
+	})
+	require.NoError(t, err)
+
+	grants, err := db.GetFlagGrants("steam1")
+	require.NoError(t, err)
+	assert.Empty(t, grants)
+}
+
+func TestBulkUpdateFlags_BansAndLifts(t *testing.T) {
+	db := newTestDB(t)
+	expires := time.Now().UTC().Add(time.Hour)
+
+	_, err := db.BulkUpdateFlags([]database.FlagChange{
+		{SteamID: "steam1", Op: database.FlagAdd, Mask: bitmask.BANNED, Reason: "cheating", Admin: "admin1", GrantedAt: time.Now().UTC(), ExpiresAt: &expires},
+	})
+	require.NoError(t, err)
+
+	ban, err := db.GetActiveBan("steam1")
+	require.NoError(t, err)
+	assert.Equal(t, "cheating", ban.Reason)
+	assert.Equal(t, "admin1", ban.Admin)
+	require.NotNil(t, ban.ExpiresAt)
+
+	// The ban's expiry is kept on the ban, not as a grant.
+	grants, err := db.GetFlagGrants("steam1")
+	require.NoError(t, err)
+	assert.Empty(t, grants)
+
+	_, err = db.BulkUpdateFlags([]database.FlagChange{
+		{SteamID: "steam1", Op: database.FlagRemove, Mask: bitmask.BANNED, Reason: "appeal", Admin: "admin2", GrantedAt: time.Now().UTC()},
+	})
+	require.NoError(t, err)
+
+	_, err = db.GetActiveBan("steam1")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+
+	bans, err := db.GetBans("steam1")
+	require.NoError(t, err)
+	require.Len(t, bans, 1)
+	require.NotNil(t, bans[0].LiftedAt)
+	assert.Equal(t, "admin2", bans[0].LiftedBy)
+	assert.Equal(t, "appeal", bans[0].LiftReason)
+}
+
+// ─── Notes ───────────────────────────────────────────────────────────────────
+
+func TestAddUserNote(t *testing.T) {
+	db := newTestDB(t)
+	charID := uuid.New()
+
+	id, err := db.AddUserNote(&schema.UserNote{
+		SteamID: "steam1",
+		Author: "admin1",
+		Category: "warning",
+		CharacterID: &charID,
+		Body: "duped items",
+		CreatedAt: time.Now().UTC(),
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, id)
+
+	notes, err := db.GetUserNotes("steam1", "")
+	require.NoError(t, err)
+	require.Len(t, notes, 1)
+	assert.Equal(t, id, notes[0].ID)
+	assert.Equal(t, "admin1", notes[0].Author)
+	assert.Equal(t, "warning", notes[0].Category)
+	assert.Equal(t, "duped items", notes[0].Body)
+	require.NotNil(t, notes[0].CharacterID)
+	assert.Equal(t, charID, *notes[0].CharacterID)
+}
+
+func TestGetUserNotes_Category(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, category := range []string{"general", "warning", "warning"} {
+		_, err := db.AddUserNote(&schema.UserNote{SteamID: "steam1", Category: category, Body: "note", CreatedAt: time.Now().UTC()})
+		require.NoError(t, err)
+	}
+	_, err := db.AddUserNote(&schema.UserNote{SteamID: "steam2", Category: "warning", Body: "note", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+
+	notes, err := db.GetUserNotes("steam1", "warning")
+	require.NoError(t, err)
+	assert.Len(t, notes, 2)
+
+	notes, err = db.GetUserNotes("steam1", "")
+	require.NoError(t, err)
+	require.Len(t, notes, 3)
+	assert.Greater(t, notes[0].ID, notes[2].ID, "newest note should be first")
+	assert.Nil(t, notes[0].CharacterID)
+}
+
+func TestGetUserNoteSummary(t *testing.T) {
+	db := newTestDB(t)
+
+	summary, err := db.GetUserNoteSummary("steam1")
+	require.NoError(t, err)
+	assert.Zero(t, summary.Count)
+	assert.Nil(t, summary.Latest)
+
+	for _, body := range []string{"first", "second"} {
+		_, err := db.AddUserNote(&schema.UserNote{SteamID: "steam1", Category: "general", Body: body, CreatedAt: time.Now().UTC()})
+		require.NoError(t, err)
+	}
+	_, err = db.AddUserNote(&schema.UserNote{SteamID: "steam1", Category: "ban", Body: "third", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+
+	summary, err = db.GetUserNoteSummary("steam1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, summary.Count)
+	assert.Equal(t, map[string]int{"general": 2, "ban": 1}, summary.Categories)
+	require.NotNil(t, summary.Latest)
+	assert.Equal(t, "third", summary.Latest.Body)
+}
+
+// ─── Audit log ───────────────────────────────────────────────────────────────
+
+func auditEntry(steamid string, endpoint string) *schema.AuditEntry {
+	flags := uint32(1)
+	return &schema.AuditEntry{
+		CreatedAt: time.Now().UTC(),
+		IP: "127.0.0.1",
+		KeyID: "abc123",
+		Method: "PATCH",
+		Endpoint: endpoint,
+		Path: "/api/v2/user/ban/" + steamid,
+		Status: 200,
+		SteamID: steamid,
+		Reason: "cheating",
+		After: &schema.AuditState{Flags: &flags},
+	}
+}
+
+func TestAddAuditEntry_Chains(t *testing.T) {
+	db := newTestDB(t)
+
+	first := auditEntry("steam1", "/api/v2/user/ban/{steamid}")
+	_, err := db.AddAuditEntry(first)
+	require.NoError(t, err)
+	assert.Empty(t, first.PrevHash)
+	assert.NotEmpty(t, first.Hash)
+
+	second := auditEntry("steam2", "/api/v2/user/ban/{steamid}")
+	_, err = db.AddAuditEntry(second)
+	require.NoError(t, err)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	entries, err := db.GetAuditLog(database.AuditFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, second.Hash, entries[0].Hash, "newest entry should be first")
+	assert.Equal(t, "cheating", entries[0].Reason)
+	require.NotNil(t, entries[0].After)
+	require.NotNil(t, entries[0].After.Flags)
+	assert.Equal(t, uint32(1), *entries[0].After.Flags)
+	assert.Nil(t, entries[0].Before)
+
+	result, err := db.VerifyAuditLog()
+	require.NoError(t, err)
+	assert.True(t, result.OK)
+	assert.Equal(t, 2, result.Checked)
+}
+
+func TestGetAuditLog_Filter(t *testing.T) {
+	db := newTestDB(t)
+	charID := uuid.New()
+
+	_, err := db.AddAuditEntry(auditEntry("steam1", "/api/v2/user/ban/{steamid}"))
+	require.NoError(t, err)
+	_, err = db.AddAuditEntry(auditEntry("steam2", "/api/v2/user/ban/{steamid}"))
+	require.NoError(t, err)
+	e := auditEntry("steam1", "/api/v2/unsafe/character/delete/{uuid}")
+	e.CharacterID = &charID
+	_, err = db.AddAuditEntry(e)
+	require.NoError(t, err)
+
+	entries, err := db.GetAuditLog(database.AuditFilter{SteamID: "steam1", Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	entries, err = db.GetAuditLog(database.AuditFilter{Endpoint: "/api/v2/unsafe", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.NotNil(t, entries[0].CharacterID)
+	assert.Equal(t, charID, *entries[0].CharacterID)
+
+	entries, err = db.GetAuditLog(database.AuditFilter{CharacterID: &charID, Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	entries, err = db.GetAuditLog(database.AuditFilter{BeforeID: 3, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(2), entries[0].ID)
+}
+
+func TestVerifyAuditLog_Tampered(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, steamid := range []string{"steam1", "steam2", "steam3"} {
+		_, err := db.AddAuditEntry(auditEntry(steamid, "/api/v2/user/ban/{steamid}"))
+		require.NoError(t, err)
+	}
+
+	_, err := db.db.Exec(`UPDATE audit_log SET reason = 'nothing to see' WHERE id = 2`)
+	require.NoError(t, err)
+
+	result, err := db.VerifyAuditLog()
+	require.NoError(t, err)
+	assert.False(t, result.OK)
+	assert.Equal(t, int64(2), result.BrokenAt)
+}
+
+func TestVerifyAuditLog_Removed(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, steamid := range []string{"steam1", "steam2", "steam3"} {
+		_, err := db.AddAuditEntry(auditEntry(steamid, "/api/v2/user/ban/{steamid}"))
+		require.NoError(t, err)
+	}
+
+	_, err := db.db.Exec(`DELETE FROM audit_log WHERE id = 2`)
+	require.NoError(t, err)
+
+	result, err := db.VerifyAuditLog()
+	require.NoError(t, err)
+	assert.False(t, result.OK)
+	assert.Equal(t, int64(3), result.BrokenAt)
+}
+
+func TestVerifyAuditLog_Keyed(t *testing.T) {
+	cfg := database.Config{}
+	cfg.SQLite.Path = filepath.Join(t.TempDir(), "audit.db")
+
+	db := New()
+	require.NoError(t, db.Connect(cfg, database.Options{AuditKey: []byte("secret")}))
+	for _, steamid := range []string{"steam1", "steam2"} {
+		_, err := db.AddAuditEntry(auditEntry(steamid, "/api/v2/user/ban/{steamid}"))
+		require.NoError(t, err)
+	}
+
+	result, err := db.VerifyAuditLog()
+	require.NoError(t, err)
+	assert.True(t, result.OK)
+	require.NoError(t, db.Disconnect())
+
+	// The same chain checked with another key doesn't verify.
+	db = New()
+	require.NoError(t, db.Connect(cfg, database.Options{AuditKey: []byte("guessed")}))
+	t.Cleanup(func() { _ = db.Disconnect() })
+
+	result, err = db.VerifyAuditLog()
+	require.NoError(t, err)
+	assert.False(t, result.OK)
+	assert.Equal(t, int64(1), result.BrokenAt)
+}
+
+// ─── Approvals ───────────────────────────────────────────────────────────────
+
+func seedApproval(t *testing.T, db *sqliteDB, expiresIn time.Duration) int64 {
+	t.Helper()
+	now := time.Now().UTC()
+	id, err := db.AddApproval(&schema.Approval{
+		Method: "DELETE",
+		Path: "/api/v2/unsafe/character/delete/" + uuid.NewString(),
+		Endpoint: "/api/v2/unsafe/character/delete/{uuid}",
+		Reason: "duped items",
+		RequestedBy: "key1",
+		RequestedIP: "127.0.0.1",
+		Status: database.ApprovalPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(expiresIn),
+	})
+	require.NoError(t, err)
+	return id
+}
+
+func TestDecideApproval(t *testing.T) {
+	db := newTestDB(t)
+	id := seedApproval(t, db, time.Hour)
+
+	require.NoError(t, db.DecideApproval(id, database.ApprovalApproved, "key2", "127.0.0.2", time.Now().UTC()))
+	require.NoError(t, db.SetApprovalResult(id, 200, `{"status":true}`))
+
+	a, err := db.GetApproval(id)
+	require.NoError(t, err)
+	assert.Equal(t, database.ApprovalApproved, a.Status)
+	assert.Equal(t, "key2", a.DecidedBy)
+	assert.Equal(t, "127.0.0.2", a.DecidedIP)
+	assert.NotNil(t, a.DecidedAt)
+	assert.Equal(t, 200, a.ResultStatus)
+	assert.Equal(t, `{"status":true}`, a.Result)
+
+	err = db.DecideApproval(id, database.ApprovalRejected, "key3", "127.0.0.3", time.Now().UTC())
+	assert.ErrorIs(t, err, database.ErrNoDocument, "only pending approvals can be decided")
+}
+
+func TestDecideApproval_Expired(t *testing.T) {
 	db := newTestDB(t)
-	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	id := seedApproval(t, db, -time.Minute)
 
-	// Create a version by updating once (backupMax>0, backupTime=0 means always snapshot).
-	require.NoError(t, db.UpdateCharacter(id, 2, "v1", 5, 0))
-	flush(t, db)
+	err := db.DecideApproval(id, database.ApprovalApproved, "key2", "127.0.0.2", time.Now().UTC())
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
 
-	// Rollback to version index 0 (the "v0" snapshot).
-	require.NoError(t, db.RollbackCharacter(id, 0))
+func TestExpireApprovals(t *testing.T) {
+	db := newTestDB(t)
+	expired := seedApproval(t, db, -time.Minute)
+	pending := seedApproval(t, db, time.Hour)
 
-	c, err := db.GetCharacter(id)
+	n, err := db.ExpireApprovals(time.Now().UTC())
 	require.NoError(t, err)
-	assert.Equal(t, "v0", c.Data.Data)
-	assert.Equal(t, 1, c.Data.Size)
+	assert.Equal(t, 1, n)
+
+	a, err := db.GetApproval(expired)
+	require.NoError(t, err)
+	assert.Equal(t, database.ApprovalExpired, a.Status)
+
+	approvals, err := db.GetApprovals(database.ApprovalPending, 10)
+	require.NoError(t, err)
+	require.Len(t, approvals, 1)
+	assert.Equal(t, pending, approvals[0].ID)
+
+	approvals, err = db.GetApprovals("", 10)
+	require.NoError(t, err)
+	assert.Len(t, approvals, 2)
 }
 
-func TestRollbackCharacter_InvalidIndex(t *testing.T) {
+func TestGetApproval_NotFound(t *testing.T) {
 	db := newTestDB(t)
-	id := seedCharacter(t, db, "steam1", 0, 1, "data")
-	err := db.RollbackCharacter(id, 99)
-	assert.Error(t, err)
+	_, err := db.GetApproval(42)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
 }
 
-func TestRollbackCharacterToLatest(t *testing.T) {
+// ─── Jobs ────────────────────────────────────────────────────────────────────
+
+func seedJob(t *testing.T, db *sqliteDB, kind string, createdAt time.Time) *schema.Job {
+	t.Helper()
+	job := &schema.Job{
+		ID: uuid.New(),
+		Kind: kind,
+		Status: database.JobQueued,
+		Params: []byte(`{"a":1}`),
+		RequestedBy: "key1",
+		CreatedAt: createdAt,
+	}
+	require.NoError(t, db.AddJob(job))
+	return job
+}
+
+func TestAddJob_GetJob(t *testing.T) {
 	db := newTestDB(t)
-	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	job := seedJob(t, db, "gc", time.Now().UTC())
 
-	require.NoError(t, db.UpdateCharacter(id, 2, "v1", 5, 0))
-	flush(t, db)
-	require.NoError(t, db.UpdateCharacter(id, 3, "v2", 5, 0))
-	flush(t, db)
+	got, err := db.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "gc", got.Kind)
+	assert.Equal(t, database.JobQueued, got.Status)
+	assert.JSONEq(t, `{"a":1}`, string(got.Params))
+	assert.Nil(t, got.Result)
+	assert.Nil(t, got.StartedAt)
+	assert.Equal(t, "key1", got.RequestedBy)
+}
 
-	// Manually clobber the current data to simulate corruption.
-	require.NoError(t, db.UpdateCharacter(id, 0, "corrupt", 0, 0))
-	flush(t, db)
+func TestUpdateJob(t *testing.T) {
+	db := newTestDB(t)
+	job := seedJob(t, db, "gc", time.Now().UTC())
+
+	now := time.Now().UTC()
+	job.Status = database.JobDone
+	job.Progress = 3
+	job.Total = 3
+	job.Result = []byte(`true`)
+	job.StartedAt = &now
+	job.FinishedAt = &now
+	require.NoError(t, db.UpdateJob(job))
+
+	got, err := db.GetJob(job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, database.JobDone, got.Status)
+	assert.Equal(t, 3, got.Progress)
+	assert.Equal(t, "true", string(got.Result))
+	require.NotNil(t, got.FinishedAt)
+	assert.WithinDuration(t, now, *got.FinishedAt, time.Second)
+}
 
-	require.NoError(t, db.RollbackCharacterToLatest(id))
+func TestUpdateJob_NotFound(t *testing.T) {
+	db := newTestDB(t)
+	err := db.UpdateJob(&schema.Job{ID: uuid.New(), Status: database.JobDone})
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
 
-	c, err := db.GetCharacter(id)
+func TestGetJobs_Filters(t *testing.T) {
+	db := newTestDB(t)
+	old := seedJob(t, db, "gc", time.Now().UTC().Add(-time.Hour))
+	newer := seedJob(t, db, "audit_verify", time.Now().UTC())
+
+	jobs, err := db.GetJobs("", "", 10)
 	require.NoError(t, err)
-	// Should have rolled back to the latest version (v2, since backupMax was 5).
-	assert.NotEqual(t, "corrupt", c.Data.Data)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, newer.ID, jobs[0].ID)
+	assert.Equal(t, old.ID, jobs[1].ID)
+
+	jobs, err = db.GetJobs(database.JobQueued, "gc", 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, old.ID, jobs[0].ID)
+
+	jobs, err = db.GetJobs(database.JobRunning, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, jobs)
 }
 
-func TestRollbackCharacterToLatest_NoVersions(t *testing.T) {
+func TestPruneJobs_KeepsUnfinished(t *testing.T) {
 	db := newTestDB(t)
-	id := seedCharacter(t, db, "steam1", 0, 1, "data")
-	err := db.RollbackCharacterToLatest(id)
-	assert.Error(t, err)
+	done := seedJob(t, db, "gc", time.Now().UTC().Add(-48*time.Hour))
+	queued := seedJob(t, db, "gc", time.Now().UTC().Add(-48*time.Hour))
+
+	finished := time.Now().UTC().Add(-24 * time.Hour)
+	done.Status = database.JobDone
+	done.FinishedAt = &finished
+	require.NoError(t, db.UpdateJob(done))
+
+	n, err := db.PruneJobs(time.Now().UTC().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = db.GetJob(done.ID)
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+	_, err = db.GetJob(queued.ID)
+	assert.NoError(t, err)
 }
 
-// ─── DeleteCharacterVersions ─────────────────────────────────────────────────
+// ─── Search ──────────────────────────────────────────────────────────────────
 
-func TestDeleteCharacterVersions(t *testing.T) {
+func TestSearchUsers_Filters(t *testing.T) {
 	db := newTestDB(t)
-	id := seedCharacter(t, db, "steam1", 0, 1, "v0")
+	seedCharacter(t, db, "steam1", 0, 1, "a")
+	seedCharacter(t, db, "steam1", 1, 1, "b")
+	seedCharacter(t, db, "steam2", 0, 1, "a")
+	seedCharacter(t, db, "steam3", 0, 1, "a")
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.DONOR|bitmask.ADMIN))
+	require.NoError(t, db.SetUserFlags("steam2", bitmask.DONOR))
+
+	ids := func(filter database.UserFilter) []string {
+		filter.Limit = 10
+		users, err := db.SearchUsers(filter)
+		require.NoError(t, err)
+		ids := make([]string, 0, len(users))
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		return ids
+	}
+
+	two := 2
+	one := 1
+	assert.Equal(t, []string{"steam1", "steam2", "steam3"}, ids(database.UserFilter{}))
+	assert.Equal(t, []string{"steam1", "steam2"}, ids(database.UserFilter{Flags: bitmask.DONOR}))
+	assert.Equal(t, []string{"steam1"}, ids(database.UserFilter{Flags: bitmask.DONOR | bitmask.ADMIN}))
+	assert.Equal(t, []string{"steam2", "steam3"}, ids(database.UserFilter{WithoutFlags: bitmask.ADMIN}))
+	assert.Equal(t, []string{"steam1"}, ids(database.UserFilter{MinCharacters: &two}))
+	assert.Equal(t, []string{"steam2", "steam3"}, ids(database.UserFilter{MaxCharacters: &one}))
+
+	future := time.Now().UTC().Add(time.Hour)
+	assert.Empty(t, ids(database.UserFilter{CreatedSince: &future}))
+	assert.Len(t, ids(database.UserFilter{CreatedUntil: &future}), 3)
+
+	users, err := db.SearchUsers(database.UserFilter{Flags: bitmask.ADMIN, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, 2, users[0].Characters)
+	assert.Equal(t, uint32(bitmask.DONOR|bitmask.ADMIN), users[0].Flags)
+	assert.False(t, users[0].CreatedAt.IsZero())
+}
+
+func TestSearchUsers_Paging(t *testing.T) {
+	db := newTestDB(t)
+	for i := 1; i <= 5; i++ {
+		seedUser(t, db, fmt.Sprintf("steam%d", i))
+	}
+
+	var seen []string
+	filter := database.UserFilter{Limit: 2}
+	for {
+		users, err := db.SearchUsers(filter)
+		require.NoError(t, err)
+		if len(users) == 0 {
+			break
+		}
+		for _, u := range users {
+			seen = append(seen, u.ID)
+		}
+		filter.After = users[len(users)-1].ID
+	}
+	assert.Equal(t, []string{"steam1", "steam2", "steam3", "steam4", "steam5"}, seen)
+}
+
+func TestSearchCharacters_Filters(t *testing.T) {
+	db := newTestDB(t)
+	small := seedCharacter(t, db, "steam1", 0, 10, "a")
+	big := seedCharacter(t, db, "steam1", 1, 1000, "b")
+	other := seedCharacter(t, db, "steam2", 0, 500, "c")
+	require.NoError(t, db.SoftDeleteCharacter(other, time.Hour))
+
+	ids := func(filter database.CharacterFilter) []uuid.UUID {
+		filter.Limit = 10
+		chars, err := db.SearchCharacters(filter)
+		require.NoError(t, err)
+		ids := make([]uuid.UUID, 0, len(chars))
+		for _, c := range chars {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	yes, no := true, false
+	minSize, maxSize := 100, 600
+	assert.Len(t, ids(database.CharacterFilter{}), 3)
+	assert.ElementsMatch(t, []uuid.UUID{small, big}, ids(database.CharacterFilter{SteamID: "steam1"}))
+	assert.ElementsMatch(t, []uuid.UUID{big, other}, ids(database.CharacterFilter{MinSize: &minSize}))
+	assert.ElementsMatch(t, []uuid.UUID{small, other}, ids(database.CharacterFilter{MaxSize: &maxSize}))
+	assert.Equal(t, []uuid.UUID{other}, ids(database.CharacterFilter{Deleted: &yes}))
+	assert.ElementsMatch(t, []uuid.UUID{small, big}, ids(database.CharacterFilter{Deleted: &no}))
+
+	future := time.Now().UTC().Add(time.Hour)
+	assert.Empty(t, ids(database.CharacterFilter{ModifiedSince: &future}))
+	assert.Len(t, ids(database.CharacterFilter{ModifiedUntil: &future}), 3)
+
+	chars, err := db.SearchCharacters(database.CharacterFilter{Deleted: &yes, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, chars, 1)
+	assert.Equal(t, "steam2", chars[0].SteamID)
+	assert.Equal(t, 500, chars[0].Size)
+	assert.NotNil(t, chars[0].DeletedAt)
+	assert.NotNil(t, chars[0].ExpiresAt)
+	assert.NotNil(t, chars[0].ModifiedAt)
+}
+
+func TestSearchCharacters_Paging(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 5; i++ {
+		seedCharacter(t, db, "steam1", i, 1, "a")
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	filter := database.CharacterFilter{Limit: 2}
+	for {
+		chars, err := db.SearchCharacters(filter)
+		require.NoError(t, err)
+		if len(chars) == 0 {
+			break
+		}
+		for _, c := range chars {
+			assert.False(t, seen[c.ID], "character listed twice")
+			seen[c.ID] = true
+		}
+		filter.After = &chars[len(chars)-1].ID
+	}
+	assert.Len(t, seen, 5)
+}
+
+// ─── Changes ─────────────────────────────────────────────────────────────────
 
-	require.NoError(t, db.UpdateCharacter(id, 2, "v1", 5, 0))
+func changeKinds(changes []schema.Change) []string {
+	kinds := make([]string, 0, len(changes))
+	for _, c := range changes {
+		kinds = append(kinds, c.Kind)
+	}
+	return kinds
+}
+
+func TestGetChanges_CharacterLifecycle(t *testing.T) {
+	db := newTestDB(t)
+	seedUser(t, db, "steam2")
+	id := seedCharacter(t, db, "steam1", 2, 10, "v1")
+
+	require.NoError(t, db.UpdateCharacter(id, 20, "v2", "", 0, 0))
 	flush(t, db)
+	require.NoError(t, db.SoftDeleteCharacter(id, time.Hour))
+	require.NoError(t, db.RestoreCharacter(id, nil, "admin"))
+	require.NoError(t, db.MoveCharacter(id, "steam2", 1, "admin"))
+	copyID, err := db.CopyCharacter(id, "steam1", 3, "admin")
+	require.NoError(t, err)
+	require.NoError(t, db.DeleteCharacter(id))
 
-	require.NoError(t, db.DeleteCharacterVersions(id))
+	changes, err := db.GetChanges(0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		database.ChangeCharacterCreated, // seeded steam2
+		database.ChangeCharacterCreated,
+		database.ChangeCharacterUpdated,
+		database.ChangeCharacterDeleted,
+		database.ChangeCharacterRestored,
+		database.ChangeCharacterMoved,
+		database.ChangeCharacterCopied,
+		database.ChangeCharacterPurged,
+	}, changeKinds(changes))
+
+	for i := 1; i < len(changes); i++ {
+		assert.Greater(t, changes[i].ID, changes[i-1].ID)
+	}
 
-	c, err := db.GetCharacter(id)
+	deleted := changes[3]
+	require.NotNil(t, deleted.CharacterID)
+	assert.Equal(t, id, *deleted.CharacterID)
+	assert.Equal(t, "steam1", deleted.SteamID)
+	require.NotNil(t, deleted.Slot)
+	assert.Equal(t, 2, *deleted.Slot, "soft deleted characters keep the slot they were deleted from")
+
+	moved := changes[5]
+	assert.Equal(t, "steam2", moved.SteamID)
+	assert.Equal(t, 1, *moved.Slot)
+
+	copied := changes[6]
+	assert.Equal(t, copyID, *copied.CharacterID)
+	require.NotNil(t, copied.SourceID)
+	assert.Equal(t, id, *copied.SourceID)
+	assert.Equal(t, 3, *copied.Slot)
+
+	purged := changes[7]
+	assert.Equal(t, id, *purged.CharacterID)
+	assert.Equal(t, "steam2", purged.SteamID)
+}
+
+func TestGetChanges_Since(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 5; i++ {
+		seedCharacter(t, db, "steam1", i, 1, "x")
+	}
+
+	first, err := db.GetChanges(0, 2)
 	require.NoError(t, err)
-	assert.Empty(t, c.Versions)
+	require.Len(t, first, 2)
+
+	rest, err := db.GetChanges(first[1].ID, 10)
+	require.NoError(t, err)
+	require.Len(t, rest, 3)
+	assert.Greater(t, rest[0].ID, first[1].ID)
+
+	none, err := db.GetChanges(rest[2].ID, 10)
+	require.NoError(t, err)
+	assert.Empty(t, none)
 }
 
-func TestDeleteCharacterVersions_NoVersions(t *testing.T) {
+func TestGetChanges_UserFlags(t *testing.T) {
 	db := newTestDB(t)
-	id := seedCharacter(t, db, "steam1", 0, 1, "data")
-	// Should succeed even if there are no versions to delete.
-	assert.NoError(t, db.DeleteCharacterVersions(id))
+	seedUser(t, db, "steam1")
+	require.NoError(t, db.SetUserFlags("steam1", bitmask.DONOR|bitmask.ADMIN))
+	_, err := db.AddBan(&schema.Ban{SteamID: "steam1", CreatedAt: time.Now().UTC()})
+	require.NoError(t, err)
+
+	changes, err := db.GetChanges(1, 10)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	for _, c := range changes {
+		assert.Equal(t, database.ChangeUserFlags, c.Kind)
+		assert.Equal(t, "steam1", c.SteamID)
+		assert.Nil(t, c.CharacterID)
+	}
+	assert.Equal(t, uint32(bitmask.DONOR|bitmask.ADMIN), *changes[0].Flags)
+	assert.Equal(t, uint32(bitmask.BANNED|bitmask.DONOR|bitmask.ADMIN), *changes[1].Flags)
+}
+
+func TestRunGC_RecordsPurgedCharacters(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 4, 10, "data")
+	require.NoError(t, db.SoftDeleteCharacter(id, -1*time.Second))
+	require.NoError(t, db.RunGC())
+
+	changes, err := db.GetChanges(0, 10)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	purged := changes[2]
+	assert.Equal(t, database.ChangeCharacterPurged, purged.Kind)
+	assert.Equal(t, id, *purged.CharacterID)
+	assert.Equal(t, "steam1", purged.SteamID)
+	assert.Equal(t, 4, *purged.Slot)
+}
+
+func TestPruneChanges(t *testing.T) {
+	db := newTestDB(t)
+	seedCharacter(t, db, "steam1", 0, 1, "x")
+
+	n, err := db.PruneChanges(time.Now().UTC().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	n, err = db.PruneChanges(time.Now().UTC().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	changes, err := db.GetChanges(0, 10)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestChangeKept(t *testing.T) {
+	db := newTestDB(t)
+	seedCharacter(t, db, "steam1", 0, 1, "x")
+	seedCharacter(t, db, "steam1", 1, 1, "y")
+
+	kept, latest, err := db.ChangeKept(1)
+	require.NoError(t, err)
+	assert.True(t, kept)
+	assert.Equal(t, int64(2), latest)
+
+	_, err = db.db.Exec(`DELETE FROM changes WHERE id = 1`)
+	require.NoError(t, err)
+
+	kept, latest, err = db.ChangeKept(1)
+	require.NoError(t, err)
+	assert.False(t, kept)
+	assert.Equal(t, int64(2), latest)
+}
+
+// ─── Webhooks ────────────────────────────────────────────────────────────────
+
+func addDelivery(t *testing.T, db database.Database, endpoint string, event string, next time.Time) int64 {
+	t.Helper()
+	id, err := db.AddWebhookDelivery(&schema.WebhookDelivery{
+		Endpoint: endpoint,
+		Event: event,
+		Body: `{"event":"` + event + `"}`,
+		Status: database.WebhookPending,
+		NextAttempt: &next,
+		CreatedAt: time.Now().UTC(),
+	})
+	require.NoError(t, err)
+	return id
+}
+
+func TestGetDueWebhookDeliveries(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+
+	first := addDelivery(t, db, "discord", "ban.created", now.Add(-time.Minute))
+	second := addDelivery(t, db, "audit", "ban.created", now)
+	addDelivery(t, db, "audit", "ban.lifted", now.Add(time.Minute))
+
+	due, err := db.GetDueWebhookDeliveries(now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 2, "deliveries scheduled after now aren't due")
+	assert.Equal(t, first, due[0].ID, "oldest first")
+	assert.Equal(t, second, due[1].ID)
+	assert.Equal(t, "discord", due[0].Endpoint)
+	assert.Equal(t, `{"event":"ban.created"}`, due[0].Body)
+	assert.Equal(t, 0, due[0].Attempts)
+
+	due, err = db.GetDueWebhookDeliveries(now, 1)
+	require.NoError(t, err)
+	assert.Len(t, due, 1)
+}
+
+func TestUpdateWebhookDelivery(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+	id := addDelivery(t, db, "discord", "ban.created", now)
+
+	due, err := db.GetDueWebhookDeliveries(now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+
+	// a failed attempt is retried later
+	retry := now.Add(time.Hour)
+	d := due[0]
+	d.Attempts = 1
+	d.ResponseCode = 500
+	d.Error = "endpoint answered 500"
+	d.NextAttempt = &retry
+	require.NoError(t, db.UpdateWebhookDelivery(&d))
+
+	due, err = db.GetDueWebhookDeliveries(now, 10)
+	require.NoError(t, err)
+	assert.Empty(t, due)
+
+	// then delivered
+	d.Attempts = 2
+	d.ResponseCode = 204
+	d.Error = ""
+	d.Status = database.WebhookDelivered
+	d.NextAttempt = nil
+	d.FinishedAt = &now
+	require.NoError(t, db.UpdateWebhookDelivery(&d))
+
+	deliveries, err := db.GetWebhookDeliveries(database.WebhookFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	got := deliveries[0]
+	assert.Equal(t, id, got.ID)
+	assert.Equal(t, database.WebhookDelivered, got.Status)
+	assert.Equal(t, 2, got.Attempts)
+	assert.Equal(t, 204, got.ResponseCode)
+	assert.Empty(t, got.Error)
+	assert.Nil(t, got.NextAttempt)
+	require.NotNil(t, got.FinishedAt)
+	assert.WithinDuration(t, now, *got.FinishedAt, time.Second)
+
+	d.ID = id + 100
+	assert.ErrorIs(t, db.UpdateWebhookDelivery(&d), database.ErrNoDocument)
+}
+
+func TestGetWebhookDeliveries_Filter(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+
+	addDelivery(t, db, "discord", "ban.created", now)
+	addDelivery(t, db, "audit", "ban.created", now)
+	failed := addDelivery(t, db, "audit", "unsafe.delete", now)
+	require.NoError(t, db.UpdateWebhookDelivery(&schema.WebhookDelivery{
+		ID: failed, Status: database.WebhookFailed, Attempts: 8, Error: "endpoint answered 404", FinishedAt: &now,
+	}))
+
+	all, err := db.GetWebhookDeliveries(database.WebhookFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, failed, all[0].ID, "newest first")
+
+	byEndpoint, err := db.GetWebhookDeliveries(database.WebhookFilter{Endpoint: "audit", Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, byEndpoint, 2)
+
+	byEvent, err := db.GetWebhookDeliveries(database.WebhookFilter{Event: "ban.created", Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, byEvent, 2)
+
+	byStatus, err := db.GetWebhookDeliveries(database.WebhookFilter{Status: database.WebhookFailed, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, byStatus, 1)
+	assert.Equal(t, "endpoint answered 404", byStatus[0].Error)
+
+	limited, err := db.GetWebhookDeliveries(database.WebhookFilter{Limit: 1})
+	require.NoError(t, err)
+	assert.Len(t, limited, 1)
+}
+
+func TestPruneWebhookDeliveries(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+	old := now.Add(-48 * time.Hour)
+
+	pending := addDelivery(t, db, "audit", "ban.created", old)
+	delivered := addDelivery(t, db, "audit", "ban.created", old)
+	failed := addDelivery(t, db, "audit", "ban.created", old)
+	recent := addDelivery(t, db, "audit", "ban.created", now)
+	require.NoError(t, db.UpdateWebhookDelivery(&schema.WebhookDelivery{ID: delivered, Status: database.WebhookDelivered, FinishedAt: &old}))
+	require.NoError(t, db.UpdateWebhookDelivery(&schema.WebhookDelivery{ID: failed, Status: database.WebhookFailed, FinishedAt: &old}))
+	require.NoError(t, db.UpdateWebhookDelivery(&schema.WebhookDelivery{ID: recent, Status: database.WebhookDelivered, FinishedAt: &now}))
+
+	n, err := db.PruneWebhookDeliveries(now.Add(-24 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	left, err := db.GetWebhookDeliveries(database.WebhookFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, left, 2)
+	assert.Equal(t, recent, left[0].ID)
+	assert.Equal(t, pending, left[1].ID, "pending deliveries are never pruned")
+}
+
+// eventOutbox queues one delivery per event, with the summary as its body.
+type eventOutbox struct{}
+
+func (eventOutbox) Deliveries(event database.Event) ([]schema.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	return []schema.WebhookDelivery{{
+		Endpoint: "test",
+		Event: event.Kind,
+		Body: event.Summary,
+		Status: database.WebhookPending,
+		NextAttempt: &now,
+		CreatedAt: now,
+	}}, nil
+}
+
+// snapshots lists a character's snapshots oldest first as "operation: data".
+func snapshots(t *testing.T, db *sqliteDB, id uuid.UUID) []string {
+	t.Helper()
+	rows, err := db.db.Query(`
+		SELECT operation, data_payload FROM character_snapshots
+		WHERE character_id = ? ORDER BY id`,
+		id.String(),
+	)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var op, data string
+		require.NoError(t, rows.Scan(&op, &data))
+		out = append(out, op+": "+data)
+	}
+	require.NoError(t, rows.Err())
+	return out
+}
+
+func queuedEvents(t *testing.T, db database.Database) []string {
+	t.Helper()
+	deliveries, err := db.GetWebhookDeliveries(database.WebhookFilter{Limit: 100})
+	require.NoError(t, err)
+
+	events := make([]string, 0, len(deliveries))
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		events = append(events, deliveries[i].Event+": "+deliveries[i].Body)
+	}
+	return events
+}
+
+func TestWithEvent_QueuedWithTheWrite(t *testing.T) {
+	db := newTestDBWith(t, database.Options{Outbox: eventOutbox{}})
+
+	uid, err := db.NewCharacter("steam1", 0, 1, "data")
+	require.NoError(t, err)
+
+	event := database.Event{Kind: database.EventUnsafeMove, Summary: "moved"}
+	require.NoError(t, db.WithEvent(event).MoveCharacter(uid, "steam1", 1, "admin"))
+
+	// Nothing is queued for a write that fails or is rolled back.
+	assert.Error(t, db.WithEvent(event).MoveCharacter(uuid.New(), "steam1", 2, "admin"))
+	require.NoError(t, db.WithEvent(event).DryRun().MoveCharacter(uid, "steam1", 2, "admin"))
+
+	// Writes made without the view queue nothing either.
+	require.NoError(t, db.MoveCharacter(uid, "steam1", 3, "admin"))
+
+	assert.Equal(t, []string{"unsafe.move: moved"}, queuedEvents(t, db))
+}
+
+func TestBanEvents(t *testing.T) {
+	db := newTestDBWith(t, database.Options{Outbox: eventOutbox{}})
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute)
+
+	_, err := db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "cheating", CreatedAt: now})
+	require.NoError(t, err)
+	// replacing the ban doesn't lift it as far as webhooks are concerned
+	_, err = db.AddBan(&schema.Ban{SteamID: "steam1", Reason: "still cheating", CreatedAt: now})
+	require.NoError(t, err)
+	require.NoError(t, db.LiftBan("steam1", "admin1", "appeal"))
+
+	_, err = db.BulkUpdateFlags([]database.FlagChange{
+		{SteamID: "steam2", Op: database.FlagAdd, Mask: bitmask.BANNED, Reason: "alt", Admin: "admin1", GrantedAt: now},
+	})
+	require.NoError(t, err)
+	_, err = db.BulkUpdateFlags([]database.FlagChange{
+		{SteamID: "steam2", Op: database.FlagRemove, Mask: bitmask.BANNED, Reason: "not an alt", Admin: "admin1", GrantedAt: now},
+	})
+	require.NoError(t, err)
+
+	id, err := db.AddBan(&schema.Ban{SteamID: "steam3", Reason: "temp", CreatedAt: now.Add(-time.Hour), ExpiresAt: &past})
+	require.NoError(t, err)
+	_, err = db.ExpireBans(now)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"ban.created: steam1 was banned: cheating",
+		"ban.created: steam1 was banned: still cheating",
+		"ban.lifted: steam1 was unbanned: appeal",
+		"ban.created: steam2 was banned: alt",
+		"ban.lifted: steam2 was unbanned by a bulk flag change: not an alt",
+		"ban.created: steam3 was banned: temp",
+		fmt.Sprintf("ban.lifted: ban %d of steam3 expired", id),
+	}, queuedEvents(t, db))
+}
+
+func TestQuiet_QueuesNoEvents(t *testing.T) {
+	db := newTestDBWith(t, database.Options{Outbox: eventOutbox{}})
+	now := time.Now().UTC()
+
+	_, err := db.Quiet().BulkUpdateFlags([]database.FlagChange{
+		{SteamID: "steam1", Op: database.FlagAdd, Mask: bitmask.BANNED, Reason: "listed", Admin: "banlist", GrantedAt: now},
+		{SteamID: "steam2", Op: database.FlagAdd, Mask: bitmask.BANNED, Reason: "listed", Admin: "banlist", GrantedAt: now},
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Quiet().LiftBan("steam1", "banlist", "removed from ban list"))
+
+	active, err := db.GetActiveBan("steam2")
+	require.NoError(t, err)
+	assert.Equal(t, "listed", active.Reason)
+	assert.Empty(t, queuedEvents(t, db))
+
+	// the database it was made from still queues them
+	require.NoError(t, db.LiftBan("steam2", "admin1", "appeal"))
+	assert.Equal(t, []string{"ban.lifted: steam2 was unbanned: appeal"}, queuedEvents(t, db))
 }
 
 // ─── SyncToDisk / RunGC ──────────────────────────────────────────────────────
@@ -602,7 +3225,7 @@ func TestRunGC_FlushesBeforeGC(t *testing.T) {
 	db := newTestDB(t)
 	id := seedCharacter(t, db, "steam1", 0, 1, "old")
 
-	require.NoError(t, db.UpdateCharacter(id, 99, "new", 0, 0))
+	require.NoError(t, db.UpdateCharacter(id, 99, "new", "", 0, 0))
 	// RunGC should flush the pending update before running the GC query.
 	require.NoError(t, db.RunGC())
 
@@ -635,6 +3258,49 @@ func TestGetAllUsers_CharacterMapsAreInitialized(t *testing.T) {
 	assert.NotNil(t, users[0].DeletedCharacters)
 }
 
+// ─── DryRun ──────────────────────────────────────────────────────────────────
+
+func TestDryRun_RollsBack(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedUser(t, db, "steam2")
+
+	require.NoError(t, db.DryRun().MoveCharacter(id, "steam2", 3, ""))
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "steam1", c.SteamID)
+	assert.Equal(t, 0, c.Slot)
+
+	journal, err := db.GetJournal(10)
+	require.NoError(t, err)
+	assert.Empty(t, journal)
+}
+
+func TestDryRun_FailsLikeTheOperation(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+	seedCharacter(t, db, "steam2", 1, 10, "other")
+
+	err := db.DryRun().MoveCharacter(id, "steam2", 1, "")
+	assert.ErrorIs(t, err, database.ErrSlotOccupied)
+
+	_, err = db.DryRun().CopyCharacter(uuid.New(), "steam2", 2, "")
+	assert.ErrorIs(t, err, database.ErrNoDocument)
+}
+
+func TestDryRun_UpdateCharacterNotQueued(t *testing.T) {
+	db := newTestDB(t)
+	id := seedCharacter(t, db, "steam1", 0, 10, "data")
+
+	require.NoError(t, db.DryRun().UpdateCharacter(id, 20, "updated", "", 10, 0))
+	flush(t, db)
+
+	c, err := db.GetCharacter(id)
+	require.NoError(t, err)
+	assert.Equal(t, "data", c.Data.Data)
+}
+
 // ─── Concurrency / coalescing sanity check ───────────────────────────────────
 
 func TestUpdateCharacter_ConcurrentUpdatesCoalesce(t *testing.T) {
@@ -646,7 +3312,7 @@ func TestUpdateCharacter_ConcurrentUpdatesCoalesce(t *testing.T) {
 	for i := 0; i < workers; i++ {
 		i := i
 		go func() {
-			_ = db.UpdateCharacter(id, i, fmt.Sprintf("payload-%d", i), 0, 0)
+			_ = db.UpdateCharacter(id, i, fmt.Sprintf("payload-%d", i), "", 0, 0)
 			done <- struct{}{}
 		}()
 	}
@@ -3,6 +3,7 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/internal/database"
@@ -14,7 +15,7 @@ import (
 func (d *sqliteDB) GetAllUsers() ([]*schema.User, error) {
 	rows, err := d.db.Query(`
 		SELECT
-			u.id, u.revision, u.flags,
+			u.id, u.revision, u.flags, COALESCE(u.merged_into, ''),
 			c.slot         AS char_slot,
 			c.id           AS char_id,
 			dc.slot        AS del_slot,
@@ -38,12 +39,13 @@ func (d *sqliteDB) GetAllUsers() ([]*schema.User, error) {
 			id string
 			revision int
 			flags uint32
+			mergedInto string
 			charSlot *int
 			charID *string
 			delSlot *int
 			delID *string
 		)
-		if err := rows.Scan(&id, &revision, &flags, &charSlot, &charID, &delSlot, &delID); err != nil {
+		if err := rows.Scan(&id, &revision, &flags, &mergedInto, &charSlot, &charID, &delSlot, &delID); err != nil {
 			return nil, err
 		}
 
@@ -53,6 +55,7 @@ func (d *sqliteDB) GetAllUsers() ([]*schema.User, error) {
 				ID: id,
 				Revision: revision,
 				Flags: flags,
+				MergedInto: mergedInto,
 				Characters: make(map[int]uuid.UUID),
 				DeletedCharacters: make(map[int]uuid.UUID),
 			}
@@ -89,7 +92,7 @@ func (d *sqliteDB) GetAllUsers() ([]*schema.User, error) {
 func (d *sqliteDB) GetUser(steamid string) (*schema.User, error) {
 	rows, err := d.db.Query(`
 		SELECT
-			u.id, u.revision, u.flags,
+			u.id, u.revision, u.flags, COALESCE(u.merged_into, ''),
 			c.slot          AS char_slot,
 			c.id            AS char_id,
 			dc.slot         AS del_slot,
@@ -113,12 +116,13 @@ func (d *sqliteDB) GetUser(steamid string) (*schema.User, error) {
 			id string
 			revision int
 			flags uint32
+			mergedInto string
 			charSlot *int
 			charID *string
 			delSlot *int
 			delID *string
 		)
-		if err := rows.Scan(&id, &revision, &flags, &charSlot, &charID, &delSlot, &delID); err != nil {
+		if err := rows.Scan(&id, &revision, &flags, &mergedInto, &charSlot, &charID, &delSlot, &delID); err != nil {
 			return nil, err
 		}
 
@@ -127,6 +131,7 @@ func (d *sqliteDB) GetUser(steamid string) (*schema.User, error) {
 				ID: id,
 				Revision: revision,
 				Flags: flags,
+				MergedInto: mergedInto,
 				Characters: make(map[int]uuid.UUID),
 				DeletedCharacters: make(map[int]uuid.UUID),
 			}
@@ -157,6 +162,33 @@ func (d *sqliteDB) GetUser(steamid string) (*schema.User, error) {
 	return u, nil
 }
 
+// NewUser creates the user if they don't exist yet.
+func (d *sqliteDB) NewUser(steamid string) error {
+	return d.exec(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO users (id) VALUES (?) ON CONFLICT(id) DO NOTHING`, steamid)
+		return err
+	})
+}
+
+// GetUsersWithFlag returns the SteamIDs of every user that has the flag set.
+func (d *sqliteDB) GetUsersWithFlag(flag bitmask.Bitmask) ([]string, error) {
+	rows, err := d.db.Query(`SELECT id FROM users WHERE flags & ? != 0 ORDER BY id`, uint32(flag))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steamids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		steamids = append(steamids, id)
+	}
+	return steamids, rows.Err()
+}
+
 func (d *sqliteDB) SetUserFlags(steamid string, flags bitmask.Bitmask) error {
 	return d.exec(func(tx *sql.Tx) error {
 		res, err := tx.Exec(`UPDATE users SET flags = ? WHERE id = ?`, uint32(flags), steamid)
@@ -167,7 +199,16 @@ func (d *sqliteDB) SetUserFlags(steamid string, flags bitmask.Bitmask) error {
 		if n == 0 {
 			return database.ErrNoDocument
 		}
-		return nil
+
+		// Grants of flags that were just cleared are gone with them.
+		_, err = tx.Exec(
+			`DELETE FROM flag_grants WHERE steam_id = ? AND (? >> bit) & 1 = 0`, steamid, uint32(flags),
+		)
+		if err != nil {
+			return err
+		}
+
+		return userChange(tx, steamid)
 	})
 }
 
@@ -179,3 +220,301 @@ func (d *sqliteDB) GetUserFlags(steamid string) (bitmask.Bitmask, error) {
 	}
 	return bitmask.Bitmask(flags), err
 }
+
+// MergeUsers moves the active and deleted characters of one account to another, which has to
+// exist already, combines their flags along with the grants and bans behind them and leaves
+// the old account as a tombstone pointing at the new one. Every active character moved is
+// journaled so the move can be reverted.
+func (d *sqliteDB) MergeUsers(from string, to string, opts database.MergeOptions) (*database.MergeResult, error) {
+	res := &database.MergeResult{
+		From: from,
+		To: to,
+		Characters: make([]database.MergedCharacter, 0),
+		DeletedCharacters: make([]database.MergedCharacter, 0),
+	}
+
+	err := d.exec(func(tx *sql.Tx) error {
+		fromFlags, err := mergeableFlags(tx, from)
+		if err != nil {
+			return err
+		}
+
+		toFlags, err := mergeableFlags(tx, to)
+		if err != nil {
+			return err
+		}
+
+		// Active characters
+		chars, err := userSlots(tx, `SELECT slot, id FROM characters WHERE steam_id = ? AND deleted_at IS NULL`, from)
+		if err != nil {
+			return err
+		}
+		taken, err := userSlots(tx, `SELECT slot, id FROM characters WHERE steam_id = ? AND deleted_at IS NULL`, to)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, slot := range database.MergeOrder(chars, taken) {
+			id := chars[slot]
+			target := slot
+
+			if opts.MaxSlots > 0 && slot >= opts.MaxSlots {
+				// The account doesn't have this slot, whatever the policy it can only go in a free one.
+				var ok bool
+				if target, ok = database.FreeSlot(taken, opts.MaxSlots); !ok {
+					return database.ErrNoFreeSlot
+				}
+			} else if other, ok := taken[slot]; ok {
+				switch opts.Policy {
+				case database.MergeReplace:
+					if err := softDeleteCharacter(tx, other, now, now.Add(opts.Expiration)); err != nil {
+						return err
+					}
+					res.Replaced = append(res.Replaced, other)
+				case database.MergeNextSlot:
+					if target, ok = database.FreeSlot(taken, opts.MaxSlots); !ok {
+						return database.ErrNoFreeSlot
+					}
+				default:
+					return &database.SlotOccupiedError{SteamID: to, Slot: slot, CharacterID: other}
+				}
+			}
+			taken[target] = id
+
+			before, err := characterState(tx, id)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec(
+				`UPDATE characters SET steam_id = ?, slot = ? WHERE id = ?`,
+				to, target, id.String(),
+			); err != nil {
+				return err
+			}
+
+			if err := recordOperation(tx, id, "move", 0, from, slot); err != nil {
+				return err
+			}
+
+			if err := recordLineage(tx, id, id, "merge", to, target, opts.Admin); err != nil {
+				return err
+			}
+
+			if err := journalMove(tx, id, before, to, target); err != nil {
+				return err
+			}
+
+			if err := characterChange(tx, database.ChangeCharacterMoved, id); err != nil {
+				return err
+			}
+
+			res.Characters = append(res.Characters, database.MergedCharacter{ID: id, FromSlot: slot, ToSlot: target})
+		}
+
+		// Deleted characters, these aren't limited to MaxSlots since they can be restored into any slot.
+		deleted, err := userSlots(tx, `SELECT slot, character_id FROM deleted_characters WHERE steam_id = ?`, from)
+		if err != nil {
+			return err
+		}
+		deletedTaken, err := userSlots(tx, `SELECT slot, character_id FROM deleted_characters WHERE steam_id = ?`, to)
+		if err != nil {
+			return err
+		}
+
+		for _, slot := range database.MergeOrder(deleted, deletedTaken) {
+			id := deleted[slot]
+			target := slot
+			if _, ok := deletedTaken[slot]; ok {
+				target, _ = database.FreeSlot(deletedTaken, 0)
+			}
+			deletedTaken[target] = id
+
+			if _, err := tx.Exec(
+				`UPDATE deleted_characters SET steam_id = ?, slot = ? WHERE character_id = ?`,
+				to, target, id.String(),
+			); err != nil {
+				return err
+			}
+
+			if err := characterChange(tx, database.ChangeCharacterMoved, id); err != nil {
+				return err
+			}
+
+			res.DeletedCharacters = append(res.DeletedCharacters, database.MergedCharacter{ID: id, FromSlot: slot, ToSlot: target})
+		}
+
+		// Flags
+		flags := fromFlags | toFlags
+		if opts.Flags != nil {
+			flags = opts.Flags(fromFlags, toFlags)
+		}
+		res.Flags = uint32(flags)
+
+		if _, err := tx.Exec(`UPDATE users SET flags = ? WHERE id = ?`, uint32(flags), to); err != nil {
+			return err
+		}
+
+		if err := mergeGrants(tx, from, to, fromFlags, toFlags, flags); err != nil {
+			return err
+		}
+
+		if err := d.mergeBans(tx, from, to, toFlags, flags, now, opts.Admin); err != nil {
+			return err
+		}
+
+		if err := userChange(tx, to); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			`UPDATE users SET merged_into = ?, merged_at = ? WHERE id = ?`,
+			to, now, from,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// mergeGrants gives the merged account the grants of the flags it keeps, the one that runs
+// out last when both accounts have the flag, so a flag granted for a while doesn't become
+// permanent. Grants given to the merged account are taken from the old one. BANNED is left
+// to mergeBans.
+func mergeGrants(tx *sql.Tx, from string, to string, fromFlags bitmask.Bitmask, toFlags bitmask.Bitmask, flags bitmask.Bitmask) error {
+	fromGrants, err := userGrants(tx, from)
+	if err != nil {
+		return err
+	}
+	toGrants, err := userGrants(tx, to)
+	if err != nil {
+		return err
+	}
+
+	// Grants of flags the merged account doesn't keep are gone with them.
+	if _, err := tx.Exec(
+		`DELETE FROM flag_grants WHERE steam_id = ? AND (? >> bit) & 1 = 0`, to, uint32(flags),
+	); err != nil {
+		return err
+	}
+
+	for bit := uint(0); bit < 32; bit++ {
+		mask := bitmask.Bitmask(1 << bit)
+		if !flags.HasFlag(mask) || mask == bitmask.BANNED {
+			continue
+		}
+
+		grant := database.MergeGrant(fromFlags.HasFlag(mask), fromGrants[bit], toFlags.HasFlag(mask), toGrants[bit])
+		if grant != nil && grant == toGrants[bit] {
+			continue
+		}
+
+		if _, err := tx.Exec(
+			`DELETE FROM flag_grants WHERE steam_id IN (?, ?) AND bit = ?`, from, to, bit,
+		); err != nil {
+			return err
+		}
+		if grant == nil {
+			continue
+		}
+
+		g := *grant
+		g.SteamID = to
+		if err := saveGrant(tx, &g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userGrants returns the user's grants by bit.
+func userGrants(tx *sql.Tx, steamid string) (map[uint]*schema.FlagGrant, error) {
+	rows, err := tx.Query(`
+		SELECT steam_id, bit, source, admin, granted_at, expires_at
+		FROM flag_grants WHERE steam_id = ?`,
+		steamid,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := readGrants(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	byBit := make(map[uint]*schema.FlagGrant, len(grants))
+	for i := range grants {
+		byBit[grants[i].Bit] = &grants[i]
+	}
+	return byBit, nil
+}
+
+// mergeBans moves the active bans of the old account to the merged one when it keeps
+// BANNED, so the ban keeps its reason and still expires. When the merged account doesn't
+// keep BANNED its own bans are lifted, the same as clearing the flag anywhere else.
+func (d *sqliteDB) mergeBans(tx *sql.Tx, from string, to string, toFlags bitmask.Bitmask, flags bitmask.Bitmask, now time.Time, admin string) error {
+	if flags.HasFlag(bitmask.BANNED) {
+		_, err := tx.Exec(`
+			UPDATE bans SET steam_id = ?
+			WHERE steam_id = ? AND lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)`,
+			to, from, now,
+		)
+		return err
+	}
+
+	if !toFlags.HasFlag(bitmask.BANNED) {
+		return nil
+	}
+	if err := liftBans(tx, to, now, admin, "merged"); err != nil {
+		return err
+	}
+	return d.queueUnban(tx, to, admin, fmt.Sprintf("%s was unbanned by merging %s into it", to, from), nil)
+}
+
+// mergeableFlags returns the user's flags, failing if the user was already merged away.
+func mergeableFlags(tx *sql.Tx, steamid string) (bitmask.Bitmask, error) {
+	var flags uint32
+	var mergedInto sql.NullString
+	err := tx.QueryRow(
+		`SELECT flags, merged_into FROM users WHERE id = ?`, steamid,
+	).Scan(&flags, &mergedInto)
+	if err == sql.ErrNoRows {
+		return 0, database.ErrNoDocument
+	}
+	if err != nil {
+		return 0, err
+	}
+	if mergedInto.Valid {
+		return 0, database.ErrAlreadyMerged
+	}
+	return bitmask.Bitmask(flags), nil
+}
+
+// userSlots runs a query selecting slot and character ID for the user into a map.
+func userSlots(tx *sql.Tx, query string, steamid string) (map[int]uuid.UUID, error) {
+	rows, err := tx.Query(query, steamid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	slots := make(map[int]uuid.UUID)
+	for rows.Next() {
+		var slot int
+		var idStr string
+		if err := rows.Scan(&slot, &idStr); err != nil {
+			return nil, err
+		}
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("bad character uuid %q: %w", idStr, err)
+		}
+		slots[slot] = id
+	}
+	return slots, rows.Err()
+}
@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+const webhookColumns = `id, endpoint, event, body, status, attempts, next_attempt, response_code, error,
+	created_at, finished_at`
+
+// AddWebhookDelivery queues a delivery and returns its ID.
+func (d *sqliteDB) AddWebhookDelivery(delivery *schema.WebhookDelivery) (int64, error) {
+	var id int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		var err error
+		id, err = insertWebhookDelivery(tx, delivery)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// queueEvent writes the event's webhook deliveries in the transaction, nothing is queued
+// without an outbox.
+func (d *sqliteDB) queueEvent(tx *sql.Tx, event database.Event) error {
+	if d.Outbox == nil {
+		return nil
+	}
+
+	deliveries, err := d.Outbox.Deliveries(event)
+	if err != nil {
+		return err
+	}
+	for i := range deliveries {
+		if _, err := insertWebhookDelivery(tx, &deliveries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertWebhookDelivery(tx *sql.Tx, delivery *schema.WebhookDelivery) (int64, error) {
+	res, err := tx.Exec(`
+		INSERT INTO webhook_deliveries (endpoint, event, body, status, attempts, next_attempt, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		delivery.Endpoint, delivery.Event, delivery.Body, delivery.Status, delivery.Attempts,
+		nullTime(delivery.NextAttempt), delivery.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateWebhookDelivery saves the outcome of an attempt, ErrNoDocument is returned when
+// there's no such delivery.
+func (d *sqliteDB) UpdateWebhookDelivery(delivery *schema.WebhookDelivery) error {
+	return d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			UPDATE webhook_deliveries
+			SET status = ?, attempts = ?, next_attempt = ?, response_code = ?, error = ?, finished_at = ?
+			WHERE id = ?`,
+			delivery.Status, delivery.Attempts, nullTime(delivery.NextAttempt), delivery.ResponseCode,
+			delivery.Error, nullTime(delivery.FinishedAt), delivery.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return database.ErrNoDocument
+		}
+		return nil
+	})
+}
+
+// GetDueWebhookDeliveries returns the pending deliveries whose next attempt is due by now,
+// oldest first.
+func (d *sqliteDB) GetDueWebhookDeliveries(now time.Time, limit int) ([]schema.WebhookDelivery, error) {
+	return d.webhookDeliveries(`
+		SELECT `+webhookColumns+`
+		FROM webhook_deliveries
+		WHERE status = ? AND next_attempt <= ?
+		ORDER BY id ASC LIMIT ?`,
+		database.WebhookPending, now.UTC(), limit,
+	)
+}
+
+// GetWebhookDeliveries returns the newest deliveries matching the filter.
+func (d *sqliteDB) GetWebhookDeliveries(filter database.WebhookFilter) ([]schema.WebhookDelivery, error) {
+	return d.webhookDeliveries(`
+		SELECT `+webhookColumns+`
+		FROM webhook_deliveries
+		WHERE (? = '' OR status = ?) AND (? = '' OR endpoint = ?) AND (? = '' OR event = ?)
+		ORDER BY id DESC LIMIT ?`,
+		filter.Status, filter.Status, filter.Endpoint, filter.Endpoint, filter.Event, filter.Event, filter.Limit,
+	)
+}
+
+// PruneWebhookDeliveries removes the deliveries that were delivered or failed for good
+// before the given time and returns how many there were. Pending deliveries are kept.
+func (d *sqliteDB) PruneWebhookDeliveries(before time.Time) (int, error) {
+	var pruned int64
+
+	err := d.exec(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`DELETE FROM webhook_deliveries WHERE status IN (?, ?) AND finished_at < ?`,
+			database.WebhookDelivered, database.WebhookFailed, before.UTC(),
+		)
+		if err != nil {
+			return err
+		}
+
+		pruned, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(pruned), nil
+}
+
+func (d *sqliteDB) webhookDeliveries(query string, args ...interface{}) ([]schema.WebhookDelivery, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make([]schema.WebhookDelivery, 0)
+	for rows.Next() {
+		var (
+			w schema.WebhookDelivery
+			nextAttempt sql.NullTime
+			finishedAt sql.NullTime
+		)
+		err := rows.Scan(
+			&w.ID, &w.Endpoint, &w.Event, &w.Body, &w.Status, &w.Attempts, &nextAttempt, &w.ResponseCode, &w.Error,
+			&w.CreatedAt, &finishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if nextAttempt.Valid {
+			w.NextAttempt = &nextAttempt.Time
+		}
+		if finishedAt.Valid {
+			w.FinishedAt = &finishedAt.Time
+		}
+		deliveries = append(deliveries, w)
+	}
+	return deliveries, rows.Err()
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"time"
+
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+// Webhook delivery statuses, pending deliveries are tried again until they run out of attempts.
+const (
+	WebhookPending = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed = "failed"
+)
+
+// Events sent to webhooks. Endpoints pick the ones they want by name, or by a prefix
+// ending in * such as "unsafe.*".
+const (
+	EventBan = "ban.created"
+	EventUnban = "ban.lifted"
+	EventRollback = "rollback.character"
+	EventMassRollback = "rollback.mass"
+	EventPurge = "character.purged"
+	EventUnsafeMove = "unsafe.move"
+	EventUnsafeCopy = "unsafe.copy"
+	EventUnsafeDelete = "unsafe.delete"
+	EventUnsafeRevert = "unsafe.revert"
+)
+
+// Event is what happened, it's posted as is to webhooks that aren't Discord.
+type Event struct {
+	Kind string `json:"event"`
+	Summary string `json:"summary"` //one line for people, used as the Discord message
+	SteamID string `json:"steamid,omitempty"`
+	CharacterID *uuid.UUID `json:"character_id,omitempty"`
+	By string `json:"by,omitempty"` //who did it, an IP or API key ID
+	Data interface{} `json:"data,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Outbox turns an event into the webhook deliveries to queue for it. The database writes
+// them in the transaction that made the change, so an event is sent exactly when its
+// change is committed.
+type Outbox interface {
+	Deliveries(event Event) ([]schema.WebhookDelivery, error)
+}
+
+// WebhookFilter narrows GetWebhookDeliveries, fields left empty match every delivery.
+type WebhookFilter struct {
+	Status string
+	Endpoint string
+	Event string
+	Limit int
+}
@@ -0,0 +1,386 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+const (
+	queueSize = 256 // jobs waiting for a worker, submitting more fails until some finish
+	saveEvery = time.Second // how often a running job's progress is written to the database
+)
+
+// Handler runs a job, what it returns is saved as the job's result. ctx is cancelled when
+// the server shuts down, a handler that stops because of it should return ctx.Err().
+type Handler func(ctx context.Context, run *Run) (interface{}, error)
+
+type Kind struct {
+	Handler Handler
+	Check func(params json.RawMessage) error // optional, rejects bad params before the job is queued
+	Resumable bool // run the job again after a restart instead of failing it, the handler carries on from its saved result
+}
+
+// Pool runs jobs on a fixed number of workers. Jobs are kept in the database so they can
+// be looked up once finished and picked back up when the server restarts.
+type Pool struct {
+	db database.Database
+	logger *slog.Logger
+	workers int
+	kinds map[string]Kind
+
+	mu sync.Mutex
+	queue chan uuid.UUID
+	running map[uuid.UUID]*Run
+
+	ctx context.Context
+	cancel context.CancelFunc
+	wg sync.WaitGroup
+}
+
+func New(db database.Database, log *slog.Logger, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		db: db,
+		logger: log,
+		workers: workers,
+		kinds: make(map[string]Kind),
+		queue: make(chan uuid.UUID, queueSize),
+		running: make(map[uuid.UUID]*Run),
+		ctx: ctx,
+		cancel: cancel,
+	}
+}
+
+// Register adds a kind of job, it must be called before Start.
+func (p *Pool) Register(kind string, k Kind) {
+	p.kinds[kind] = k
+}
+
+// Start queues the jobs left over from before a restart and starts the workers.
+func (p *Pool) Start() error {
+	if err := p.resume(); err != nil {
+		return err
+	}
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return nil
+}
+
+// Stop cancels the running jobs and waits for the workers to return. Interrupted jobs are
+// left as running, Start decides what happens to them next time.
+func (p *Pool) Stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// resume requeues resumable jobs that were running when the server stopped, fails the
+// rest and queues every job that was still waiting, oldest first.
+func (p *Pool) resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	running, err := p.db.GetJobs(database.JobRunning, "", queueSize)
+	if err != nil {
+		return err
+	}
+	for i := range running {
+		job := &running[i]
+		if k, ok := p.kinds[job.Kind]; !ok || !k.Resumable {
+			p.fail(job, "interrupted by a restart")
+			continue
+		}
+
+		job.Status = database.JobQueued
+		if err := p.db.UpdateJob(job); err != nil {
+			return err
+		}
+	}
+
+	queued, err := p.db.GetJobs(database.JobQueued, "", queueSize)
+	if err != nil {
+		return err
+	}
+	for i := len(queued) - 1; i >= 0; i-- {
+		job := &queued[i]
+		if _, ok := p.kinds[job.Kind]; !ok {
+			p.fail(job, static.ErrUnknownJobKind.Error())
+			continue
+		}
+
+		p.queue <- job.ID
+		p.logger.Info("jobs: job resumed", "id", job.ID, "kind", job.Kind, "progress", job.Progress, "total", job.Total)
+	}
+
+	return nil
+}
+
+// Submit saves a new job and queues it, params are marshalled to JSON.
+func (p *Pool) Submit(kind string, params interface{}, by string) (*schema.Job, error) {
+	k, ok := p.kinds[kind]
+	if !ok {
+		return nil, static.ErrUnknownJobKind
+	}
+
+	job := &schema.Job{
+		ID: uuid.New(),
+		Kind: kind,
+		Status: database.JobQueued,
+		RequestedBy: by,
+		CreatedAt: time.Now().UTC(),
+	}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		job.Params = raw
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k.Check != nil {
+		if err := k.Check(job.Params); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(p.queue) == cap(p.queue) {
+		return nil, static.ErrJobQueueFull
+	}
+	if err := p.db.AddJob(job); err != nil {
+		return nil, err
+	}
+	p.queue <- job.ID
+
+	p.logger.Info("jobs: job queued", "id", job.ID, "kind", kind, "by", by)
+	return job, nil
+}
+
+// Get returns a job, running jobs come with the progress they've made since it was last saved.
+func (p *Pool) Get(id uuid.UUID) (*schema.Job, error) {
+	p.mu.Lock()
+	run, ok := p.running[id]
+	p.mu.Unlock()
+	if ok {
+		return run.snapshot(), nil
+	}
+
+	return p.db.GetJob(id)
+}
+
+// List returns the newest jobs, status and kind filter them when they're not empty.
+func (p *Pool) List(status string, kind string, limit int) ([]schema.Job, error) {
+	jobs, err := p.db.GetJobs(status, kind, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, job := range jobs {
+		if run, ok := p.running[job.ID]; ok {
+			jobs[i] = *run.snapshot()
+		}
+	}
+	return jobs, nil
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case id := <-p.queue:
+			p.run(id)
+		}
+	}
+}
+
+func (p *Pool) run(id uuid.UUID) {
+	job, err := p.db.GetJob(id)
+	if err != nil {
+		p.logger.Error("jobs: failed to get job", "id", id, "error", err)
+		return
+	}
+	if job.Status != database.JobQueued {
+		return
+	}
+
+	now := time.Now().UTC()
+	job.Status = database.JobRunning
+	job.StartedAt = &now
+	job.Error = ""
+	if err := p.db.UpdateJob(job); err != nil {
+		p.logger.Error("jobs: failed to start job", "id", id, "error", err)
+		return
+	}
+
+	run := &Run{pool: p, job: *job, saved: now}
+	p.mu.Lock()
+	p.running[id] = run
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.running, id)
+		p.mu.Unlock()
+	}()
+
+	p.logger.Info("jobs: job started", "id", id, "kind", job.Kind)
+	result, err := call(p.ctx, p.kinds[job.Kind].Handler, run)
+	if err != nil && p.ctx.Err() != nil {
+		run.save()
+		p.logger.Warn("jobs: job interrupted", "id", id, "kind", job.Kind, "progress", run.job.Progress, "total", run.job.Total)
+		return
+	}
+
+	if result != nil {
+		run.pending = result
+	}
+
+	finished := time.Now().UTC()
+	run.mu.Lock()
+	run.job.FinishedAt = &finished
+	if err != nil {
+		run.job.Status = database.JobFailed
+		run.job.Error = err.Error()
+	} else {
+		run.job.Status = database.JobDone
+		if run.job.Progress < run.job.Total {
+			run.job.Progress = run.job.Total
+		}
+	}
+	run.mu.Unlock()
+	run.save()
+
+	if err != nil {
+		p.logger.Error("jobs: job failed", "id", id, "kind", job.Kind, "error", err, "ping", time.Since(now))
+		return
+	}
+	p.logger.Info("jobs: job finished", "id", id, "kind", job.Kind, "ping", time.Since(now))
+}
+
+// call runs the handler, a panic fails the job instead of taking the worker down with it.
+func call(ctx context.Context, h Handler, run *Run) (result interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("job panicked: %v", v)
+		}
+	}()
+
+	return h(ctx, run)
+}
+
+func (p *Pool) fail(job *schema.Job, reason string) {
+	now := time.Now().UTC()
+	job.Status = database.JobFailed
+	job.Error = reason
+	job.FinishedAt = &now
+	if err := p.db.UpdateJob(job); err != nil {
+		p.logger.Error("jobs: failed to fail job", "id", job.ID, "error", err)
+		return
+	}
+	p.logger.Warn("jobs: job failed", "id", job.ID, "kind", job.Kind, "error", reason)
+}
+
+// Run is the job a handler is running. Only the handler's goroutine changes it, the mutex
+// guards job since Get can read it at the same time.
+type Run struct {
+	pool *Pool
+	mu sync.Mutex
+	job schema.Job
+	pending interface{} // result that hasn't been marshalled into job yet
+	saved time.Time
+}
+
+func (r *Run) ID() uuid.UUID {
+	return r.job.ID
+}
+
+// RequestedBy is who submitted the job.
+func (r *Run) RequestedBy() string {
+	return r.job.RequestedBy
+}
+
+// Params unmarshals the job's params into v.
+func (r *Run) Params(v interface{}) error {
+	if len(r.job.Params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.job.Params, v)
+}
+
+// Result unmarshals the result saved so far into v, false is returned when there's none.
+// A resumed job uses it to carry on where it was interrupted.
+func (r *Run) Result(v interface{}) (bool, error) {
+	if len(r.job.Result) == 0 {
+		return false, nil
+	}
+	return true, json.Unmarshal(r.job.Result, v)
+}
+
+// Progress records how far along the job is, it's saved at most once every saveEvery.
+func (r *Run) Progress(done int, total int) {
+	r.mu.Lock()
+	r.job.Progress = done
+	r.job.Total = total
+	r.mu.Unlock()
+
+	if time.Since(r.saved) >= saveEvery {
+		r.save()
+	}
+}
+
+// Save records the result so far along with the progress. v is only marshalled when it's
+// written, so a handler can keep passing the same pointer while it works.
+func (r *Run) Save(v interface{}, done int, total int) {
+	r.pending = v
+	r.Progress(done, total)
+}
+
+func (r *Run) save() {
+	r.saved = time.Now()
+
+	if r.pending != nil {
+		raw, err := json.Marshal(r.pending)
+		if err != nil {
+			r.pool.logger.Error("jobs: failed to marshal result", "id", r.job.ID, "error", err)
+		} else {
+			r.mu.Lock()
+			r.job.Result = raw
+			r.mu.Unlock()
+		}
+	}
+
+	job := r.snapshot()
+	if err := r.pool.db.UpdateJob(job); err != nil {
+		r.pool.logger.Error("jobs: failed to save job", "id", job.ID, "error", err)
+	}
+}
+
+func (r *Run) snapshot() *schema.Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job := r.job
+	return &job
+}
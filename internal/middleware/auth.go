@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/msrevive/nexus2/pkg/utils"
@@ -17,6 +18,16 @@ func (mw *Middleware) BasicAuth(next http.Handler) http.Handler {
 	})
 }
 
+// systemAdminKey marks a request that sent the API key its IP has in the system admins list.
+type systemAdminKey struct{}
+
+// IsSystemAdmin reports whether the request came from a system admin's IP with that admin's
+// API key. Requests can get through ExternalAuth without one when enforceip is off.
+func IsSystemAdmin(r *http.Request) bool {
+	ok, _ := r.Context().Value(systemAdminKey{}).(bool)
+	return ok
+}
+
 /* ---
 	External Authenication
 	Performs IP whitelist and API key checks against what's allowed (if they're enabled in the config).
@@ -29,6 +40,9 @@ func (mw *Middleware) ExternalAuth(next http.Handler) http.Handler {
 		key := r.Header.Get("Authorization")
 
 		val,ok := mw.systemAdmins.GetHas(ip)
+		if ok && key != "" && val == key {
+			r = r.WithContext(context.WithValue(r.Context(), systemAdminKey{}, true))
+		}
 		if ip == "127.0.0.1" {
 			ok = true
 		}
@@ -121,6 +121,7 @@ func (m *Migrator) migrateCharacter(user *schema.User, char *schema.Character) e
 			newID,
 			ver.Size,
 			ver.Data,
+			ver.Server,
 			len(char.Versions)+1, // never prune during migration
 			0,                    // no time gap required
 		); err != nil {
@@ -139,6 +140,7 @@ func (m *Migrator) migrateCharacter(user *schema.User, char *schema.Character) e
 			newID,
 			char.Data.Size,
 			char.Data.Data,
+			char.Data.Server,
 			0, // backupMax=0 so this update creates no new version entry
 			0,
 		); err != nil {
@@ -0,0 +1,159 @@
+package payload
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	json "github.com/sugawarayuuta/sonnet"
+	"github.com/google/uuid"
+)
+
+type ExportManifest struct {
+	SteamID string `json:"steamid"`
+	ExportedAt time.Time `json:"exported_at"`
+	Characters []ExportManifestCharacter `json:"characters"`
+}
+
+type ExportManifestCharacter struct {
+	ID uuid.UUID `json:"id"`
+	Slot int `json:"slot"`
+	Deleted bool `json:"deleted"`
+	File string `json:"file"`
+	CreatedAt time.Time `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DataCreatedAt time.Time `json:"data_created_at"`
+	Size int `json:"size"`
+	Versions []ExportManifestVersion `json:"versions,omitempty"`
+}
+
+type ExportManifestVersion struct {
+	Version int64 `json:"version"`
+	File string `json:"file"`
+	CreatedAt time.Time `json:"created_at"`
+	Size int `json:"size"`
+	Tag string `json:"tag,omitempty"`
+}
+
+// ArchiveCharacter is a character to be written into an export archive. Slot is
+// passed separately since soft deleted characters no longer keep their slot.
+type ArchiveCharacter struct {
+	Slot int
+	Deleted bool
+	Character *schema.Character
+}
+
+// WriteCharArchive streams a zip archive with a .char file for every character, and
+// optionally every stored version, followed by a manifest.json describing the contents.
+func WriteCharArchive(w io.Writer, steamid string, chars []ArchiveCharacter, versions bool) error {
+	zw := zip.NewWriter(w)
+	manifest := ExportManifest{
+		SteamID: steamid,
+		ExportedAt: time.Now().UTC(),
+		Characters: make([]ExportManifestCharacter, 0, len(chars)),
+	}
+
+	for _, ac := range chars {
+		char := ac.Character
+
+		dir := ""
+		if ac.Deleted {
+			dir = "deleted"
+		}
+
+		name, err := writeCharFile(zw, dir, steamid, ac.Slot, char.Data)
+		if err != nil {
+			return fmt.Errorf("character %s: %w", char.ID, err)
+		}
+
+		entry := ExportManifestCharacter{
+			ID: char.ID,
+			Slot: ac.Slot,
+			Deleted: ac.Deleted,
+			File: name,
+			CreatedAt: char.CreatedAt,
+			DeletedAt: char.DeletedAt,
+			DataCreatedAt: char.Data.CreatedAt,
+			Size: char.Data.Size,
+		}
+
+		if versions {
+			// versions/<character file name>/<version id>_<timestamp>.char
+			verDir := path.Join(dir, "versions", strings.TrimSuffix(path.Base(name), ".char"))
+			for _, v := range char.Versions {
+				verName := path.Join(verDir, fmt.Sprintf("%d_%s.char", v.ID, v.CreatedAt.UTC().Format("20060102T150405Z")))
+				if err := writeZipFile(zw, verName, v.Data); err != nil {
+					return fmt.Errorf("character %s version %d: %w", char.ID, v.ID, err)
+				}
+
+				entry.Versions = append(entry.Versions, ExportManifestVersion{
+					Version: v.ID,
+					File: verName,
+					CreatedAt: v.CreatedAt,
+					Size: v.Size,
+					Tag: v.Tag,
+				})
+			}
+		}
+
+		manifest.Characters = append(manifest.Characters, entry)
+	}
+
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// writeCharFile writes the character data using the same file name GenerateCharFile uses.
+func writeCharFile(zw *zip.Writer, dir string, steamid string, slot int, data schema.CharacterData) (string, error) {
+	rd, fn, err := GenerateCharFile(steamid, slot, data.Data)
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Join(dir, fn)
+	fw, err := createZipFile(zw, name)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(fw, rd)
+	return name, err
+}
+
+func writeZipFile(zw *zip.Writer, name string, data string) error {
+	d, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+
+	fw, err := createZipFile(zw, name)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.Write(d)
+	return err
+}
+
+func createZipFile(zw *zip.Writer, name string) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{
+		Name: name,
+		Method: zip.Deflate,
+		Modified: time.Now().UTC(),
+	})
+}
@@ -0,0 +1,23 @@
+package payload
+
+import (
+	"time"
+)
+
+type Ban struct {
+	Reason string `json:"reason"`
+	Duration string `json:"duration,omitempty"` //e.g. "7d", empty for a permanent ban
+	Evidence string `json:"evidence,omitempty"` //link to a demo, screenshot, etc.
+}
+
+type Unban struct {
+	Reason string `json:"reason"`
+}
+
+// BanVerify is the ban status the game server is sent when a player joins, reason and
+// expiry are shown to the player when they're kicked.
+type BanVerify struct {
+	Banned bool `json:"banned"`
+	Reason string `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` //nil for permanent bans
+}
@@ -0,0 +1,14 @@
+package payload
+
+import (
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+// ChangePage is a batch of the change feed. Next is passed as ?since= to get the changes
+// after it, it stays the same when there were none. Reset is set when changes after since
+// were already pruned, the reader has to load everything again and carry on from Next.
+type ChangePage struct {
+	Changes []schema.Change `json:"changes"`
+	Next int64 `json:"next"`
+	Reset bool `json:"reset,omitempty"`
+}
@@ -5,6 +5,9 @@ import (
 	"encoding/base64"
 	"strconv"
 	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
 
 	"github.com/msrevive/nexus2/pkg/utils"
 )
@@ -19,4 +22,37 @@ func GenerateCharFile(steam64 string, slot int, data string) (rd *bytes.Reader,
 	//we want to create the file in memory only to avoid unneeded io operations
 	rd = bytes.NewReader(d)
 	return
+}
+
+// MaxCharFileSize is the largest character file we'll accept for import, real character
+// files are only a few kilobytes.
+const MaxCharFileSize = 1 << 20
+
+var (
+	ErrCharFileEmpty = errors.New("character file is empty")
+	ErrCharFileTooLarge = errors.New("character file is too large")
+	ErrCharFileExt = errors.New("character file must have a .char extension")
+)
+
+// ParseCharFile is the reverse of GenerateCharFile, it reads a raw character file and
+// returns it base64 encoded the way the game server sends character data.
+// fn is optional and only used to check the extension.
+func ParseCharFile(rd io.Reader, fn string) (data string, size int, err error) {
+	if fn != "" && filepath.Ext(fn) != ".char" {
+		return "", 0, ErrCharFileExt
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(rd, MaxCharFileSize+1))
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch {
+	case len(raw) == 0:
+		return "", 0, ErrCharFileEmpty
+	case len(raw) > MaxCharFileSize:
+		return "", 0, ErrCharFileTooLarge
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), len(raw), nil
 }
\ No newline at end of file
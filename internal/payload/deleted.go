@@ -0,0 +1,21 @@
+package payload
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeletedExpiry sets when GC purges a soft deleted character, Duration counts from now and
+// takes precedence over ExpiresAt.
+type DeletedExpiry struct {
+	Duration string `json:"duration,omitempty"` //e.g. "30d"
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type DeletedRestore struct {
+	ID uuid.UUID `json:"id"`
+	Slot int `json:"slot"`
+	Restored bool `json:"restored"`
+	Error string `json:"error,omitempty"` //why the character was skipped, e.g. its slot is taken
+}
@@ -0,0 +1,163 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/msrevive/nexus2/pkg/utils"
+)
+
+// maxDiffRanges caps how many changed byte ranges we report so a completely
+// different payload doesn't produce a response larger than the payload itself.
+const maxDiffRanges = 256
+
+// ErrDiffDecode is returned when a payload being diffed isn't valid base64.
+var ErrDiffDecode = errors.New("unable to decode character payload")
+
+type FieldChange struct {
+	Field string `json:"field"`
+	From interface{} `json:"from,omitempty"`
+	To interface{} `json:"to,omitempty"`
+}
+
+type ByteRange struct {
+	Start int `json:"start"`
+	End int `json:"end"` // exclusive
+	Length int `json:"length"`
+}
+
+type CharacterDiff struct {
+	From string `json:"from"`
+	To string `json:"to"`
+	Mode string `json:"mode"` // "fields" when both payloads could be decoded, otherwise "bytes"
+	Identical bool `json:"identical"`
+	FromSize int `json:"from_size"`
+	ToSize int `json:"to_size"`
+	SizeDelta int `json:"size_delta"`
+	Fields []FieldChange `json:"fields,omitempty"`
+	Ranges []ByteRange `json:"ranges,omitempty"`
+	ChangedBytes int `json:"changed_bytes,omitempty"`
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// DiffCharacterData compares two base64 encoded character payloads. If both payloads
+// decode into structured data we return a field-level diff, otherwise we fall back to
+// a summary of the byte ranges that changed.
+func DiffCharacterData(fromRef, toRef, from, to string) (*CharacterDiff, error) {
+	a, err := base64.StdEncoding.DecodeString(from)
+	if err != nil {
+		return nil, fmt.Errorf("%w of %s: %w", ErrDiffDecode, fromRef, err)
+	}
+	b, err := base64.StdEncoding.DecodeString(to)
+	if err != nil {
+		return nil, fmt.Errorf("%w of %s: %w", ErrDiffDecode, toRef, err)
+	}
+
+	diff := &CharacterDiff{
+		From: fromRef,
+		To: toRef,
+		FromSize: len(a),
+		ToSize: len(b),
+		SizeDelta: len(b) - len(a),
+		Identical: bytes.Equal(a, b),
+	}
+
+	fa, okA := decodeFields(a)
+	fb, okB := decodeFields(b)
+	if okA && okB {
+		diff.Mode = "fields"
+		diffFields("", fa, fb, &diff.Fields)
+		return diff, nil
+	}
+
+	diff.Mode = "bytes"
+	diffBytes(a, b, diff)
+	return diff, nil
+}
+
+// decodeFields attempts to read the payload as a JSON object. The game stores
+// characters in its own binary format so most payloads will fail here, which is fine.
+func decodeFields(data []byte) (map[string]interface{}, bool) {
+	var fields map[string]interface{}
+	if err := utils.ProcessJSON(data, &fields); err != nil || fields == nil {
+		return nil, false
+	}
+
+	return fields, true
+}
+
+func diffFields(prefix string, a, b map[string]interface{}, changes *[]FieldChange) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		field := k
+		if prefix != "" {
+			field = prefix + "." + k
+		}
+
+		va, okA := a[k]
+		vb, okB := b[k]
+
+		ma, isMapA := va.(map[string]interface{})
+		mb, isMapB := vb.(map[string]interface{})
+		if isMapA && isMapB {
+			diffFields(field, ma, mb, changes)
+			continue
+		}
+
+		if okA && okB && reflect.DeepEqual(va, vb) {
+			continue
+		}
+
+		*changes = append(*changes, FieldChange{
+			Field: field,
+			From: va,
+			To: vb,
+		})
+	}
+}
+
+func diffBytes(a, b []byte, diff *CharacterDiff) {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+
+	start := -1
+	for i := 0; i <= n; i++ {
+		changed := i < n && (i >= len(a) || i >= len(b) || a[i] != b[i])
+		if changed {
+			diff.ChangedBytes++
+			if start == -1 {
+				start = i
+			}
+			continue
+		}
+
+		if start != -1 {
+			if len(diff.Ranges) < maxDiffRanges {
+				diff.Ranges = append(diff.Ranges, ByteRange{
+					Start: start,
+					End: i,
+					Length: i - start,
+				})
+			} else {
+				diff.Truncated = true
+			}
+			start = -1
+		}
+	}
+}
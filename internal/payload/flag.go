@@ -0,0 +1,42 @@
+package payload
+
+import (
+	"time"
+)
+
+type FlagGrant struct {
+	Source string `json:"source,omitempty"` //e.g. a payment ID
+	Duration string `json:"duration,omitempty"` //e.g. "30d", takes precedence over expires_at
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// BulkFlags changes the flags of many users in one go. SteamIDs, Op and Flags make the same
+// change to every user listed, Changes holds changes that differ from user to user. The
+// grant fields apply to every flag that's added, setting or clearing banned records or
+// lifts a ban with Reason.
+type BulkFlags struct {
+	SteamIDs []string `json:"steamids,omitempty"`
+	Op string `json:"op,omitempty"` //add, remove or set
+	Flags []string `json:"flags,omitempty"`
+	Clear bool `json:"clear,omitempty"` //required for a set without flags
+	Reason string `json:"reason,omitempty"`
+	Changes []BulkFlagChange `json:"changes,omitempty"`
+	FlagGrant
+}
+
+type BulkFlagChange struct {
+	SteamID string `json:"steamid"`
+	Op string `json:"op"` //add, remove or set, set leaves the user with only these flags
+	Flags []string `json:"flags"`
+	Clear bool `json:"clear,omitempty"` //required for a set without flags, it clears every flag
+}
+
+type BulkFlagResult struct {
+	SteamID string `json:"steamid"`
+	Op string `json:"op"`
+	Flags []string `json:"flags"`
+	Before uint32 `json:"before"`
+	After uint32 `json:"after"`
+	Changed bool `json:"changed"`
+	Created bool `json:"created,omitempty"` //the user hadn't joined yet
+}
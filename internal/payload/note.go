@@ -0,0 +1,11 @@
+package payload
+
+import (
+	"github.com/google/uuid"
+)
+
+type UserNote struct {
+	Category string `json:"category,omitempty"` //defaults to general
+	CharacterID *uuid.UUID `json:"character_id,omitempty"` //the character the note is about, if any
+	Body string `json:"body"`
+}
@@ -4,6 +4,8 @@ package payload
 // inside the database like the character version or w/e
 
 import (
+	"time"
+
 	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/google/uuid"
 )
@@ -15,9 +17,16 @@ type Character struct {
 	Size int `json:"size"`
 	Data string `json:"data"`
 	Flags bitmask.Bitmask `json:"flags"`
+	FlagExpiry map[string]time.Time `json:"flag_expiry,omitempty"` //flag name => when it runs out, e.g. "donor until ..."
 }
 
 type CharacterCreate struct {
 	ID uuid.UUID `json:"id"`
 	Flags bitmask.Bitmask `json:"flags"`
+	FlagExpiry map[string]time.Time `json:"flag_expiry,omitempty"`
+}
+
+type CharacterImport struct {
+	ID uuid.UUID `json:"id"`
+	Created bool `json:"created"`
 }
\ No newline at end of file
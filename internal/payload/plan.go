@@ -0,0 +1,43 @@
+package payload
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Plan is what a mutating request would change, it's returned instead of making the
+// change when the request is sent with ?dry_run=true.
+type Plan struct {
+	Operation string `json:"operation"`
+	Characters []PlannedCharacter `json:"characters,omitempty"`
+	Flags *PlannedFlags `json:"flags,omitempty"`
+	Result interface{} `json:"result,omitempty"` //what the request would respond with, when it's known up front
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type PlannedCharacter struct {
+	ID uuid.UUID `json:"id"` //the source character for copies, nil for characters that would be created
+	Action string `json:"action"` //move, copy, create, import, restore, swap, rollback, soft_delete, delete, extend_expiry or prune_versions
+	SteamID string `json:"steamid,omitempty"` //owner before the request
+	Slot *int `json:"slot,omitempty"`
+	ToSteamID string `json:"to_steamid,omitempty"` //owner after the request
+	ToSlot *int `json:"to_slot,omitempty"`
+	Version int64 `json:"version,omitempty"` //version the current data would be replaced with
+	Snapshot int64 `json:"snapshot,omitempty"` //snapshot the current data would be replaced with by an undo
+	Overwritten *PlannedVersion `json:"overwritten,omitempty"` //current data that would be replaced, it's kept as a snapshot
+	Pruned []PlannedVersion `json:"pruned,omitempty"` //versions that would be removed for good
+}
+
+type PlannedVersion struct {
+	ID int64 `json:"id,omitempty"` //0 for the current data
+	CreatedAt time.Time `json:"created_at"`
+	Size int `json:"size"`
+	Tag string `json:"tag,omitempty"`
+}
+
+type PlannedFlags struct {
+	SteamID string `json:"steamid"`
+	Before uint32 `json:"before"`
+	After uint32 `json:"after"`
+}
@@ -0,0 +1,40 @@
+package payload
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MassRollback struct {
+	From time.Time `json:"from"`
+	To time.Time `json:"to"`
+	Servers []string `json:"servers,omitempty"` //only characters saved by these game server IPs
+	DryRun bool `json:"dry_run"`
+}
+
+type MassRollbackResult struct {
+	ID uuid.UUID `json:"id"`
+	SteamID string `json:"steamid"`
+	Slot int `json:"slot"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Server string `json:"server,omitempty"`
+	Version int64 `json:"version,omitempty"` //version we roll back to
+	VersionCreatedAt *time.Time `json:"version_created_at,omitempty"`
+	Status string `json:"status"` //pending, rolled_back or skipped
+	Error string `json:"error,omitempty"`
+}
+
+type MassRollbackJob struct {
+	ID uuid.UUID `json:"id"`
+	Request MassRollback `json:"request"`
+	Status string `json:"status"` //dry_run, running, done or failed
+	Total int `json:"total"`
+	Processed int `json:"processed"`
+	RolledBack int `json:"rolled_back"`
+	Skipped int `json:"skipped"`
+	StartedAt time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error string `json:"error,omitempty"` //why the job failed
+	Results []MassRollbackResult `json:"results"`
+}
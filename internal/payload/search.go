@@ -0,0 +1,17 @@
+package payload
+
+import (
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+// UserPage is one page of a user search, Next is passed as ?cursor= to get the page after
+// it and is empty on the last page.
+type UserPage struct {
+	Users []schema.UserSummary `json:"users"`
+	Next string `json:"next,omitempty"`
+}
+
+type CharacterPage struct {
+	Characters []schema.CharacterSummary `json:"characters"`
+	Next string `json:"next,omitempty"`
+}
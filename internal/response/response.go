@@ -2,6 +2,7 @@ package response
 
 import (
 	"net/http"
+	"time"
 
 	json "github.com/sugawarayuuta/sonnet"
 )
@@ -99,6 +100,28 @@ func Result(w http.ResponseWriter, b bool) {
 	resp.SendJson()
 }
 
+//same as Result with the ban's reason and expiry next to data, so servers reading data as a bool still work.
+func BanResult(w http.ResponseWriter, banned bool, reason string, expiresAt *time.Time) {
+	resp := struct {
+		Response
+		Reason string `json:"reason,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}{
+		Response: Response{
+			Status: true,
+			Code: http.StatusOK,
+			Error: "",
+			Data: banned,
+		},
+		Reason: reason,
+		ExpiresAt: expiresAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.Code)
+	json.NewEncoder(w).Encode(resp)
+}
+
 func BadRequest(w http.ResponseWriter, err error) {
 	resp := Response{
 		Status: false,
@@ -110,6 +133,42 @@ func BadRequest(w http.ResponseWriter, err error) {
 	resp.SendJson()
 }
 
+// Forbidden is for callers who got through auth but can't make this request.
+func Forbidden(w http.ResponseWriter, err error) {
+	resp := Response{
+		Status: false,
+		Code: http.StatusForbidden,
+		Error: err.Error(),
+		Data: nil,
+		w: w,
+	}
+	resp.SendJson()
+}
+
+// NotFound is for requests naming something that doesn't exist.
+func NotFound(w http.ResponseWriter, err error) {
+	resp := Response{
+		Status: false,
+		Code: http.StatusNotFound,
+		Error: err.Error(),
+		Data: nil,
+		w: w,
+	}
+	resp.SendJson()
+}
+
+// Conflict is for requests that would overwrite existing data, data describes what's in the way.
+func Conflict(w http.ResponseWriter, err error, data interface{}) {
+	resp := Response{
+		Status: false,
+		Code: http.StatusConflict,
+		Error: err.Error(),
+		Data: data,
+		w: w,
+	}
+	resp.SendJson()
+}
+
 func Error(w http.ResponseWriter, err error) {
 	resp := Response{
 		Status: false,
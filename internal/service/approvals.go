@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
+)
+
+const defaultApprovalExpiry = 24 * time.Hour
+
+// RequestApproval holds a destructive request until a second admin approves it.
+func (s *Service) RequestApproval(approval *schema.Approval) (*schema.Approval, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	approval.Reason = strings.TrimSpace(approval.Reason)
+	if approval.Reason == "" {
+		return nil, static.ErrApprovalReason
+	}
+
+	expiry := defaultApprovalExpiry
+	if s.config.Approval.Expiry != "" {
+		var err error
+		expiry, err = utils.ParseDuration(s.config.Approval.Expiry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	approval.Status = database.ApprovalPending
+	approval.CreatedAt = time.Now().UTC()
+	approval.ExpiresAt = approval.CreatedAt.Add(expiry)
+
+	id, err := s.db.AddApproval(approval)
+	if err != nil {
+		return nil, err
+	}
+	approval.ID = id
+
+	return approval, nil
+}
+
+// ApproveRequest approves a pending request, the caller runs it afterwards. The admin
+// who made the request can't approve it themselves, with their key or from their IP.
+func (s *Service) ApproveRequest(id int64, by string, ip string) (*schema.Approval, error) {
+	return s.decideApproval(id, database.ApprovalApproved, by, ip)
+}
+
+// RejectRequest rejects a pending request, the admin who made it may reject it to cancel it.
+func (s *Service) RejectRequest(id int64, by string, ip string) (*schema.Approval, error) {
+	return s.decideApproval(id, database.ApprovalRejected, by, ip)
+}
+
+func (s *Service) decideApproval(id int64, status string, by string, ip string) (*schema.Approval, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	approval, err := s.PlanDecision(id, status, by, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if err := s.db.DecideApproval(id, status, by, ip, now); err != nil {
+		// Someone else decided it first.
+		if errors.Is(err, database.ErrNoDocument) {
+			return nil, static.ErrApprovalNotPending
+		}
+		return nil, err
+	}
+
+	approval.Status = status
+	approval.DecidedBy = by
+	approval.DecidedIP = ip
+	approval.DecidedAt = &now
+	return approval, nil
+}
+
+// PlanDecision checks that the admin can approve or reject the request without deciding it.
+func (s *Service) PlanDecision(id int64, status string, by string, ip string) (*schema.Approval, error) {
+	approval, err := s.db.GetApproval(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if approval.Status != database.ApprovalPending {
+		return nil, static.ErrApprovalNotPending
+	}
+	if !time.Now().Before(approval.ExpiresAt) {
+		return nil, static.ErrApprovalExpired
+	}
+	if status == database.ApprovalApproved && (by == approval.RequestedBy || ip == approval.RequestedIP) {
+		return nil, static.ErrSelfApproval
+	}
+
+	return approval, nil
+}
+
+func (s *Service) SetApprovalResult(approval *schema.Approval, status int, result string) error {
+	if s.readonly {
+		return nil
+	}
+
+	approval.ResultStatus = status
+	approval.Result = result
+
+	return s.db.SetApprovalResult(approval.ID, status, result)
+}
+
+func (s *Service) GetApproval(id int64) (*schema.Approval, error) {
+	return s.db.GetApproval(id)
+}
+
+func (s *Service) GetApprovals(status string, limit int) ([]schema.Approval, error) {
+	return s.db.GetApprovals(status, limit)
+}
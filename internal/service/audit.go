@@ -0,0 +1,69 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+)
+
+// AuditState summarises the user and character a request targets, it's taken before and
+// after the request so the audit log shows what changed. Targets that don't exist are
+// left out, nil is returned when neither does.
+func (s *Service) AuditState(steamid string, charID *uuid.UUID) *schema.AuditState {
+	var state schema.AuditState
+	found := false
+
+	if steamid != "" {
+		user, err := s.db.GetUser(steamid)
+		if err == nil {
+			state.Flags = &user.Flags
+			state.Characters = user.Characters
+			found = true
+		} else if !errors.Is(err, database.ErrNoDocument) {
+			s.logger.Warn("audit: failed to get user", "steamid", steamid, "error", err)
+		}
+	}
+
+	if charID != nil {
+		char, err := s.db.GetCharacter(*charID)
+		if err == nil {
+			state.Owner = char.SteamID
+			state.Slot = &char.Slot
+			state.Size = char.Data.Size
+			state.Deleted = char.DeletedAt != nil
+			found = true
+		} else if !errors.Is(err, database.ErrNoDocument) {
+			s.logger.Warn("audit: failed to get character", "uuid", charID, "error", err)
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return &state
+}
+
+func (s *Service) RecordAudit(entry *schema.AuditEntry) error {
+	if s.readonly {
+		return nil
+	}
+
+	id, err := s.db.AddAuditEntry(entry)
+	if err != nil {
+		return err
+	}
+	entry.ID = id
+
+	return nil
+}
+
+func (s *Service) GetAuditLog(filter database.AuditFilter) ([]schema.AuditEntry, error) {
+	return s.db.GetAuditLog(filter)
+}
+
+func (s *Service) VerifyAuditLog() (*database.AuditVerify, error) {
+	return s.db.VerifyAuditLog()
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
+)
+
+// BanUser records a ban for the user and sets their BANNED flag, bans without a
+// duration are permanent.
+func (s *Service) BanUser(steamid string, req payload.Ban, admin string) (*schema.Ban, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	ban, err := newBan(steamid, req, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.db.AddBan(ban)
+	if err != nil {
+		return nil, err
+	}
+	ban.ID = id
+
+	return ban, nil
+}
+
+// PlanBanUser validates the ban, any active ban it would replace is listed in the warnings.
+func (s *Service) PlanBanUser(steamid string, req payload.Ban, admin string) (*payload.Plan, error) {
+	ban, err := newBan(steamid, req, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.DryRun().AddBan(ban); err != nil {
+		return nil, err
+	}
+
+	plan := &payload.Plan{
+		Operation: "ban",
+		Result: ban,
+	}
+
+	// Banning a user we haven't seen yet creates them.
+	flags, err := s.db.GetUserFlags(steamid)
+	if err != nil && !errors.Is(err, database.ErrNoDocument) {
+		return nil, err
+	}
+	plan.Flags = &payload.PlannedFlags{
+		SteamID: steamid,
+		Before: uint32(flags),
+		After: uint32(flags | bitmask.BANNED),
+	}
+
+	if err := s.planLiftBan(plan, steamid, "replace"); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func newBan(steamid string, req payload.Ban, admin string) (*schema.Ban, error) {
+	ban := &schema.Ban{
+		SteamID: steamid,
+		Reason: req.Reason,
+		Admin: admin,
+		Evidence: req.Evidence,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if req.Duration != "" {
+		d, err := utils.ParseDuration(req.Duration)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, static.ErrBadBanDuration
+		}
+
+		expiresAt := ban.CreatedAt.Add(d)
+		ban.ExpiresAt = &expiresAt
+	}
+
+	return ban, nil
+}
+
+// isBanGrant tells whether the grant is of the banned flag, those are recorded as bans.
+func isBanGrant(grant *schema.FlagGrant) bool {
+	return bitmask.Bitmask(1)<<grant.Bit == bitmask.BANNED
+}
+
+// grantBan is the ban a grant of the banned flag stands for.
+func grantBan(grant *schema.FlagGrant) *schema.Ban {
+	return &schema.Ban{
+		SteamID: grant.SteamID,
+		Reason: grant.Source,
+		Admin: grant.Admin,
+		CreatedAt: grant.GrantedAt,
+		ExpiresAt: grant.ExpiresAt,
+	}
+}
+
+func (s *Service) UnbanUser(steamid string, reason string, admin string) error {
+	if s.readonly {
+		return nil
+	}
+
+	return s.db.LiftBan(steamid, admin, reason)
+}
+
+func (s *Service) PlanUnbanUser(steamid string) (*payload.Plan, error) {
+	flags, err := s.db.GetUserFlags(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().LiftBan(steamid, "", ""); err != nil {
+		return nil, err
+	}
+
+	after := flags
+	after.ClearFlag(bitmask.BANNED)
+	plan := &payload.Plan{
+		Operation: "unban",
+		Flags: &payload.PlannedFlags{
+			SteamID: steamid,
+			Before: uint32(flags),
+			After: uint32(after),
+		},
+	}
+
+	if err := s.planLiftBan(plan, steamid, "lift"); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// planLiftBan warns about the user's active ban being lifted by the plan.
+func (s *Service) planLiftBan(plan *payload.Plan, steamid string, verb string) error {
+	active, err := s.db.GetActiveBan(steamid)
+	if errors.Is(err, database.ErrNoDocument) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	plan.Warnings = append(plan.Warnings, fmt.Sprintf("would %s active ban %d", verb, active.ID))
+	return nil
+}
+
+func (s *Service) GetBans(steamid string) ([]schema.Ban, error) {
+	bans, err := s.db.GetBans(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	return bans, nil
+}
+
+// GetBanStatus tells whether the user is banned along with the reason and expiry of
+// the ban. The BANNED flag has the final say, so users banned before bans were recorded
+// are still banned, just without a reason.
+func (s *Service) GetBanStatus(steamid string) (*payload.BanVerify, error) {
+	flags, err := s.db.GetUserFlags(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &payload.BanVerify{}
+	if !flags.HasFlag(bitmask.BANNED) {
+		return status, nil
+	}
+
+	ban, err := s.db.GetActiveBan(steamid)
+	if err == nil {
+		status.Banned = true
+		status.Reason = ban.Reason
+		status.ExpiresAt = ban.ExpiresAt
+		return status, nil
+	}
+	if !errors.Is(err, database.ErrNoDocument) {
+		return nil, err
+	}
+
+	// The flag is only cleared once the expiry job runs, don't keep the player out
+	// in the meantime if their latest ban already ran out.
+	bans, err := s.db.GetBans(steamid)
+	if err != nil {
+		return nil, err
+	}
+	if len(bans) > 0 && bans[0].LiftedAt == nil {
+		return status, nil
+	}
+
+	status.Banned = true
+	return status, nil
+}
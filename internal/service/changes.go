@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+// changePoll is how often WaitChanges checks for new changes.
+const changePoll = time.Second
+
+// WaitChanges returns the changes made after the change since. When there are none yet it
+// waits up to wait for some to be made, an empty page is returned if none were. A since
+// that has been pruned gets a reset page pointing at the newest change instead.
+func (s *Service) WaitChanges(ctx context.Context, since int64, limit int, wait time.Duration) (*payload.ChangePage, error) {
+	// since is always the ID of a change that was handed out, once it's gone the changes
+	// after it may have been pruned too.
+	if since > 0 {
+		kept, latest, err := s.db.ChangeKept(since)
+		if err != nil {
+			return nil, err
+		}
+		if !kept {
+			return &payload.ChangePage{Changes: make([]schema.Change, 0), Next: latest, Reset: true}, nil
+		}
+	}
+
+	deadline := time.Now().Add(wait)
+	ticker := time.NewTicker(changePoll)
+	defer ticker.Stop()
+
+	for {
+		changes, err := s.db.GetChanges(since, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(changes) > 0 || !time.Now().Before(deadline) {
+			page := &payload.ChangePage{Changes: changes, Next: since}
+			if len(changes) > 0 {
+				page.Next = changes[len(changes)-1].ID
+			}
+			return page, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return &payload.ChangePage{Changes: changes, Next: since}, nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package service
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
 	"github.com/msrevive/nexus2/internal/payload"
 	"github.com/msrevive/nexus2/internal/static"
 	"github.com/msrevive/nexus2/pkg/database/schema"
@@ -21,15 +25,16 @@ func (s *Service) NewCharacter(char payload.Character) (uuid.UUID, bitmask.Bitma
 		return uuid.Nil, 0, err
 	}
 
-	return uid, flags, nil
+	return uid, s.gameFlags(flags), nil
 }
 
-func (s *Service) UpdateCharacter(uuid uuid.UUID, char payload.Character) error {
+// UpdateCharacter saves the character data, server is the IP of the game server that sent it.
+func (s *Service) UpdateCharacter(uuid uuid.UUID, char payload.Character, server string) error {
 	if s.readonly {
 		return nil
 	}
 
-	if err := s.db.UpdateCharacter(uuid, char.Size, char.Data, s.config.Char.MaxBackups, s.config.Char.BackupTime); err != nil {
+	if err := s.db.UpdateCharacter(uuid, char.Size, char.Data, server, s.config.Char.MaxBackups, s.config.Char.BackupTime); err != nil {
 		return err
 	}
 
@@ -62,7 +67,7 @@ func (s *Service) GetCharacter(steamid string, slot int) (*schema.Character, bit
 		return nil, 0, err
 	}
 
-	return char, bitmask.Bitmask(user.Flags), err
+	return char, s.gameFlags(bitmask.Bitmask(user.Flags)), err
 }
 
 func (s *Service) GetCharacters(steamid string) (map[int]schema.Character, bitmask.Bitmask, error) {
@@ -79,13 +84,38 @@ func (s *Service) GetCharacters(steamid string) (map[int]schema.Character, bitma
 	return chars, flags, nil
 }
 
+// GetDeletedCharacters returns the IDs of the user's soft deleted characters by the slot
+// they were deleted from.
 func (s *Service) GetDeletedCharacters(steamid string) (map[int]uuid.UUID, error) {
-	user, err := s.db.GetUser(steamid)
+	deleted, err := s.GetDeletedCharacterSummaries(steamid)
 	if err != nil {
 		return nil, err
 	}
 
-	return user.DeletedCharacters, nil
+	ids := make(map[int]uuid.UUID, len(deleted))
+	for slot, c := range deleted {
+		ids[slot] = c.ID
+	}
+	return ids, nil
+}
+
+// GetDeletedCharacterSummaries returns the user's soft deleted characters by the slot they
+// were deleted from, with when they were deleted and when GC purges them.
+func (s *Service) GetDeletedCharacterSummaries(steamid string) (map[int]schema.CharacterSummary, error) {
+	if _, err := s.db.GetUser(steamid); err != nil {
+		return nil, err
+	}
+
+	deleted, err := s.db.GetDeletedCharacters(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	chars := make(map[int]schema.CharacterSummary, len(deleted))
+	for _, c := range deleted {
+		chars[c.Slot] = c
+	}
+	return chars, nil
 }
 
 func (s *Service) SoftDeleteCharacter(uid uuid.UUID, expiration string) error {
@@ -110,24 +140,30 @@ func (s *Service) LookUpCharacterID(steamid string, slot int) (uuid.UUID, error)
 	return uid, nil
 }
 
-func (s *Service) MoveCharacter(uid uuid.UUID, steamid string, slot int) (uuid.UUID, error) {
+func (s *Service) MoveCharacter(uid uuid.UUID, steamid string, slot int, admin string) (uuid.UUID, error) {
 	if s.readonly {
 		return uuid.Nil, nil
 	}
 
-	if err := s.db.MoveCharacter(uid, steamid, slot); err != nil {
+	db := s.notifyCharacter(database.EventUnsafeMove, uid, nil,
+		fmt.Sprintf("character %s was moved to %s slot %d", uid, steamid, slot), admin, map[string]interface{}{"steamid": steamid, "slot": slot})
+	if err := db.MoveCharacter(uid, steamid, slot, admin); err != nil {
 		return uuid.Nil, err
 	}
 
 	return uid, nil
 }
 
-func (s *Service) CopyCharacter(uid uuid.UUID, steamid string, slot int) (uuid.UUID, error) {
+func (s *Service) CopyCharacter(uid uuid.UUID, steamid string, slot int, admin string) (uuid.UUID, error) {
 	if s.readonly {
 		return uuid.Nil, nil
 	}
 
-	newUID, err := s.db.CopyCharacter(uid, steamid, slot); 
+	// The copy's ID is only known once it's committed, receivers find it by its slot.
+	db := s.notifyCharacter(database.EventUnsafeCopy, uid, nil,
+		fmt.Sprintf("character %s was copied to %s slot %d", uid, steamid, slot), admin,
+		map[string]interface{}{"steamid": steamid, "slot": slot})
+	newUID, err := db.CopyCharacter(uid, steamid, slot, admin); 
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -135,13 +171,13 @@ func (s *Service) CopyCharacter(uid uuid.UUID, steamid string, slot int) (uuid.U
 	return newUID, nil
 }
 
-func (s *Service) HardDeleteCharacter(uid uuid.UUID) error {
+func (s *Service) HardDeleteCharacter(uid uuid.UUID, admin string) error {
 	if s.readonly {
 		return nil
 	}
 
 	// make sure character exists
-	_, err := s.db.GetCharacter(uid);
+	char, err := s.db.GetCharacter(uid);
 	if err != nil {
 		return err
 	}
@@ -150,17 +186,106 @@ func (s *Service) HardDeleteCharacter(uid uuid.UUID) error {
 	// 	return err
 	// }
 
-	if err := s.db.DeleteCharacter(uid); err != nil {
+	db := s.notifyCharacter(database.EventUnsafeDelete, uid, char, fmt.Sprintf("character %s of %s was hard deleted", uid, char.SteamID), admin, nil)
+	return db.DeleteCharacter(uid)
+}
+
+// RestoreCharacter restores a soft deleted character, into slot when it's not nil.
+func (s *Service) RestoreCharacter(uid uuid.UUID, slot *int, admin string) error {
+	if err := s.db.RestoreCharacter(uid, slot, admin); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (s *Service) RestoreCharacter(uid uuid.UUID) error {
-	if err := s.db.RestoreCharacter(uid); err != nil {
+func (s *Service) SwapCharacters(steamid string, a int, b int) error {
+	if s.readonly {
+		return nil
+	}
+
+	if err := swapCharacters(s.db, steamid, a, b); err != nil {
 		return err
 	}
 
 	return nil
+}
+
+// swapCharacters is the body of SwapCharacters, PlanSwapCharacters runs it against a
+// dry run of the database.
+func swapCharacters(db database.Database, steamid string, a int, b int) error {
+	if a == b {
+		return static.ErrSameSlot
+	}
+
+	return db.SwapCharacters(steamid, a, b)
+}
+
+// ImportCharacter stores an uploaded character file in the given slot, returns the
+// character ID and whether a new character had to be created.
+func (s *Service) ImportCharacter(steamid string, slot int, size int, data string, note string, admin string) (uuid.UUID, bool, error) {
+	if s.readonly {
+		return uuid.Nil, false, nil
+	}
+
+	return s.db.ImportCharacter(steamid, slot, size, data, note, admin, s.config.Char.MaxBackups)
+}
+
+// GetLineage returns where the character came from and every copy made of it.
+func (s *Service) GetLineage(uid uuid.UUID) (*schema.Lineage, error) {
+	lineage, err := s.db.GetLineage(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return lineage, nil
+}
+
+// GetCharacterArchive collects every character for a user for a bulk export. Deleted
+// characters are only included when requested.
+func (s *Service) GetCharacterArchive(steamid string, deleted bool) ([]payload.ArchiveCharacter, error) {
+	user, err := s.db.GetUser(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	chars := make([]payload.ArchiveCharacter, 0, len(user.Characters)+len(user.DeletedCharacters))
+	for _, slot := range sortedSlots(user.Characters) {
+		char, err := s.db.GetCharacter(user.Characters[slot])
+		if err != nil {
+			return nil, err
+		}
+
+		chars = append(chars, payload.ArchiveCharacter{
+			Slot: slot,
+			Character: char,
+		})
+	}
+
+	if deleted {
+		for _, slot := range sortedSlots(user.DeletedCharacters) {
+			char, err := s.db.GetCharacter(user.DeletedCharacters[slot])
+			if err != nil {
+				return nil, err
+			}
+
+			chars = append(chars, payload.ArchiveCharacter{
+				Slot: slot,
+				Deleted: true,
+				Character: char,
+			})
+		}
+	}
+
+	return chars, nil
+}
+
+func sortedSlots(chars map[int]uuid.UUID) []int {
+	slots := make([]int, 0, len(chars))
+	for slot := range chars {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+
+	return slots
 }
\ No newline at end of file
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+// SetDeletedExpiry changes when GC purges a soft deleted character and returns the new expiry.
+func (s *Service) SetDeletedExpiry(uid uuid.UUID, req payload.DeletedExpiry) (*time.Time, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	expiresAt, err := deletedExpiry(req, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.deletedCharacter(uid); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.SetCharacterExpiry(uid, expiresAt); err != nil {
+		return nil, err
+	}
+
+	return &expiresAt, nil
+}
+
+func (s *Service) PlanSetDeletedExpiry(uid uuid.UUID, req payload.DeletedExpiry) (*payload.Plan, error) {
+	expiresAt, err := deletedExpiry(req, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	char, err := s.deletedCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().SetCharacterExpiry(uid, expiresAt); err != nil {
+		return nil, err
+	}
+
+	planned, err := s.plannedCharacter(char, "extend_expiry")
+	if err != nil {
+		return nil, err
+	}
+
+	return &payload.Plan{
+		Operation: "extend_expiry",
+		Characters: []payload.PlannedCharacter{*planned},
+		Result: expiresAt,
+	}, nil
+}
+
+// PurgeDeletedCharacter permanently deletes a soft deleted character without waiting for GC.
+// Like HardDeleteCharacter it goes through DeleteCharacter, which journals the character so
+// the purge can be reverted within the journal retention window.
+func (s *Service) PurgeDeletedCharacter(uid uuid.UUID, admin string) error {
+	if s.readonly {
+		return nil
+	}
+
+	char, err := s.deletedCharacter(uid)
+	if err != nil {
+		return err
+	}
+
+	db := s.notifyCharacter(database.EventPurge, uid, char, fmt.Sprintf("deleted character %s of %s was purged", uid, char.SteamID), admin, nil)
+	return db.DeleteCharacter(uid)
+}
+
+func (s *Service) PlanPurgeDeletedCharacter(uid uuid.UUID) (*payload.Plan, error) {
+	if _, err := s.deletedCharacter(uid); err != nil {
+		return nil, err
+	}
+
+	plan, err := s.PlanHardDeleteCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+	plan.Operation = "purge"
+	return plan, nil
+}
+
+// RestoreDeletedCharacters restores every soft deleted character of the user into the slot
+// it was deleted from. Characters whose slot has been taken since are skipped and left deleted.
+func (s *Service) RestoreDeletedCharacters(steamid string, admin string) ([]payload.DeletedRestore, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	return restoreDeletedCharacters(s.db, steamid, admin)
+}
+
+// restoreDeletedCharacters is the body of RestoreDeletedCharacters, PlanRestoreDeletedCharacters
+// runs it against a dry run of the database.
+func restoreDeletedCharacters(db database.Database, steamid string, admin string) ([]payload.DeletedRestore, error) {
+	deleted, err := db.GetDeletedCharacters(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every restore in a dry run is rolled back before the next one, so a slot restored
+	// earlier in the loop has to be remembered to skip later characters from it.
+	restored := make(map[int]uuid.UUID)
+	results := make([]payload.DeletedRestore, 0, len(deleted))
+	for _, c := range deleted {
+		res := payload.DeletedRestore{ID: c.ID, Slot: c.Slot}
+
+		var err error
+		if other, ok := restored[c.Slot]; ok {
+			err = &database.SlotOccupiedError{SteamID: steamid, Slot: c.Slot, CharacterID: other}
+		} else {
+			err = db.RestoreCharacter(c.ID, nil, admin)
+		}
+		var occupied *database.SlotOccupiedError
+		switch {
+		case errors.As(err, &occupied):
+			res.Error = err.Error()
+		case err != nil:
+			return nil, err
+		default:
+			res.Restored = true
+			restored[c.Slot] = c.ID
+		}
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+func (s *Service) PlanRestoreDeletedCharacters(steamid string) (*payload.Plan, error) {
+	results, err := restoreDeletedCharacters(s.db.DryRun(), steamid, "")
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &payload.Plan{Operation: "restore_all"}
+	for _, res := range results {
+		if !res.Restored {
+			plan.Warnings = append(plan.Warnings, "skipped: "+res.Error)
+			continue
+		}
+
+		plan.Characters = append(plan.Characters, payload.PlannedCharacter{
+			ID: res.ID,
+			Action: "restore",
+			SteamID: steamid,
+			Slot: intPtr(res.Slot),
+			ToSteamID: steamid,
+			ToSlot: intPtr(res.Slot),
+		})
+	}
+
+	plan.Result = results
+	return plan, nil
+}
+
+// deletedCharacter returns the character, failing when it isn't soft deleted.
+func (s *Service) deletedCharacter(uid uuid.UUID) (*schema.Character, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+	if char.DeletedAt == nil {
+		return nil, static.ErrNotDeleted
+	}
+	return char, nil
+}
+
+// deletedExpiry works out when a soft deleted character should be purged, it has to be
+// after the given time.
+func deletedExpiry(req payload.DeletedExpiry, at time.Time) (time.Time, error) {
+	expiresAt := req.ExpiresAt
+	if req.Duration != "" {
+		d, err := utils.ParseDuration(req.Duration)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		t := at.Add(d)
+		expiresAt = &t
+	}
+	if expiresAt == nil || !expiresAt.After(at) {
+		return time.Time{}, static.ErrBadDeletedExpiry
+	}
+
+	return expiresAt.UTC(), nil
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+)
+
+const maxBulkFlagChanges = 5000
+
+// BulkUpdateFlags checks every change and applies them all in one transaction, nothing is
+// changed when one of them is bad. Users that haven't joined yet are created, the same as
+// when they're banned.
+func (s *Service) BulkUpdateFlags(req payload.BulkFlags, admin string) ([]payload.BulkFlagResult, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	listed, changes, err := s.bulkFlagChanges(req, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.db.BulkUpdateFlags(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return bulkFlagResults(listed, res), nil
+}
+
+// SyncListFlags applies the flag changes of a FN ban or admin list sync in one
+// transaction, the same as BulkUpdateFlags. A quiet sync queues no webhooks, the first
+// sync of a big ban list would otherwise send one for every ban in it.
+func (s *Service) SyncListFlags(changes []database.FlagChange, quiet bool) ([]database.FlagChangeResult, error) {
+	if s.readonly || len(changes) == 0 {
+		return nil, nil
+	}
+
+	db := s.db
+	if quiet {
+		db = db.Quiet()
+	}
+
+	return db.BulkUpdateFlags(changes)
+}
+
+// PlanBulkUpdateFlags checks every change and works out each user's flags after it.
+func (s *Service) PlanBulkUpdateFlags(req payload.BulkFlags, admin string) (*payload.Plan, error) {
+	listed, changes, err := s.bulkFlagChanges(req, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.db.DryRun().BulkUpdateFlags(changes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payload.Plan{
+		Operation: "bulk_flags",
+		Result: bulkFlagResults(listed, res),
+	}, nil
+}
+
+// bulkFlagChanges lists the request's changes, the same change for every SteamID first,
+// and checks them against the flag registry.
+func (s *Service) bulkFlagChanges(req payload.BulkFlags, admin string) ([]payload.BulkFlagChange, []database.FlagChange, error) {
+	listed := make([]payload.BulkFlagChange, 0, len(req.SteamIDs)+len(req.Changes))
+	for _, steamid := range req.SteamIDs {
+		listed = append(listed, payload.BulkFlagChange{SteamID: steamid, Op: req.Op, Flags: req.Flags, Clear: req.Clear})
+	}
+	listed = append(listed, req.Changes...)
+
+	if len(listed) == 0 {
+		return nil, nil, static.ErrNoFlagChanges
+	}
+	if len(listed) > maxBulkFlagChanges {
+		return nil, nil, fmt.Errorf("%w: %d is more than %d", static.ErrTooManyFlagChanges, len(listed), maxBulkFlagChanges)
+	}
+
+	// Every added flag is granted the same way.
+	now := time.Now().UTC()
+	expiresAt, err := grantExpiry(req.FlagGrant, now)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changes := make([]database.FlagChange, 0, len(listed))
+	for i, l := range listed {
+		c, err := s.flagChange(l, req.Reason, req.Source, admin, now, expiresAt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("change %d (%s): %w", i+1, l.SteamID, err)
+		}
+		changes = append(changes, c)
+	}
+
+	return listed, changes, nil
+}
+
+func (s *Service) flagChange(l payload.BulkFlagChange, reason string, source string, admin string, at time.Time, expiresAt *time.Time) (database.FlagChange, error) {
+	c := database.FlagChange{
+		SteamID: l.SteamID,
+		Op: l.Op,
+		Reason: reason,
+		Source: source,
+		Admin: admin,
+		GrantedAt: at,
+		ExpiresAt: expiresAt,
+	}
+
+	if _, err := strconv.ParseUint(l.SteamID, 10, 64); err != nil {
+		return c, static.ErrBadSteamID
+	}
+
+	switch l.Op {
+	case database.FlagAdd, database.FlagRemove:
+		if len(l.Flags) == 0 {
+			return c, fmt.Errorf("%w: no flags to %s", static.ErrBadFlagOp, l.Op)
+		}
+	case database.FlagSet:
+		// A set without flags wipes the user's flags, a missing list shouldn't do that.
+		if len(l.Flags) == 0 && !l.Clear {
+			return c, fmt.Errorf("%w: set without flags clears every flag, pass clear to confirm", static.ErrBadFlagOp)
+		}
+	default:
+		return c, fmt.Errorf("%w: %q", static.ErrBadFlagOp, l.Op)
+	}
+
+	for _, name := range l.Flags {
+		flag, ok := s.flags.Lookup(name)
+		if !ok {
+			return c, fmt.Errorf("%w: %s", static.ErrUnknownFlag, name)
+		}
+		c.Mask.AddFlag(flag.Mask())
+	}
+
+	return c, nil
+}
+
+func bulkFlagResults(listed []payload.BulkFlagChange, res []database.FlagChangeResult) []payload.BulkFlagResult {
+	results := make([]payload.BulkFlagResult, 0, len(res))
+	for i, r := range res {
+		flags := listed[i].Flags
+		if flags == nil {
+			flags = make([]string, 0)
+		}
+
+		results = append(results, payload.BulkFlagResult{
+			SteamID: r.SteamID,
+			Op: listed[i].Op,
+			Flags: flags,
+			Before: uint32(r.Before),
+			After: uint32(r.After),
+			Changed: r.Before != r.After,
+			Created: r.Created,
+		})
+	}
+	return results
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+
+	"github.com/msrevive/nexus2/internal/jobs"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// Kinds of jobs the service runs, the app registers its own on top of these.
+const (
+	JobMassRollback = "mass_rollback"
+	JobGC = "gc"
+	JobAuditVerify = "audit_verify"
+)
+
+func (s *Service) registerJobs() {
+	s.jobs.Register(JobMassRollback, jobs.Kind{
+		Handler: s.runMassRollback,
+		Check: s.checkMassRollback,
+		Resumable: true,
+	})
+
+	s.jobs.Register(JobGC, jobs.Kind{
+		Handler: func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+			return true, s.db.RunGC()
+		},
+		Resumable: true,
+	})
+
+	s.jobs.Register(JobAuditVerify, jobs.Kind{
+		Handler: func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+			return s.db.VerifyAuditLog()
+		},
+		Resumable: true,
+	})
+}
+
+// RegisterJob adds a kind of job, it must be called before StartJobs.
+func (s *Service) RegisterJob(kind string, k jobs.Kind) {
+	s.jobs.Register(kind, k)
+}
+
+// StartJobs starts the job workers, nothing runs in read only mode.
+func (s *Service) StartJobs() error {
+	if s.readonly {
+		return nil
+	}
+
+	return s.jobs.Start()
+}
+
+func (s *Service) StopJobs() {
+	if s.readonly {
+		return
+	}
+
+	s.jobs.Stop()
+}
+
+// SubmitJob queues a job to run in the background, params is the job's JSON params and
+// may be empty.
+func (s *Service) SubmitJob(kind string, params json.RawMessage, by string) (*schema.Job, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	if len(params) == 0 {
+		return s.jobs.Submit(kind, nil, by)
+	}
+	return s.jobs.Submit(kind, params, by)
+}
+
+func (s *Service) GetJob(id uuid.UUID) (*schema.Job, error) {
+	return s.jobs.Get(id)
+}
+
+func (s *Service) GetJobs(status string, kind string, limit int) ([]schema.Job, error) {
+	return s.jobs.List(status, kind, limit)
+}
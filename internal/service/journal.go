@@ -0,0 +1,82 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
+)
+
+func (s *Service) GetJournal(limit int) ([]schema.JournalEntry, error) {
+	entries, err := s.db.GetJournal(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *Service) GetJournalEntry(id int64) (*schema.JournalEntry, error) {
+	entry, err := s.db.GetJournalEntry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// RevertJournalEntry reverts a journaled move, copy or delete as long as it's still
+// within the journal retention window.
+func (s *Service) RevertJournalEntry(id int64, admin string) (*schema.JournalEntry, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	entry, err := s.db.GetJournalEntry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.notifyCharacter(database.EventUnsafeRevert, entry.CharacterID, nil,
+		fmt.Sprintf("journaled %s of character %s was reverted", entry.Operation, entry.CharacterID), admin, entry)
+	return s.revertJournalEntry(db, id, admin)
+}
+
+// revertJournalEntry is the body of RevertJournalEntry, PlanRevertJournalEntry runs it
+// against a dry run of the database.
+func (s *Service) revertJournalEntry(db database.Database, id int64, admin string) (*schema.JournalEntry, error) {
+	retention, err := s.journalRetention()
+	if err != nil {
+		return nil, err
+	}
+
+	if retention > 0 {
+		entry, err := db.GetJournalEntry(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if time.Since(entry.CreatedAt) > retention {
+			return nil, static.ErrJournalExpired
+		}
+	}
+
+	expire, err := utils.ParseDuration(s.config.Char.DeletedExpireTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.RevertJournalEntry(id, expire, admin)
+}
+
+// journalRetention is how long journal entries can be reverted, 0 keeps them forever.
+func (s *Service) journalRetention() (time.Duration, error) {
+	if s.config.Char.JournalRetention == "" {
+		return 0, nil
+	}
+
+	return utils.ParseDuration(s.config.Char.JournalRetention)
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/jobs"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	"github.com/google/uuid"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// PlanMassRollback finds every character modified in the window and the version each
+// one would be rolled back to, nothing is changed.
+func (s *Service) PlanMassRollback(req payload.MassRollback) ([]payload.MassRollbackResult, error) {
+	if !req.From.Before(req.To) {
+		return nil, static.ErrBadTimeWindow
+	}
+
+	chars, err := s.db.GetModifiedCharacters(req.From, req.To, req.Servers)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]payload.MassRollbackResult, 0, len(chars))
+	for _, c := range chars {
+		res := payload.MassRollbackResult{
+			ID: c.ID,
+			SteamID: c.SteamID,
+			Slot: c.Slot,
+			ModifiedAt: c.ModifiedAt,
+			Server: c.Server,
+			Status: "pending",
+		}
+
+		if c.Version == 0 {
+			res.Status = "skipped"
+			res.Error = "no version from before the window"
+		} else {
+			createdAt := c.VersionCreatedAt
+			res.Version = c.Version
+			res.VersionCreatedAt = &createdAt
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// StartMassRollback plans the mass rollback when it's a dry run, otherwise it's queued as
+// a job that plans and applies it in the background. Only one mass rollback may be
+// queued or running at a time.
+func (s *Service) StartMassRollback(req payload.MassRollback, by string) (*payload.MassRollbackJob, error) {
+	if req.DryRun || s.readonly {
+		results, err := s.PlanMassRollback(req)
+		if err != nil {
+			return nil, err
+		}
+
+		return &payload.MassRollbackJob{
+			ID: uuid.New(),
+			Request: req,
+			Status: "dry_run",
+			Total: len(results),
+			StartedAt: time.Now().UTC(),
+			Results: results,
+		}, nil
+	}
+
+	job, err := s.jobs.Submit(JobMassRollback, req, by)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("mass rollback queued", "id", job.ID, "from", req.From, "to", req.To, "servers", req.Servers)
+	return massRollbackJob(job)
+}
+
+// checkMassRollback rejects a mass rollback before it's queued, the pool holds its lock
+// while checking so two can't slip in at once.
+func (s *Service) checkMassRollback(params json.RawMessage) error {
+	var req payload.MassRollback
+	if err := json.Unmarshal(params, &req); err != nil {
+		return err
+	}
+	if !req.From.Before(req.To) {
+		return static.ErrBadTimeWindow
+	}
+
+	for _, status := range []string{database.JobQueued, database.JobRunning} {
+		others, err := s.db.GetJobs(status, JobMassRollback, 1)
+		if err != nil {
+			return err
+		}
+		if len(others) > 0 {
+			return static.ErrMassRollbackRunning
+		}
+	}
+
+	return nil
+}
+
+// runMassRollback rolls back every planned character in one transaction, so either all of
+// them are rolled back or none are. Every rollback can be reverted with the undo endpoint.
+// The plan is saved before it's applied, a resumed job that already applied it is done.
+func (s *Service) runMassRollback(ctx context.Context, run *jobs.Run) (interface{}, error) {
+	var m payload.MassRollbackJob
+	resumed, err := run.Result(&m)
+	if err != nil {
+		return nil, err
+	}
+
+	if !resumed {
+		var req payload.MassRollback
+		if err := run.Params(&req); err != nil {
+			return nil, err
+		}
+
+		results, err := s.PlanMassRollback(req)
+		if err != nil {
+			return nil, err
+		}
+
+		m = payload.MassRollbackJob{
+			ID: run.ID(),
+			Request: req,
+			Status: "running",
+			Total: len(results),
+			StartedAt: time.Now().UTC(),
+			Results: results,
+		}
+		run.Save(&m, 0, m.Total)
+		s.logger.Info("mass rollback started", "id", m.ID, "from", req.From, "to", req.To, "servers", req.Servers, "characters", m.Total)
+	} else if m.Processed == m.Total {
+		return &m, nil
+	} else {
+		s.logger.Info("mass rollback resumed", "id", m.ID, "total", m.Total)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	targets := make([]database.RollbackTarget, 0, len(m.Results))
+	for _, res := range m.Results {
+		if res.Status == "pending" {
+			targets = append(targets, database.RollbackTarget{ID: res.ID, Version: res.Version})
+		}
+	}
+	skipped := len(m.Results) - len(targets)
+
+	db := s.notify(database.Event{
+		Kind: database.EventMassRollback,
+		Summary: fmt.Sprintf("mass rollback %s finished: %d rolled back, %d skipped", m.ID, len(targets), skipped),
+		By: run.RequestedBy(),
+		Data: map[string]interface{}{
+			"id": m.ID,
+			"request": m.Request,
+			"total": m.Total,
+			"rolled_back": len(targets),
+			"skipped": skipped,
+		},
+	})
+	if err := db.RollbackCharacters(targets); err != nil {
+		s.logger.Error("mass rollback failed, nothing was rolled back", "id", m.ID, "error", err)
+		return nil, err
+	}
+
+	for i, res := range m.Results {
+		if res.Status == "pending" {
+			m.Results[i].Status = "rolled_back"
+		}
+	}
+
+	now := time.Now().UTC()
+	m.Status = "done"
+	m.Processed = m.Total
+	m.RolledBack = len(targets)
+	m.Skipped = skipped
+	m.FinishedAt = &now
+
+	s.logger.Info("mass rollback finished", "id", m.ID, "rolled_back", m.RolledBack, "skipped", m.Skipped)
+	return &m, nil
+}
+
+func (s *Service) GetMassRollback(id uuid.UUID) (*payload.MassRollbackJob, error) {
+	job, err := s.jobs.Get(id)
+	if errors.Is(err, database.ErrNoDocument) {
+		return nil, static.ErrNoMassRollback
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.Kind != JobMassRollback {
+		return nil, static.ErrNoMassRollback
+	}
+
+	return massRollbackJob(job)
+}
+
+// massRollbackJob is the mass rollback a job has got to, a job that hasn't planned it yet
+// only has its request.
+func massRollbackJob(job *schema.Job) (*payload.MassRollbackJob, error) {
+	var m payload.MassRollbackJob
+	if len(job.Result) > 0 {
+		if err := json.Unmarshal(job.Result, &m); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := json.Unmarshal(job.Params, &m.Request); err != nil {
+			return nil, err
+		}
+		m.ID = job.ID
+		m.StartedAt = job.CreatedAt
+		m.Results = make([]payload.MassRollbackResult, 0)
+	}
+
+	switch job.Status {
+	case database.JobQueued, database.JobRunning:
+		m.Status = "running"
+	case database.JobFailed:
+		m.Status = "failed"
+		m.Error = job.Error
+		m.FinishedAt = job.FinishedAt
+	}
+	return &m, nil
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/utils"
+)
+
+// MergeUsers moves everything from one account into another, policy overrides the
+// configured slot policy when it's not empty.
+func (s *Service) MergeUsers(from string, to string, policy string, admin string) (*database.MergeResult, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	if from == to {
+		return nil, static.ErrSameUser
+	}
+
+	opts, err := s.mergeOptions(policy, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.db.MergeUsers(from, to, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("merged users", "from", from, "to", to, "characters", len(res.Characters), "deleted_characters", len(res.DeletedCharacters), "flags", res.Flags)
+	return res, nil
+}
+
+// PlanMergeUsers runs the merge in a transaction that's rolled back, so the result is
+// exactly what MergeUsers would do at this moment.
+func (s *Service) PlanMergeUsers(from string, to string, policy string, admin string) (*payload.Plan, error) {
+	if from == to {
+		return nil, static.ErrSameUser
+	}
+
+	opts, err := s.mergeOptions(policy, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.db.DryRun().MergeUsers(from, to, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &payload.Plan{
+		Operation: "merge",
+		Result: res,
+	}, nil
+}
+
+func (s *Service) mergeOptions(policy string, admin string) (database.MergeOptions, error) {
+	if policy == "" {
+		policy = s.config.Merge.SlotPolicy
+	}
+	switch policy {
+	case "":
+		policy = database.MergeNextSlot
+	case database.MergeNextSlot, database.MergeReplace, database.MergeFail:
+	default:
+		return database.MergeOptions{}, fmt.Errorf("%w: %s", static.ErrBadMergePolicy, policy)
+	}
+
+	combine, err := s.mergeFlags()
+	if err != nil {
+		return database.MergeOptions{}, err
+	}
+
+	expire, err := utils.ParseDuration(s.config.Char.DeletedExpireTime)
+	if err != nil {
+		return database.MergeOptions{}, err
+	}
+
+	return database.MergeOptions{
+		Policy: policy,
+		MaxSlots: s.config.Merge.MaxSlots,
+		Expiration: expire,
+		Flags: combine,
+		Admin: admin,
+	}, nil
+}
+
+// mergeFlags builds the flag combiner from the configured rules, flags without a
+// rule are kept if either account has them.
+func (s *Service) mergeFlags() (func(from bitmask.Bitmask, to bitmask.Bitmask) bitmask.Bitmask, error) {
+	rules := make(map[bitmask.Bitmask]string, len(s.config.Merge.Flags))
+	for name, rule := range s.config.Merge.Flags {
+		flag, ok := s.flags.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown flag %s", static.ErrBadMergeRule, name)
+		}
+
+		switch rule {
+		case "any", "both", "from", "to":
+			rules[flag.Mask()] = rule
+		default:
+			return nil, fmt.Errorf("%w: %s: %s", static.ErrBadMergeRule, name, rule)
+		}
+	}
+
+	return func(from bitmask.Bitmask, to bitmask.Bitmask) bitmask.Bitmask {
+		flags := from | to
+		for flag, rule := range rules {
+			var has bool
+			switch rule {
+			case "any":
+				has = from.HasFlag(flag) || to.HasFlag(flag)
+			case "both":
+				has = from.HasFlag(flag) && to.HasFlag(flag)
+			case "from":
+				has = from.HasFlag(flag)
+			case "to":
+				has = to.HasFlag(flag)
+			}
+
+			if has {
+				flags.AddFlag(flag)
+			} else {
+				flags.ClearFlag(flag)
+			}
+		}
+		return flags
+	}, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+// Categories a note can be filed under.
+var noteCategories = map[string]bool{
+	"general": true,
+	"warning": true,
+	"ban": true,
+	"rollback": true,
+	"character": true,
+}
+
+const defaultNoteCategory = "general"
+
+// AddUserNote appends a note to the user's moderation history, the author is whoever
+// made the request.
+func (s *Service) AddUserNote(steamid string, req payload.UserNote, author string) (*schema.UserNote, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	note, err := newUserNote(steamid, req, author)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.db.AddUserNote(note)
+	if err != nil {
+		return nil, err
+	}
+	note.ID = id
+
+	return note, nil
+}
+
+func (s *Service) PlanUserNote(steamid string, req payload.UserNote, author string) (*payload.Plan, error) {
+	note, err := newUserNote(steamid, req, author)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.DryRun().AddUserNote(note); err != nil {
+		return nil, err
+	}
+
+	return &payload.Plan{
+		Operation: "note",
+		Result: note,
+	}, nil
+}
+
+func newUserNote(steamid string, req payload.UserNote, author string) (*schema.UserNote, error) {
+	note := &schema.UserNote{
+		SteamID: steamid,
+		Author: author,
+		Category: strings.ToLower(strings.TrimSpace(req.Category)),
+		CharacterID: req.CharacterID,
+		Body: strings.TrimSpace(req.Body),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if note.Body == "" {
+		return nil, static.ErrEmptyNote
+	}
+	if note.Category == "" {
+		note.Category = defaultNoteCategory
+	}
+	if !noteCategories[note.Category] {
+		return nil, static.ErrBadNoteCategory
+	}
+	return note, nil
+}
+
+func (s *Service) GetUserNotes(steamid string, category string) ([]schema.UserNote, error) {
+	category = strings.ToLower(category)
+	if category != "" && !noteCategories[category] {
+		return nil, static.ErrBadNoteCategory
+	}
+
+	notes, err := s.db.GetUserNotes(steamid, category)
+	if err != nil {
+		return nil, err
+	}
+
+	return notes, nil
+}
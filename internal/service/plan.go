@@ -0,0 +1,431 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/pkg/utils"
+
+	"github.com/google/uuid"
+)
+
+// The Plan functions work out what the character operations would change without
+// changing anything. They run the real operation against a dry run of the database,
+// so they fail exactly the way the operation would.
+
+func (s *Service) PlanMoveCharacter(uid uuid.UUID, steamid string, slot int) (*payload.Plan, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().MoveCharacter(uid, steamid, slot, ""); err != nil {
+		return nil, err
+	}
+
+	return &payload.Plan{
+		Operation: "move",
+		Characters: []payload.PlannedCharacter{{
+			ID: uid,
+			Action: "move",
+			SteamID: char.SteamID,
+			Slot: intPtr(char.Slot),
+			ToSteamID: steamid,
+			ToSlot: intPtr(slot),
+		}},
+	}, nil
+}
+
+func (s *Service) PlanCopyCharacter(uid uuid.UUID, steamid string, slot int) (*payload.Plan, error) {
+	_, err := s.db.GetUser(steamid)
+	created := errors.Is(err, database.ErrNoDocument)
+	if err != nil && !created {
+		return nil, err
+	}
+
+	if _, err := s.db.DryRun().CopyCharacter(uid, steamid, slot, ""); err != nil {
+		return nil, err
+	}
+
+	plan := &payload.Plan{
+		Operation: "copy",
+		Characters: []payload.PlannedCharacter{{
+			ID: uid,
+			Action: "copy",
+			ToSteamID: steamid,
+			ToSlot: intPtr(slot),
+		}},
+	}
+	if created {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("user %s would be created", steamid))
+	}
+
+	return plan, nil
+}
+
+func (s *Service) PlanHardDeleteCharacter(uid uuid.UUID) (*payload.Plan, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().DeleteCharacter(uid); err != nil {
+		return nil, err
+	}
+
+	planned, err := s.plannedCharacter(char, "delete")
+	if err != nil {
+		return nil, err
+	}
+	planned.Pruned = plannedVersions(char.Versions)
+
+	return &payload.Plan{
+		Operation: "delete",
+		Characters: []payload.PlannedCharacter{*planned},
+	}, nil
+}
+
+// PlanRestoreCharacter plans restoring a soft deleted character, into slot when it's not nil.
+func (s *Service) PlanRestoreCharacter(uid uuid.UUID, slot *int) (*payload.Plan, error) {
+	steamid, origSlot, err := s.db.GetDeletedSlot(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().RestoreCharacter(uid, slot, ""); err != nil {
+		return nil, err
+	}
+
+	target := origSlot
+	if slot != nil {
+		target = *slot
+	}
+
+	return &payload.Plan{
+		Operation: "restore",
+		Characters: []payload.PlannedCharacter{{
+			ID: uid,
+			Action: "restore",
+			SteamID: steamid,
+			Slot: intPtr(origSlot),
+			ToSteamID: steamid,
+			ToSlot: intPtr(target),
+		}},
+	}, nil
+}
+
+func (s *Service) PlanSwapCharacters(steamid string, a int, b int) (*payload.Plan, error) {
+	user, err := s.db.GetUser(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := swapCharacters(s.db.DryRun(), steamid, a, b); err != nil {
+		return nil, err
+	}
+
+	plan := &payload.Plan{Operation: "swap"}
+	for _, slots := range [][2]int{{a, b}, {b, a}} {
+		uid, ok := user.Characters[slots[0]]
+		if !ok {
+			continue
+		}
+
+		plan.Characters = append(plan.Characters, payload.PlannedCharacter{
+			ID: uid,
+			Action: "swap",
+			SteamID: steamid,
+			Slot: intPtr(slots[0]),
+			ToSteamID: steamid,
+			ToSlot: intPtr(slots[1]),
+		})
+	}
+
+	return plan, nil
+}
+
+// PlanImportCharacter plans storing an uploaded character file in the slot.
+func (s *Service) PlanImportCharacter(steamid string, slot int, size int, data string) (*payload.Plan, error) {
+	user, err := s.db.GetUser(steamid)
+	if err != nil && !errors.Is(err, database.ErrNoDocument) {
+		return nil, err
+	}
+
+	var char *schema.Character
+	if user != nil {
+		if uid, ok := user.Characters[slot]; ok {
+			if char, err = s.db.GetCharacter(uid); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if _, _, err := s.db.DryRun().ImportCharacter(steamid, slot, size, data, "", "", s.config.Char.MaxBackups); err != nil {
+		return nil, err
+	}
+
+	plan := &payload.Plan{Operation: "import"}
+	planned := payload.PlannedCharacter{
+		Action: "create",
+		ToSteamID: steamid,
+		ToSlot: intPtr(slot),
+	}
+	if user == nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("user %s would be created", steamid))
+	}
+	if char != nil {
+		planned.ID = char.ID
+		planned.Action = "import"
+		planned.SteamID = steamid
+		planned.Slot = intPtr(slot)
+		planned.Overwritten = currentVersion(char)
+	}
+
+	plan.Characters = append(plan.Characters, planned)
+	plan.Result = payload.CharacterImport{
+		ID: planned.ID,
+		Created: char == nil,
+	}
+	return plan, nil
+}
+
+func (s *Service) PlanRollbackCharacter(uid uuid.UUID, ver int64) (*payload.Plan, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().RollbackCharacter(uid, ver); err != nil {
+		return nil, err
+	}
+
+	return s.planRollback(char, ver)
+}
+
+// PlanRollbackCharacterAt plans rolling back to the newest version saved at or before t.
+func (s *Service) PlanRollbackCharacterAt(uid uuid.UUID, t time.Time) (*payload.Plan, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	ver, err := s.db.GetCharacterVersionAt(uid, t)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().RollbackCharacter(uid, ver); err != nil {
+		return nil, err
+	}
+
+	plan, err := s.planRollback(char, ver)
+	if err != nil {
+		return nil, err
+	}
+	plan.Result = ver
+
+	return plan, nil
+}
+
+func (s *Service) PlanRollbackCharacterToLatest(uid uuid.UUID) (*payload.Plan, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().RollbackCharacterToLatest(uid); err != nil {
+		return nil, err
+	}
+
+	return s.planRollback(char, char.Versions[len(char.Versions)-1].ID)
+}
+
+func (s *Service) planRollback(char *schema.Character, ver int64) (*payload.Plan, error) {
+	planned, err := s.plannedCharacter(char, "rollback")
+	if err != nil {
+		return nil, err
+	}
+	planned.Version = ver
+	planned.Overwritten = currentVersion(char)
+
+	return &payload.Plan{
+		Operation: "rollback",
+		Characters: []payload.PlannedCharacter{*planned},
+	}, nil
+}
+
+// PlanUndoCharacterOperation plans undoing the last operation made to the character.
+func (s *Service) PlanUndoCharacterOperation(uid uuid.UUID) (*payload.Plan, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	expire, err := utils.ParseDuration(s.config.Char.DeletedExpireTime)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := s.db.DryRun().UndoCharacterOperation(uid, expire, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var planned *payload.PlannedCharacter
+	switch {
+	case op.Operation == "move":
+		if planned, err = s.plannedCharacter(char, "move"); err != nil {
+			return nil, err
+		}
+		planned.ToSteamID = op.SteamID
+		planned.ToSlot = intPtr(op.Slot)
+	case op.Operation == "rollback", op.Operation == "import" && op.SnapshotID != 0:
+		if planned, err = s.plannedCharacter(char, "rollback"); err != nil {
+			return nil, err
+		}
+		planned.Snapshot = op.SnapshotID
+		planned.Overwritten = currentVersion(char)
+	default: // copy, restore, or an import that created the character
+		if planned, err = s.plannedCharacter(char, "soft_delete"); err != nil {
+			return nil, err
+		}
+	}
+
+	return &payload.Plan{
+		Operation: "undo",
+		Characters: []payload.PlannedCharacter{*planned},
+		Result: op,
+	}, nil
+}
+
+func (s *Service) PlanDeleteCharacterVersions(uid uuid.UUID) (*payload.Plan, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DryRun().DeleteCharacterVersions(uid); err != nil {
+		return nil, err
+	}
+
+	planned, err := s.plannedCharacter(char, "prune_versions")
+	if err != nil {
+		return nil, err
+	}
+	planned.Pruned = plannedVersions(char.Versions)
+
+	return &payload.Plan{
+		Operation: "delete_versions",
+		Characters: []payload.PlannedCharacter{*planned},
+	}, nil
+}
+
+// PlanRevertJournalEntry plans reverting a journaled move, copy or delete.
+func (s *Service) PlanRevertJournalEntry(id int64) (*payload.Plan, error) {
+	if _, err := s.revertJournalEntry(s.db.DryRun(), id, ""); err != nil {
+		return nil, err
+	}
+
+	entry, err := s.db.GetJournalEntry(id)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &payload.Plan{
+		Operation: "revert",
+		Result: entry,
+	}
+
+	switch entry.Operation {
+	case "move":
+		char, err := s.db.GetCharacter(entry.CharacterID)
+		if err != nil {
+			return nil, err
+		}
+
+		planned, err := s.plannedCharacter(char, "move")
+		if err != nil {
+			return nil, err
+		}
+		planned.ToSteamID = entry.Before.SteamID
+		planned.ToSlot = intPtr(entry.Before.Slot)
+		plan.Characters = append(plan.Characters, *planned)
+	case "copy":
+		char, err := s.db.GetCharacter(*entry.TargetID)
+		if err != nil {
+			return nil, err
+		}
+
+		planned, err := s.plannedCharacter(char, "soft_delete")
+		if err != nil {
+			return nil, err
+		}
+		plan.Characters = append(plan.Characters, *planned)
+	case "delete":
+		if entry.Before.DeletedAt != nil {
+			plan.Warnings = append(plan.Warnings, "the character was soft deleted when it was removed and would come back soft deleted")
+		}
+
+		plan.Characters = append(plan.Characters, payload.PlannedCharacter{
+			ID: entry.CharacterID,
+			Action: "restore",
+			ToSteamID: entry.Before.SteamID,
+			ToSlot: intPtr(entry.Before.Slot),
+		})
+	}
+
+	return plan, nil
+}
+
+// plannedCharacter starts the plan for a character with its current owner and slot,
+// soft deleted characters get the ones they were deleted from.
+func (s *Service) plannedCharacter(char *schema.Character, action string) (*payload.PlannedCharacter, error) {
+	planned := &payload.PlannedCharacter{
+		ID: char.ID,
+		Action: action,
+		SteamID: char.SteamID,
+		Slot: intPtr(char.Slot),
+	}
+
+	if char.DeletedAt != nil {
+		steamid, slot, err := s.db.GetDeletedSlot(char.ID)
+		if err != nil && !errors.Is(err, database.ErrNoDocument) {
+			return nil, err
+		}
+		planned.SteamID = steamid
+		planned.Slot = intPtr(slot)
+		if err != nil {
+			planned.Slot = nil
+		}
+	}
+
+	return planned, nil
+}
+
+func currentVersion(char *schema.Character) *payload.PlannedVersion {
+	return &payload.PlannedVersion{
+		CreatedAt: char.Data.CreatedAt,
+		Size: char.Data.Size,
+	}
+}
+
+func plannedVersions(versions []schema.CharacterData) []payload.PlannedVersion {
+	planned := make([]payload.PlannedVersion, 0, len(versions))
+	for _, v := range versions {
+		planned = append(planned, payload.PlannedVersion{
+			ID: v.ID,
+			CreatedAt: v.CreatedAt,
+			Size: v.Size,
+			Tag: v.Tag,
+		})
+	}
+	return planned
+}
+
+func intPtr(i int) *int {
+	return &i
+}
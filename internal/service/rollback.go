@@ -1,13 +1,20 @@
 package service
 
 import (
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/msrevive/nexus2/pkg/database/schema"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
 	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/utils"
 
 	"github.com/google/uuid"
 )
 
-func (s *Service) GetCharacterVersions(uid uuid.UUID) (map[int]schema.CharacterData, error) {
+func (s *Service) GetCharacterVersions(uid uuid.UUID) (map[int64]schema.CharacterData, error) {
 	char, err := s.db.GetCharacter(uid)
 	if err != nil {
 		return nil, err
@@ -15,10 +22,10 @@ func (s *Service) GetCharacterVersions(uid uuid.UUID) (map[int]schema.CharacterD
 
 	backupLen := len(char.Versions)
 	if backupLen > 0 {
-		datas := make(map[int]schema.CharacterData, backupLen)
+		datas := make(map[int64]schema.CharacterData, backupLen)
 
-		for k,v := range char.Versions {
-			datas[k] = v
+		for _,v := range char.Versions {
+			datas[v.ID] = v
 		}
 
 		return datas, nil
@@ -27,30 +34,65 @@ func (s *Service) GetCharacterVersions(uid uuid.UUID) (map[int]schema.CharacterD
 	return nil, static.ErrNoCharacterVersions
 }
 
-func (s *Service) RollbackCharacter(uid uuid.UUID, ver int) error {
+func (s *Service) RollbackCharacter(uid uuid.UUID, ver int64, admin string) error {
 	if s.readonly {
 		return nil
 	}
 
-	err := s.db.RollbackCharacter(uid, ver)
+	return s.notifyRollback(uid, ver, admin).RollbackCharacter(uid, ver)
+}
+
+// RollbackCharacterAt rolls back to the newest version saved at or before t,
+// returning the version ID that was restored.
+func (s *Service) RollbackCharacterAt(uid uuid.UUID, t time.Time, admin string) (int64, error) {
+	if s.readonly {
+		return 0, nil
+	}
+
+	// The version is found first so the webhook event can name it.
+	ver, err := s.db.GetCharacterVersionAt(uid, t)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	if err := s.notifyRollback(uid, ver, admin).RollbackCharacter(uid, ver); err != nil {
+		return 0, err
+	}
+
+	return ver, nil
 }
 
-func (s *Service) RollbackCharacterToLatest(uid uuid.UUID) error {
+func (s *Service) RollbackCharacterToLatest(uid uuid.UUID, admin string) error {
 	if s.readonly {
 		return nil
 	}
 
-	err := s.db.RollbackCharacterToLatest(uid)
+	db := s.notifyCharacter(database.EventRollback, uid, nil, fmt.Sprintf("character %s was rolled back to its latest version", uid), admin, nil)
+	return db.RollbackCharacterToLatest(uid)
+}
+
+func (s *Service) notifyRollback(uid uuid.UUID, ver int64, admin string) database.Database {
+	return s.notifyCharacter(database.EventRollback, uid, nil,
+		fmt.Sprintf("character %s was rolled back to version %d", uid, ver), admin, map[string]int64{"version": ver})
+}
+
+// UndoCharacterOperation reverts the last rollback, restore, move, copy or import made to the character.
+func (s *Service) UndoCharacterOperation(uid uuid.UUID, expiration string, admin string) (*schema.CharacterOperation, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	expire, err := utils.ParseDuration(expiration)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	op, err := s.db.UndoCharacterOperation(uid, expire, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
 }
 
 func (s *Service) DeleteCharacterVersions(uid uuid.UUID) error {
@@ -66,11 +108,68 @@ func (s *Service) DeleteCharacterVersions(uid uuid.UUID) error {
 	return nil
 }
 
-func (s *Service) GetCharacterVersionsTimestamp(uid uuid.UUID) (data map[int]string, err error) {
+func (s *Service) GetCharacterVersionsTimestamp(uid uuid.UUID) (data map[int64]string, err error) {
 	data, err = s.db.GetRollbackVersionsTimestamp(uid)
 	if len(data) == 0 {
 		return data, static.ErrNoCharacterVersions
 	}
 
 	return
+}
+
+// GetCharacterVersion returns the character along with the data for one of its versions
+// without modifying anything.
+func (s *Service) GetCharacterVersion(uid uuid.UUID, ver string) (*schema.Character, schema.CharacterData, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, schema.CharacterData{}, err
+	}
+
+	data, err := characterVersion(char, ver)
+	if err != nil {
+		return nil, schema.CharacterData{}, err
+	}
+
+	return char, data, nil
+}
+
+// DiffCharacterVersions compares two versions of a character, either side can be
+// "current" to reference the live character data instead of a backup.
+func (s *Service) DiffCharacterVersions(uid uuid.UUID, from string, to string) (*payload.CharacterDiff, error) {
+	char, err := s.db.GetCharacter(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	fromData, err := characterVersion(char, from)
+	if err != nil {
+		return nil, err
+	}
+
+	toData, err := characterVersion(char, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload.DiffCharacterData(from, to, fromData.Data, toData.Data)
+}
+
+// characterVersion resolves a version reference, which is either "current" or a version ID.
+func characterVersion(char *schema.Character, ref string) (schema.CharacterData, error) {
+	if ref == "current" {
+		return char.Data, nil
+	}
+
+	ver, err := strconv.ParseInt(ref, 10, 64)
+	if err != nil {
+		return schema.CharacterData{}, static.ErrNoCharacterVersion
+	}
+
+	for _, v := range char.Versions {
+		if v.ID == ver {
+			return v, nil
+		}
+	}
+
+	return schema.CharacterData{}, static.ErrNoCharacterVersion
 }
\ No newline at end of file
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+)
+
+// SearchUsers returns a page of the users matching the filter, flags and without are flag
+// names the users must all have or have none of.
+func (s *Service) SearchUsers(filter database.UserFilter, flags []string, without []string) (*payload.UserPage, error) {
+	var err error
+	if filter.Flags, err = s.flagMask(flags); err != nil {
+		return nil, err
+	}
+	if filter.WithoutFlags, err = s.flagMask(without); err != nil {
+		return nil, err
+	}
+
+	// One more than asked for tells whether there's a page after this one.
+	limit := filter.Limit
+	filter.Limit++
+	users, err := s.db.SearchUsers(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &payload.UserPage{Users: users}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.Next = page.Users[limit-1].ID
+	}
+	return page, nil
+}
+
+// SearchCharacters returns a page of the characters matching the filter.
+func (s *Service) SearchCharacters(filter database.CharacterFilter) (*payload.CharacterPage, error) {
+	limit := filter.Limit
+	filter.Limit++
+	chars, err := s.db.SearchCharacters(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &payload.CharacterPage{Characters: chars}
+	if len(chars) > limit {
+		page.Characters = chars[:limit]
+		page.Next = page.Characters[limit-1].ID.String()
+	}
+	return page, nil
+}
+
+func (s *Service) flagMask(names []string) (bitmask.Bitmask, error) {
+	var mask bitmask.Bitmask
+	for _, name := range names {
+		flag, ok := s.flags.Lookup(name)
+		if !ok {
+			return 0, fmt.Errorf("%w: %s", static.ErrUnknownFlag, name)
+		}
+		mask.AddFlag(flag.Mask())
+	}
+	return mask, nil
+}
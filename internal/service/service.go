@@ -1,20 +1,36 @@
 package service
 
 import (
+	"log/slog"
+
+	"github.com/msrevive/nexus2/internal/bitmask"
 	"github.com/msrevive/nexus2/internal/database"
 	"github.com/msrevive/nexus2/internal/config"
+	"github.com/msrevive/nexus2/internal/jobs"
+	"github.com/msrevive/nexus2/internal/webhook"
 )
 
 type Service struct {
 	db database.Database
+	logger *slog.Logger
 	config *config.Config
+	flags *bitmask.Registry
 	readonly bool
+	jobs *jobs.Pool
+	webhooks *webhook.Dispatcher
 }
 
-func New(db database.Database, cfg *config.Config, ro bool) *Service {
-	return &Service{
+func New(db database.Database, log *slog.Logger, cfg *config.Config, flags *bitmask.Registry, ro bool) *Service {
+	s := &Service{
 		db: db,
+		logger: log,
 		config: cfg,
+		flags: flags,
 		readonly: ro,
+		jobs: jobs.New(db, log, cfg.Jobs.Workers),
+		webhooks: webhook.New(db, log, cfg.Webhooks.Endpoints, cfg.Webhooks.MaxAttempts),
 	}
+	s.registerJobs()
+
+	return s
 }
\ No newline at end of file
@@ -1,8 +1,14 @@
 package service
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/msrevive/nexus2/pkg/database/schema"
 	"github.com/msrevive/nexus2/internal/bitmask"
+	"github.com/msrevive/nexus2/internal/payload"
+	"github.com/msrevive/nexus2/internal/static"
+	"github.com/msrevive/nexus2/pkg/utils"
 )
 
 func (s *Service) GetAllUsers() ([]*schema.User, error) {
@@ -10,7 +16,22 @@ func (s *Service) GetAllUsers() ([]*schema.User, error) {
 }
 
 func (s *Service) GetUser(steamid string) (*schema.User, error) {
-	return s.db.GetUser(steamid)
+	user, err := s.db.GetUser(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Grants, err = s.GetFlagGrants(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Notes, err = s.db.GetUserNoteSummary(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
 }
 
 func (s *Service) GetUserFlags(steamid string) (bitmask.Bitmask, error) {
@@ -58,4 +79,208 @@ func (s *Service) RemoveUserFlag(steamid string, flag bitmask.Bitmask) (error) {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func (s *Service) GetFlags() []bitmask.Flag {
+	return s.flags.Flags()
+}
+
+// GrantUserFlag sets the registered flag with the given name, the grant expires after
+// the requested duration or at the requested time and never when neither is given.
+func (s *Service) GrantUserFlag(steamid string, name string, req payload.FlagGrant, admin string) (*schema.FlagGrant, error) {
+	if s.readonly {
+		return nil, nil
+	}
+
+	grant, err := s.newFlagGrant(steamid, name, req, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	// The banned flag is recorded as a ban like any other, the grant's source is the reason.
+	if isBanGrant(grant) {
+		if _, err := s.db.AddBan(grantBan(grant)); err != nil {
+			return nil, err
+		}
+		return grant, nil
+	}
+
+	if err := s.db.GrantUserFlag(grant); err != nil {
+		return nil, err
+	}
+
+	return grant, nil
+}
+
+// PlanGrantUserFlag validates the grant and works out the user's flags after it.
+func (s *Service) PlanGrantUserFlag(steamid string, name string, req payload.FlagGrant, admin string) (*payload.Plan, error) {
+	grant, err := s.newFlagGrant(steamid, name, req, admin)
+	if err != nil {
+		return nil, err
+	}
+
+	flags, err := s.db.GetUserFlags(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBanGrant(grant) {
+		_, err = s.db.DryRun().AddBan(grantBan(grant))
+	} else {
+		err = s.db.DryRun().GrantUserFlag(grant)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &payload.Plan{
+		Operation: "grant_flag",
+		Flags: &payload.PlannedFlags{
+			SteamID: steamid,
+			Before: uint32(flags),
+			After: uint32(flags | 1<<grant.Bit),
+		},
+		Result: grant,
+	}
+
+	if isBanGrant(grant) {
+		if err := s.planLiftBan(plan, steamid, "replace"); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// newFlagGrant validates the grant request against the flag registry.
+func (s *Service) newFlagGrant(steamid string, name string, req payload.FlagGrant, admin string) (*schema.FlagGrant, error) {
+	flag, ok := s.flags.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", static.ErrUnknownFlag, name)
+	}
+
+	now := time.Now().UTC()
+	expiresAt, err := grantExpiry(req, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema.FlagGrant{
+		SteamID: steamid,
+		Bit: flag.Bit,
+		Flag: flag.Name,
+		Source: req.Source,
+		Admin: admin,
+		GrantedAt: now,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// grantExpiry works out when a grant made at the given time runs out, nil is never.
+func grantExpiry(req payload.FlagGrant, at time.Time) (*time.Time, error) {
+	expiresAt := req.ExpiresAt
+	if req.Duration != "" {
+		d, err := utils.ParseDuration(req.Duration)
+		if err != nil {
+			return nil, err
+		}
+
+		t := at.Add(d)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(at) {
+		return nil, static.ErrBadGrantExpiry
+	}
+
+	return expiresAt, nil
+}
+
+// ClearUserFlag clears the registered flag with the given name along with its grant,
+// clearing the banned flag lifts the user's ban.
+func (s *Service) ClearUserFlag(steamid string, name string, admin string) error {
+	flag, ok := s.flags.Lookup(name)
+	if !ok {
+		return fmt.Errorf("%w: %s", static.ErrUnknownFlag, name)
+	}
+
+	if flag.Mask() == bitmask.BANNED {
+		return s.UnbanUser(steamid, "", admin)
+	}
+
+	return s.RemoveUserFlag(steamid, flag.Mask())
+}
+
+func (s *Service) PlanClearUserFlag(steamid string, name string) (*payload.Plan, error) {
+	flag, ok := s.flags.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", static.ErrUnknownFlag, name)
+	}
+
+	if flag.Mask() == bitmask.BANNED {
+		return s.PlanUnbanUser(steamid)
+	}
+
+	flags, err := s.db.GetUserFlags(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	after := flags
+	after.ClearFlag(flag.Mask())
+	if err := s.db.DryRun().SetUserFlags(steamid, after); err != nil {
+		return nil, err
+	}
+
+	return &payload.Plan{
+		Operation: "clear_flag",
+		Flags: &payload.PlannedFlags{
+			SteamID: steamid,
+			Before: uint32(flags),
+			After: uint32(after),
+		},
+	}, nil
+}
+
+// GetFlagGrants returns the user's grants with the names of their flags, grants of
+// bits that are no longer in the registry are left unnamed.
+func (s *Service) GetFlagGrants(steamid string) ([]schema.FlagGrant, error) {
+	grants, err := s.db.GetFlagGrants(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uint]string)
+	for _, f := range s.flags.Flags() {
+		names[f.Bit] = f.Name
+	}
+	for i := range grants {
+		grants[i].Flag = names[grants[i].Bit]
+	}
+
+	return grants, nil
+}
+
+// GetFlagExpiry returns when each of the user's expiring flags runs out, only flags the
+// game server is sent are included.
+func (s *Service) GetFlagExpiry(steamid string) (map[string]time.Time, error) {
+	grants, err := s.GetFlagGrants(steamid)
+	if err != nil {
+		return nil, err
+	}
+
+	game := s.flags.GameMask()
+	expiry := make(map[string]time.Time)
+	for _, g := range grants {
+		if g.ExpiresAt == nil || g.Flag == "" || !game.HasFlag(1<<g.Bit) {
+			continue
+		}
+		expiry[g.Flag] = *g.ExpiresAt
+	}
+
+	return expiry, nil
+}
+
+// gameFlags strips the flags the game server isn't meant to see.
+func (s *Service) gameFlags(flags bitmask.Bitmask) bitmask.Bitmask {
+	return flags & s.flags.GameMask()
+}
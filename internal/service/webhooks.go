@@ -0,0 +1,57 @@
+package service
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+// StartWebhooks starts delivering webhooks, nothing is sent in read only mode.
+func (s *Service) StartWebhooks() {
+	if s.readonly {
+		return
+	}
+
+	s.webhooks.Start()
+}
+
+func (s *Service) StopWebhooks() {
+	if s.readonly {
+		return
+	}
+
+	s.webhooks.Stop()
+}
+
+func (s *Service) GetWebhookDeliveries(filter database.WebhookFilter) ([]schema.WebhookDelivery, error) {
+	return s.db.GetWebhookDeliveries(filter)
+}
+
+// notify returns a view of the database that queues the event for the webhooks in the
+// same transaction as the write made through it, so the event is sent exactly when the
+// change is committed.
+func (s *Service) notify(event database.Event) database.Database {
+	return s.db.WithEvent(event)
+}
+
+// notifyCharacter returns the view of notify for an event about a character. char is
+// looked up when it's nil.
+func (s *Service) notifyCharacter(kind string, uid uuid.UUID, char *schema.Character, summary string, by string, data interface{}) database.Database {
+	if char == nil && s.webhooks.Wants(kind) {
+		char, _ = s.db.GetCharacter(uid)
+	}
+
+	event := database.Event{
+		Kind: kind,
+		Summary: summary,
+		CharacterID: &uid,
+		By: by,
+		Data: data,
+	}
+	if char != nil {
+		event.SteamID = char.SteamID
+	}
+
+	return s.notify(event)
+}
@@ -20,4 +20,32 @@ var (
 var (
 	ErrNoCharacterVersions = errors.New("no character versions exist")
 	ErrBadCharacterData = errors.New("malformed character data")
+	ErrNoCharacterVersion = errors.New("character version does not exist")
+	ErrBadTimeWindow = errors.New("from must be before to")
+	ErrMassRollbackRunning = errors.New("a mass rollback is already running")
+	ErrNoMassRollback = errors.New("mass rollback does not exist")
+	ErrJournalExpired = errors.New("journal entry is past the retention window")
+	ErrSameSlot = errors.New("slots must be different")
+	ErrSameUser = errors.New("users must be different")
+	ErrBadMergePolicy = errors.New("unknown merge slot policy")
+	ErrBadMergeRule = errors.New("bad merge flag rule")
+	ErrBadBanDuration = errors.New("ban duration must be positive")
+	ErrUnknownFlag = errors.New("unknown user flag")
+	ErrBadGrantExpiry = errors.New("flag grant must expire in the future")
+	ErrEmptyNote = errors.New("note body is empty")
+	ErrBadNoteCategory = errors.New("unknown note category")
+	ErrApprovalReason = errors.New("a reason is required, set the X-Audit-Reason header")
+	ErrApprovalNotPending = errors.New("approval is not pending")
+	ErrApprovalExpired = errors.New("approval has expired")
+	ErrSelfApproval = errors.New("approval must come from a different admin")
+	ErrNoDryRun = errors.New("dry_run is not supported by this endpoint")
+	ErrNotSystemAdmin = errors.New("approvals need the API key listed for your IP in the system admins")
+	ErrUnknownJobKind = errors.New("unknown job kind")
+	ErrJobQueueFull = errors.New("job queue is full, try again later")
+	ErrNoFlagChanges = errors.New("no flag changes")
+	ErrTooManyFlagChanges = errors.New("too many flag changes in one request")
+	ErrBadFlagOp = errors.New("flag operation must be add, remove or set")
+	ErrBadSteamID = errors.New("steamid must be a SteamID64")
+	ErrNotDeleted = errors.New("character is not deleted")
+	ErrBadDeletedExpiry = errors.New("deleted character must expire in the future, set duration or expires_at")
 )
\ No newline at end of file
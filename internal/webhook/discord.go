@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+)
+
+// Embed colours, by the first part of the event name.
+var discordColors = map[string]int{
+	"ban": 0xE74C3C,
+	"character": 0xE67E22,
+	"unsafe": 0xE67E22,
+	"rollback": 0x3498DB,
+}
+
+type discordBody struct {
+	Username string `json:"username"`
+	Embeds []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title string `json:"title"`
+	Description string `json:"description"`
+	Color int `json:"color,omitempty"`
+	Fields []discordField `json:"fields,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+type discordField struct {
+	Name string `json:"name"`
+	Value string `json:"value"`
+	Inline bool `json:"inline"`
+}
+
+// discordMessage formats the event as a Discord webhook message with one embed.
+func discordMessage(event database.Event) discordBody {
+	embed := discordEmbed{
+		Title: event.Kind,
+		Description: event.Summary,
+		Color: discordColors[strings.SplitN(event.Kind, ".", 2)[0]],
+		Timestamp: event.CreatedAt.UTC().Format(time.RFC3339),
+	}
+
+	if event.SteamID != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "SteamID", Value: event.SteamID, Inline: true})
+	}
+	if event.CharacterID != nil {
+		embed.Fields = append(embed.Fields, discordField{Name: "Character", Value: event.CharacterID.String(), Inline: true})
+	}
+	if event.By != "" {
+		embed.Fields = append(embed.Fields, discordField{Name: "By", Value: event.By, Inline: true})
+	}
+
+	return discordBody{
+		Username: "Nexus2",
+		Embeds: []discordEmbed{embed},
+	}
+}
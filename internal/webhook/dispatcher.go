@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+)
+
+const (
+	pollEvery = 5 * time.Second // how often the queue is checked for deliveries that are due
+	batchSize = 50 // deliveries sent per check
+	firstRetry = 30 * time.Second // wait before the first retry, doubled after every attempt
+	maxRetry = time.Hour
+	defaultAttempts = 8
+	sendTimeout = 10 * time.Second
+)
+
+// Dispatcher delivers the deliveries the database queued for the endpoints in the
+// background. The queue is kept in the database so deliveries survive a restart.
+type Dispatcher struct {
+	db database.Database
+	logger *slog.Logger
+	endpoints Outbox
+	maxAttempts int
+	client *http.Client
+
+	ctx context.Context
+	cancel context.CancelFunc
+	wg sync.WaitGroup
+}
+
+// New returns a dispatcher for the endpoints, maxAttempts is how many times a delivery is
+// tried before it fails for good.
+func New(db database.Database, log *slog.Logger, endpoints []Endpoint, maxAttempts int) *Dispatcher {
+	if maxAttempts < 1 {
+		maxAttempts = defaultAttempts
+	}
+
+	for _, ep := range endpoints {
+		if ep.URL == "" {
+			log.Warn("webhooks: endpoint has no url, skipping it", "name", ep.Name)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		db: db,
+		logger: log,
+		endpoints: NewOutbox(endpoints),
+		maxAttempts: maxAttempts,
+		client: &http.Client{Timeout: sendTimeout},
+		ctx: ctx,
+		cancel: cancel,
+	}
+}
+
+// Start starts delivering, deliveries left pending from before a restart are sent first.
+func (d *Dispatcher) Start() {
+	if len(d.endpoints) == 0 {
+		return
+	}
+
+	d.wg.Add(1)
+	go d.work()
+}
+
+// Stop waits for the delivery being sent to finish, the rest stay queued.
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Wants reports whether any endpoint is sent the event.
+func (d *Dispatcher) Wants(event string) bool {
+	return d.endpoints.Wants(event)
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(pollEvery)
+	defer ticker.Stop()
+
+	for {
+		d.deliverDue()
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue sends the deliveries that are due, a batch at a time until none are left.
+func (d *Dispatcher) deliverDue() {
+	for d.ctx.Err() == nil {
+		due, err := d.db.GetDueWebhookDeliveries(time.Now().UTC(), batchSize)
+		if err != nil {
+			d.logger.Error("webhooks: failed to get due deliveries", "error", err)
+			return
+		}
+
+		for i := range due {
+			if d.ctx.Err() != nil {
+				return
+			}
+			d.deliver(&due[i])
+		}
+
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(delivery *schema.WebhookDelivery) {
+	ep, ok := d.endpoints.endpoint(delivery.Endpoint)
+	if !ok {
+		d.finish(delivery, database.WebhookFailed, "endpoint is no longer configured")
+		return
+	}
+
+	code, retryAfter, err := d.send(ep, delivery)
+	if err != nil && d.ctx.Err() != nil {
+		return // shutting down, the delivery is tried again after the restart
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	if err == nil {
+		d.finish(delivery, database.WebhookDelivered, "")
+		return
+	}
+
+	if delivery.Attempts >= d.maxAttempts {
+		d.logger.Warn("webhooks: delivery failed", "id", delivery.ID, "endpoint", ep.Name, "event", delivery.Event, "attempts", delivery.Attempts, "error", err)
+		d.finish(delivery, database.WebhookFailed, err.Error())
+		return
+	}
+
+	wait := backoff(delivery.Attempts)
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	next := time.Now().UTC().Add(wait)
+	delivery.NextAttempt = &next
+	delivery.Error = err.Error()
+	if err := d.db.UpdateWebhookDelivery(delivery); err != nil {
+		d.logger.Error("webhooks: failed to save delivery", "id", delivery.ID, "error", err)
+	}
+}
+
+// send posts the delivery's body, a response other than 2xx is an error. retryAfter is
+// the wait the endpoint asked for when it rate limited us.
+func (d *Dispatcher) send(ep Endpoint, delivery *schema.WebhookDelivery) (code int, retryAfter time.Duration, err error) {
+	body := []byte(delivery.Body)
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Nexus2-Webhook")
+	req.Header.Set("X-Nexus-Event", delivery.Event)
+	req.Header.Set("X-Nexus-Delivery", strconv.FormatInt(delivery.ID, 10))
+	if ep.Secret != "" {
+		req.Header.Set("X-Nexus-Signature", Sign(ep.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, 0, nil
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && secs > 0 {
+			retryAfter = time.Duration(secs * float64(time.Second))
+		}
+	}
+	return resp.StatusCode, retryAfter, fmt.Errorf("endpoint answered %s", resp.Status)
+}
+
+func (d *Dispatcher) finish(delivery *schema.WebhookDelivery, status string, reason string) {
+	now := time.Now().UTC()
+	delivery.Status = status
+	delivery.Error = reason
+	delivery.NextAttempt = nil
+	delivery.FinishedAt = &now
+	if err := d.db.UpdateWebhookDelivery(delivery); err != nil {
+		d.logger.Error("webhooks: failed to save delivery", "id", delivery.ID, "error", err)
+	}
+}
+
+// backoff is how long to wait before trying again after the given number of attempts.
+func backoff(attempts int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	if wait > maxRetry {
+		wait = maxRetry
+	}
+	return wait
+}
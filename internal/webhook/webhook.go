@@ -0,0 +1,125 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/msrevive/nexus2/internal/database"
+	"github.com/msrevive/nexus2/pkg/database/schema"
+
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+// Endpoint is a webhook from the config.
+type Endpoint struct {
+	Name string `json:"name"` //shown on its deliveries, the URL is used when it's empty
+	URL string `json:"url"`
+	Secret string `json:"secret"` //signs the body, see Sign
+	Events []string `json:"events"` //events to send, every event when empty
+	Discord bool `json:"discord"` //post Discord messages instead of the JSON event
+}
+
+// Wants reports whether the endpoint is sent the event.
+func (e Endpoint) Wants(event string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+
+	for _, want := range e.Events {
+		if want == event || want == "*" {
+			return true
+		}
+		if strings.HasSuffix(want, "*") && strings.HasPrefix(event, strings.TrimSuffix(want, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Body renders the event for the endpoint.
+func (e Endpoint) Body(event database.Event) ([]byte, error) {
+	if e.Discord {
+		return json.Marshal(discordMessage(event))
+	}
+	return json.Marshal(event)
+}
+
+// Outbox is the endpoints events are queued for, the database asks it for the deliveries
+// to write alongside each change.
+type Outbox []Endpoint
+
+// NewOutbox returns the outbox for the endpoints, endpoints without a URL are left out and
+// the rest are named after their URL when they have no name.
+func NewOutbox(endpoints []Endpoint) Outbox {
+	out := make(Outbox, 0, len(endpoints))
+	for _, ep := range endpoints {
+		if ep.URL == "" {
+			continue
+		}
+		if ep.Name == "" {
+			ep.Name = ep.URL
+		}
+		out = append(out, ep)
+	}
+	return out
+}
+
+// Wants reports whether any endpoint is sent the event.
+func (o Outbox) Wants(event string) bool {
+	for _, ep := range o {
+		if ep.Wants(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deliveries returns a pending delivery of the event for every endpoint that wants it.
+func (o Outbox) Deliveries(event database.Event) ([]schema.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = now
+	}
+
+	var deliveries []schema.WebhookDelivery
+	for _, ep := range o {
+		if !ep.Wants(event.Kind) {
+			continue
+		}
+
+		body, err := ep.Body(event)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, schema.WebhookDelivery{
+			Endpoint: ep.Name,
+			Event: event.Kind,
+			Body: string(body),
+			Status: database.WebhookPending,
+			NextAttempt: &now,
+			CreatedAt: now,
+		})
+	}
+	return deliveries, nil
+}
+
+func (o Outbox) endpoint(name string) (Endpoint, bool) {
+	for _, ep := range o {
+		if ep.Name == name {
+			return ep, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+// Sign returns the X-Nexus-Signature header for the body, the hex HMAC-SHA256 of the body
+// keyed with the endpoint's secret. Receivers should compute it themselves and compare.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/msrevive/nexus2/internal/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	json "github.com/sugawarayuuta/sonnet"
+)
+
+func TestSign(t *testing.T) {
+	// HMAC-SHA256 test vector from Wikipedia.
+	assert.Equal(t,
+		"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		Sign("key", []byte("The quick brown fox jumps over the lazy dog")),
+	)
+	assert.NotEqual(t, Sign("key", []byte("body")), Sign("other", []byte("body")))
+}
+
+func TestEndpointWants(t *testing.T) {
+	tests := []struct {
+		events []string
+		event string
+		want bool
+	}{
+		{nil, database.EventBan, true},
+		{[]string{"*"}, database.EventUnsafeMove, true},
+		{[]string{database.EventBan}, database.EventBan, true},
+		{[]string{database.EventBan}, database.EventUnban, false},
+		{[]string{"unsafe.*"}, database.EventUnsafeDelete, true},
+		{[]string{"unsafe.*"}, database.EventPurge, false},
+		{[]string{"ban.*", database.EventPurge}, database.EventPurge, true},
+	}
+
+	for _, tt := range tests {
+		ep := Endpoint{URL: "https://example.com", Events: tt.events}
+		assert.Equal(t, tt.want, ep.Wants(tt.event), "%v wants %s", tt.events, tt.event)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, backoff(1))
+	assert.Equal(t, time.Minute, backoff(2))
+	assert.Equal(t, 2*time.Minute, backoff(3))
+	assert.Equal(t, 32*time.Minute, backoff(7))
+	assert.Equal(t, time.Hour, backoff(8), "capped at maxRetry")
+	assert.Equal(t, time.Hour, backoff(50))
+}
+
+func TestDiscordBody(t *testing.T) {
+	uid := uuid.New()
+	at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := database.Event{
+		Kind: database.EventUnsafeDelete,
+		Summary: "character was hard deleted",
+		SteamID: "76561198000000000",
+		CharacterID: &uid,
+		By: "key1",
+		CreatedAt: at,
+	}
+
+	body, err := Endpoint{Discord: true}.Body(event)
+	require.NoError(t, err)
+
+	var msg discordBody
+	require.NoError(t, json.Unmarshal(body, &msg))
+	assert.Equal(t, "Nexus2", msg.Username)
+	require.Len(t, msg.Embeds, 1)
+
+	embed := msg.Embeds[0]
+	assert.Equal(t, database.EventUnsafeDelete, embed.Title)
+	assert.Equal(t, "character was hard deleted", embed.Description)
+	assert.Equal(t, discordColors["unsafe"], embed.Color)
+	assert.Equal(t, "2026-01-02T03:04:05Z", embed.Timestamp)
+	assert.Equal(t, []discordField{
+		{Name: "SteamID", Value: "76561198000000000", Inline: true},
+		{Name: "Character", Value: uid.String(), Inline: true},
+		{Name: "By", Value: "key1", Inline: true},
+	}, embed.Fields)
+
+	// Fields that are empty are left out.
+	msg = discordMessage(database.Event{Kind: database.EventMassRollback, Summary: "done"})
+	assert.Empty(t, msg.Embeds[0].Fields)
+}
+
+func TestOutboxDeliveries(t *testing.T) {
+	outbox := NewOutbox([]Endpoint{
+		{Name: "bans", URL: "https://example.com/bans", Events: []string{"ban.*"}},
+		{URL: "https://example.com/all"},
+		{Name: "no url"},
+	})
+	require.Len(t, outbox, 2, "endpoints without a url are left out")
+
+	deliveries, err := outbox.Deliveries(database.Event{Kind: database.EventBan, Summary: "banned"})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "bans", deliveries[0].Endpoint)
+	assert.Equal(t, "https://example.com/all", deliveries[1].Endpoint, "named after the url")
+	assert.Equal(t, database.WebhookPending, deliveries[0].Status)
+	require.NotNil(t, deliveries[0].NextAttempt)
+
+	var event database.Event
+	require.NoError(t, json.Unmarshal([]byte(deliveries[0].Body), &event))
+	assert.Equal(t, database.EventBan, event.Kind)
+	assert.False(t, event.CreatedAt.IsZero())
+
+	deliveries, err = outbox.Deliveries(database.Event{Kind: database.EventPurge})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, "https://example.com/all", deliveries[0].Endpoint)
+}
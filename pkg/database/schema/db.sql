@@ -1,8 +1,10 @@
 CREATE TABLE IF NOT EXISTS users (
-    id         TEXT PRIMARY KEY,
-    revision   INTEGER NOT NULL DEFAULT 0,
-    flags      INTEGER NOT NULL DEFAULT 0,
-    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+    id          TEXT PRIMARY KEY,
+    revision    INTEGER NOT NULL DEFAULT 0,
+    flags       INTEGER NOT NULL DEFAULT 0,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    merged_into TEXT,          -- SteamID64 of the account this one was merged into
+    merged_at   DATETIME
 );
 
 CREATE TABLE IF NOT EXISTS characters (
@@ -14,7 +16,8 @@ CREATE TABLE IF NOT EXISTS characters (
     expires_at      DATETIME,      -- populated on soft-delete for GC
     data_created_at DATETIME,
     data_size       INTEGER NOT NULL DEFAULT 0,
-    data_payload    TEXT NOT NULL DEFAULT ''
+    data_payload    TEXT NOT NULL DEFAULT '',
+    data_server     TEXT NOT NULL DEFAULT ''   -- game server that saved the current data
 );
 
 CREATE TABLE IF NOT EXISTS deleted_characters (
@@ -33,8 +36,204 @@ CREATE TABLE IF NOT EXISTS character_versions (
     character_id TEXT NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
     created_at   DATETIME NOT NULL,
     size         INTEGER NOT NULL,
-    data_payload TEXT NOT NULL
+    data_payload TEXT NOT NULL,
+    tag          TEXT NOT NULL DEFAULT '',
+    note         TEXT NOT NULL DEFAULT '',
+    server       TEXT NOT NULL DEFAULT ''
+);
+
+-- Data saved before an admin operation replaced it, kept apart from the versions
+-- so rollbacks never pick one and the version cap never prunes one an undo needs.
+CREATE TABLE IF NOT EXISTS character_snapshots (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    character_id    TEXT NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
+    operation       TEXT NOT NULL, -- the operation it was taken before
+    data_created_at DATETIME NOT NULL,
+    data_size       INTEGER NOT NULL,
+    data_payload    TEXT NOT NULL,
+    created_at      DATETIME NOT NULL
+);
+
+-- Journal of operations that replaced a character's data or owner, the most
+-- recent operation not yet undone can be reverted.
+CREATE TABLE IF NOT EXISTS character_operations (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    character_id TEXT NOT NULL REFERENCES characters(id) ON DELETE CASCADE,
+    operation    TEXT NOT NULL,
+    snapshot_id  INTEGER,
+    steam_id     TEXT,
+    slot         INTEGER,
+    created_at   DATETIME NOT NULL,
+    undone_at    DATETIME
+);
+
+-- Journal of unsafe operations (move, copy and hard delete) with the full character
+-- from before the operation. No foreign key so entries outlive deleted characters.
+CREATE TABLE IF NOT EXISTS operation_journal (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation    TEXT NOT NULL,
+    character_id TEXT NOT NULL,
+    target_id    TEXT,
+    to_steam_id  TEXT,
+    to_slot      INTEGER,
+    before_state TEXT NOT NULL,
+    created_at   DATETIME NOT NULL,
+    reverted_at  DATETIME
+);
+
+-- Where each character came from. Copies point at the character they were copied
+-- from, other operations point at the character itself. No foreign keys so the
+-- lineage of duplicated characters can still be traced after they're deleted.
+CREATE TABLE IF NOT EXISTS character_lineage (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    character_id TEXT NOT NULL,
+    source_id    TEXT,
+    operation    TEXT NOT NULL,
+    steam_id     TEXT NOT NULL,
+    slot         INTEGER NOT NULL,
+    admin        TEXT NOT NULL DEFAULT '',
+    created_at   DATETIME NOT NULL
+);
+
+-- Every ban a user has had, lifted bans are kept for the history. A user has at
+-- most one active ban, one that hasn't been lifted and hasn't expired.
+CREATE TABLE IF NOT EXISTS bans (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    steam_id    TEXT NOT NULL REFERENCES users(id),
+    reason      TEXT NOT NULL DEFAULT '',
+    admin       TEXT NOT NULL DEFAULT '',
+    evidence    TEXT NOT NULL DEFAULT '',
+    created_at  DATETIME NOT NULL,
+    expires_at  DATETIME,
+    lifted_at   DATETIME,
+    lifted_by   TEXT NOT NULL DEFAULT '',
+    lift_reason TEXT NOT NULL DEFAULT ''
+);
+
+-- Who gave a user each of their flags and until when. Flags set without a grant,
+-- or grants without an expiry, stay until they're cleared.
+CREATE TABLE IF NOT EXISTS flag_grants (
+    steam_id   TEXT NOT NULL REFERENCES users(id),
+    bit        INTEGER NOT NULL,
+    source     TEXT NOT NULL DEFAULT '',
+    admin      TEXT NOT NULL DEFAULT '',
+    granted_at DATETIME NOT NULL,
+    expires_at DATETIME,
+    PRIMARY KEY (steam_id, bit)
+);
+
+-- Moderation notes staff leave on a user, append only. No foreign keys so notes
+-- can be left before the user first joins and outlive the characters they link.
+CREATE TABLE IF NOT EXISTS user_notes (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    steam_id     TEXT NOT NULL,
+    author       TEXT NOT NULL DEFAULT '',
+    category     TEXT NOT NULL,
+    character_id TEXT,
+    body         TEXT NOT NULL,
+    created_at   DATETIME NOT NULL
+);
+
+-- Audit log of external API requests that change something. Each hash covers the
+-- entry and the hash before it, so editing or removing an entry breaks the chain.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at   DATETIME NOT NULL,
+    ip           TEXT NOT NULL,
+    key_id       TEXT NOT NULL DEFAULT '',
+    method       TEXT NOT NULL,
+    endpoint     TEXT NOT NULL,
+    path         TEXT NOT NULL,
+    status       INTEGER NOT NULL,
+    steam_id     TEXT NOT NULL DEFAULT '',
+    character_id TEXT,
+    reason       TEXT NOT NULL DEFAULT '',
+    before_state TEXT,
+    after_state  TEXT,
+    prev_hash    TEXT NOT NULL,
+    hash         TEXT NOT NULL
+);
+
+-- Destructive requests waiting for a second admin to approve them. The request is
+-- kept as it was made so it can be run once approved.
+CREATE TABLE IF NOT EXISTS approvals (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    method        TEXT NOT NULL,
+    path          TEXT NOT NULL,
+    endpoint      TEXT NOT NULL,
+    body          TEXT NOT NULL DEFAULT '',
+    reason        TEXT NOT NULL,
+    requested_by  TEXT NOT NULL,
+    requested_ip  TEXT NOT NULL,
+    status        TEXT NOT NULL,
+    created_at    DATETIME NOT NULL,
+    expires_at    DATETIME NOT NULL,
+    decided_by    TEXT NOT NULL DEFAULT '',
+    decided_ip    TEXT NOT NULL DEFAULT '',
+    decided_at    DATETIME,
+    result_status INTEGER NOT NULL DEFAULT 0,
+    result        TEXT NOT NULL DEFAULT ''
+);
+
+-- Long running admin operations run in the background by the job pool. Params and
+-- result are JSON, the result is saved as the job goes so it can resume after a restart.
+CREATE TABLE IF NOT EXISTS jobs (
+    id            TEXT PRIMARY KEY,
+    kind          TEXT NOT NULL,
+    status        TEXT NOT NULL,
+    params        TEXT NOT NULL DEFAULT '',
+    progress      INTEGER NOT NULL DEFAULT 0,
+    total         INTEGER NOT NULL DEFAULT 0,
+    result        TEXT NOT NULL DEFAULT '',
+    error         TEXT NOT NULL DEFAULT '',
+    requested_by  TEXT NOT NULL,
+    created_at    DATETIME NOT NULL,
+    started_at    DATETIME,
+    finished_at   DATETIME
+);
+
+-- Ordered feed of character and user changes served by GET /changes, consumers keep the
+-- id of the last change they've seen. No foreign keys so changes outlive purged characters.
+CREATE TABLE IF NOT EXISTS changes (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind         TEXT NOT NULL,
+    character_id TEXT,
+    source_id    TEXT,          -- the character a copy was made from
+    steam_id     TEXT NOT NULL DEFAULT '',
+    slot         INTEGER,
+    flags        INTEGER,       -- the user's flags after a flag change
+    created_at   DATETIME NOT NULL
+);
+
+-- Outbound webhook queue, one row per event per endpoint. Pending deliveries are retried
+-- with backoff until they're delivered or run out of attempts.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    endpoint      TEXT NOT NULL, -- name of the endpoint in the config
+    event         TEXT NOT NULL,
+    body          TEXT NOT NULL, -- sent as is on every attempt
+    status        TEXT NOT NULL, -- pending, delivered or failed
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    next_attempt  DATETIME,      -- when a pending delivery is tried next
+    response_code INTEGER NOT NULL DEFAULT 0,
+    error         TEXT NOT NULL DEFAULT '',
+    created_at    DATETIME NOT NULL,
+    finished_at   DATETIME
 );
 
 CREATE INDEX IF NOT EXISTS idx_chars_steam_id   ON characters(steam_id);
-CREATE INDEX IF NOT EXISTS idx_charver_char_id  ON character_versions(character_id);
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_charver_char_id  ON character_versions(character_id);
+CREATE INDEX IF NOT EXISTS idx_charsnap_char_id ON character_snapshots(character_id);
+CREATE INDEX IF NOT EXISTS idx_charops_char_id  ON character_operations(character_id);
+CREATE INDEX IF NOT EXISTS idx_journal_created  ON operation_journal(created_at);
+CREATE INDEX IF NOT EXISTS idx_lineage_char_id  ON character_lineage(character_id);
+CREATE INDEX IF NOT EXISTS idx_lineage_src_id   ON character_lineage(source_id);
+CREATE INDEX IF NOT EXISTS idx_bans_steam_id    ON bans(steam_id);
+CREATE INDEX IF NOT EXISTS idx_grants_expires  ON flag_grants(expires_at);
+CREATE INDEX IF NOT EXISTS idx_notes_steam_id   ON user_notes(steam_id);
+CREATE INDEX IF NOT EXISTS idx_audit_steam_id   ON audit_log(steam_id);
+CREATE INDEX IF NOT EXISTS idx_audit_created    ON audit_log(created_at);
+CREATE INDEX IF NOT EXISTS idx_approvals_status ON approvals(status);
+CREATE INDEX IF NOT EXISTS idx_jobs_status      ON jobs(status);
+CREATE INDEX IF NOT EXISTS idx_changes_created  ON changes(created_at);
+CREATE INDEX IF NOT EXISTS idx_webhooks_status  ON webhook_deliveries(status, next_attempt);
\ No newline at end of file
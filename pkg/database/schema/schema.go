@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	json "github.com/sugawarayuuta/sonnet"
 )
 
 //Characters collection
@@ -23,13 +24,222 @@ type User struct {
 	Flags uint32 `bson:"flags" json:"flags"` //account flags
 	Characters map[int]uuid.UUID `bson:"characters" json:"characters"` //Slot => reference Character by ID
 	DeletedCharacters map[int]uuid.UUID `bson:"deleted_characters" json:"deleted_characters"`
+	Grants []FlagGrant `bson:"grants,omitempty" json:"grants,omitempty"` //who gave the user their flags and until when
+	Notes *NoteSummary `bson:"notes,omitempty" json:"notes,omitempty"`
+	MergedInto string `bson:"merged_into,omitempty" json:"merged_into,omitempty"` //SteamID64 this account was merged into, the account is a tombstone once set
+}
+
+//Character operations, used to undo the last change made to a character
+type CharacterOperation struct {
+	ID int64 `bson:"_id" json:"_id"`
+	CharacterID uuid.UUID `bson:"character_id" json:"character_id"`
+	Operation string `bson:"operation" json:"operation"` //rollback, move, copy, restore or import
+	SnapshotID int64 `bson:"snapshot_id,omitempty" json:"snapshot_id,omitempty"` //snapshot of the data from before the operation
+	SteamID string `bson:"steamid,omitempty" json:"steamid,omitempty"` //owner before the operation
+	Slot int `bson:"slot" json:"slot"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UndoneAt *time.Time `bson:"undone_at,omitempty" json:"undone_at,omitempty"`
+}
+
+//Journal of unsafe operations, keeps the whole character from before the operation so it can be reverted
+type JournalEntry struct {
+	ID int64 `bson:"_id" json:"_id"`
+	Operation string `bson:"operation" json:"operation"` //move, copy or delete
+	CharacterID uuid.UUID `bson:"character_id" json:"character_id"`
+	TargetID *uuid.UUID `bson:"target_id,omitempty" json:"target_id,omitempty"` //the new character made by a copy
+	ToSteamID string `bson:"to_steamid,omitempty" json:"to_steamid,omitempty"` //where a move put the character
+	ToSlot *int `bson:"to_slot,omitempty" json:"to_slot,omitempty"`
+	Before *Character `bson:"before,omitempty" json:"before,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	RevertedAt *time.Time `bson:"reverted_at,omitempty" json:"reverted_at,omitempty"`
+}
+
+//Lineage of a character, copies point at the character they were copied from while
+//every other operation points at the character itself
+type LineageEdge struct {
+	ID int64 `bson:"_id" json:"_id"`
+	CharacterID uuid.UUID `bson:"character_id" json:"character_id"`
+	SourceID *uuid.UUID `bson:"source_id,omitempty" json:"source_id,omitempty"` //nil for imports
+	Operation string `bson:"operation" json:"operation"` //copy, move, import, restore, merge, revert or undo
+	SteamID string `bson:"steamid" json:"steamid"` //owner after the operation
+	Slot int `bson:"slot" json:"slot"`
+	Admin string `bson:"admin,omitempty" json:"admin,omitempty"` //who made the request
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+type Lineage struct {
+	CharacterID uuid.UUID `bson:"character_id" json:"character_id"`
+	History []LineageEdge `bson:"history" json:"history"` //the character's own edges, oldest first
+	Ancestors []LineageEdge `bson:"ancestors" json:"ancestors"` //copies leading to the character, nearest first
+	Descendants []LineageEdge `bson:"descendants" json:"descendants"` //copies of the character and of those copies, oldest first
+}
+
+//Flag grants collection, one per user and flag
+type FlagGrant struct {
+	SteamID string `bson:"steamid" json:"steamid"`
+	Bit uint `bson:"bit" json:"bit"`
+	Flag string `bson:"flag,omitempty" json:"flag,omitempty"` //name of the flag in the registry, not stored
+	Source string `bson:"source,omitempty" json:"source,omitempty"` //e.g. a payment ID
+	Admin string `bson:"admin,omitempty" json:"admin,omitempty"` //who made the grant
+	GrantedAt time.Time `bson:"granted_at" json:"granted_at"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"` //nil for grants that don't expire
+}
+
+//User notes collection, moderation notes staff leave on a user. Notes are never changed once added
+type UserNote struct {
+	ID int64 `bson:"_id" json:"_id"`
+	SteamID string `bson:"steamid" json:"steamid"`
+	Author string `bson:"author" json:"author"`
+	Category string `bson:"category" json:"category"`
+	CharacterID *uuid.UUID `bson:"character_id,omitempty" json:"character_id,omitempty"` //the character the note is about, if any
+	Body string `bson:"body" json:"body"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+type NoteSummary struct {
+	Count int `bson:"count" json:"count"`
+	Categories map[string]int `bson:"categories" json:"categories"` //category => number of notes
+	Latest *UserNote `bson:"latest,omitempty" json:"latest,omitempty"`
+}
+
+//Audit log of external API requests that changed something. Every entry hashes the
+//one before it, an entry that was edited or removed breaks the chain
+type AuditEntry struct {
+	ID int64 `bson:"_id" json:"_id"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	IP string `bson:"ip" json:"ip"`
+	KeyID string `bson:"key_id,omitempty" json:"key_id,omitempty"` //fingerprint of the API key, never the key itself
+	Method string `bson:"method" json:"method"`
+	Endpoint string `bson:"endpoint" json:"endpoint"` //route pattern, e.g. /api/v2/user/ban/{steamid}
+	Path string `bson:"path" json:"path"`
+	Status int `bson:"status" json:"status"` //HTTP status of the response
+	SteamID string `bson:"steamid,omitempty" json:"steamid,omitempty"`
+	CharacterID *uuid.UUID `bson:"character_id,omitempty" json:"character_id,omitempty"`
+	Reason string `bson:"reason,omitempty" json:"reason,omitempty"`
+	Before *AuditState `bson:"before,omitempty" json:"before,omitempty"`
+	After *AuditState `bson:"after,omitempty" json:"after,omitempty"`
+	PrevHash string `bson:"prev_hash" json:"prev_hash"`
+	Hash string `bson:"hash" json:"hash"`
+}
+
+//Summary of the user and character a request targeted
+type AuditState struct {
+	Flags *uint32 `bson:"flags,omitempty" json:"flags,omitempty"`
+	Characters map[int]uuid.UUID `bson:"characters,omitempty" json:"characters,omitempty"` //Slot => character ID
+	Owner string `bson:"owner,omitempty" json:"owner,omitempty"` //SteamID64 the character belongs to
+	Slot *int `bson:"slot,omitempty" json:"slot,omitempty"`
+	Size int `bson:"size,omitempty" json:"size,omitempty"`
+	Deleted bool `bson:"deleted,omitempty" json:"deleted,omitempty"`
+}
+
+//Approvals collection, destructive requests wait here until a second admin approves them
+type Approval struct {
+	ID int64 `bson:"_id" json:"_id"`
+	Method string `bson:"method" json:"method"`
+	Path string `bson:"path" json:"path"` //path of the request along with its query
+	Endpoint string `bson:"endpoint" json:"endpoint"` //route pattern of the request
+	Body string `bson:"body,omitempty" json:"body,omitempty"`
+	Reason string `bson:"reason" json:"reason"`
+	RequestedBy string `bson:"requested_by" json:"requested_by"` //API key ID of the admin who made the request, or their IP when they have no key
+	RequestedIP string `bson:"requested_ip" json:"requested_ip"`
+	Status string `bson:"status" json:"status"` //pending, approved, rejected or expired
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	DecidedBy string `bson:"decided_by,omitempty" json:"decided_by,omitempty"`
+	DecidedIP string `bson:"decided_ip,omitempty" json:"decided_ip,omitempty"`
+	DecidedAt *time.Time `bson:"decided_at,omitempty" json:"decided_at,omitempty"`
+	ResultStatus int `bson:"result_status,omitempty" json:"result_status,omitempty"` //HTTP status of the request once it ran
+	Result string `bson:"result,omitempty" json:"result,omitempty"` //response body of the request once it ran
+}
+
+//Jobs collection, long running admin operations that run in the background
+type Job struct {
+	ID uuid.UUID `bson:"_id" json:"_id"`
+	Kind string `bson:"kind" json:"kind"` //mass_rollback, gc, audit_verify, export_lists
+	Status string `bson:"status" json:"status"` //queued, running, done or failed
+	Params json.RawMessage `bson:"params,omitempty" json:"params,omitempty"`
+	Progress int `bson:"progress" json:"progress"`
+	Total int `bson:"total" json:"total"`
+	Result json.RawMessage `bson:"result,omitempty" json:"result,omitempty"` //kept as the job goes so an interrupted job can carry on
+	Error string `bson:"error,omitempty" json:"error,omitempty"`
+	RequestedBy string `bson:"requested_by" json:"requested_by"` //API key ID of the admin who submitted the job, or their IP when they have no key
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	StartedAt *time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+//Bans collection, lifted and expired bans are kept as the user's ban history
+type Ban struct {
+	ID int64 `bson:"_id" json:"_id"`
+	SteamID string `bson:"steamid" json:"steamid"`
+	Reason string `bson:"reason" json:"reason"`
+	Admin string `bson:"admin,omitempty" json:"admin,omitempty"` //who issued the ban
+	Evidence string `bson:"evidence,omitempty" json:"evidence,omitempty"` //link to a demo, screenshot, etc.
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"` //nil for permanent bans
+	LiftedAt *time.Time `bson:"lifted_at,omitempty" json:"lifted_at,omitempty"`
+	LiftedBy string `bson:"lifted_by,omitempty" json:"lifted_by,omitempty"` //who unbanned the user, empty when the ban expired
+	LiftReason string `bson:"lift_reason,omitempty" json:"lift_reason,omitempty"`
+}
+
+//A user as listed by a search, without the character maps
+type UserSummary struct {
+	ID string `bson:"_id" json:"_id"`
+	Flags uint32 `bson:"flags" json:"flags"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	Characters int `bson:"characters" json:"characters"` //characters that aren't deleted
+	MergedInto string `bson:"merged_into,omitempty" json:"merged_into,omitempty"`
+}
+
+//A character as listed by a search, without its data or versions
+type CharacterSummary struct {
+	ID uuid.UUID `bson:"_id" json:"_id"`
+	SteamID string `bson:"steamid" json:"steamid"`
+	Slot int `bson:"slot" json:"slot"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	ModifiedAt *time.Time `bson:"modified_at,omitempty" json:"modified_at,omitempty"` //when the current data was saved
+	Size int `bson:"size" json:"size"`
+	Server string `bson:"server,omitempty" json:"server,omitempty"`
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+//Changes collection, an ordered feed of what happened to characters and users
+type Change struct {
+	ID int64 `bson:"_id" json:"_id"` //cursor, always increasing
+	Kind string `bson:"kind" json:"kind"` //character_created, character_updated, user_flags, etc.
+	CharacterID *uuid.UUID `bson:"character_id,omitempty" json:"character_id,omitempty"`
+	SourceID *uuid.UUID `bson:"source_id,omitempty" json:"source_id,omitempty"` //the character a copy was made from
+	SteamID string `bson:"steamid" json:"steamid"` //owner of the character after the change
+	Slot *int `bson:"slot,omitempty" json:"slot,omitempty"`
+	Flags *uint32 `bson:"flags,omitempty" json:"flags,omitempty"` //the user's flags after a flag change
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+//Webhook deliveries collection, one per event sent to each endpoint that wants it
+type WebhookDelivery struct {
+	ID int64 `bson:"_id" json:"_id"`
+	Endpoint string `bson:"endpoint" json:"endpoint"` //name of the endpoint in the config
+	Event string `bson:"event" json:"event"`
+	Body string `bson:"body" json:"body"` //exactly what is posted, the same on every attempt
+	Status string `bson:"status" json:"status"` //pending, delivered or failed
+	Attempts int `bson:"attempts" json:"attempts"`
+	NextAttempt *time.Time `bson:"next_attempt,omitempty" json:"next_attempt,omitempty"`
+	ResponseCode int `bson:"response_code,omitempty" json:"response_code,omitempty"` //HTTP status of the last attempt
+	Error string `bson:"error,omitempty" json:"error,omitempty"` //why the last attempt failed
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
 }
 
 //
 //	Children Schema
 //
 type CharacterData struct {
+	ID int64 `bson:"id,omitempty" json:"id,omitempty"` //version ID, stays the same when older versions are pruned. 0 for the current data
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	Size int `bson:"size" json:"size"`
 	Data string `bson:"data" json:"data"`
+	Tag string `bson:"tag,omitempty" json:"tag,omitempty"` //why this version was made, e.g. "import"
+	Note string `bson:"note,omitempty" json:"note,omitempty"`
+	Server string `bson:"server,omitempty" json:"server,omitempty"` //IP of the game server that saved this data, if known
 }
\ No newline at end of file